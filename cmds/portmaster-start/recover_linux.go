@@ -1,12 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/network/ebpf"
 	"github.com/spf13/cobra"
 )
 
@@ -56,8 +58,41 @@
 	SilenceUsage: true,
 }
 
+var recoverNftablesCmd = &cobra.Command{
+	Use:   "recover-nftables",
+	Short: "Removes the obsolete nftables table in case of an unclean shutdown",
+	RunE: func(*cobra.Command, []string) error {
+		// The table is removed in a single netlink batch, which also
+		// succeeds if the table does not exist anymore. As we talk to the
+		// kernel directly, we get the errno of the actual error.
+		err := interception.DeactivateNftablesFirewall()
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("failed to cleanup nftables: %w", os.ErrPermission)
+		}
+		return err
+	},
+	SilenceUsage: true,
+}
+
+var recoverEBPFCmd = &cobra.Command{
+	Use:   "recover-ebpf",
+	Short: "Detaches the obsolete eBPF socket attribution programs in case of an unclean shutdown",
+	RunE: func(*cobra.Command, []string) error {
+		// The programs stay attached to the root cgroup when the Portmaster
+		// exits without detaching them.
+		err := ebpf.DetachAll()
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("failed to cleanup eBPF programs: %w", os.ErrPermission)
+		}
+		return err
+	},
+	SilenceUsage: true,
+}
+
 func init() {
 	rootCmd.AddCommand(recoverIPTablesCmd)
+	rootCmd.AddCommand(recoverNftablesCmd)
+	rootCmd.AddCommand(recoverEBPFCmd)
 }
 
 func formatNfqErrors(es []error) string {
@@ -0,0 +1,47 @@
+package payload
+
+import (
+	"math"
+)
+
+// EntropyScore calculates how random the given data looks. It returns a value
+// between 0 and 100, representing the Shannon entropy of the data in
+// percent of the maximum entropy possible for its length. The entropy of the
+// differences between subsequent bytes is considered too, so that generated
+// patterns, like incrementing bytes, score low.
+func EntropyScore(data []byte) float64 {
+	if len(data) < 2 {
+		return 0
+	}
+
+	deltas := make([]byte, len(data)-1)
+	for i := 1; i < len(data); i++ {
+		deltas[i-1] = data[i] - data[i-1]
+	}
+
+	return math.Min(relativeEntropy(data), relativeEntropy(deltas)) * 100
+}
+
+// relativeEntropy returns the Shannon entropy of the given data relative to
+// the maximum entropy possible for its length.
+func relativeEntropy(data []byte) float64 {
+	if len(data) < 2 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	var entropy float64
+	total := float64(len(data))
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / total
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	return entropy / math.Log2(math.Min(total, 256))
+}
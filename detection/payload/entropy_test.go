@@ -0,0 +1,35 @@
+package payload
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestEntropyScore(t *testing.T) {
+	// Windows ping payload.
+	testEntropy(t, []byte("abcdefghijklmnopqrstuvwabcdefghi"), 0, 20)
+
+	// Linux ping payload: timestamp followed by incrementing bytes.
+	linux := []byte{0x6b, 0x2c, 0x9f, 0x5f, 0, 0, 0, 0, 0x3d, 0x8e, 0x0b, 0, 0, 0, 0, 0}
+	for b := byte(0x10); b < 0x38; b++ {
+		linux = append(linux, b)
+	}
+	testEntropy(t, linux, 0, 50)
+
+	// Zeros.
+	testEntropy(t, make([]byte, 1024), 0, 0)
+
+	// Random data.
+	random := make([]byte, 512)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	testEntropy(t, random, 90, 100)
+}
+
+func testEntropy(t *testing.T, data []byte, min, max float64) {
+	score := EntropyScore(data)
+	if score < min || score > max {
+		t.Errorf("data %x has scored %.2f, but should be between %.0f and %.0f", data, score, min, max)
+	}
+}
@@ -1,15 +1,70 @@
 package firewall
 
 import (
+	"context"
+	"fmt"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
 	"github.com/safing/portmaster/nameserver/nsutil"
 	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
 	"github.com/safing/portmaster/profile/endpoints"
 )
 
+// dnsBypassNotifyInterval defines how often the user is notified about DNS
+// bypass attempts of the same profile.
+const dnsBypassNotifyInterval = 1 * time.Hour
+
 var (
 	resolverFilterLists = []string{"17-DNS"}
+
+	// knownDoHServers holds server names of public DNS-over-HTTPS
+	// resolvers. Subdomains are matched too.
+	knownDoHServers = []string{
+		"dns.google",
+		"dns.google.com",
+		"cloudflare-dns.com",
+		"one.one.one.one",
+		"dns.quad9.net",
+		"dns9.quad9.net",
+		"dns10.quad9.net",
+		"dns11.quad9.net",
+		"doh.opendns.com",
+		"doh.familyshield.opendns.com",
+		"dns.nextdns.io",
+		"doh.cleanbrowsing.org",
+		"dns.adguard.com",
+		"dns.adguard-dns.com",
+		"doh.mullvad.net",
+		"dns.controld.com",
+		"freedns.controld.com",
+		"doh.dns.sb",
+		"dns.alidns.com",
+		"doh.pub",
+		"doh.libredns.gr",
+	}
+
+	// dohPaths holds the request paths used by DNS-over-HTTPS.
+	dohPaths = []string{
+		"/dns-query",
+		"/resolve",
+	}
+
+	// dohMediaTypes holds the media types of DNS-over-HTTPS messages, in
+	// wire format and as JSON.
+	dohMediaTypes = []string{
+		"application/dns-message",
+		"application/dns-json",
+	}
+
+	dnsBypassNotified     = make(map[string]time.Time)
+	dnsBypassNotifiedLock sync.Mutex
 )
 
 // PreventBypassing checks if the connection should be denied or permitted
@@ -28,5 +83,149 @@ func PreventBypassing(conn *network.Connection) (endpoints.EPResult, string, nsu
 			nsutil.ZeroIP()
 	}
 
+	if evidence := detectDNSBypass(conn); evidence != "" {
+		return endpoints.Denied,
+			"blocked rogue DNS traffic: " + evidence,
+			nil
+	}
+
 	return endpoints.NoMatch, "", nil
 }
+
+// detectDNSBypass checks the information the inspectors gathered about the
+// connection for signs of DNS traffic that does not use the system resolver.
+// It returns a description of the evidence, or an empty string if there is
+// none.
+func detectDNSBypass(conn *network.Connection) (evidence string) {
+	if conn.Inbound {
+		return ""
+	}
+
+	if conn.DNSPayloadContext != nil {
+		return fmt.Sprintf("DNS query for %s sent to port %d", conn.DNSPayloadContext.Name, conn.Entity.Port)
+	}
+
+	if tlsCtx := conn.TLSContext; tlsCtx != nil {
+		for _, alpn := range tlsCtx.ALPN {
+			switch alpn {
+			case "dot":
+				return "DNS-over-TLS protocol requested in TLS handshake"
+			case "doq":
+				return "DNS-over-QUIC protocol requested in QUIC handshake"
+			}
+		}
+		if conn.Entity.Port == 853 {
+			return "TLS connection to DNS-over-TLS port 853"
+		}
+		if isKnownDoHServer(tlsCtx.SNI) {
+			return "TLS connection to DNS-over-HTTPS server " + tlsCtx.SNI
+		}
+	}
+
+	if httpCtx := conn.HTTPContext; httpCtx != nil && isDoHRequest(httpCtx) {
+		return "DNS-over-HTTPS request to " + httpCtx.Host + httpCtx.Path
+	}
+
+	return ""
+}
+
+// isDoHRequest returns whether the HTTP request is a DNS-over-HTTPS request.
+// As the paths are also used by other services, the request must also carry
+// a DNS message or ask for one.
+func isDoHRequest(httpCtx *network.HTTPContext) bool {
+	var isDoHPath bool
+	for _, path := range dohPaths {
+		if httpCtx.Path == path {
+			isDoHPath = true
+			break
+		}
+	}
+	if !isDoHPath {
+		return false
+	}
+
+	// GET requests carry the DNS message in the dns parameter.
+	// See RFC 8484, Section 4.1.
+	if query, err := url.ParseQuery(httpCtx.Query); err == nil && query.Get("dns") != "" {
+		return true
+	}
+
+	for _, mediaType := range dohMediaTypes {
+		if httpCtx.ContentType == mediaType || strings.Contains(httpCtx.Accept, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownDoHServer returns whether the given server name belongs to a known
+// DNS-over-HTTPS resolver.
+func isKnownDoHServer(serverName string) bool {
+	if serverName == "" {
+		return false
+	}
+
+	serverName = strings.TrimSuffix(serverName, ".")
+	for _, known := range knownDoHServers {
+		if serverName == known || strings.HasSuffix(serverName, "."+known) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyDNSBypass notifies the user that an app tried to bypass the system
+// resolver and how to fix it. Notifications are rate limited per profile.
+func notifyDNSBypass(conn *network.Connection, evidence string) {
+	// Do not notify about staged profile changes.
+	if conn.Shadow {
+		return
+	}
+
+	localProfile := conn.Process().Profile().LocalProfile()
+	if localProfile == nil {
+		return
+	}
+	profileID := localProfile.ScopedID()
+
+	dnsBypassNotifiedLock.Lock()
+	defer dnsBypassNotifiedLock.Unlock()
+
+	if time.Since(dnsBypassNotified[profileID]) < dnsBypassNotifyInterval {
+		return
+	}
+	dnsBypassNotified[profileID] = time.Now()
+
+	notifications.Notify(&notifications.Notification{
+		EventID:  "filter:dns-bypass-" + profileID,
+		Type:     notifications.Warning,
+		Title:    "DNS Bypass Blocked",
+		Category: "Privacy Filter",
+		Message: fmt.Sprintf(
+			"%s tried to resolve domains with its own DNS resolver and was blocked, as this bypasses the Portmaster (%s). Please configure %s to use the DNS resolver of the system, eg. by disabling \"Secure DNS\" or \"DNS over HTTPS\" in its settings.",
+			localProfile.Name,
+			evidence,
+			localProfile.Name,
+		),
+	})
+}
+
+// checkInspectedBypassing runs the bypass prevention checks after an
+// inspector gathered new information about the connection. Like in the
+// deciders, internal connections and connections permitted by the endpoint
+// lists are exempt.
+func checkInspectedBypassing(ctx context.Context, conn *network.Connection, pkt packet.Packet) bool {
+	if conn.Internal ||
+		(conn.Verdict == network.VerdictAccept && conn.Reason.OptionKey == profile.CfgOptionEndpointsKey) {
+		return false
+	}
+
+	if checkBypassPrevention(ctx, conn, pkt) {
+		log.Tracer(ctx).Infof("filter: blocked %s: %s", conn, conn.Reason.Msg)
+		if evidence := detectDNSBypass(conn); evidence != "" {
+			notifyDNSBypass(conn, evidence)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/network"
+)
+
+func TestIsDoHRequest(t *testing.T) {
+	for _, test := range []struct {
+		httpCtx *network.HTTPContext
+		isDoH   bool
+	}{
+		{
+			// GET request with the DNS message in the query.
+			httpCtx: &network.HTTPContext{Path: "/dns-query", Query: "dns=AAABAAABAAAAAAAAA3d3dwdleGFtcGxlA2NvbQAAAQAB"},
+			isDoH:   true,
+		},
+		{
+			// POST request with a DNS message.
+			httpCtx: &network.HTTPContext{Path: "/dns-query", ContentType: "application/dns-message"},
+			isDoH:   true,
+		},
+		{
+			// JSON API.
+			httpCtx: &network.HTTPContext{Path: "/resolve", Query: "name=example.com", Accept: "application/dns-json"},
+			isDoH:   true,
+		},
+		{
+			// Other services use the same paths.
+			httpCtx: &network.HTTPContext{Path: "/resolve", Query: "name=example.com", Accept: "text/html"},
+			isDoH:   false,
+		},
+		{
+			httpCtx: &network.HTTPContext{Path: "/dns-query"},
+			isDoH:   false,
+		},
+		{
+			// The path must match.
+			httpCtx: &network.HTTPContext{Path: "/api", Query: "dns=AAABAAABAAAAAAAAA3d3dwdleGFtcGxlA2NvbQAAAQAB"},
+			isDoH:   false,
+		},
+	} {
+		if isDoHRequest(test.httpCtx) != test.isDoH {
+			t.Errorf("%+v: expected DoH=%v", test.httpCtx, test.isDoH)
+		}
+	}
+}
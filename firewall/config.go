@@ -4,6 +4,7 @@
 	"github.com/safing/portbase/api"
 	"github.com/safing/portbase/config"
 	"github.com/safing/portmaster/core"
+	"github.com/safing/portmaster/profile/endpoints"
 )
 
 // Configuration Keys
@@ -21,6 +22,42 @@
 	cfgOptionPermanentVerdictsOrder = 96
 	permanentVerdicts               config.BoolOption
 
+	CfgOptionFailModeKey   = "filter/failMode"
+	cfgOptionFailModeOrder = 97
+	failMode               config.StringOption
+
+	CfgOptionGatewayModeKey   = "filter/gatewayMode"
+	cfgOptionGatewayModeOrder = 98
+	gatewayMode               config.BoolOption
+
+	CfgOptionFastTrackRulesKey   = "filter/fastTrackRules"
+	cfgOptionFastTrackRulesOrder = 99
+	fastTrackRuleDefinitions     config.StringArrayOption
+
+	CfgOptionFilterICMPKey   = "filter/filterICMP"
+	cfgOptionFilterICMPOrder = 100
+	filterICMP               config.BoolOption
+
+	CfgOptionTLSFingerprintRulesKey   = "filter/tlsFingerprintRules"
+	cfgOptionTLSFingerprintRulesOrder = 101
+	tlsFingerprintRuleDefinitions     config.StringArrayOption
+
+	CfgOptionPortScanDetectionKey   = "filter/portScanDetection"
+	cfgOptionPortScanDetectionOrder = 102
+	portScanDetection               config.BoolOption
+
+	CfgOptionPortScanBlockDurationKey   = "filter/portScanBlockDuration"
+	cfgOptionPortScanBlockDurationOrder = 103
+	portScanBlockDuration               config.IntOption
+
+	CfgOptionKillSwitchInterfacesKey   = "filter/killSwitchInterfaces"
+	cfgOptionKillSwitchInterfacesOrder = 104
+	killSwitchInterfaces               config.StringArrayOption
+
+	CfgOptionKillSwitchExemptionsKey   = "filter/killSwitchExemptions"
+	cfgOptionKillSwitchExemptionsOrder = 105
+	killSwitchExemptionDefinitions     config.StringArrayOption
+
 	devMode          config.BoolOption
 	apiListenAddress config.StringOption
 )
@@ -44,6 +81,195 @@ func registerConfig() error {
 	}
 	permanentVerdicts = config.Concurrent.GetAsBool(CfgOptionPermanentVerdictsKey, true)
 
+	err = config.Register(&config.Option{
+		Name:            "Failure Mode",
+		Key:             CfgOptionFailModeKey,
+		Description:     "Defines what happens to network traffic if the Portmaster stops handling packets, eg. because it crashed or stalled. Failing open lets all traffic pass unfiltered, while failing closed blocks all traffic until the Portmaster is running again or is stopped cleanly.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		RequiresRestart: true,
+		DefaultValue:    "open",
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionFailModeOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Fail Open",
+				Value:       "open",
+				Description: "Let traffic pass unfiltered",
+			},
+			{
+				Name:        "Fail Closed",
+				Value:       "closed",
+				Description: "Block all traffic",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	failMode = config.Concurrent.GetAsString(CfgOptionFailModeKey, "open")
+
+	err = config.Register(&config.Option{
+		Name:            "Gateway Mode",
+		Key:             CfgOptionGatewayModeKey,
+		Description:     "Filter network traffic that this device forwards for other devices, eg. when it is used as a router for the local network. Connections of other devices are attributed to the device by its hardware address and are filtered using a separate profile per device.",
+		OptType:         config.OptTypeBool,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		RequiresRestart: true,
+		DefaultValue:    false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionGatewayModeOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	gatewayMode = config.Concurrent.GetAsBool(CfgOptionGatewayModeKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Fast-Track Rules",
+		Key:            CfgOptionFastTrackRulesKey,
+		Description:    `Packets matching these rules are accepted before the process is looked up and are not shown in the network monitor. Rules use the endpoint rule syntax and are matched against the remote IP address and port of the packet. The first matching rule wins: "+" fast-tracks the packet, "-" hands it to the regular filtering. The special endpoint "Me" matches the IP addresses of this device. Access to the Portmaster API is always fast-tracked.`,
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   defaultFastTrackRules,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  endpoints.DisplayHintEndpointList,
+			config.DisplayOrderAnnotation: cfgOptionFastTrackRulesOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^(\+|\-) [A-Za-z0-9_\.:\-*/,]+( [A-Za-z0-9*/\-]+)?( @[A-Za-z0-9_,:/+\-]+)?( ~[A-Za-z0-9:\-]+)?$`,
+	})
+	if err != nil {
+		return err
+	}
+	fastTrackRuleDefinitions = config.Concurrent.GetAsStringArray(CfgOptionFastTrackRulesKey, defaultFastTrackRules)
+
+	err = config.Register(&config.Option{
+		Name:           "ICMP Filtering",
+		Key:            CfgOptionFilterICMPKey,
+		Description:    "Filter ICMP and ICMPv6 messages, such as ping, like other connections instead of always permitting them. Echo requests are attributed to the app that sent them and are checked for tunneled data. Messages that are required for the network to function, such as Neighbor Discovery or Path MTU Discovery, are always permitted.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionFilterICMPOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	filterICMP = config.Concurrent.GetAsBool(CfgOptionFilterICMPKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "TLS Fingerprint Rules",
+		Key:            CfgOptionTLSFingerprintRulesKey,
+		Description:    `Rules that are matched against the JA3 and JA4 fingerprints of the TLS clients of all apps, eg. "- JA4:t13d1516h2_8daaf6152771_e5627efa2ab1" or "- JA3:<md5 hash>". A trailing "*" matches all fingerprints with the given prefix. The first matching rule wins. Only blocking rules have an effect, as fingerprints are chosen by the client. Fingerprint rules may also be used in the outgoing rules of apps.`,
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  endpoints.DisplayHintEndpointList,
+			config.DisplayOrderAnnotation: cfgOptionTLSFingerprintRulesOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^(\+|\-) (JA3|ja3|JA4|ja4):[A-Za-z0-9_]+\*?( [A-Za-z0-9*/\-]+)?$`,
+	})
+	if err != nil {
+		return err
+	}
+	tlsFingerprintRuleDefinitions = config.Concurrent.GetAsStringArray(CfgOptionTLSFingerprintRulesKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name:           "Port Scan Detection",
+		Key:            CfgOptionPortScanDetectionKey,
+		Description:    "Detect port scans and connection floods against this device by tracking the incoming connections from the LAN and the Internet. Detected scans are reported as threats.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPortScanDetectionOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	portScanDetection = config.Concurrent.GetAsBool(CfgOptionPortScanDetectionKey, true)
+
+	err = config.Register(&config.Option{
+		Name:           "Block Port Scanners",
+		Key:            CfgOptionPortScanBlockDurationKey,
+		Description:    "Drop all incoming connections from a detected port scanner for the given duration. Set to 0 to only report port scans.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   0,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPortScanBlockDurationOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Advanced",
+			config.RequiresAnnotation: config.ValueRequirement{
+				Key:   CfgOptionPortScanDetectionKey,
+				Value: true,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	portScanBlockDuration = config.Concurrent.GetAsInt(CfgOptionPortScanBlockDurationKey, 0)
+
+	err = config.Register(&config.Option{
+		Name:           "VPN Kill Switch",
+		Key:            CfgOptionKillSwitchInterfacesKey,
+		Description:    `Only permit outgoing connections to the Internet that leave through one of these network interfaces, eg. "wg0" or "tun0". A trailing "*" matches all interfaces with the given prefix. This ensures that nothing leaks through other interfaces when the VPN drops. Connections are re-checked when the network changes. Leave empty to disable.`,
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionKillSwitchInterfacesOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^[A-Za-z0-9\.\-_ ]+\*?$`,
+	})
+	if err != nil {
+		return err
+	}
+	killSwitchInterfaces = config.Concurrent.GetAsStringArray(CfgOptionKillSwitchInterfacesKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name:           "VPN Kill Switch Exemptions",
+		Key:            CfgOptionKillSwitchExemptionsKey,
+		Description:    `Connections matching these rules may leave through any network interface, such as the handshake traffic of the VPN to its server, eg. "+ 203.0.113.1 UDP/51820". Rules use the endpoint rule syntax, the first matching rule wins. Connectivity checks of the network are always exempt.`,
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  endpoints.DisplayHintEndpointList,
+			config.DisplayOrderAnnotation: cfgOptionKillSwitchExemptionsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^(\+|\-) [A-Za-z0-9_\.:\-*/,]+( [A-Za-z0-9*/\-]+)?$`,
+	})
+	if err != nil {
+		return err
+	}
+	killSwitchExemptionDefinitions = config.Concurrent.GetAsStringArray(CfgOptionKillSwitchExemptionsKey, []string{})
+
 	err = config.Register(&config.Option{
 		Name:           "Prompt Desktop Notifications",
 		Key:            CfgOptionAskWithSystemNotificationsKey,
@@ -0,0 +1,53 @@
+package firewall
+
+import (
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// dnsPayloadInspector looks for DNS queries in wire format that are sent to
+// global IP addresses on ports other than 53, which is a way to bypass the
+// system resolver.
+type dnsPayloadInspector struct{}
+
+func (di *dnsPayloadInspector) Name() string {
+	return "DNS Payload"
+}
+
+func (di *dnsPayloadInspector) Applies(conn *network.Connection) bool {
+	return !conn.Inbound &&
+		(conn.IPProtocol == packet.TCP || conn.IPProtocol == packet.UDP) &&
+		conn.Entity.Port != 53 &&
+		netutils.IPIsGlobal(conn.Entity.IP) &&
+		conn.DNSPayloadContext == nil
+}
+
+func (di *dnsPayloadInspector) Inspect(conn *network.Connection, pkt packet.Packet, data []byte) (inspection.Action, error) {
+	query, err := inspection.ParseDNSQuery(data, conn.IPProtocol == packet.TCP)
+	switch err {
+	case nil:
+	case inspection.ErrIncomplete:
+		return inspection.ActionContinue, nil
+	default:
+		// Not DNS, nothing to do.
+		return inspection.ActionStop, nil
+	}
+
+	conn.DNSPayloadContext = &network.DNSPayloadContext{
+		Name: query.Name,
+		Type: query.Type,
+	}
+	conn.SaveWhenFinished()
+	log.Tracer(pkt.Ctx()).Tracef("filter: connection %s carries DNS query for %s to port %d", conn, query.Name, conn.Entity.Port)
+
+	if layeredProfile := conn.Process().Profile(); layeredProfile != nil {
+		layeredProfile.LockForUsage()
+		checkInspectedBypassing(pkt.Ctx(), conn, pkt)
+		layeredProfile.UnlockForUsage()
+	}
+
+	return inspection.ActionStop, nil
+}
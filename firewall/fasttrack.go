@@ -0,0 +1,239 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/metrics"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// fastTrackMeEndpoint is the endpoint of fast-track rules that matches the IP
+// addresses of this device.
+const fastTrackMeEndpoint = "Me"
+
+// Indexes of the default fast-track rules.
+const (
+	defaultFastTrackICMP = iota
+	defaultFastTrackICMP6
+	defaultFastTrackDHCP
+	defaultFastTrackDHCPv6
+	defaultFastTrackDNSUDP
+	defaultFastTrackDNSTCP
+)
+
+var (
+	// defaultFastTrackRules always permit ICMP, DHCP and DHCPv6 in local
+	// network scopes, and DNS to this device. They are matched by
+	// matchDefaultFastTrackRules while they are not changed.
+	defaultFastTrackRules = []string{
+		defaultFastTrackICMP:   "+ * ICMP",
+		defaultFastTrackICMP6:  "+ * ICMP6",
+		defaultFastTrackDHCP:   "+ Localhost,LAN UDP/67-68",
+		defaultFastTrackDHCPv6: "+ Localhost,LAN UDP/546-547",
+		defaultFastTrackDNSUDP: "+ Me UDP/53",
+		defaultFastTrackDNSTCP: "+ Me TCP/53",
+	}
+
+	fastTrackRules []*fastTrackRule
+	// fastTrackDefaults is set if the fast-track rules are the default rules.
+	fastTrackDefaults  bool
+	fastTrackRulesLock sync.RWMutex
+
+	fastTrackMetric = metrics.NewCounterVec(
+		"portmaster_firewall_fasttrack_packets",
+		"Packets matched by fast-track rules, by rule.",
+		"rule",
+	)
+)
+
+// fastTrackRule is a parsed rule of the fast-track rules option.
+type fastTrackRule struct {
+	definition string
+	endpoint   endpoints.Endpoints
+	// me restricts the rule to packets from and to other IP addresses of
+	// this device.
+	me bool
+	// packets counts the packets matched by the rule.
+	packets *metrics.Counter
+}
+
+func parseFastTrackRule(definition string) (*fastTrackRule, error) {
+	fields := strings.Fields(definition)
+	rule := &fastTrackRule{
+		definition: strings.Join(fields, " "),
+	}
+
+	// Replace the "Me" endpoint with "any" and check the IP when matching.
+	if len(fields) >= 2 && fields[1] == fastTrackMeEndpoint {
+		rule.me = true
+		fields[1] = "*"
+	}
+
+	ep, err := endpoints.ParseEndpoints([]string{strings.Join(fields, " ")})
+	if err != nil {
+		return nil, err
+	}
+	if len(ep) != 1 {
+		return nil, fmt.Errorf(`invalid fast-track rule: "%s"`, definition)
+	}
+	rule.endpoint = ep
+	rule.packets = fastTrackMetric.With(rule.definition)
+
+	return rule, nil
+}
+
+// updateFastTrackRules parses the configured fast-track rules. Invalid rules
+// are skipped.
+func updateFastTrackRules(_ context.Context, _ interface{}) error {
+	definitions := fastTrackRuleDefinitions()
+	rules := make([]*fastTrackRule, 0, len(definitions))
+	for _, definition := range definitions {
+		rule, err := parseFastTrackRule(definition)
+		if err != nil {
+			log.Warningf("filter: ignoring invalid fast-track rule: %s", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	defaults := len(rules) == len(defaultFastTrackRules)
+	for i := 0; defaults && i < len(rules); i++ {
+		defaults = rules[i].definition == defaultFastTrackRules[i]
+	}
+
+	fastTrackRulesLock.Lock()
+	defer fastTrackRulesLock.Unlock()
+
+	fastTrackRules = rules
+	fastTrackDefaults = defaults
+	return nil
+}
+
+// matchFastTrackRules checks the remote side of the packet against the
+// fast-track rules and returns whether it should be fast-tracked.
+func matchFastTrackRules(ctx context.Context, pkt packet.Packet) (fastTrack bool, rule *fastTrackRule) {
+	meta := pkt.Info()
+
+	fastTrackRulesLock.RLock()
+	defer fastTrackRulesLock.RUnlock()
+
+	switch {
+	case len(fastTrackRules) == 0:
+		return false, nil
+	case fastTrackDefaults:
+		// Skip the endpoint matching, as it is the hot path.
+		index := matchDefaultFastTrackRules(meta)
+		if index < 0 {
+			return false, nil
+		}
+		rule := fastTrackRules[index]
+		rule.packets.Inc()
+		return true, rule
+	}
+
+	// Unlike endpoint rules of connections, fast-track rules also match the
+	// remote port of inbound packets, as they apply to both directions.
+	entity := (&intel.Entity{
+		IP:       meta.RemoteIP(),
+		Protocol: uint8(meta.Protocol),
+		Port:     meta.RemotePort(),
+	}).Init()
+	entity.SetDstPort(meta.RemotePort())
+
+	for _, rule := range fastTrackRules {
+		result, _ := rule.endpoint.Match(ctx, entity)
+		if result != endpoints.Permitted && result != endpoints.Denied {
+			continue
+		}
+
+		if rule.me && !fastTrackRemoteIsMe(meta) {
+			continue
+		}
+
+		rule.packets.Inc()
+		return result == endpoints.Permitted, rule
+	}
+
+	return false, nil
+}
+
+// matchDefaultFastTrackRules matches the packet against the default
+// fast-track rules and returns the index of the matching rule, or -1 if no
+// rule matches.
+func matchDefaultFastTrackRules(meta *packet.Info) (index int) {
+	switch meta.Protocol {
+	case packet.ICMP:
+		return defaultFastTrackICMP
+	case packet.ICMPv6:
+		return defaultFastTrackICMP6
+	case packet.UDP:
+		switch netutils.ClassifyIP(meta.RemoteIP()) {
+		case netutils.HostLocal, netutils.LinkLocal, netutils.SiteLocal, netutils.LocalMulticast:
+			switch meta.RemotePort() {
+			case 67, 68:
+				return defaultFastTrackDHCP
+			case 546, 547:
+				return defaultFastTrackDHCPv6
+			}
+		}
+		if meta.RemotePort() == 53 && fastTrackRemoteIsMe(meta) {
+			return defaultFastTrackDNSUDP
+		}
+	case packet.TCP:
+		if meta.RemotePort() == 53 && fastTrackRemoteIsMe(meta) {
+			return defaultFastTrackDNSTCP
+		}
+	}
+
+	return -1
+}
+
+func fastTrackRemoteIsMe(meta *packet.Info) bool {
+	remoteIsMe, err := netenv.IsMyIP(meta.RemoteIP())
+	if err != nil {
+		log.Warningf("filter: failed to check if IP %s is local: %s", meta.RemoteIP(), err)
+	}
+	return remoteIsMe
+}
+
+// FastTrackRuleStats holds the number of packets matched by a fast-track rule.
+type FastTrackRuleStats struct {
+	Rule    string
+	Packets uint64
+}
+
+func registerFastTrackAPI() {
+	api.RegisterHandleFunc("/api/v1/firewall/fasttrack", handleFastTrackRequest).Methods("GET")
+}
+
+func handleFastTrackRequest(w http.ResponseWriter, r *http.Request) {
+	fastTrackRulesLock.RLock()
+	stats := make([]FastTrackRuleStats, 0, len(fastTrackRules))
+	for _, rule := range fastTrackRules {
+		stats = append(stats, FastTrackRuleStats{
+			Rule:    rule.definition,
+			Packets: rule.packets.Value(),
+		})
+	}
+	fastTrackRulesLock.RUnlock()
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,216 @@
+package firewall
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// legacyFastTrack is the hard-coded fast-tracking that the default fast-track
+// rules replace, without the Portmaster API. It matches the destination of the
+// packet, which is the remote side of outbound packets.
+func legacyFastTrack(meta *packet.Info) bool {
+	switch meta.Protocol {
+	case packet.ICMP, packet.ICMPv6:
+		return true
+
+	case packet.UDP, packet.TCP:
+		switch meta.DstPort {
+		case 67, 68, 546, 547:
+			if meta.Protocol != packet.UDP {
+				return false
+			}
+			switch netutils.ClassifyIP(meta.Dst) {
+			case netutils.HostLocal, netutils.LinkLocal, netutils.SiteLocal, netutils.LocalMulticast:
+				return true
+			}
+		case 53:
+			dstIsMe, _ := netenv.IsMyIP(meta.Dst)
+			return dstIsMe
+		}
+	}
+
+	return false
+}
+
+func resetFastTrackRules(definitions config.StringArrayOption) {
+	fastTrackRuleDefinitions = definitions
+
+	fastTrackRulesLock.Lock()
+	defer fastTrackRulesLock.Unlock()
+
+	fastTrackRules = nil
+	fastTrackDefaults = false
+}
+
+func TestDefaultFastTrackRules(t *testing.T) {
+	previousDefinitions := fastTrackRuleDefinitions
+	defer resetFastTrackRules(previousDefinitions)
+
+	fastTrackRuleDefinitions = func() []string { return defaultFastTrackRules }
+	if err := updateFastTrackRules(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(fastTrackRules) != len(defaultFastTrackRules) {
+		t.Fatalf("expected %d rules, got %d", len(defaultFastTrackRules), len(fastTrackRules))
+	}
+	if !fastTrackDefaults {
+		t.Fatal("default rules must be detected")
+	}
+
+	for _, info := range []packet.Info{
+		{Protocol: packet.ICMP, Dst: net.IPv4(8, 8, 8, 8)},
+		{Protocol: packet.ICMPv6, Dst: net.ParseIP("2001:db8::1")},
+		{Protocol: packet.UDP, Dst: net.IPv4(192, 168, 1, 1), DstPort: 67},
+		{Protocol: packet.UDP, Dst: net.IPv4(10, 0, 0, 1), DstPort: 68},
+		{Protocol: packet.UDP, Dst: net.IPv4(255, 255, 255, 255), DstPort: 67},
+		{Protocol: packet.UDP, Dst: net.IPv4(8, 8, 8, 8), DstPort: 67},
+		{Protocol: packet.TCP, Dst: net.IPv4(192, 168, 1, 1), DstPort: 67},
+		{Protocol: packet.UDP, Dst: net.ParseIP("fe80::1"), DstPort: 547},
+		{Protocol: packet.UDP, Dst: net.ParseIP("ff02::1:2"), DstPort: 547},
+		{Protocol: packet.UDP, Dst: net.ParseIP("2001:db8::1"), DstPort: 547},
+		{Protocol: packet.UDP, Dst: net.IPv4(127, 0, 0, 1), DstPort: 53},
+		{Protocol: packet.TCP, Dst: net.IPv4(127, 0, 0, 1), DstPort: 53},
+		{Protocol: packet.TCP, Dst: net.IPv6loopback, DstPort: 53},
+		{Protocol: packet.UDPLite, Dst: net.IPv4(127, 0, 0, 1), DstPort: 53},
+		{Protocol: packet.UDP, Dst: net.IPv4(192, 0, 2, 1), DstPort: 53},
+		{Protocol: packet.TCP, Dst: net.IPv4(127, 0, 0, 1), DstPort: 443},
+	} {
+		pkt := &testPacket{}
+		pkt.SetPacketInfo(info)
+		expected := legacyFastTrack(pkt.Info())
+
+		// Check the fast path for the default rules.
+		fastTrackDefaults = true
+		fastTrack, defaultRule := matchFastTrackRules(context.Background(), pkt)
+		if fastTrack != expected {
+			t.Errorf("%s: default rules returned %v, expected %v", pkt, fastTrack, expected)
+		}
+
+		// Check the endpoint matching of the default rules.
+		fastTrackDefaults = false
+		fastTrack, rule := matchFastTrackRules(context.Background(), pkt)
+		if fastTrack != expected {
+			t.Errorf("%s: endpoint matching of default rules returned %v, expected %v", pkt, fastTrack, expected)
+		}
+		if rule != defaultRule {
+			t.Errorf("%s: fast path and endpoint matching disagree on the matching rule", pkt)
+		}
+	}
+
+	// Inbound packets are matched by their remote side.
+	for _, test := range []struct {
+		info  packet.Info
+		index int
+	}{
+		{
+			// DHCP offer from the router.
+			info:  packet.Info{Inbound: true, Protocol: packet.UDP, Src: net.IPv4(192, 168, 1, 1), SrcPort: 67, Dst: net.IPv4(192, 168, 1, 2), DstPort: 68},
+			index: defaultFastTrackDHCP,
+		},
+		{
+			// DNS reply from the local resolver.
+			info:  packet.Info{Inbound: true, Protocol: packet.UDP, Src: net.IPv4(127, 0, 0, 1), SrcPort: 53, Dst: net.IPv4(127, 0, 0, 1), DstPort: 40000},
+			index: defaultFastTrackDNSUDP,
+		},
+		{
+			// DNS query from the LAN.
+			info:  packet.Info{Inbound: true, Protocol: packet.UDP, Src: net.IPv4(192, 168, 1, 3), SrcPort: 40000, Dst: net.IPv4(192, 168, 1, 2), DstPort: 53},
+			index: -1,
+		},
+	} {
+		pkt := &testPacket{}
+		pkt.SetPacketInfo(test.info)
+
+		fastTrackDefaults = true
+		fastTrack, defaultRule := matchFastTrackRules(context.Background(), pkt)
+		if fastTrack != (test.index >= 0) || (test.index >= 0 && defaultRule != fastTrackRules[test.index]) {
+			t.Errorf("%s: default rules returned %v (%v), expected rule %d", pkt, fastTrack, defaultRule, test.index)
+		}
+
+		fastTrackDefaults = false
+		fastTrack, rule := matchFastTrackRules(context.Background(), pkt)
+		if fastTrack != (test.index >= 0) || rule != defaultRule {
+			t.Errorf("%s: endpoint matching of default rules disagrees with the fast path", pkt)
+		}
+	}
+}
+
+func TestDefaultFastTrackRuleIndexes(t *testing.T) {
+	for index, definition := range map[int]string{
+		defaultFastTrackICMP:   "+ * ICMP",
+		defaultFastTrackICMP6:  "+ * ICMP6",
+		defaultFastTrackDHCP:   "+ Localhost,LAN UDP/67-68",
+		defaultFastTrackDHCPv6: "+ Localhost,LAN UDP/546-547",
+		defaultFastTrackDNSUDP: "+ Me UDP/53",
+		defaultFastTrackDNSTCP: "+ Me TCP/53",
+	} {
+		if index >= len(defaultFastTrackRules) || defaultFastTrackRules[index] != definition {
+			t.Errorf("default fast-track rule %d must be %q", index, definition)
+		}
+	}
+	if len(defaultFastTrackRules) != 6 {
+		t.Errorf("unexpected number of default fast-track rules: %d", len(defaultFastTrackRules))
+	}
+}
+
+func TestCustomFastTrackRules(t *testing.T) {
+	previousDefinitions := fastTrackRuleDefinitions
+	defer resetFastTrackRules(previousDefinitions)
+
+	fastTrackRuleDefinitions = func() []string {
+		return []string{
+			"- * ICMP",
+			"+ 192.0.2.123 UDP/123",
+			"+ 10.0.0.5 UDP/51820",
+			"invalid",
+		}
+	}
+	if err := updateFastTrackRules(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(fastTrackRules) != 3 || fastTrackDefaults {
+		t.Fatalf("unexpected rules: %d, defaults=%v", len(fastTrackRules), fastTrackDefaults)
+	}
+
+	ntp := &testPacket{}
+	ntp.SetPacketInfo(packet.Info{Protocol: packet.UDP, Dst: net.IPv4(192, 0, 2, 123), DstPort: 123})
+	if fastTrack, rule := matchFastTrackRules(context.Background(), ntp); !fastTrack || rule != fastTrackRules[1] {
+		t.Error("NTP packet must be fast-tracked by the second rule")
+	}
+	if fastTrackRules[1].packets.Value() == 0 {
+		t.Error("matched packets must be counted")
+	}
+
+	// Inbound packets are matched by their remote side.
+	ntpReply := &testPacket{}
+	ntpReply.SetPacketInfo(packet.Info{Inbound: true, Protocol: packet.UDP, Src: net.IPv4(192, 0, 2, 123), SrcPort: 123, Dst: net.IPv4(192, 168, 1, 2), DstPort: 40000})
+	if fastTrack, rule := matchFastTrackRules(context.Background(), ntpReply); !fastTrack || rule != fastTrackRules[1] {
+		t.Error("NTP reply must be fast-tracked by the second rule")
+	}
+	wireguard := &testPacket{}
+	wireguard.SetPacketInfo(packet.Info{Inbound: true, Protocol: packet.UDP, Src: net.IPv4(10, 0, 0, 5), SrcPort: 51820, Dst: net.IPv4(10, 0, 0, 2), DstPort: 51820})
+	if fastTrack, rule := matchFastTrackRules(context.Background(), wireguard); !fastTrack || rule != fastTrackRules[2] {
+		t.Error("WireGuard packet from the concentrator must be fast-tracked by the third rule")
+	}
+
+	ping := &testPacket{}
+	ping.SetPacketInfo(packet.Info{Protocol: packet.ICMP, Dst: net.IPv4(192, 0, 2, 123)})
+	if fastTrack, rule := matchFastTrackRules(context.Background(), ping); fastTrack || rule != fastTrackRules[0] {
+		t.Error("ICMP packet must be handed to the regular filtering by the first rule")
+	}
+
+	// Nothing is fast-tracked without rules.
+	fastTrackRuleDefinitions = func() []string { return nil }
+	if err := updateFastTrackRules(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if fastTrack, _ := matchFastTrackRules(context.Background(), ping); fastTrack {
+		t.Error("nothing must be fast-tracked without rules")
+	}
+}
@@ -0,0 +1,132 @@
+package firewall
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// httpInspector parses the first request of outgoing plain-text HTTP
+// connections and stores the requested host and path on the connection.
+type httpInspector struct{}
+
+func (hi *httpInspector) Name() string {
+	return "HTTP"
+}
+
+func (hi *httpInspector) Applies(conn *network.Connection) bool {
+	return !conn.Inbound &&
+		conn.IPProtocol == packet.TCP &&
+		conn.Entity.Port == 80 &&
+		conn.HTTPContext == nil
+}
+
+func (hi *httpInspector) Inspect(conn *network.Connection, pkt packet.Packet, data []byte) (inspection.Action, error) {
+	request, err := inspection.ParseHTTPRequest(data)
+	switch err {
+	case nil:
+	case inspection.ErrIncomplete:
+		return inspection.ActionContinue, nil
+	default:
+		// Not HTTP, nothing to do.
+		return inspection.ActionStop, nil
+	}
+
+	conn.HTTPContext = &network.HTTPContext{
+		Method:      request.Method,
+		Host:        request.Host,
+		Path:        request.Path,
+		Query:       request.Query,
+		ContentType: request.ContentType,
+		Accept:      request.Accept,
+	}
+	if conn.Entity.Domain != "" && request.Host != "" && !httpHostMatches(conn, request.Host) {
+		conn.HTTPContext.HostMismatch = true
+		log.Tracer(pkt.Ctx()).Warningf(
+			"filter: possible domain fronting by %s: requested HTTP host %s does not match domain %s",
+			conn.Process(), request.Host, conn.Entity.Domain,
+		)
+	}
+	conn.SaveWhenFinished()
+	log.Tracer(pkt.Ctx()).Tracef("filter: HTTP connection %s requested %s %s%s", conn, request.Method, request.Host, request.Path)
+
+	// Check the request against the endpoint lists and for DNS bypass
+	// attempts.
+	if layeredProfile := conn.Process().Profile(); layeredProfile != nil {
+		layeredProfile.LockForUsage()
+		if !checkHTTPEndpointLists(pkt.Ctx(), conn, pkt) {
+			checkInspectedBypassing(pkt.Ctx(), conn, pkt)
+		}
+		layeredProfile.UnlockForUsage()
+	}
+
+	return inspection.ActionStop, nil
+}
+
+// httpHostMatches returns whether the requested host matches the domain or
+// IP address of the connection.
+func httpHostMatches(conn *network.Connection, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.Equal(conn.Entity.IP)
+	}
+
+	fqdn := dns.Fqdn(host)
+	if fqdn == conn.Entity.Domain {
+		return true
+	}
+	for _, cname := range conn.Entity.CNAME {
+		if fqdn == cname {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHTTPEndpointLists checks the host and path of plain-text HTTP requests
+// against the endpoint lists. The host is only used if the connection has no
+// domain. As the request is chosen by the client, it is only used to deny
+// connections.
+func checkHTTPEndpointLists(ctx context.Context, conn *network.Connection, _ packet.Packet) bool {
+	if conn.Inbound || conn.HTTPContext == nil {
+		return false
+	}
+
+	// Use a copy of the entity, so that the request does not show up as a
+	// resolved domain.
+	entity := &intel.Entity{
+		Protocol:        conn.Entity.Protocol,
+		Port:            conn.Entity.Port,
+		Domain:          conn.Entity.Domain,
+		CNAME:           conn.Entity.CNAME,
+		URLPath:         conn.HTTPContext.Path,
+		IP:              conn.Entity.IP,
+		Interface:       conn.Entity.Interface,
+		NetworkLocation: conn.Entity.NetworkLocation,
+	}
+	entity.SetDstPort(conn.Entity.DstPort())
+	entity.EnableCNAMECheck(ctx, conn.Entity.CNAMECheckEnabled())
+	if entity.Domain == "" && conn.HTTPContext.Host != "" && net.ParseIP(conn.HTTPContext.Host) == nil {
+		if fqdn := dns.Fqdn(conn.HTTPContext.Host); netutils.IsValidFqdn(fqdn) {
+			entity.Domain = fqdn
+		}
+	}
+
+	p := conn.Process().Profile()
+	result, reason := p.MatchEndpoint(ctx, entity)
+	if result == endpoints.Denied {
+		conn.DenyWithContext("HTTP request: "+reason.String(), profile.CfgOptionEndpointsKey, reason.Context())
+		return true
+	}
+
+	return false
+}
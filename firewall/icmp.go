@@ -0,0 +1,184 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/detection/payload"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+const (
+	// icmpEchoMaxPayloadSize is the largest echo payload that fits into a
+	// single unfragmented packet on Ethernet. Larger payloads are only used
+	// to test fragmentation and are suspicious otherwise.
+	icmpEchoMaxPayloadSize = 1472
+
+	// icmpEchoMinEntropyCheckSize is the smallest echo payload size for
+	// which the entropy is meaningful.
+	icmpEchoMinEntropyCheckSize = 64
+
+	// icmpEchoMaxEntropyScore is the highest entropy score of an echo payload
+	// that is not regarded as a tunnel. Ping tools use fixed patterns or
+	// timestamps, while tunnels carry encrypted or compressed data.
+	icmpEchoMaxEntropyScore = 80
+)
+
+// isRequiredICMP returns whether the packet is an ICMP message that is
+// required for the network to function and must therefore never be blocked.
+// This includes error messages, which relate to other connections, and the
+// messages of the Neighbor Discovery Protocol and Multicast Listener
+// Discovery. See RFC 4890.
+func isRequiredICMP(info *packet.Info) bool {
+	switch info.Protocol {
+	case packet.ICMP:
+		switch info.ICMPType {
+		case 3, // Destination Unreachable, including Fragmentation Needed
+			11, // Time Exceeded
+			12: // Parameter Problem
+			return true
+		}
+
+	case packet.ICMPv6:
+		switch info.ICMPType {
+		case 1, // Destination Unreachable
+			2,                  // Packet Too Big
+			3,                  // Time Exceeded
+			4,                  // Parameter Problem
+			130, 131, 132, 143, // Multicast Listener Discovery
+			133, 134, 135, 136, 137: // Neighbor Discovery
+			return true
+		}
+	}
+
+	return false
+}
+
+// icmpEchoData returns the data of the ICMP echo message in the given raw
+// IP packet.
+func icmpEchoData(info *packet.Info, raw []byte) []byte {
+	var offset int
+	switch info.Version {
+	case packet.IPv4:
+		if len(raw) < 1 {
+			return nil
+		}
+		offset = int(raw[0]&0x0f) * 4
+	case packet.IPv6:
+		// Extension headers are not supported, as they are very unusual for
+		// echo messages.
+		if len(raw) < 40 || raw[6] != byte(packet.ICMPv6) {
+			return nil
+		}
+		offset = 40
+	default:
+		return nil
+	}
+
+	// Skip the echo header: type, code, checksum, identifier, sequence.
+	offset += 8
+	if len(raw) < offset {
+		return nil
+	}
+	return raw[offset:]
+}
+
+// checkICMPTunnel checks the payload of an ICMP echo packet for signs of
+// tunneling and returns the reason if it is suspicious.
+func checkICMPTunnel(pkt packet.Packet) (suspicious bool, reason string) {
+	raw, err := pkt.GetPayload()
+	if err != nil {
+		return false, ""
+	}
+	data := icmpEchoData(pkt.Info(), raw)
+
+	switch {
+	case len(data) > icmpEchoMaxPayloadSize:
+		return true, fmt.Sprintf("oversized echo payload of %d bytes", len(data))
+	case len(data) >= icmpEchoMinEntropyCheckSize:
+		score := payload.EntropyScore(data)
+		if score > icmpEchoMaxEntropyScore {
+			return true, fmt.Sprintf("random-looking echo payload with an entropy score of %.2f", score)
+		}
+	}
+
+	return false, ""
+}
+
+func checkICMP(_ context.Context, conn *network.Connection, _ packet.Packet) bool {
+	if conn.Entity.Protocol != uint8(packet.ICMP) && conn.Entity.Protocol != uint8(packet.ICMPv6) {
+		return false
+	}
+
+	p := conn.Process().Profile()
+	if p.BlockICMP() {
+		conn.Deny("ICMP blocked", profile.CfgOptionBlockICMPKey)
+		return true
+	}
+
+	return false
+}
+
+func checkICMPHeuristics(ctx context.Context, conn *network.Connection, pkt packet.Packet) bool {
+	if pkt == nil || !pkt.Info().IsICMPEcho() {
+		return false
+	}
+
+	p := conn.Process().Profile()
+	if !p.ICMPHeuristics() {
+		return false
+	}
+
+	if suspicious, reason := checkICMPTunnel(pkt); suspicious {
+		log.Tracer(ctx).Debugf("filter: possible ICMP tunnel by %s: %s", conn.Process(), reason)
+		conn.Block("possible ICMP tunnel for covert communication and protection bypassing", profile.CfgOptionICMPHeuristicsKey)
+		return true
+	}
+
+	return false
+}
+
+// icmpEchoHandler handles the packets of accepted ICMP echo connections. As
+// a tunnel may start with regular echo messages, every packet is checked and
+// the verdict is never made permanent.
+func icmpEchoHandler(conn *network.Connection, pkt packet.Packet) {
+	// Re-evaluate the connection if its profile changed in the meantime.
+	if filterEnabled() && profileChanged(conn) {
+		DecideOnConnection(pkt.Ctx(), conn, pkt)
+	}
+
+	if layeredProfile := conn.Process().Profile(); layeredProfile != nil &&
+		conn.Verdict == network.VerdictAccept {
+		layeredProfile.LockForUsage()
+		checkICMPHeuristics(pkt.Ctx(), conn, pkt)
+		layeredProfile.UnlockForUsage()
+	}
+
+	// Make the verdict permanent once the connection is not accepted anymore.
+	if conn.Verdict != network.VerdictAccept {
+		conn.StopFirewallHandler()
+		issueVerdict(conn, pkt, 0, true)
+		return
+	}
+	issueVerdict(conn, pkt, 0, false)
+}
+
+// needsICMPEchoHandler returns whether the packets of the connection must be
+// handled by the icmpEchoHandler.
+func needsICMPEchoHandler(conn *network.Connection, pkt packet.Packet) bool {
+	if !pkt.Info().IsICMPEcho() || conn.Verdict != network.VerdictAccept {
+		return false
+	}
+
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return false
+	}
+	layeredProfile.LockForUsage()
+	defer layeredProfile.UnlockForUsage()
+
+	return layeredProfile.ICMPHeuristics()
+}
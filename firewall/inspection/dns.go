@@ -0,0 +1,148 @@
+package inspection
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	dnsHeaderLen = 12
+
+	dnsFlagResponse = 0x8000
+	dnsOpcodeMask   = 0x7800
+
+	dnsClassINET = 1
+	dnsTypeOPT   = 41
+
+	dnsMaxNameLen  = 255
+	dnsMaxLabelLen = 63
+)
+
+// ErrNotDNS is returned when the data is not a DNS query.
+var ErrNotDNS = errors.New("not a DNS query")
+
+// DNSQuery holds the question of a DNS query in wire format.
+type DNSQuery struct {
+	// Name is the queried name in lower case, as an FQDN.
+	Name string
+	// Type is the queried record type.
+	Type uint16
+}
+
+// ParseDNSQuery parses the DNS query in wire format at the start of the
+// given data. Set tcp if the data is a TCP stream, where messages are
+// prefixed with their length. It returns ErrIncomplete if the TCP stream
+// does not contain the full message yet, and ErrNotDNS if the data is not
+// a standard query with a single question.
+//
+// Only queries as sent by stub resolvers are accepted, which makes it
+// unlikely that other protocols are mistaken for DNS.
+func ParseDNSQuery(data []byte, tcp bool) (*DNSQuery, error) {
+	if tcp {
+		if len(data) < 2 {
+			return nil, ErrIncomplete
+		}
+		messageLen := int(data[0])<<8 | int(data[1])
+		if messageLen < dnsHeaderLen {
+			return nil, ErrNotDNS
+		}
+		if len(data) < 2+messageLen {
+			// Check the header early, so that other protocols are not
+			// buffered.
+			if len(data) >= 2+dnsHeaderLen && !validDNSQueryHeader(data[2:]) {
+				return nil, ErrNotDNS
+			}
+			return nil, ErrIncomplete
+		}
+		data = data[2 : 2+messageLen]
+	}
+
+	if len(data) < dnsHeaderLen || !validDNSQueryHeader(data) {
+		return nil, ErrNotDNS
+	}
+	additional := int(data[10])<<8 | int(data[11])
+
+	r := &tlsReader{data: data[dnsHeaderLen:]}
+	name, ok := readDNSName(r)
+	if !ok {
+		return nil, ErrNotDNS
+	}
+	query := &DNSQuery{
+		Name: name,
+		Type: r.uint16(),
+	}
+	if r.uint16() != dnsClassINET {
+		return nil, ErrNotDNS
+	}
+
+	// The only additional record of a query is the EDNS OPT record.
+	if additional == 1 {
+		if r.uint8() != 0 || r.uint16() != dnsTypeOPT {
+			return nil, ErrNotDNS
+		}
+		r.skip(2 + 4) // Class and TTL
+		r.skip(int(r.uint16()))
+	}
+
+	// A query must not have trailing data.
+	if r.err != nil || !r.empty() {
+		return nil, ErrNotDNS
+	}
+	return query, nil
+}
+
+// validDNSQueryHeader checks if the given header is the header of a standard
+// query with a single question and at most one additional record.
+func validDNSQueryHeader(header []byte) bool {
+	flags := int(header[2])<<8 | int(header[3])
+	questions := int(header[4])<<8 | int(header[5])
+	answers := int(header[6])<<8 | int(header[7])
+	authorities := int(header[8])<<8 | int(header[9])
+	additional := int(header[10])<<8 | int(header[11])
+
+	return flags&dnsFlagResponse == 0 &&
+		flags&dnsOpcodeMask == 0 &&
+		questions == 1 &&
+		answers == 0 &&
+		authorities == 0 &&
+		additional <= 1
+}
+
+// readDNSName reads an uncompressed domain name. Queries do not use name
+// compression.
+func readDNSName(r *tlsReader) (name string, ok bool) {
+	var labels []string
+	nameLen := 0
+	for {
+		labelLen := int(r.uint8())
+		if r.err != nil || labelLen > dnsMaxLabelLen {
+			return "", false
+		}
+		if labelLen == 0 {
+			break
+		}
+
+		label := r.bytes(labelLen)
+		if label == nil || !validDNSLabel(label) {
+			return "", false
+		}
+		nameLen += labelLen + 1
+		if nameLen > dnsMaxNameLen {
+			return "", false
+		}
+		labels = append(labels, strings.ToLower(string(label)))
+	}
+
+	return strings.Join(labels, ".") + ".", true
+}
+
+// validDNSLabel checks if the label only contains characters that are used
+// in host names.
+func validDNSLabel(label []byte) bool {
+	for _, c := range label {
+		if !isAlphanumeric(c) && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package inspection
+
+import (
+	"testing"
+)
+
+func TestParseDNSQuery(t *testing.T) {
+	// Query for example.com A with an EDNS OPT record.
+	query := []byte{
+		0x12, 0x34, // ID
+		0x01, 0x20, // Flags: RD, AD
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, // Counts
+		7, 'E', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0x00, 0x01, // Type A
+		0x00, 0x01, // Class IN
+		0,          // OPT: root name
+		0x00, 0x29, // Type OPT
+		0x10, 0x00, // UDP payload size
+		0x00, 0x00, 0x00, 0x00, // Extended RCODE and flags
+		0x00, 0x00, // No options
+	}
+
+	parsed, err := ParseDNSQuery(query, false)
+	if err != nil {
+		t.Fatalf("failed to parse query: %s", err)
+	}
+	if parsed.Name != "example.com." || parsed.Type != 1 {
+		t.Errorf("unexpected query %+v", parsed)
+	}
+
+	// TCP with length prefix.
+	stream := append([]byte{0, byte(len(query))}, query...)
+	if _, err := ParseDNSQuery(stream[:20], true); err != ErrIncomplete {
+		t.Errorf("expected ErrIncomplete for partial TCP message, got %v", err)
+	}
+	if _, err := ParseDNSQuery(stream, true); err != nil {
+		t.Errorf("failed to parse TCP query: %s", err)
+	}
+
+	// Responses are not queries.
+	response := append([]byte{}, query...)
+	response[2] |= 0x80
+	if _, err := ParseDNSQuery(response, false); err != ErrNotDNS {
+		t.Errorf("expected ErrNotDNS for response, got %v", err)
+	}
+
+	// Trailing data.
+	if _, err := ParseDNSQuery(append(query, 0), false); err != ErrNotDNS {
+		t.Errorf("expected ErrNotDNS for trailing data, got %v", err)
+	}
+
+	// Other protocols.
+	for _, data := range [][]byte{
+		[]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+		{0x16, 0x03, 0x01, 0x02, 0x00, 0x01, 0x00, 0x01, 0xfc, 0x03, 0x03, 0x00, 0x00},
+		make([]byte, 100),
+	} {
+		if _, err := ParseDNSQuery(data, false); err != ErrNotDNS {
+			t.Errorf("expected ErrNotDNS for %q, got %v", data, err)
+		}
+	}
+}
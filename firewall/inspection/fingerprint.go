@@ -0,0 +1,181 @@
+package inspection
+
+import (
+	"crypto/md5" //nolint:gosec // JA3 is defined to use MD5.
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isGREASE returns whether the given value is a GREASE value. Clients send
+// random GREASE values to keep servers tolerant to unknown values, so they
+// are ignored for fingerprinting. See RFC 8701.
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	filtered := make([]uint16, 0, len(values))
+	for _, value := range values {
+		if !isGREASE(value) {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
+
+// JA3 returns the JA3 fingerprint of the ClientHello, which is the MD5 hash
+// of its JA3 string.
+func (hello *ClientHello) JA3() string {
+	sum := md5.Sum([]byte(hello.JA3String())) //nolint:gosec // See import.
+	return hex.EncodeToString(sum[:])
+}
+
+// JA3String returns the JA3 string of the ClientHello. It consists of the
+// decimal values of the version, cipher suites, extensions, supported groups
+// and EC point formats.
+func (hello *ClientHello) JA3String() string {
+	pointFormats := make([]uint16, 0, len(hello.ECPointFormats))
+	for _, format := range hello.ECPointFormats {
+		pointFormats = append(pointFormats, uint16(format))
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(hello.Version)),
+		joinDecimal(withoutGREASE(hello.CipherSuites)),
+		joinDecimal(withoutGREASE(hello.Extensions)),
+		joinDecimal(withoutGREASE(hello.SupportedGroups)),
+		joinDecimal(pointFormats),
+	}, ",")
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello. It is made up of a
+// readable summary of the ClientHello, a truncated hash of the sorted cipher
+// suites and a truncated hash of the sorted extensions and the signature
+// algorithms. Set quic if the ClientHello was sent using QUIC.
+func (hello *ClientHello) JA4(quic bool) string {
+	ciphers := withoutGREASE(hello.CipherSuites)
+	extensions := withoutGREASE(hello.Extensions)
+
+	// Summary.
+	protocol := "t"
+	if quic {
+		protocol = "q"
+	}
+	sni := "i"
+	if hello.SNI != "" {
+		sni = "d"
+	}
+	summary := fmt.Sprintf(
+		"%s%s%s%02d%02d%s",
+		protocol,
+		ja4Version(hello),
+		sni,
+		min99(len(ciphers)),
+		min99(len(extensions)),
+		ja4ALPN(hello),
+	)
+
+	// Cipher suites.
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+
+	// Extensions, without SNI and ALPN, as they are part of the summary.
+	hashedExtensions := make([]uint16, 0, len(extensions))
+	for _, extension := range extensions {
+		if extension != tlsExtensionServerName && extension != tlsExtensionALPN {
+			hashedExtensions = append(hashedExtensions, extension)
+		}
+	}
+	sort.Slice(hashedExtensions, func(i, j int) bool { return hashedExtensions[i] < hashedExtensions[j] })
+	extensionsHash := "000000000000"
+	if len(hashedExtensions) > 0 {
+		value := joinHex(hashedExtensions)
+		if len(hello.SignatureAlgorithms) > 0 {
+			value += "_" + joinHex(hello.SignatureAlgorithms)
+		}
+		extensionsHash = ja4Hash(value)
+	}
+
+	ciphersHash := "000000000000"
+	if len(ciphers) > 0 {
+		ciphersHash = ja4Hash(joinHex(ciphers))
+	}
+
+	return summary + "_" + ciphersHash + "_" + extensionsHash
+}
+
+// ja4Version returns the highest offered TLS version in JA4 notation.
+func ja4Version(hello *ClientHello) string {
+	version := hello.Version
+	for _, supported := range withoutGREASE(hello.SupportedVersions) {
+		if supported > version {
+			version = supported
+		}
+	}
+
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN returns the first and last character of the first offered ALPN
+// protocol, or the hex representation if they are not alphanumeric.
+func ja4ALPN(hello *ClientHello) string {
+	if len(hello.ALPN) == 0 || hello.ALPN[0] == "" {
+		return "00"
+	}
+
+	alpn := hello.ALPN[0]
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	hexALPN := hex.EncodeToString([]byte(alpn))
+	return hexALPN[:1] + hexALPN[len(hexALPN)-1:]
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+func ja4Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func joinDecimal(values []uint16) string {
+	formatted := make([]string, 0, len(values))
+	for _, value := range values {
+		formatted = append(formatted, strconv.Itoa(int(value)))
+	}
+	return strings.Join(formatted, "-")
+}
+
+func joinHex(values []uint16) string {
+	formatted := make([]string, 0, len(values))
+	for _, value := range values {
+		formatted = append(formatted, fmt.Sprintf("%04x", value))
+	}
+	return strings.Join(formatted, ",")
+}
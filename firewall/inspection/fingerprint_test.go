@@ -0,0 +1,56 @@
+package inspection
+
+import (
+	"testing"
+)
+
+func TestFingerprints(t *testing.T) {
+	// The ClientHello of the JA4 documentation, with GREASE values and
+	// shuffled extensions, as sent by Chrome.
+	hello := &ClientHello{
+		Version: 0x0303,
+		SNI:     "example.com",
+		ALPN:    []string{"h2", "http/1.1"},
+		CipherSuites: []uint16{
+			0x2a2a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030,
+			0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		Extensions: []uint16{
+			0x3a3a, 0x0033, 0x002b, 0x0000, 0x000d, 0x0017, 0x4469, 0x0012, 0x0005,
+			0xff01, 0x000b, 0x0023, 0x0010, 0x000a, 0x001b, 0x002d, 0x0015, 0xdada,
+		},
+		SupportedGroups:     []uint16{0x4a4a, 0x001d, 0x0017, 0x0018},
+		ECPointFormats:      []uint8{0},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+		SupportedVersions:   []uint16{0x6a6a, 0x0304, 0x0303},
+	}
+
+	if ja4 := hello.JA4(false); ja4 != "t13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Errorf("unexpected JA4 %s", ja4)
+	}
+	if ja4 := hello.JA4(true); ja4 != "q13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Errorf("unexpected JA4 for QUIC %s", ja4)
+	}
+
+	expectedJA3 := "771," +
+		"4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+		"51-43-0-13-23-17513-18-5-65281-11-35-16-10-27-45-21," +
+		"29-23-24," +
+		"0"
+	if ja3 := hello.JA3String(); ja3 != expectedJA3 {
+		t.Errorf("unexpected JA3 string %s", ja3)
+	}
+	if ja3 := hello.JA3(); len(ja3) != 32 {
+		t.Errorf("unexpected JA3 %s", ja3)
+	}
+
+	// Minimal ClientHello of an old client without extensions.
+	hello = &ClientHello{
+		Version:      0x0301,
+		CipherSuites: []uint16{0x0035},
+		ALPN:         []string{"\x01x"},
+	}
+	if ja4 := hello.JA4(false); ja4 != "t10i010008_"+ja4Hash("0035")+"_000000000000" {
+		t.Errorf("unexpected JA4 %s", ja4)
+	}
+}
@@ -0,0 +1,118 @@
+package inspection
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrNotHTTP is returned when the data is not an HTTP/1.x request.
+	ErrNotHTTP = errors.New("not an HTTP request")
+
+	httpMethods = []string{
+		"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH",
+	}
+
+	httpHeaderEnd = []byte("\r\n\r\n")
+)
+
+// HTTPRequest holds the information parsed from the head of an HTTP/1.x
+// request.
+type HTTPRequest struct {
+	// Method is the request method, eg. GET.
+	Method string
+	// Host is the value of the Host header, or the host of the request
+	// target if it is in absolute form. It does not include the port.
+	Host string
+	// Path is the path of the request target, without the query.
+	Path string
+	// Query is the raw query of the request target.
+	Query string
+	// ContentType is the media type of the Content-Type header, without
+	// parameters.
+	ContentType string
+	// Accept is the value of the Accept header.
+	Accept string
+}
+
+// ParseHTTPRequest parses the head of the HTTP/1.x request at the start of
+// the given stream. It returns ErrIncomplete if the head is not fully
+// contained in data yet, and ErrNotHTTP if the stream does not start with an
+// HTTP request.
+func ParseHTTPRequest(data []byte) (*HTTPRequest, error) {
+	if !hasHTTPMethod(data) {
+		return nil, ErrNotHTTP
+	}
+
+	end := bytes.Index(data, httpHeaderEnd)
+	if end < 0 {
+		return nil, ErrIncomplete
+	}
+	lines := strings.Split(string(data[:end]), "\r\n")
+
+	// Parse the request line.
+	requestLine := strings.Split(lines[0], " ")
+	if len(requestLine) != 3 || !strings.HasPrefix(requestLine[2], "HTTP/1.") {
+		return nil, ErrNotHTTP
+	}
+	target, err := url.ParseRequestURI(requestLine[1])
+	if err != nil {
+		return nil, ErrNotHTTP
+	}
+	request := &HTTPRequest{
+		Method: requestLine[0],
+		Host:   target.Hostname(),
+		Path:   target.Path,
+		Query:  target.RawQuery,
+	}
+
+	for _, line := range lines[1:] {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, ErrNotHTTP
+		}
+		value := strings.TrimSpace(line[colon+1:])
+
+		switch strings.ToLower(line[:colon]) {
+		case "host":
+			// The Host header is ignored if the request target is in
+			// absolute form. See RFC 7230, Section 5.4.
+			if request.Host != "" {
+				continue
+			}
+			hostURL, err := url.Parse("//" + value)
+			if err != nil {
+				return nil, ErrNotHTTP
+			}
+			request.Host = hostURL.Hostname()
+		case "content-type":
+			mediaType := strings.Split(value, ";")[0]
+			request.ContentType = strings.ToLower(strings.TrimSpace(mediaType))
+		case "accept":
+			request.Accept = strings.ToLower(value)
+		}
+	}
+
+	request.Host = strings.ToLower(request.Host)
+	return request, nil
+}
+
+// hasHTTPMethod returns whether data starts with a known HTTP method. It
+// also returns true if data could still become one.
+func hasHTTPMethod(data []byte) bool {
+	for _, method := range httpMethods {
+		prefix := method + " "
+		if len(data) < len(prefix) {
+			if strings.HasPrefix(prefix, string(data)) {
+				return true
+			}
+			continue
+		}
+		if string(data[:len(prefix)]) == prefix {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package inspection
+
+import (
+	"testing"
+)
+
+func TestParseHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		data    string
+		method  string
+		host    string
+		path    string
+		wantErr error
+	}{
+		{
+			data:   "GET /firmware/latest.bin?model=x1 HTTP/1.1\r\nHost: Updates.Example.com\r\nUser-Agent: test\r\n\r\n",
+			method: "GET",
+			host:   "updates.example.com",
+			path:   "/firmware/latest.bin",
+		},
+		{
+			data:   "POST /api HTTP/1.0\r\nhost: 192.0.2.1:8080\r\n\r\nbody",
+			method: "POST",
+			host:   "192.0.2.1",
+			path:   "/api",
+		},
+		{
+			// The host of an absolute request target takes precedence.
+			data:   "GET http://proxied.example.com/index.html HTTP/1.1\r\nHost: other.example.com\r\n\r\n",
+			method: "GET",
+			host:   "proxied.example.com",
+			path:   "/index.html",
+		},
+		{
+			data:   "GET / HTTP/1.1\r\n\r\n",
+			method: "GET",
+			path:   "/",
+		},
+		{data: "", wantErr: ErrIncomplete},
+		{data: "GE", wantErr: ErrIncomplete},
+		{data: "GET / HTTP/1.1\r\nHost: example.com\r\n", wantErr: ErrIncomplete},
+		{data: "SSH-2.0-OpenSSH_8.2\r\n", wantErr: ErrNotHTTP},
+		{data: "\x16\x03\x01\x02\x00\x01", wantErr: ErrNotHTTP},
+		{data: "GET / SPDY/3\r\n\r\n", wantErr: ErrNotHTTP},
+		{data: "GET / HTTP/1.1\r\nbroken header\r\n\r\n", wantErr: ErrNotHTTP},
+	}
+
+	for _, tc := range testCases {
+		request, err := ParseHTTPRequest([]byte(tc.data))
+		if err != tc.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tc.data, tc.wantErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if request.Method != tc.method || request.Host != tc.host || request.Path != tc.path {
+			t.Errorf("%q: unexpected result %+v", tc.data, request)
+		}
+	}
+}
+
+func TestParseHTTPRequestHeaders(t *testing.T) {
+	request, err := ParseHTTPRequest([]byte("POST /dns-query?ct HTTP/1.1\r\nHost: dns.example.com\r\nContent-Type: Application/DNS-Message; charset=binary\r\nAccept: application/dns-message\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Query != "ct" ||
+		request.ContentType != "application/dns-message" ||
+		request.Accept != "application/dns-message" {
+		t.Errorf("unexpected result %+v", request)
+	}
+}
@@ -1,102 +1,171 @@
 package inspection
 
 import (
+	"fmt"
 	"sync"
 
+	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/packet"
 )
 
-//nolint:golint,stylecheck // FIXME
+// Action is the action an inspector requests after inspecting a packet.
+type Action uint8
+
+// Inspector Actions
 const (
-	DO_NOTHING uint8 = iota
-	BLOCK_PACKET
-	DROP_PACKET
-	BLOCK_CONN
-	DROP_CONN
-	STOP_INSPECTING
+	// ActionContinue accepts the packet and continues inspection.
+	ActionContinue Action = iota
+	// ActionBlockPacket blocks the packet and continues inspection.
+	ActionBlockPacket
+	// ActionDropPacket drops the packet and continues inspection.
+	ActionDropPacket
+	// ActionBlockConn blocks the connection and stops the inspector.
+	ActionBlockConn
+	// ActionDropConn drops the connection and stops the inspector.
+	ActionDropConn
+	// ActionStop stops the inspector without changing the verdict.
+	ActionStop
 )
 
-type inspectorFn func(*network.Connection, packet.Packet) uint8
+// Inspector inspects the traffic of connections.
+type Inspector interface {
+	// Name returns the name of the inspector.
+	Name() string
+
+	// Applies returns whether the inspector wants to inspect the given
+	// connection. It is called with the locked connection before the
+	// first packet is inspected.
+	Applies(conn *network.Connection) bool
+
+	// Inspect inspects the data the client sent on the connection. For TCP
+	// connections, data is the reassembled stream from its start, for other
+	// protocols it is the payload of the packet. Inspect is only called when
+	// new data is available and is called with the locked connection.
+	Inspect(conn *network.Connection, pkt packet.Packet, data []byte) (Action, error)
+}
+
+type registeredInspector struct {
+	Inspector
+	inspectVerdict network.Verdict
+}
 
 var (
-	inspectors      []inspectorFn
-	inspectorNames  []string
-	inspectVerdicts []network.Verdict
-	inspectorsLock  sync.Mutex
+	inspectors     []*registeredInspector
+	inspectorsLock sync.Mutex
 )
 
-// RegisterInspector registers a traffic inspector.
-func RegisterInspector(name string, inspector inspectorFn, inspectVerdict network.Verdict) (index int) {
+// RegisterInspector registers a traffic inspector. The inspector is only run
+// on connections whose verdict is not higher than the given inspectVerdict.
+func RegisterInspector(inspector Inspector, inspectVerdict network.Verdict) (index int) {
 	inspectorsLock.Lock()
 	defer inspectorsLock.Unlock()
+
 	index = len(inspectors)
-	inspectors = append(inspectors, inspector)
-	inspectorNames = append(inspectorNames, name)
-	inspectVerdicts = append(inspectVerdicts, inspectVerdict)
+	inspectors = append(inspectors, &registeredInspector{
+		Inspector:      inspector,
+		inspectVerdict: inspectVerdict,
+	})
 	return
 }
 
+func getInspectors() []*registeredInspector {
+	inspectorsLock.Lock()
+	defer inspectorsLock.Unlock()
+
+	return inspectors
+}
+
+// ShouldInspect returns whether any of the registered inspectors applies to
+// the given connection.
+func ShouldInspect(conn *network.Connection) bool {
+	for _, inspector := range getInspectors() {
+		if conn.Verdict <= inspector.inspectVerdict && inspector.Applies(conn) {
+			return true
+		}
+	}
+	return false
+}
+
 // RunInspectors runs all the applicable inspectors on the given packet.
-func RunInspectors(conn *network.Connection, pkt packet.Packet) (network.Verdict, bool) {
-	// inspectorsLock.Lock()
-	// defer inspectorsLock.Unlock()
+func RunInspectors(conn *network.Connection, pkt packet.Packet) (verdict network.Verdict, continueInspection bool) {
+	registered := getInspectors()
 
 	activeInspectors := conn.GetActiveInspectors()
 	if activeInspectors == nil {
-		activeInspectors = make([]bool, len(inspectors))
+		activeInspectors = make([]bool, len(registered))
+		for key, inspector := range registered {
+			activeInspectors[key] = !inspector.Applies(conn)
+		}
 		conn.SetActiveInspectors(activeInspectors)
 	}
 
-	inspectorData := conn.GetInspectorData()
-	if inspectorData == nil {
-		inspectorData = make(map[uint8]interface{})
-		conn.SetInspectorData(inspectorData)
+	if conn.GetInspectorData() == nil {
+		conn.SetInspectorData(make(map[uint8]interface{}))
 	}
 
-	continueInspection := false
-	verdict := network.VerdictUndecided
-
-	for key, skip := range activeInspectors {
+	// Get the new data of the client.
+	data, err := getData(conn, pkt)
+	if err != nil {
+		log.Tracer(pkt.Ctx()).Debugf("filter: stopping inspection of %s: %s", conn, err)
+		return network.VerdictUndecided, false
+	}
 
-		if skip {
+	verdict = network.VerdictAccept
+	for key, done := range activeInspectors {
+		if done {
 			continue
 		}
+		inspector := registered[key]
 
 		// check if the current verdict is already past the inspection criteria.
-		if conn.Verdict > inspectVerdicts[key] {
+		if conn.Verdict > inspector.inspectVerdict {
+			activeInspectors[key] = true
+			continue
+		}
+
+		// Wait for new data.
+		if data == nil {
+			continueInspection = true
+			continue
+		}
+
+		action, err := inspector.Inspect(conn, pkt, data) // Actually run inspector
+		if err != nil {
+			log.Tracer(pkt.Ctx()).Debugf("filter: %s inspector failed on %s: %s", inspector.Name(), conn, err)
 			activeInspectors[key] = true
 			continue
 		}
 
-		action := inspectors[key](conn, pkt) // Actually run inspector
 		switch action {
-		case DO_NOTHING:
-			if verdict < network.VerdictAccept {
-				verdict = network.VerdictAccept
-			}
+		case ActionContinue:
 			continueInspection = true
-		case BLOCK_PACKET:
+		case ActionBlockPacket:
 			if verdict < network.VerdictBlock {
 				verdict = network.VerdictBlock
 			}
 			continueInspection = true
-		case DROP_PACKET:
+		case ActionDropPacket:
 			verdict = network.VerdictDrop
 			continueInspection = true
-		case BLOCK_CONN:
-			conn.SetVerdict(network.VerdictBlock, "", "", nil)
-			verdict = conn.Verdict
+		case ActionBlockConn:
+			// The inspector may have already set the verdict with a better reason.
+			if conn.Verdict < network.VerdictBlock {
+				conn.SetVerdict(network.VerdictBlock, fmt.Sprintf("blocked by %s inspector", inspector.Name()), "", nil)
+			}
 			activeInspectors[key] = true
-		case DROP_CONN:
-			conn.SetVerdict(network.VerdictDrop, "", "", nil)
-			verdict = conn.Verdict
+		case ActionDropConn:
+			if conn.Verdict < network.VerdictDrop {
+				conn.SetVerdict(network.VerdictDrop, fmt.Sprintf("dropped by %s inspector", inspector.Name()), "", nil)
+			}
 			activeInspectors[key] = true
-		case STOP_INSPECTING:
+		case ActionStop:
 			activeInspectors[key] = true
 		}
-
 	}
 
+	if verdict < conn.Verdict {
+		verdict = conn.Verdict
+	}
 	return verdict, continueInspection
 }
@@ -0,0 +1,321 @@
+package inspection
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	quicVersion1 = 0x00000001
+
+	quicLongHeaderForm    = 0x80
+	quicFixedBit          = 0x40
+	quicPacketTypeInitial = 0
+
+	quicFramePadding = 0x00
+	quicFramePing    = 0x01
+	quicFrameAck     = 0x02
+	quicFrameAckECN  = 0x03
+	quicFrameCrypto  = 0x06
+
+	// quicMaxCryptoSize limits the amount of crypto data that is reassembled.
+	quicMaxCryptoSize = 65536
+)
+
+var (
+	// ErrNotQUIC is returned when the data is not a valid QUIC Initial packet.
+	ErrNotQUIC = errors.New("not a QUIC Initial packet")
+	// ErrUnsupportedQUICVersion is returned for QUIC versions whose Initial
+	// packets cannot be decrypted.
+	ErrUnsupportedQUICVersion = errors.New("unsupported QUIC version")
+
+	// quicV1InitialSalt is the salt for deriving the Initial secrets of QUIC
+	// version 1. See RFC 9001, Section 5.2.
+	quicV1InitialSalt = []byte{
+		0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+	}
+)
+
+// QUICInitialAssembler decrypts the Initial packets a QUIC client sends and
+// reassembles the ClientHello from their CRYPTO frames. The ClientHello may
+// span multiple Initial packets.
+type QUICInitialAssembler struct {
+	// Packets holds the number of Initial packets that were added.
+	Packets int
+
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+
+	fragments  []quicCryptoFragment
+	crypto     []byte
+	cryptoSize int
+}
+
+type quicCryptoFragment struct {
+	offset uint64
+	data   []byte
+}
+
+// Add adds the QUIC packets of a UDP datagram sent by the client and returns
+// the ClientHello once it is complete. It returns ErrIncomplete if more
+// packets are needed, ErrUnsupportedQUICVersion if the client uses a QUIC
+// version other than 1 and ErrNotQUIC if the data is not QUIC.
+func (qa *QUICInitialAssembler) Add(datagram []byte) (*ClientHello, error) {
+	// A datagram may contain multiple coalesced packets.
+	for len(datagram) > 0 && datagram[0]&quicLongHeaderForm != 0 {
+		packetLen, err := qa.addPacket(datagram)
+		if err != nil {
+			return nil, err
+		}
+		datagram = datagram[packetLen:]
+	}
+
+	if qa.Packets == 0 {
+		return nil, ErrNotQUIC
+	}
+	return qa.clientHello()
+}
+
+// addPacket decrypts the long header packet at the start of data and returns
+// its length.
+func (qa *QUICInitialAssembler) addPacket(data []byte) (packetLen int, err error) {
+	r := &tlsReader{data: data}
+	firstByte := r.uint8()
+	version := r.uint32()
+	dcid := r.bytes(int(r.uint8()))
+	r.skip(int(r.uint8())) // Source Connection ID
+	switch {
+	case r.err != nil:
+		return 0, ErrNotQUIC
+	case version != quicVersion1:
+		return 0, ErrUnsupportedQUICVersion
+	case firstByte&quicFixedBit == 0:
+		return 0, ErrNotQUIC
+	}
+
+	packetType := (firstByte >> 4) & 0x03
+	if packetType == quicPacketTypeInitial {
+		r.skip(int(r.varint())) // Token
+	}
+	length := int(r.varint())
+	if r.err != nil || length < 0 || len(r.data) < length {
+		return 0, ErrNotQUIC
+	}
+	pnOffset := len(data) - len(r.data)
+	packetLen = pnOffset + length
+
+	// Only Initial packets can be decrypted without the handshake.
+	if packetType != quicPacketTypeInitial {
+		return packetLen, nil
+	}
+
+	// Derive the keys from the destination connection ID of the first
+	// Initial packet of the client.
+	if qa.aead == nil {
+		if err := qa.deriveKeys(dcid); err != nil {
+			return 0, err
+		}
+	}
+
+	payload, err := qa.decrypt(data[:packetLen], pnOffset)
+	if err != nil {
+		return 0, err
+	}
+	if err := qa.addFrames(payload); err != nil {
+		return 0, err
+	}
+
+	qa.Packets++
+	return packetLen, nil
+}
+
+// deriveKeys derives the keys that protect the Initial packets of the
+// client. See RFC 9001, Section 5.2.
+func (qa *QUICInitialAssembler) deriveKeys(dcid []byte) error {
+	initialSecret := hkdfExtract(quicV1InitialSalt, dcid)
+	clientSecret := hkdfExpandLabel(initialSecret, "client in", sha256.Size)
+
+	block, err := aes.NewCipher(hkdfExpandLabel(clientSecret, "quic key", 16))
+	if err != nil {
+		return err
+	}
+	qa.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	qa.iv = hkdfExpandLabel(clientSecret, "quic iv", qa.aead.NonceSize())
+	qa.hp, err = aes.NewCipher(hkdfExpandLabel(clientSecret, "quic hp", 16))
+	return err
+}
+
+// decrypt removes the header protection and decrypts the payload of the
+// given Initial packet. See RFC 9001, Sections 5.3 and 5.4.
+func (qa *QUICInitialAssembler) decrypt(packet []byte, pnOffset int) ([]byte, error) {
+	// The sample starts four bytes after the start of the packet number.
+	sampleOffset := pnOffset + 4
+	if len(packet) < sampleOffset+aes.BlockSize {
+		return nil, ErrNotQUIC
+	}
+	mask := make([]byte, aes.BlockSize)
+	qa.hp.Encrypt(mask, packet[sampleOffset:sampleOffset+aes.BlockSize])
+
+	// Work on a copy, the packet must not be modified.
+	header := make([]byte, pnOffset+4)
+	copy(header, packet)
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	header = header[:pnOffset+pnLen]
+
+	nonce := make([]byte, len(qa.iv))
+	copy(nonce, qa.iv)
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		nonce[len(nonce)-pnLen+i] ^= header[pnOffset+i]
+	}
+
+	payload, err := qa.aead.Open(nil, nonce, packet[pnOffset+pnLen:], header)
+	if err != nil {
+		return nil, ErrNotQUIC
+	}
+	return payload, nil
+}
+
+// addFrames collects the CRYPTO frames from the given decrypted payload.
+func (qa *QUICInitialAssembler) addFrames(payload []byte) error {
+	r := &tlsReader{data: payload}
+	for r.err == nil && !r.empty() {
+		switch frameType := r.varint(); frameType {
+		case quicFramePadding, quicFramePing:
+		case quicFrameAck, quicFrameAckECN:
+			r.varint() // Largest Acknowledged
+			r.varint() // ACK Delay
+			ranges := r.varint()
+			r.varint() // First ACK Range
+			for i := uint64(0); i < ranges && r.err == nil; i++ {
+				r.varint() // Gap
+				r.varint() // ACK Range Length
+			}
+			if frameType == quicFrameAckECN {
+				r.varint() // ECT0 Count
+				r.varint() // ECT1 Count
+				r.varint() // ECN-CE Count
+			}
+		case quicFrameCrypto:
+			offset := r.varint()
+			data := r.bytes(int(r.varint()))
+			if r.err != nil {
+				break
+			}
+			qa.cryptoSize += len(data)
+			if qa.cryptoSize > quicMaxCryptoSize {
+				return ErrNotQUIC
+			}
+			qa.fragments = append(qa.fragments, quicCryptoFragment{
+				offset: offset,
+				data:   data,
+			})
+		default:
+			// Other frames, including CONNECTION_CLOSE, are not expected
+			// in the first Initial packets of a client.
+			return ErrNotQUIC
+		}
+	}
+
+	if r.err != nil {
+		return ErrNotQUIC
+	}
+	return nil
+}
+
+// clientHello reassembles the crypto stream and parses the ClientHello.
+func (qa *QUICInitialAssembler) clientHello() (*ClientHello, error) {
+	// Append all fragments that continue the stream, they may arrive in
+	// any order.
+	for progress := true; progress; {
+		progress = false
+		for _, fragment := range qa.fragments {
+			end := fragment.offset + uint64(len(fragment.data))
+			if fragment.offset <= uint64(len(qa.crypto)) && end > uint64(len(qa.crypto)) {
+				qa.crypto = append(qa.crypto, fragment.data[uint64(len(qa.crypto))-fragment.offset:]...)
+				progress = true
+			}
+		}
+	}
+
+	// The crypto stream carries handshake messages without the TLS record
+	// layer.
+	if len(qa.crypto) < 4 {
+		return nil, ErrIncomplete
+	}
+	messageLen := int(qa.crypto[1])<<16 | int(qa.crypto[2])<<8 | int(qa.crypto[3])
+	if len(qa.crypto) < 4+messageLen {
+		return nil, ErrIncomplete
+	}
+
+	hello, err := parseClientHelloMessage(qa.crypto[:4+messageLen])
+	if err != nil {
+		return nil, ErrNotQUIC
+	}
+	return hello, nil
+}
+
+// hkdfExtract implements HKDF-Extract with SHA-256. See RFC 5869.
+func hkdfExtract(salt, secret []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	_, _ = mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label of TLS 1.3 with SHA-256 and
+// an empty context. See RFC 8446, Section 7.1.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	fullLabel := "tls13 " + label
+	info := make([]byte, 0, 4+len(fullLabel))
+	info = append(info, byte(length>>8), byte(length), byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, 0)
+
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(sha256.New, secret)
+		_, _ = mac.Write(t)
+		_, _ = mac.Write(info)
+		_, _ = mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+func (r *tlsReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// varint reads a QUIC variable-length integer. See RFC 9000, Section 16.
+func (r *tlsReader) varint() uint64 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	length := 1 << (b[0] >> 6)
+	value := uint64(b[0] & 0x3f)
+
+	rest := r.bytes(length - 1)
+	if rest == nil && length > 1 {
+		return 0
+	}
+	for _, c := range rest {
+		value = value<<8 | uint64(c)
+	}
+	return value
+}
@@ -0,0 +1,149 @@
+package inspection
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestQUICInitialKeys(t *testing.T) {
+	// Test vectors from RFC 9001, Appendix A.1.
+	dcid := mustDecodeHex(t, "8394c8f03e515708")
+
+	initialSecret := hkdfExtract(quicV1InitialSalt, dcid)
+	clientSecret := hkdfExpandLabel(initialSecret, "client in", 32)
+	testCases := []struct {
+		value    []byte
+		expected string
+	}{
+		{clientSecret, "c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea"},
+		{hkdfExpandLabel(clientSecret, "quic key", 16), "1f369613dd76d5467730efcbe3b1a22d"},
+		{hkdfExpandLabel(clientSecret, "quic iv", 12), "fa044b2f42a3fd3b46fb255c"},
+		{hkdfExpandLabel(clientSecret, "quic hp", 16), "9f50449e04a0e810283a1e9933adedd2"},
+	}
+	for _, tc := range testCases {
+		if hex.EncodeToString(tc.value) != tc.expected {
+			t.Errorf("expected %s, got %x", tc.expected, tc.value)
+		}
+	}
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	default:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+func cryptoFrame(offset int, data []byte) []byte {
+	frame := []byte{quicFrameCrypto}
+	frame = appendVarint(frame, uint64(offset))
+	frame = appendVarint(frame, uint64(len(data)))
+	return append(frame, data...)
+}
+
+// sealInitial builds a protected client Initial packet with the given
+// frames, using a two byte packet number.
+func sealInitial(t *testing.T, qa *QUICInitialAssembler, dcid []byte, pn uint16, frames []byte) []byte {
+	t.Helper()
+
+	// Pad to the minimum size of client Initial datagrams.
+	if len(frames) < 1162 {
+		frames = append(frames, make([]byte, 1162-len(frames))...)
+	}
+
+	header := []byte{0xc1, 0, 0, 0, 1, byte(len(dcid))}
+	header = append(header, dcid...)
+	header = append(header, 0, 0) // No source connection ID and token.
+	header = appendVarint(header, uint64(2+len(frames)+qa.aead.Overhead()))
+	pnOffset := len(header)
+	header = append(header, byte(pn>>8), byte(pn))
+
+	nonce := make([]byte, len(qa.iv))
+	copy(nonce, qa.iv)
+	nonce[len(nonce)-2] ^= byte(pn >> 8)
+	nonce[len(nonce)-1] ^= byte(pn)
+	packet := qa.aead.Seal(header, nonce, frames, header)
+
+	mask := make([]byte, aes.BlockSize)
+	qa.hp.Encrypt(mask, packet[pnOffset+4:pnOffset+4+aes.BlockSize])
+	packet[0] ^= mask[0] & 0x0f
+	packet[pnOffset] ^= mask[1]
+	packet[pnOffset+1] ^= mask[2]
+	return packet
+}
+
+func TestQUICInitialAssembler(t *testing.T) {
+	// Get a ClientHello handshake message without the record layer.
+	record := captureClientHello(t, "quic.example.com")
+	message := record[tlsRecordHeaderLen:]
+	dcid := mustDecodeHex(t, "8394c8f03e515708")
+
+	// Derive the keys for sealing.
+	sealer := &QUICInitialAssembler{}
+	if err := sealer.deriveKeys(dcid); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the ClientHello over two packets with CRYPTO frames out of
+	// order, like some browsers do.
+	split := len(message) / 2
+	first := cryptoFrame(split, message[split:split+10])
+	first = append(first, quicFramePing)
+	first = append(first, cryptoFrame(0, message[:split])...)
+	second := cryptoFrame(split+10, message[split+10:])
+
+	qa := &QUICInitialAssembler{}
+	_, err := qa.Add(sealInitial(t, sealer, dcid, 0, first))
+	if err != ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete after the first packet, got %v", err)
+	}
+	hello, err := qa.Add(sealInitial(t, sealer, dcid, 1, second))
+	if err != nil {
+		t.Fatalf("failed to get ClientHello: %s", err)
+	}
+	if hello.SNI != "quic.example.com" {
+		t.Errorf("unexpected SNI %q", hello.SNI)
+	}
+	if len(hello.ALPN) != 2 || hello.ALPN[0] != "h2" {
+		t.Errorf("unexpected ALPN %q", hello.ALPN)
+	}
+	if qa.Packets != 2 {
+		t.Errorf("expected 2 packets, got %d", qa.Packets)
+	}
+
+	// Tampered packets must not be accepted.
+	tampered := sealInitial(t, sealer, dcid, 0, cryptoFrame(0, message))
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := (&QUICInitialAssembler{}).Add(tampered); err != ErrNotQUIC {
+		t.Errorf("expected ErrNotQUIC for tampered packet, got %v", err)
+	}
+
+	// Unknown versions.
+	unknown := sealInitial(t, sealer, dcid, 0, cryptoFrame(0, message))
+	binary.BigEndian.PutUint32(unknown[1:5], 0x6b3343cf)
+	if _, err := (&QUICInitialAssembler{}).Add(unknown); err != ErrUnsupportedQUICVersion {
+		t.Errorf("expected ErrUnsupportedQUICVersion, got %v", err)
+	}
+
+	// Not QUIC.
+	if _, err := (&QUICInitialAssembler{}).Add(bytes.Repeat([]byte{0x42}, 100)); err != ErrNotQUIC {
+		t.Errorf("expected ErrNotQUIC, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package inspection
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+)
+
+const (
+	// maxStreamSize is the amount of data of a TCP stream after which the
+	// inspection is stopped. Inspectors are expected to find what they are
+	// looking for at the start of a stream.
+	maxStreamSize = 16384
+
+	// maxBufferedPages limits the amount of out-of-order data that is
+	// buffered during reassembly.
+	maxBufferedPages = 16
+)
+
+var (
+	errStreamTooLarge    = errors.New("inspection limit reached")
+	errUnsupportedPacket = errors.New("unsupported packet")
+)
+
+// stream holds the reassembly state of the TCP stream of a connection.
+type stream struct {
+	manager   *netutils.SimpleStreamAssemblerManager
+	assembler *tcpassembly.Assembler
+	// seen holds the amount of data that was already inspected.
+	seen int
+}
+
+func newStream() *stream {
+	manager := new(netutils.SimpleStreamAssemblerManager)
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(manager))
+	assembler.MaxBufferedPagesPerConnection = maxBufferedPages
+
+	return &stream{
+		manager:   manager,
+		assembler: assembler,
+	}
+}
+
+// getData returns the data the client sent on the connection. For TCP
+// connections, this is the reassembled stream. It returns nil if the packet
+// did not add any data.
+func getData(conn *network.Connection, pkt packet.Packet) ([]byte, error) {
+	// Only the data sent by the client is inspected.
+	if pkt.IsInbound() != conn.Inbound {
+		return nil, nil
+	}
+
+	raw, err := pkt.GetPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	var decoder gopacket.Decoder
+	switch pkt.Info().Version {
+	case packet.IPv4:
+		decoder = layers.LayerTypeIPv4
+	case packet.IPv6:
+		decoder = layers.LayerTypeIPv6
+	default:
+		return nil, errUnsupportedPacket
+	}
+	decoded := gopacket.NewPacket(raw, decoder, gopacket.DecodeOptions{Lazy: true})
+
+	switch transport := decoded.TransportLayer().(type) {
+	case *layers.TCP:
+		return getStreamData(conn, decoded.NetworkLayer(), transport)
+	case *layers.UDP:
+		if len(transport.Payload) == 0 {
+			return nil, nil
+		}
+		return transport.Payload, nil
+	default:
+		return nil, errUnsupportedPacket
+	}
+}
+
+func getStreamData(conn *network.Connection, ip gopacket.NetworkLayer, tcp *layers.TCP) ([]byte, error) {
+	if ip == nil {
+		return nil, errUnsupportedPacket
+	}
+
+	s, ok := conn.GetInspectorStream().(*stream)
+	if !ok {
+		s = newStream()
+		conn.SetInspectorStream(s)
+	}
+
+	s.assembler.AssembleWithTimestamp(ip.NetworkFlow(), tcp, time.Now())
+	assembled := s.manager.GetLastAssembler()
+	if assembled == nil || assembled.CumulatedLen == s.seen {
+		return nil, nil
+	}
+	if assembled.CumulatedLen > maxStreamSize {
+		return nil, errStreamTooLarge
+	}
+
+	s.seen = assembled.CumulatedLen
+	return assembled.Cumulated, nil
+}
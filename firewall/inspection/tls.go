@@ -0,0 +1,249 @@
+package inspection
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	tlsRecordHeaderLen     = 5
+	tlsRecordTypeHandshake = 22
+
+	tlsHandshakeTypeClientHello = 1
+
+	tlsExtensionServerName          = 0
+	tlsServerNameTypeHost           = 0
+	tlsExtensionSupportedGroups     = 10
+	tlsExtensionECPointFormats      = 11
+	tlsExtensionSignatureAlgorithms = 13
+	tlsExtensionALPN                = 16
+	tlsExtensionSupportedVersions   = 43
+)
+
+var (
+	// ErrIncomplete is returned when more data is needed for parsing.
+	ErrIncomplete = errors.New("incomplete data")
+	// ErrNotTLS is returned when the data is not a TLS ClientHello.
+	ErrNotTLS = errors.New("not a TLS ClientHello")
+)
+
+// ClientHello holds the information parsed from a TLS ClientHello.
+type ClientHello struct {
+	// Version is the legacy version field of the ClientHello.
+	Version uint16
+	// SNI is the server name from the server name indication extension.
+	SNI string
+	// ALPN holds the protocols the client offered in the application layer
+	// protocol negotiation extension.
+	ALPN []string
+
+	// The following fields are used for fingerprinting and hold the values
+	// in the order the client sent them, including GREASE values.
+
+	// CipherSuites holds the offered cipher suites.
+	CipherSuites []uint16
+	// Extensions holds the types of the extensions.
+	Extensions []uint16
+	// SupportedGroups holds the groups of the supported groups extension.
+	SupportedGroups []uint16
+	// ECPointFormats holds the formats of the EC point formats extension.
+	ECPointFormats []uint8
+	// SignatureAlgorithms holds the algorithms of the signature algorithms
+	// extension.
+	SignatureAlgorithms []uint16
+	// SupportedVersions holds the versions of the supported versions
+	// extension.
+	SupportedVersions []uint16
+}
+
+// ParseClientHello parses the TLS ClientHello at the start of the given
+// stream. It returns ErrIncomplete if the ClientHello is not fully contained
+// in data yet, and ErrNotTLS if the stream does not start with a ClientHello.
+func ParseClientHello(data []byte) (*ClientHello, error) {
+	handshake, err := readHandshakeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return parseClientHelloMessage(handshake)
+}
+
+// parseClientHelloMessage parses a complete ClientHello handshake message.
+func parseClientHelloMessage(handshake []byte) (*ClientHello, error) {
+	if handshake[0] != tlsHandshakeTypeClientHello {
+		return nil, ErrNotTLS
+	}
+
+	r := &tlsReader{data: handshake[4:]}
+	hello := &ClientHello{
+		Version: r.uint16(),
+	}
+	r.skip(32)             // Random
+	r.skip(int(r.uint8())) // Session ID
+	hello.CipherSuites = r.uint16List(int(r.uint16()))
+	r.skip(int(r.uint8())) // Compression Methods
+	if r.err == nil && r.empty() {
+		// Extensions are optional.
+		return hello, nil
+	}
+
+	extensions := &tlsReader{data: r.bytes(int(r.uint16()))}
+	for r.err == nil && extensions.err == nil && !extensions.empty() {
+		extType := extensions.uint16()
+		extData := extensions.bytes(int(extensions.uint16()))
+		if extensions.err != nil {
+			break
+		}
+		hello.Extensions = append(hello.Extensions, extType)
+
+		var err error
+		extReader := &tlsReader{data: extData}
+		switch extType {
+		case tlsExtensionServerName:
+			hello.SNI, err = parseServerName(extData)
+		case tlsExtensionSupportedGroups:
+			hello.SupportedGroups = extReader.uint16List(int(extReader.uint16()))
+		case tlsExtensionECPointFormats:
+			hello.ECPointFormats = extReader.bytes(int(extReader.uint8()))
+		case tlsExtensionSignatureAlgorithms:
+			hello.SignatureAlgorithms = extReader.uint16List(int(extReader.uint16()))
+		case tlsExtensionALPN:
+			hello.ALPN, err = parseALPN(extData)
+		case tlsExtensionSupportedVersions:
+			hello.SupportedVersions = extReader.uint16List(int(extReader.uint8()))
+		}
+		if err == nil && extReader.err != nil {
+			err = ErrNotTLS
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.err != nil || extensions.err != nil {
+		return nil, ErrNotTLS
+	}
+	return hello, nil
+}
+
+// readHandshakeMessage returns the first handshake message in the given
+// stream. The message may span multiple TLS records.
+func readHandshakeMessage(data []byte) ([]byte, error) {
+	var message []byte
+	for {
+		if len(data) < tlsRecordHeaderLen {
+			return nil, ErrIncomplete
+		}
+		// Check the content type and the major version.
+		if data[0] != tlsRecordTypeHandshake || data[1] != 3 {
+			return nil, ErrNotTLS
+		}
+
+		recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < tlsRecordHeaderLen+recordLen {
+			return nil, ErrIncomplete
+		}
+		message = append(message, data[tlsRecordHeaderLen:tlsRecordHeaderLen+recordLen]...)
+		data = data[tlsRecordHeaderLen+recordLen:]
+
+		if len(message) >= 4 {
+			messageLen := int(message[1])<<16 | int(message[2])<<8 | int(message[3])
+			if len(message) >= 4+messageLen {
+				return message[:4+messageLen], nil
+			}
+		}
+	}
+}
+
+// parseServerName returns the host name from the data of a server name
+// extension.
+func parseServerName(data []byte) (string, error) {
+	r := &tlsReader{data: data}
+	names := &tlsReader{data: r.bytes(int(r.uint16()))}
+	for r.err == nil && names.err == nil && !names.empty() {
+		nameType := names.uint8()
+		name := names.bytes(int(names.uint16()))
+		if nameType == tlsServerNameTypeHost && names.err == nil {
+			return string(name), nil
+		}
+	}
+
+	if r.err != nil || names.err != nil {
+		return "", ErrNotTLS
+	}
+	return "", nil
+}
+
+// parseALPN returns the protocols from the data of an application layer
+// protocol negotiation extension.
+func parseALPN(data []byte) ([]string, error) {
+	r := &tlsReader{data: data}
+	protocols := &tlsReader{data: r.bytes(int(r.uint16()))}
+	var alpn []string
+	for r.err == nil && protocols.err == nil && !protocols.empty() {
+		protocol := protocols.bytes(int(protocols.uint8()))
+		if protocols.err == nil {
+			alpn = append(alpn, string(protocol))
+		}
+	}
+
+	if r.err != nil || protocols.err != nil {
+		return nil, ErrNotTLS
+	}
+	return alpn, nil
+}
+
+// tlsReader reads the big endian fields of TLS messages. After the first
+// read failed, all reads return zero values and err is set.
+type tlsReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tlsReader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data) < n {
+		r.err = ErrNotTLS
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *tlsReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *tlsReader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *tlsReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+// uint16List reads a list of 16 bit values with the given length in bytes.
+func (r *tlsReader) uint16List(n int) []uint16 {
+	b := r.bytes(n)
+	if r.err != nil || len(b)%2 != 0 {
+		r.err = ErrNotTLS
+		return nil
+	}
+
+	list := make([]uint16, 0, len(b)/2)
+	for i := 0; i < len(b); i += 2 {
+		list = append(list, binary.BigEndian.Uint16(b[i:]))
+	}
+	return list
+}
@@ -0,0 +1,107 @@
+package inspection
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// captureClientHello returns the ClientHello that the Go TLS client sends
+// for the given server name.
+func captureClientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer server.Close() //nolint:errcheck
+
+	go func() {
+		tlsConn := tls.Client(client, &tls.Config{
+			ServerName:         serverName,
+			NextProtos:         []string{"h2", "http/1.1"},
+			InsecureSkipVerify: true, //nolint:gosec // The handshake is never completed.
+		})
+		_ = tlsConn.Handshake()
+	}()
+
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 0, 4096)
+	for {
+		_, err := ParseClientHello(buf)
+		if err == nil {
+			_ = client.Close()
+			return buf
+		}
+		if err != ErrIncomplete {
+			t.Fatalf("failed to parse captured ClientHello: %s", err)
+		}
+
+		n, err := server.Read(buf[len(buf):cap(buf)])
+		if err != nil {
+			t.Fatalf("failed to capture ClientHello: %s", err)
+		}
+		buf = buf[:len(buf)+n]
+	}
+}
+
+func TestParseClientHello(t *testing.T) {
+	data := captureClientHello(t, "portmaster.example.com")
+
+	hello, err := ParseClientHello(data)
+	if err != nil {
+		t.Fatalf("failed to parse ClientHello: %s", err)
+	}
+	if hello.SNI != "portmaster.example.com" {
+		t.Errorf("unexpected SNI %q", hello.SNI)
+	}
+	if len(hello.ALPN) != 2 || hello.ALPN[0] != "h2" || hello.ALPN[1] != "http/1.1" {
+		t.Errorf("unexpected ALPN %q", hello.ALPN)
+	}
+
+	// Incomplete data.
+	for _, n := range []int{0, 3, tlsRecordHeaderLen, len(data) / 2, len(data) - 1} {
+		_, err := ParseClientHello(data[:n])
+		if err != ErrIncomplete {
+			t.Errorf("expected ErrIncomplete for %d of %d bytes, got %v", n, len(data), err)
+		}
+	}
+
+	// Not TLS.
+	_, err = ParseClientHello([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	if err != ErrNotTLS {
+		t.Errorf("expected ErrNotTLS for HTTP, got %v", err)
+	}
+
+	// No SNI is sent for IP addresses.
+	hello, err = ParseClientHello(captureClientHello(t, "192.0.2.1"))
+	if err != nil {
+		t.Fatalf("failed to parse ClientHello without SNI: %s", err)
+	}
+	if hello.SNI != "" {
+		t.Errorf("unexpected SNI %q", hello.SNI)
+	}
+}
+
+func TestParseFragmentedClientHello(t *testing.T) {
+	data := captureClientHello(t, "fragmented.example.com")
+
+	// Split the handshake message into two records.
+	message := data[tlsRecordHeaderLen:]
+	split := len(message) / 3
+	var fragmented []byte
+	for _, fragment := range [][]byte{message[:split], message[split:]} {
+		header := []byte{tlsRecordTypeHandshake, data[1], data[2], 0, 0}
+		binary.BigEndian.PutUint16(header[3:], uint16(len(fragment)))
+		fragmented = append(fragmented, header...)
+		fragmented = append(fragmented, fragment...)
+	}
+
+	hello, err := ParseClientHello(fragmented)
+	if err != nil {
+		t.Fatalf("failed to parse fragmented ClientHello: %s", err)
+	}
+	if hello.SNI != "fragmented.example.com" {
+		t.Errorf("unexpected SNI %q", hello.SNI)
+	}
+}
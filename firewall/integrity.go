@@ -0,0 +1,162 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/runtime"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/status"
+)
+
+const (
+	ruleIntegrityCheckTickDuration = 30 * time.Second
+	ruleIntegrityThreatTTL         = 1 * time.Hour
+
+	ruleIntegrityThreatID = "interception:rules-changed"
+)
+
+var (
+	lastRuleIntegrityCheck     *RuleIntegrityCheck
+	lastRuleIntegrityCheckLock sync.Mutex
+
+	pushRuleIntegrityCheck runtime.PushFunc = func(...record.Record) {}
+
+	ruleIntegrityThreat *status.Threat
+)
+
+// RuleIntegrityCheck is the result of a check of the firewall rules of the
+// interception. It is exposed via runtime:firewall/integrity.
+type RuleIntegrityCheck struct {
+	record.Base
+	sync.Mutex
+
+	// Checked holds the UNIX epoch timestamp in seconds of the check.
+	Checked int64
+	// Intact is set to true if the rules were found unchanged.
+	Intact bool
+	// Problems holds the changes that were detected.
+	Problems []string
+	// Reinstalled is set to true if the rules were re-installed.
+	Reinstalled bool
+	// Error holds the error that occurred during the check, if any.
+	Error string
+	// LastTampered holds the UNIX epoch timestamp in seconds of when the
+	// rules were last found changed.
+	LastTampered int64
+}
+
+func registerRuleIntegrityProvider() error {
+	push, err := runtime.Register("firewall/integrity", runtime.SimpleValueGetterFunc(
+		func(_ string) ([]record.Record, error) {
+			lastRuleIntegrityCheckLock.Lock()
+			defer lastRuleIntegrityCheckLock.Unlock()
+
+			if lastRuleIntegrityCheck == nil {
+				return nil, nil
+			}
+			return []record.Record{lastRuleIntegrityCheck}, nil
+		},
+	))
+	if err != nil {
+		return err
+	}
+
+	pushRuleIntegrityCheck = push
+	return nil
+}
+
+// ruleIntegrityChecker periodically checks that the firewall rules of the
+// interception were not changed by another tool, eg. by flushing or
+// reordering chains, which would cause the Portmaster to stop seeing packets.
+func ruleIntegrityChecker(ctx context.Context) error {
+	ticker := time.NewTicker(ruleIntegrityCheckTickDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !checkRuleIntegrity() {
+				log.Debugf("filter: checking the integrity of the firewall rules is not supported on this platform")
+				return nil
+			}
+		}
+	}
+}
+
+// checkRuleIntegrity checks the firewall rules and reports changes. It returns
+// false if this is not supported.
+func checkRuleIntegrity() (supported bool) {
+	problems, err := interception.CheckRules()
+	if errors.Is(err, interception.ErrNotSupported) {
+		return false
+	}
+
+	check := &RuleIntegrityCheck{
+		Checked:     time.Now().Unix(),
+		Intact:      len(problems) == 0 && err == nil,
+		Problems:    problems,
+		Reinstalled: len(problems) > 0 && err == nil,
+	}
+	if err != nil {
+		check.Error = err.Error()
+		log.Warningf("filter: failed to check the integrity of the firewall rules: %s", err)
+	}
+	check.SetKey("runtime:firewall/integrity")
+	check.UpdateMeta()
+
+	lastRuleIntegrityCheckLock.Lock()
+	if lastRuleIntegrityCheck != nil {
+		check.LastTampered = lastRuleIntegrityCheck.LastTampered
+	}
+	if len(problems) > 0 {
+		check.LastTampered = check.Checked
+	}
+	lastRuleIntegrityCheck = check
+	lastRuleIntegrityCheckLock.Unlock()
+
+	updateRuleIntegrityThreat(check)
+
+	check.Lock()
+	defer check.Unlock()
+	pushRuleIntegrityCheck(check)
+
+	return true
+}
+
+// updateRuleIntegrityThreat raises a threat when the rules were changed and
+// removes it once they have been intact for some time.
+func updateRuleIntegrityThreat(check *RuleIntegrityCheck) {
+	switch {
+	case len(check.Problems) > 0:
+		msg := fmt.Sprintf(
+			"The firewall rules of the Portmaster were changed by another program, which could cause network traffic to not be filtered. The rules were re-installed. Detected changes: %s",
+			strings.Join(check.Problems, "; "),
+		)
+		if !check.Reinstalled {
+			msg = fmt.Sprintf(
+				"The firewall rules of the Portmaster were changed by another program and could not be re-installed. Network traffic might not be filtered. Detected changes: %s",
+				strings.Join(check.Problems, "; "),
+			)
+		}
+
+		ruleIntegrityThreat = status.NewThreat(
+			ruleIntegrityThreatID,
+			"Firewall Rules Changed",
+			msg,
+		).SetData(check.Problems).Publish()
+
+	case ruleIntegrityThreat != nil &&
+		time.Since(time.Unix(check.LastTampered, 0)) > ruleIntegrityThreatTTL:
+		ruleIntegrityThreat.Delete().Publish()
+		ruleIntegrityThreat = nil
+	}
+}
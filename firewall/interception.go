@@ -36,22 +36,84 @@
 )
 
 func init() {
-	interceptionModule = modules.Register("interception", interceptionPrep, interceptionStart, interceptionStop, "base", "updates", "network")
+	interceptionModule = modules.Register("interception", interceptionPrep, interceptionStart, interceptionStop, "base", "updates", "network", "netenv")
 
 	network.SetDefaultFirewallHandler(defaultHandler)
+	network.SetForwardedConnectionChecker(interception.ConnectionExists)
 }
 
 func interceptionPrep() (err error) {
+	registerReevaluationAPI()
+	registerFastTrackAPI()
+	inspection.RegisterInspector(&tlsInspector{}, network.VerdictAccept)
+	inspection.RegisterInspector(&httpInspector{}, network.VerdictAccept)
+	quic := &quicInspector{}
+	quic.index = uint8(inspection.RegisterInspector(quic, network.VerdictAccept))
+	inspection.RegisterInspector(&dnsPayloadInspector{}, network.VerdictAccept)
+
 	return prepAPIAuth()
 }
 
 func interceptionStart() error {
 	startAPIAuth()
 
+	err := registerRuleIntegrityProvider()
+	if err != nil {
+		return err
+	}
+
+	_ = updateFastTrackRules(interceptionModule.Ctx, nil)
+	err = interceptionModule.RegisterEventHook(
+		"config",
+		"config change",
+		"update fast-track rules",
+		updateFastTrackRules,
+	)
+	if err != nil {
+		return err
+	}
+
+	_ = updateTLSFingerprintRules(interceptionModule.Ctx, nil)
+	err = interceptionModule.RegisterEventHook(
+		"config",
+		"config change",
+		"update tls fingerprint rules",
+		updateTLSFingerprintRules,
+	)
+	if err != nil {
+		return err
+	}
+
+	_ = updateKillSwitchExemptions(interceptionModule.Ctx, nil)
+	err = interceptionModule.RegisterEventHook(
+		"config",
+		"config change",
+		"update vpn kill switch exemptions",
+		updateKillSwitchExemptions,
+	)
+	if err != nil {
+		return err
+	}
+	err = interceptionModule.RegisterEventHook(
+		"netenv",
+		netenv.NetworkChangedEvent,
+		"re-check vpn kill switch",
+		recheckKillSwitch,
+	)
+	if err != nil {
+		return err
+	}
+
 	interceptionModule.StartWorker("stat logger", statLogger)
-	interceptionModule.StartWorker("packet handler", packetHandler)
+	startPacketWorkers()
 	interceptionModule.StartWorker("ports state cleaner", portsInUseCleaner)
+	interceptionModule.StartServiceWorker("connection re-evaluator", 0, reevaluationWorker)
+	interceptionModule.StartServiceWorker("traffic updater", 0, trafficUpdater)
+	interceptionModule.StartServiceWorker("rule integrity checker", 0, ruleIntegrityChecker)
+	interceptionModule.StartServiceWorker("port scan detector", 0, portScanDetector)
 
+	interception.SetFailClosed(failMode() == "closed")
+	interception.SetGatewayMode(gatewayMode())
 	return interception.Start()
 }
 
@@ -72,15 +134,19 @@ func handlePacket(ctx context.Context, pkt packet.Packet) {
 	}
 	pkt.SetCtx(traceCtx)
 
+	// Queue the packet if its connection is still being created.
+	if queuePendingPacket(pkt) {
+		tracer.Tracef("filter: queued for pending connection %s", pkt.GetConnectionID())
+		return
+	}
+
 	// associate packet to link and handle
 	conn, ok := network.GetConnection(pkt.GetConnectionID())
-	if ok {
-		tracer.Tracef("filter: assigned to connection %s", conn.ID)
-	} else {
-		conn = network.NewConnectionFromFirstPacket(pkt)
-		tracer.Tracef("filter: created new connection %s", conn.ID)
-		conn.SetFirewallHandler(initialHandler)
+	if !ok {
+		createConnection(pkt)
+		return
 	}
+	tracer.Tracef("filter: assigned to connection %s", conn.ID)
 
 	// handle packet
 	conn.HandlePacket(pkt)
@@ -95,95 +161,54 @@ func fastTrackedPermit(pkt packet.Packet) (handled bool) {
 		_ = pkt.PermanentBlock()
 	}
 
-	switch meta.Protocol {
-	case packet.ICMP:
-		// Always permit ICMP.
-		log.Debugf("filter: fast-track accepting ICMP: %s", pkt)
-		_ = pkt.PermanentAccept()
-		return true
-
-	case packet.ICMPv6:
-		// Always permit ICMPv6.
-		log.Debugf("filter: fast-track accepting ICMPv6: %s", pkt)
+	// Hand ICMP to the connection pipeline, if enabled. ICMP messages that
+	// are required for the network to function are always permitted.
+	if filterICMP() && (meta.Protocol == packet.ICMP || meta.Protocol == packet.ICMPv6) {
+		if !isRequiredICMP(meta) {
+			return false
+		}
+		log.Debugf("filter: fast-track accepting required %s: %s", meta.Protocol, pkt)
 		_ = pkt.PermanentAccept()
 		return true
+	}
 
-	case packet.UDP, packet.TCP:
-		switch meta.DstPort {
-
-		case 67, 68, 546, 547:
-			// Always allow DHCP, DHCPv6.
-
-			// DHCP and DHCPv6 must be UDP.
-			if meta.Protocol != packet.UDP {
-				return false
-			}
-
-			// DHCP is only valid in local network scopes.
-			switch netutils.ClassifyIP(meta.Dst) {
-			case netutils.HostLocal, netutils.LinkLocal, netutils.SiteLocal, netutils.LocalMulticast:
-			default:
-				return false
-			}
-
-			// Log and permit.
-			log.Debugf("filter: fast-track accepting DHCP: %s", pkt)
-			_ = pkt.PermanentAccept()
-			return true
-
-		case apiPort:
-			// Always allow direct access to the Portmaster API.
-
-			// Check if the api port is even set.
-			if !apiPortSet {
-				return false
-			}
-
-			// Portmaster API must be TCP
-			if meta.Protocol != packet.TCP {
-				return false
-			}
-
-			fallthrough
-		case 53:
-			// Always allow direct local access to own services.
-			// DNS is both UDP and TCP.
-
-			// Only allow to own IPs.
-			dstIsMe, err := netenv.IsMyIP(meta.Dst)
-			if err != nil {
-				log.Warningf("filter: failed to check if IP %s is local: %s", meta.Dst, err)
-			}
-			if !dstIsMe {
-				return false
-			}
-
-			// Log and permit.
-			switch meta.DstPort {
-			case 53:
-				log.Debugf("filter: fast-track accepting local dns: %s", pkt)
-			case apiPort:
-				log.Debugf("filter: fast-track accepting api connection: %s", pkt)
-			default:
-				return false
-			}
+	// Always allow direct access to the Portmaster API.
+	if apiPortSet &&
+		meta.Protocol == packet.TCP &&
+		meta.DstPort == apiPort {
+		dstIsMe, err := netenv.IsMyIP(meta.Dst)
+		if err != nil {
+			log.Warningf("filter: failed to check if IP %s is local: %s", meta.Dst, err)
+		}
+		if dstIsMe {
+			log.Debugf("filter: fast-track accepting api connection: %s", pkt)
 			_ = pkt.PermanentAccept()
 			return true
 		}
 	}
 
-	return false
+	// Check the configured fast-track rules.
+	fastTrack, rule := matchFastTrackRules(interceptionModule.Ctx, pkt)
+	if !fastTrack {
+		return false
+	}
+
+	log.Debugf("filter: fast-track accepting %s via rule %q", pkt, rule.definition)
+	_ = pkt.PermanentAccept()
+	return true
 }
 
 func initialHandler(conn *network.Connection, pkt packet.Packet) {
 	log.Tracer(pkt.Ctx()).Trace("filter: handing over to connection-based handler")
 
 	// check for internal firewall bypass
-	ps := getPortStatusAndMarkUsed(pkt.Info().LocalPort())
-	if ps.isMe {
-		// approve
-		conn.Accept("connection by Portmaster", noReasonOptionKey)
+	// Forwarded connections never belong to the Portmaster.
+	if !pkt.Info().Forwarded && pkt.HasPorts() && getPortStatusAndMarkUsed(pkt.Info().LocalPort()).isMe {
 		conn.Internal = true
+		// approve, unless the connection would leak past the VPN kill switch
+		if !checkKillSwitch(pkt.Ctx(), conn, pkt) {
+			conn.Accept("connection by Portmaster", noReasonOptionKey)
+		}
 		// finish
 		conn.StopFirewallHandler()
 		issueVerdict(conn, pkt, 0, true)
@@ -191,7 +216,9 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 	}
 
 	// reroute dns requests to nameserver
-	if conn.Process().Pid != os.Getpid() && pkt.IsOutbound() && pkt.Info().DstPort == 53 && !pkt.Info().Src.Equal(pkt.Info().Dst) {
+	// Forwarded connections cannot be rerouted, as the redirect rules only
+	// apply to connections of this host.
+	if !pkt.Info().Forwarded && conn.Process().Pid != os.Getpid() && pkt.IsOutbound() && pkt.HasPorts() && pkt.Info().DstPort == 53 && !pkt.Info().Src.Equal(pkt.Info().Dst) {
 		conn.Verdict = network.VerdictRerouteToNameserver
 		conn.Reason.Msg = "redirecting rogue dns query"
 		conn.Internal = true
@@ -209,13 +236,18 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 		return
 	}
 
+	recordInboundConnection(pkt.Ctx(), conn, time.Now())
+
 	log.Tracer(pkt.Ctx()).Trace("filter: starting decision process")
 	DecideOnConnection(pkt.Ctx(), conn, pkt)
-	conn.Inspecting = false // TODO: enable inspecting again
+	conn.Inspecting = conn.Verdict == network.VerdictAccept && inspection.ShouldInspect(conn)
 
 	// tunneling
 	// TODO: add implementation for forced tunneling
+	// Only TCP and UDP can be tunneled.
 	if pkt.IsOutbound() &&
+		!pkt.Info().Forwarded &&
+		pkt.HasPorts() &&
 		captain.ClientReady() &&
 		netutils.IPIsGlobal(conn.Entity.IP) &&
 		conn.Verdict == network.VerdictAccept {
@@ -234,6 +266,9 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 		log.Tracer(pkt.Ctx()).Trace("filter: start inspecting")
 		conn.SetFirewallHandler(inspectThenVerdict)
 		inspectThenVerdict(conn, pkt)
+	case needsICMPEchoHandler(conn, pkt):
+		conn.SetFirewallHandler(icmpEchoHandler)
+		issueVerdict(conn, pkt, 0, false)
 	default:
 		conn.StopFirewallHandler()
 		issueVerdict(conn, pkt, 0, true)
@@ -243,10 +278,34 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 
 func defaultHandler(conn *network.Connection, pkt packet.Packet) {
 	// TODO: `pkt` has an active trace log, which we currently don't submit.
+
+	// Re-evaluate the connection if its profile changed in the meantime, eg.
+	// when a scheduled rule became active or inactive.
+	if !conn.Internal && filterEnabled() && profileChanged(conn) {
+		DecideOnConnection(pkt.Ctx(), conn, pkt)
+	}
+
 	issueVerdict(conn, pkt, 0, true)
 }
 
+// profileChanged returns whether the profile of the connection changed since
+// the connection was last decided on.
+func profileChanged(conn *network.Connection) bool {
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return false
+	}
+
+	return layeredProfile.NeedsUpdate() ||
+		conn.ProfileRevisionCounter != layeredProfile.RevisionCnt()
+}
+
 func inspectThenVerdict(conn *network.Connection, pkt packet.Packet) {
+	// Re-evaluate the connection if its profile changed in the meantime.
+	if filterEnabled() && profileChanged(conn) {
+		DecideOnConnection(pkt.Ctx(), conn, pkt)
+	}
+
 	pktVerdict, continueInspection := inspection.RunInspectors(conn, pkt)
 	if continueInspection {
 		issueVerdict(conn, pkt, pktVerdict, false)
@@ -276,6 +335,7 @@ func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.V
 	switch verdict {
 	case network.VerdictAccept:
 		atomic.AddUint64(packetsAccepted, 1)
+		metricPacketsAccepted.Inc()
 		if conn.VerdictPermanent {
 			err = pkt.PermanentAccept()
 		} else {
@@ -283,6 +343,7 @@ func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.V
 		}
 	case network.VerdictBlock:
 		atomic.AddUint64(packetsBlocked, 1)
+		metricPacketsBlocked.Inc()
 		if conn.VerdictPermanent {
 			err = pkt.PermanentBlock()
 		} else {
@@ -290,20 +351,25 @@ func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.V
 		}
 	case network.VerdictDrop:
 		atomic.AddUint64(packetsDropped, 1)
+		metricPacketsDropped.Inc()
 		if conn.VerdictPermanent {
 			err = pkt.PermanentDrop()
 		} else {
 			err = pkt.Drop()
 		}
 	case network.VerdictRerouteToNameserver:
+		metricPacketsRerouted.Inc()
 		err = pkt.RerouteToNameserver()
 	case network.VerdictRerouteToTunnel:
+		metricPacketsRerouted.Inc()
 		err = pkt.RerouteToTunnel()
 	case network.VerdictFailed:
 		atomic.AddUint64(packetsFailed, 1)
+		metricPacketsFailed.Inc()
 		err = pkt.Drop()
 	default:
 		atomic.AddUint64(packetsDropped, 1)
+		metricPacketsDropped.Inc()
 		err = pkt.Drop()
 	}
 
@@ -325,20 +391,6 @@ func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.V
 // 	return
 // }
 
-func packetHandler(ctx context.Context) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case pkt := <-interception.Packets:
-			interceptionModule.StartWorker("initial packet handler", func(workerCtx context.Context) error {
-				handlePacket(workerCtx, pkt)
-				return nil
-			})
-		}
-	}
-}
-
 func statLogger(ctx context.Context) error {
 	for {
 		select {
@@ -352,10 +404,14 @@ func statLogger(ctx context.Context) error {
 				atomic.LoadUint64(packetsDropped),
 				atomic.LoadUint64(packetsFailed),
 			)
+			logPacketQueues()
 			atomic.StoreUint64(packetsAccepted, 0)
 			atomic.StoreUint64(packetsBlocked, 0)
 			atomic.StoreUint64(packetsDropped, 0)
 			atomic.StoreUint64(packetsFailed, 0)
+			atomic.StoreUint64(packetsQueuedMax, 0)
+			atomic.StoreUint64(packetsBackpressured, 0)
+			atomic.StoreUint64(packetsOverloaded, 0)
 		}
 	}
 }
@@ -0,0 +1,32 @@
+package interception
+
+import (
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/network/packet"
+)
+
+var (
+	// gatewayMode defines whether traffic forwarded by the host is
+	// intercepted too.
+	gatewayMode bool
+)
+
+// SetGatewayMode sets whether traffic that the host forwards for LAN devices
+// should be intercepted too. It must be called before Start.
+func SetGatewayMode(enabled bool) {
+	gatewayMode = enabled
+}
+
+// setForwardedDirection marks the packet as forwarded and sets its direction
+// from the perspective of the LAN device. Packets from a LAN device are
+// outbound, packets to a LAN device are inbound.
+func setForwardedDirection(pkt packet.Packet) {
+	info := pkt.Info()
+	info.Forwarded = true
+
+	if !netenv.IsLANIP(info.Src) && netenv.IsLANIP(info.Dst) {
+		pkt.SetInbound()
+	} else {
+		pkt.SetOutbound()
+	}
+}
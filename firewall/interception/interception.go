@@ -1,9 +1,12 @@
 package interception
 
 import (
+	"errors"
 	"flag"
 
 	"github.com/safing/portbase/log"
+	pmmetrics "github.com/safing/portmaster/metrics"
+	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/packet"
 )
 
@@ -12,6 +15,10 @@
 	Packets = make(chan packet.Packet, 1000)
 
 	disableInterception bool
+
+	// ErrNotSupported is returned when an operation is not supported by the
+	// interception of the current platform.
+	ErrNotSupported = errors.New("not supported by interception on this platform")
 )
 
 func init() {
@@ -26,7 +33,7 @@ func Start() error {
 	}
 
 	var inputPackets = Packets
-	if packetMetricsDestination != "" {
+	if packetMetricsDestination != "" || pmmetrics.Enabled() {
 		go metrics.writeMetrics()
 		inputPackets = make(chan packet.Packet)
 		go func() {
@@ -36,6 +43,9 @@ func Start() error {
 		}()
 	}
 
+	if replayEnabled() {
+		return startReplay(inputPackets)
+	}
 	return start(inputPackets)
 }
 
@@ -47,5 +57,64 @@ func Stop() error {
 
 	close(metrics.done)
 
+	if replayEnabled() {
+		return stopReplay()
+	}
 	return stop()
 }
+
+// UpdateVerdict changes the permanent verdict of an established connection
+// that was already handed back to the system. The connection is identified by
+// the packet info of its first packet. Verdicts other than accept, block or
+// drop cause the next packet of the connection to be intercepted again.
+func UpdateVerdict(info *packet.Info, verdict network.Verdict) error {
+	if disableInterception {
+		return nil
+	}
+
+	if replayEnabled() {
+		return updateReplayVerdict(info, verdict)
+	}
+	return updateVerdict(info, verdict)
+}
+
+// GetTrafficStats returns the traffic counters of an established connection
+// from the connection tracking of the system. The connection is identified by
+// the packet info of its first packet.
+func GetTrafficStats(info *packet.Info) (*network.TrafficStats, error) {
+	if disableInterception {
+		return nil, ErrNotSupported
+	}
+
+	if replayEnabled() {
+		return nil, ErrNotSupported
+	}
+	return getTrafficStats(info)
+}
+
+// CheckRules checks whether the firewall rules of the interception are still
+// intact and re-installs them if they were changed by another tool. It returns
+// the detected problems.
+func CheckRules() (problems []string, err error) {
+	if disableInterception {
+		return nil, nil
+	}
+
+	if replayEnabled() {
+		return nil, ErrNotSupported
+	}
+	return checkRules()
+}
+
+// ConnectionExists returns whether the system still tracks the connection
+// identified by the packet info of its first packet.
+func ConnectionExists(info *packet.Info) bool {
+	if disableInterception {
+		return false
+	}
+
+	if replayEnabled() {
+		return false
+	}
+	return connectionExists(info)
+}
@@ -4,6 +4,7 @@
 
 import (
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/packet"
 )
 
@@ -17,3 +18,23 @@ func start(_ chan packet.Packet) error {
 func stop() error {
 	return nil
 }
+
+// updateVerdict is not supported on this platform.
+func updateVerdict(_ *packet.Info, _ network.Verdict) error {
+	return ErrNotSupported
+}
+
+// getTrafficStats is not supported on this platform.
+func getTrafficStats(_ *packet.Info) (*network.TrafficStats, error) {
+	return nil, ErrNotSupported
+}
+
+// checkRules is not supported on this platform.
+func checkRules() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// connectionExists is not supported on this platform.
+func connectionExists(_ *packet.Info) bool {
+	return false
+}
@@ -1,6 +1,10 @@
 package interception
 
-import "github.com/safing/portmaster/network/packet"
+import (
+	"github.com/safing/portmaster/firewall/interception/nfq"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
 
 // start starts the interception.
 func start(ch chan packet.Packet) error {
@@ -11,3 +15,51 @@ func start(ch chan packet.Packet) error {
 func stop() error {
 	return StopNfqueueInterception()
 }
+
+// updateVerdict re-marks the conntrack entry of the connection.
+func updateVerdict(info *packet.Info, verdict network.Verdict) error {
+	var mark uint32
+	switch verdict {
+	case network.VerdictAccept:
+		mark = nfq.MarkAcceptAlways
+	case network.VerdictBlock:
+		mark = nfq.MarkBlockAlways
+	case network.VerdictDrop:
+		mark = nfq.MarkDropAlways
+	default:
+		// Reset the mark so that the next packet is queued again.
+		mark = 0
+	}
+
+	return nfq.SetConnMark(info, mark)
+}
+
+// getTrafficStats queries the conntrack counters of the connection.
+func getTrafficStats(info *packet.Info) (*network.TrafficStats, error) {
+	counters, err := nfq.GetConnCounters(info)
+	if err != nil {
+		return nil, err
+	}
+
+	// The original direction is the direction of the first packet.
+	if info.Inbound {
+		return &network.TrafficStats{
+			PacketsSent:     counters.ReplyPackets,
+			BytesSent:       counters.ReplyBytes,
+			PacketsReceived: counters.OrigPackets,
+			BytesReceived:   counters.OrigBytes,
+		}, nil
+	}
+	return &network.TrafficStats{
+		PacketsSent:     counters.OrigPackets,
+		BytesSent:       counters.OrigBytes,
+		PacketsReceived: counters.ReplyPackets,
+		BytesReceived:   counters.ReplyBytes,
+	}, nil
+}
+
+// connectionExists checks if conntrack has an entry for the connection.
+func connectionExists(info *packet.Info) bool {
+	_, err := nfq.GetConnCounters(info)
+	return err == nil
+}
@@ -7,6 +7,7 @@
 	"github.com/safing/portbase/notifications"
 	"github.com/safing/portbase/utils/osdetail"
 	"github.com/safing/portmaster/firewall/interception/windowskext"
+	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/portmaster/updates"
 )
@@ -83,3 +84,23 @@ func notifyRebootRequired() {
 		Type:    notifications.Warning,
 	}).Save()
 }
+
+// updateVerdict is not supported on this platform.
+func updateVerdict(_ *packet.Info, _ network.Verdict) error {
+	return ErrNotSupported
+}
+
+// getTrafficStats is not supported on this platform.
+func getTrafficStats(_ *packet.Info) (*network.TrafficStats, error) {
+	return nil, ErrNotSupported
+}
+
+// checkRules is not supported on this platform.
+func checkRules() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// connectionExists is not supported on this platform.
+func connectionExists(_ *packet.Info) bool {
+	return false
+}
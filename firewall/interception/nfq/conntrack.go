@@ -0,0 +1,251 @@
+// +build linux
+
+package nfq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+
+	pmpacket "github.com/safing/portmaster/network/packet"
+)
+
+// Conntrack netlink message types and attributes.
+// See linux/netfilter/nfnetlink.h and linux/netfilter/nfnetlink_conntrack.h.
+const (
+	nfnlSubsysCTNetlink = 1
+	nfnetlinkV0         = 0
+
+	ipctnlMsgCtNew = 0
+	ipctnlMsgCtGet = 1
+
+	ctaTupleOrig     = 1
+	ctaMark          = 8
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaCountersPackets = 1
+	ctaCountersBytes   = 2
+
+	conntrackAccountingSysctl = "/proc/sys/net/netfilter/nf_conntrack_acct"
+)
+
+// ConnCounters holds the traffic counters of a conntrack entry.
+type ConnCounters struct {
+	OrigPackets  uint64
+	OrigBytes    uint64
+	ReplyPackets uint64
+	ReplyBytes   uint64
+}
+
+// SetConnMark sets the mark of the conntrack entry that matches the given
+// packet info in its original direction.
+func SetConnMark(info *pmpacket.Info, mark uint32) error {
+	_, err := sendConntrackMsg(ipctnlMsgCtNew, info, func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(ctaMark, mark)
+	})
+	return err
+}
+
+// GetConnCounters returns the traffic counters of the conntrack entry that
+// matches the given packet info in its original direction. The counters are
+// only filled if conntrack accounting is enabled.
+func GetConnCounters(info *pmpacket.Info) (*ConnCounters, error) {
+	msgs, err := sendConntrackMsg(ipctnlMsgCtGet, info, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := &ConnCounters{}
+	for _, msg := range msgs {
+		// Skip nfgenmsg header.
+		if len(msg.Data) < 4 {
+			continue
+		}
+		ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode conntrack attributes: %w", err)
+		}
+		ad.ByteOrder = binary.BigEndian
+
+		for ad.Next() {
+			switch ad.Type() {
+			case ctaCountersOrig:
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					decodeCounters(nad, &counters.OrigPackets, &counters.OrigBytes)
+					return nil
+				})
+			case ctaCountersReply:
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					decodeCounters(nad, &counters.ReplyPackets, &counters.ReplyBytes)
+					return nil
+				})
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, fmt.Errorf("failed to decode conntrack attributes: %w", err)
+		}
+	}
+
+	return counters, nil
+}
+
+func decodeCounters(ad *netlink.AttributeDecoder, packets, bytes *uint64) {
+	ad.ByteOrder = binary.BigEndian
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaCountersPackets:
+			*packets = ad.Uint64()
+		case ctaCountersBytes:
+			*bytes = ad.Uint64()
+		}
+	}
+}
+
+// conntrackAccountingPrevious holds the conntrack accounting setting before
+// it was enabled, if it was changed.
+var conntrackAccountingPrevious []byte
+
+// EnableConntrackAccounting enables the traffic counters of conntrack. The
+// previous setting is restored by RestoreConntrackAccounting.
+func EnableConntrackAccounting() error {
+	previous, err := ioutil.ReadFile(conntrackAccountingSysctl)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(previous)) == "1" {
+		// Already enabled, nothing to restore.
+		return nil
+	}
+
+	err = ioutil.WriteFile(conntrackAccountingSysctl, []byte("1"), 0644) //nolint:gosec // sysctl permissions
+	if err != nil {
+		return err
+	}
+	conntrackAccountingPrevious = previous
+	return nil
+}
+
+// RestoreConntrackAccounting restores the conntrack accounting setting that
+// was changed by EnableConntrackAccounting.
+func RestoreConntrackAccounting() error {
+	if conntrackAccountingPrevious == nil {
+		return nil
+	}
+
+	err := ioutil.WriteFile(conntrackAccountingSysctl, conntrackAccountingPrevious, 0644) //nolint:gosec // sysctl permissions
+	if err != nil {
+		return err
+	}
+	conntrackAccountingPrevious = nil
+	return nil
+}
+
+func sendConntrackMsg(msgType uint16, info *pmpacket.Info, addAttrs func(ae *netlink.AttributeEncoder)) ([]netlink.Message, error) {
+	msg, err := newConntrackMsg(msgType, info, addAttrs)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to conntrack: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	msgs, err := conn.Execute(msg)
+	if err != nil {
+		return nil, fmt.Errorf("conntrack request failed: %w", err)
+	}
+
+	return msgs, nil
+}
+
+// newConntrackMsg builds a conntrack request for the entry that matches the
+// given packet info in its original direction.
+func newConntrackMsg(msgType uint16, info *pmpacket.Info, addAttrs func(ae *netlink.AttributeEncoder)) (netlink.Message, error) {
+	// Get address family and encode the tuple of the original direction.
+	var family uint8
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	switch info.Version {
+	case pmpacket.IPv4:
+		family = unix.AF_INET
+		if info.Src.To4() == nil || info.Dst.To4() == nil {
+			return netlink.Message{}, errors.New("invalid IPv4 addresses")
+		}
+		ae.Nested(ctaTupleOrig, func(nae *netlink.AttributeEncoder) error {
+			nae.Nested(ctaTupleIP, func(ipae *netlink.AttributeEncoder) error {
+				ipae.Bytes(ctaIPv4Src, info.Src.To4())
+				ipae.Bytes(ctaIPv4Dst, info.Dst.To4())
+				return nil
+			})
+			encodeProtoTuple(nae, info)
+			return nil
+		})
+	case pmpacket.IPv6:
+		family = unix.AF_INET6
+		if info.Src.To16() == nil || info.Dst.To16() == nil {
+			return netlink.Message{}, errors.New("invalid IPv6 addresses")
+		}
+		ae.Nested(ctaTupleOrig, func(nae *netlink.AttributeEncoder) error {
+			nae.Nested(ctaTupleIP, func(ipae *netlink.AttributeEncoder) error {
+				ipae.Bytes(ctaIPv6Src, info.Src.To16())
+				ipae.Bytes(ctaIPv6Dst, info.Dst.To16())
+				return nil
+			})
+			encodeProtoTuple(nae, info)
+			return nil
+		})
+	default:
+		return netlink.Message{}, fmt.Errorf("unsupported IP version %d", info.Version)
+	}
+	if addAttrs != nil {
+		addAttrs(ae)
+	}
+
+	attrs, err := ae.Encode()
+	if err != nil {
+		return netlink.Message{}, fmt.Errorf("failed to encode conntrack attributes: %w", err)
+	}
+
+	// Prepend nfgenmsg header.
+	data := append([]byte{family, nfnetlinkV0, 0, 0}, attrs...)
+
+	return netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(nfnlSubsysCTNetlink<<8 | msgType),
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: data,
+	}, nil
+}
+
+func encodeProtoTuple(ae *netlink.AttributeEncoder, info *pmpacket.Info) {
+	ae.Nested(ctaTupleProto, func(pae *netlink.AttributeEncoder) error {
+		pae.Uint8(ctaProtoNum, uint8(info.Protocol))
+		switch info.Protocol {
+		case pmpacket.TCP, pmpacket.UDP, pmpacket.UDPLite:
+			pae.Uint16(ctaProtoSrcPort, info.SrcPort)
+			pae.Uint16(ctaProtoDstPort, info.DstPort)
+		}
+		return nil
+	})
+}
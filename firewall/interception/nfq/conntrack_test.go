@@ -0,0 +1,118 @@
+// +build linux
+
+package nfq
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+
+	pmpacket "github.com/safing/portmaster/network/packet"
+)
+
+func TestNewConntrackMsg(t *testing.T) {
+	info := &pmpacket.Info{
+		Version:  pmpacket.IPv4,
+		Protocol: pmpacket.TCP,
+		Src:      net.IPv4(192, 168, 1, 2),
+		SrcPort:  51234,
+		Dst:      net.IPv4(1, 1, 1, 1),
+		DstPort:  443,
+	}
+
+	msg, err := newConntrackMsg(ipctnlMsgCtNew, info, func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(ctaMark, 1717)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Type != netlink.HeaderType(nfnlSubsysCTNetlink<<8|ipctnlMsgCtNew) {
+		t.Errorf("unexpected message type %d", msg.Header.Type)
+	}
+	if len(msg.Data) < 4 || msg.Data[0] != unix.AF_INET {
+		t.Fatalf("unexpected nfgenmsg header %v", msg.Data)
+	}
+
+	ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	var (
+		src, dst         net.IP
+		proto            uint8
+		srcPort, dstPort uint16
+		mark             uint32
+	)
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaTupleOrig:
+			ad.Nested(func(tad *netlink.AttributeDecoder) error {
+				tad.ByteOrder = binary.BigEndian
+				for tad.Next() {
+					switch tad.Type() {
+					case ctaTupleIP:
+						tad.Nested(func(iad *netlink.AttributeDecoder) error {
+							for iad.Next() {
+								switch iad.Type() {
+								case ctaIPv4Src:
+									src = net.IP(iad.Bytes())
+								case ctaIPv4Dst:
+									dst = net.IP(iad.Bytes())
+								}
+							}
+							return nil
+						})
+					case ctaTupleProto:
+						tad.Nested(func(pad *netlink.AttributeDecoder) error {
+							pad.ByteOrder = binary.BigEndian
+							for pad.Next() {
+								switch pad.Type() {
+								case ctaProtoNum:
+									proto = pad.Uint8()
+								case ctaProtoSrcPort:
+									srcPort = pad.Uint16()
+								case ctaProtoDstPort:
+									dstPort = pad.Uint16()
+								}
+							}
+							return nil
+						})
+					}
+				}
+				return nil
+			})
+		case ctaMark:
+			mark = ad.Uint32()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !src.Equal(info.Src) || !dst.Equal(info.Dst) {
+		t.Errorf("unexpected addresses %s -> %s", src, dst)
+	}
+	if proto != uint8(pmpacket.TCP) || srcPort != info.SrcPort || dstPort != info.DstPort {
+		t.Errorf("unexpected protocol tuple %d %d -> %d", proto, srcPort, dstPort)
+	}
+	if mark != 1717 {
+		t.Errorf("unexpected mark %d", mark)
+	}
+
+	// Invalid packet infos must be rejected.
+	if _, err := newConntrackMsg(ipctnlMsgCtGet, &pmpacket.Info{
+		Version: pmpacket.IPv4,
+		Src:     net.ParseIP("fd00::1"),
+		Dst:     net.IPv4(1, 1, 1, 1),
+	}, nil); err == nil {
+		t.Error("IPv6 address in IPv4 packet info should be rejected")
+	}
+	if _, err := newConntrackMsg(ipctnlMsgCtGet, &pmpacket.Info{}, nil); err == nil {
+		t.Error("unknown IP version should be rejected")
+	}
+}
@@ -78,6 +78,15 @@ func New(qid uint16, v6 bool) (*Queue, error) { //nolint:gocognit
 			pkt.Payload = *attrs.Payload
 		}
 
+		// Record the interface, the output device is set for outgoing and
+		// forwarded packets, the input device for incoming packets.
+		switch {
+		case attrs.OutDev != nil:
+			pkt.Info().InterfaceIndex = int(*attrs.OutDev)
+		case attrs.InDev != nil:
+			pkt.Info().InterfaceIndex = int(*attrs.InDev)
+		}
+
 		if err := pmpacket.Parse(pkt.Payload, pkt.Info()); err != nil {
 			log.Warningf("nfqueue: failed to parse payload: %s", err)
 			_ = pkt.Drop()
@@ -1,13 +1,19 @@
 package interception
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"os/exec"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/hashicorp/go-multierror"
+	"github.com/tevino/abool"
 
 	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/firewall/interception/nfq"
@@ -23,18 +29,109 @@
 	v6rules  []string
 	v6once   []string
 
-	out4Queue nfQueue
-	in4Queue  nfQueue
-	out6Queue nfQueue
-	in6Queue  nfQueue
+	// Additional rules for gateway mode.
+	v4forwardChains []string
+	v4forwardRules  []string
+	v4forwardOnce   []string
+
+	v6forwardChains []string
+	v6forwardRules  []string
+	v6forwardOnce   []string
+
+	out4Queues []nfQueue
+	in4Queues  []nfQueue
+	out6Queues []nfQueue
+	in6Queues  []nfQueue
+	fwd4Queues []nfQueue
+	fwd6Queues []nfQueue
 
 	shutdownSignal = make(chan struct{})
+	watchdogStop   = make(chan struct{})
+
+	// queueCount is the number of nfqueues per direction and IP version.
+	// The kernel balances connections over the queues by their flow hash,
+	// so all packets of a connection are received by the same queue.
+	queueCount uint
+
+	iptablesQueueRegex = regexp.MustCompile(`--queue-num ([0-9]+)`)
 
 	experimentalNfqueueBackend bool
+
+	firewallBackendFlag   string
+	activeFirewallBackend string
+
+	// firewallLock serializes changes to the firewall rules.
+	firewallLock sync.Mutex
+	// firewallOpened is set when the watchdog removed the firewall rules.
+	firewallOpened = abool.New()
+)
+
+// Firewall backends that direct packets to the nfqueues.
+const (
+	firewallBackendAuto     = "auto"
+	firewallBackendIPTables = "iptables"
+	firewallBackendNftables = "nftables"
 )
 
 func init() {
 	flag.BoolVar(&experimentalNfqueueBackend, "experimental-nfqueue", false, "(deprecated flag; always used)")
+	flag.StringVar(&firewallBackendFlag, "firewall-backend", firewallBackendAuto, "set the firewall backend to use for interception: auto, iptables or nftables")
+	flag.UintVar(&queueCount, "nfqueue-count", 1, fmt.Sprintf("set the number of nfqueues per direction and IP version to balance packets over (1-%d)", maxQueueCount))
+}
+
+// maxQueueCount is the maximum number of nfqueues per direction and IP
+// version, as the queue numbers of the different queues must not overlap.
+const maxQueueCount = 16
+
+// prepareRules replaces the queue numbers in the given rules with queue
+// ranges if more than one queue is used and removes the queue bypass when
+// failing closed.
+func prepareRules(rules []string) ([]string, error) {
+	prepared := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if queueCount > 1 {
+			var err error
+			rule, err = replaceQueueNum(rule, func(first uint16) string {
+				return fmt.Sprintf("--queue-balance %d:%d", first, first+uint16(queueCount)-1)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to prepare rule %q: %w", rule, err)
+			}
+		}
+		if failClosed {
+			rule = strings.TrimSuffix(rule, " --queue-bypass")
+		}
+		prepared = append(prepared, rule)
+	}
+	return prepared, nil
+}
+
+// replaceQueueNum replaces the queue number option in the given rule with
+// the option returned by replace.
+func replaceQueueNum(rule string, replace func(first uint16) string) (string, error) {
+	var err error
+	replaced := iptablesQueueRegex.ReplaceAllStringFunc(rule, func(match string) string {
+		first, parseErr := queueNumFromMatch(iptablesQueueRegex, match)
+		if parseErr != nil {
+			err = parseErr
+			return match
+		}
+		return replace(first)
+	})
+	return replaced, err
+}
+
+func queueNumFromMatch(re *regexp.Regexp, match string) (uint16, error) {
+	submatches := re.FindStringSubmatch(match)
+	if len(submatches) < 2 {
+		return 0, fmt.Errorf("no queue number in %q", match)
+	}
+
+	n, err := strconv.ParseUint(submatches[1], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid queue number in %q: %w", match, err)
+	}
+	return uint16(n), nil
 }
 
 // nfQueue encapsulates nfQueue providers.
@@ -123,34 +220,202 @@ func init() {
 		// "nat OUTPUT -m mark --mark 1717 ! -p tcp ! -p udp -j DNAT --to [::1]",
 	}
 
+	v4forwardChains = []string{
+		"mangle C172",
+	}
+
+	v4forwardRules = []string{
+		"mangle C172 -j CONNMARK --restore-mark",
+		"mangle C172 -m mark --mark 0 -j NFQUEUE --queue-num 17240 --queue-bypass",
+	}
+
+	v4forwardOnce = []string{
+		"mangle FORWARD -j C172",
+		"filter FORWARD -j C17",
+	}
+
+	v6forwardChains = []string{
+		"mangle C172",
+	}
+
+	v6forwardRules = []string{
+		"mangle C172 -j CONNMARK --restore-mark",
+		"mangle C172 -m mark --mark 0 -j NFQUEUE --queue-num 17260 --queue-bypass",
+	}
+
+	v6forwardOnce = []string{
+		"mangle FORWARD -j C172",
+		"filter FORWARD -j C17",
+	}
+
 	// Reverse because we'd like to insert in a loop
 	_ = sort.Reverse(sort.StringSlice(v4once)) // silence vet (sort is used just like in the docs)
 	_ = sort.Reverse(sort.StringSlice(v6once)) // silence vet (sort is used just like in the docs)
 
 }
 
+// activateFirewall detects the firewall backend to use and activates it.
+func activateFirewall() error {
+	switch firewallBackendFlag {
+	case firewallBackendIPTables, firewallBackendNftables:
+		activeFirewallBackend = firewallBackendFlag
+	case firewallBackendAuto:
+		// Prefer nftables, as iptables may only be a compatibility layer or
+		// not be available at all.
+		switch {
+		case !nftablesAvailable():
+			activeFirewallBackend = firewallBackendIPTables
+		case iptablesLegacyInUse():
+			log.Infof("interception: legacy iptables chains are in use, staying with iptables")
+			activeFirewallBackend = firewallBackendIPTables
+		default:
+			activeFirewallBackend = firewallBackendNftables
+		}
+	default:
+		return fmt.Errorf("unknown firewall backend %q", firewallBackendFlag)
+	}
+	log.Infof("interception: using %s firewall backend", activeFirewallBackend)
+
+	if activeFirewallBackend != firewallBackendNftables {
+		return activateNfqueueFirewall()
+	}
+
+	err := activateNftablesFirewall()
+	if err == nil || firewallBackendFlag != firewallBackendAuto {
+		return err
+	}
+
+	// The kernel may lack features the nftables rules need, eg. nat chains
+	// in the inet family before Linux 5.2.
+	log.Warningf("interception: failed to activate nftables firewall backend, falling back to iptables: %s", err)
+	activeFirewallBackend = firewallBackendIPTables
+	return activateNfqueueFirewall()
+}
+
+// iptablesLegacyInUse returns whether iptables uses the legacy kernel
+// interface and already has custom chains, eg. of firewalld or docker. Legacy
+// iptables rules are evaluated next to nftables rules, so they are kept in
+// charge in this case.
+func iptablesLegacyInUse() bool {
+	path, err := exec.LookPath("iptables")
+	if err != nil {
+		return false
+	}
+	version, err := exec.Command(path, "--version").Output()
+	if err != nil || bytes.Contains(version, []byte("nf_tables")) {
+		return false
+	}
+
+	tbls, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		return false
+	}
+	for _, table := range []string{"filter", "nat", "mangle"} {
+		chains, err := tbls.ListChains(table)
+		if err != nil {
+			continue
+		}
+		for _, chain := range chains {
+			if !builtinIPTablesChains[chain] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// builtinIPTablesChains holds the chains that exist without any rules.
+var builtinIPTablesChains = map[string]bool{
+	"INPUT":       true,
+	"FORWARD":     true,
+	"OUTPUT":      true,
+	"PREROUTING":  true,
+	"POSTROUTING": true,
+}
+
+// reactivateFirewall re-installs the rules of the active firewall backend.
+func reactivateFirewall() error {
+	if activeFirewallBackend == firewallBackendNftables {
+		return activateNftablesFirewall()
+	}
+	return activateNfqueueFirewall()
+}
+
+// deactivateFirewall deactivates the active firewall backend.
+func deactivateFirewall() error {
+	switch activeFirewallBackend {
+	case firewallBackendNftables:
+		return DeactivateNftablesFirewall()
+	case firewallBackendIPTables:
+		return DeactivateNfqueueFirewall()
+	default:
+		// Not activated.
+		return nil
+	}
+}
+
 func activateNfqueueFirewall() error {
-	if err := activateIPTables(iptables.ProtocolIPv4, v4rules, v4once, v4chains); err != nil {
+	v4r, v4o, v4c := ipTablesRules(false)
+	v4r, err := prepareRules(v4r)
+	if err != nil {
+		return err
+	}
+	if err := activateIPTables(iptables.ProtocolIPv4, v4r, v4o, v4c); err != nil {
 		return err
 	}
 
-	if err := activateIPTables(iptables.ProtocolIPv6, v6rules, v6once, v6chains); err != nil {
+	v6r, v6o, v6c := ipTablesRules(true)
+	v6r, err = prepareRules(v6r)
+	if err != nil {
+		return err
+	}
+	if err := activateIPTables(iptables.ProtocolIPv6, v6r, v6o, v6c); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// ipTablesRules returns the rules, jump rules and chains to install,
+// including the rules for gateway mode if enabled.
+func ipTablesRules(v6 bool) (rules, once, chains []string) {
+	if v6 {
+		rules, once, chains = v6rules, v6once, v6chains
+		if gatewayMode {
+			rules = append(append([]string{}, rules...), v6forwardRules...)
+			once = append(append([]string{}, once...), v6forwardOnce...)
+			chains = append(append([]string{}, chains...), v6forwardChains...)
+		}
+		return rules, once, chains
+	}
+
+	rules, once, chains = v4rules, v4once, v4chains
+	if gatewayMode {
+		rules = append(append([]string{}, rules...), v4forwardRules...)
+		once = append(append([]string{}, once...), v4forwardOnce...)
+		chains = append(append([]string{}, chains...), v4forwardChains...)
+	}
+	return rules, once, chains
+}
+
 // DeactivateNfqueueFirewall drops portmaster related IP tables rules.
 // Any errors encountered accumulated into a *multierror.Error.
 func DeactivateNfqueueFirewall() error {
 	// IPv4
+	// The gateway mode rules are always removed first, as they jump to the
+	// main chains.
 	var result *multierror.Error
+	if err := deactivateIPTables(iptables.ProtocolIPv4, v4forwardOnce, v4forwardChains); err != nil {
+		result = multierror.Append(result, err)
+	}
 	if err := deactivateIPTables(iptables.ProtocolIPv4, v4once, v4chains); err != nil {
 		result = multierror.Append(result, err)
 	}
 
 	// IPv6
+	if err := deactivateIPTables(iptables.ProtocolIPv6, v6forwardOnce, v6forwardChains); err != nil {
+		result = multierror.Append(result, err)
+	}
 	if err := deactivateIPTables(iptables.ProtocolIPv6, v6once, v6chains); err != nil {
 		result = multierror.Append(result, err)
 	}
@@ -236,80 +501,389 @@ func StartNfqueueInterception(packets chan<- packet.Packet) (err error) {
 		log.Warningf("[DEPRECATED] please remove the flag from your configuration!")
 	}
 
-	err = activateNfqueueFirewall()
+	if queueCount < 1 || queueCount > maxQueueCount {
+		return fmt.Errorf("invalid nfqueue count %d: must be between 1 and %d", queueCount, maxQueueCount)
+	}
+
+	err = activateFirewall()
 	if err != nil {
 		_ = Stop()
 		return fmt.Errorf("could not initialize nfqueue: %s", err)
 	}
 
-	out4Queue, err = nfq.New(17040, false)
+	// Enable conntrack accounting for traffic counters of connections with a
+	// permanent verdict.
+	err = nfq.EnableConntrackAccounting()
+	if err != nil {
+		log.Warningf("interception: failed to enable conntrack accounting, traffic of established connections will not be counted: %s", err)
+	}
+
+	out4Queues, err = openQueues(17040, false)
 	if err != nil {
 		_ = Stop()
 		return fmt.Errorf("nfqueue(IPv4, out): %w", err)
 	}
-	in4Queue, err = nfq.New(17140, false)
+	in4Queues, err = openQueues(17140, false)
 	if err != nil {
 		_ = Stop()
 		return fmt.Errorf("nfqueue(IPv4, in): %w", err)
 	}
-	out6Queue, err = nfq.New(17060, true)
+	out6Queues, err = openQueues(17060, true)
 	if err != nil {
 		_ = Stop()
 		return fmt.Errorf("nfqueue(IPv6, out): %w", err)
 	}
-	in6Queue, err = nfq.New(17160, true)
+	in6Queues, err = openQueues(17160, true)
 	if err != nil {
 		_ = Stop()
 		return fmt.Errorf("nfqueue(IPv6, in): %w", err)
 	}
+	if gatewayMode {
+		fwd4Queues, err = openQueues(17240, false)
+		if err != nil {
+			_ = Stop()
+			return fmt.Errorf("nfqueue(IPv4, forward): %w", err)
+		}
+		fwd6Queues, err = openQueues(17260, true)
+		if err != nil {
+			_ = Stop()
+			return fmt.Errorf("nfqueue(IPv6, forward): %w", err)
+		}
+	}
+
+	wd := &watchdog{
+		threshold:  queueStallThreshold,
+		failClosed: failClosed,
+		openFirewall: func() error {
+			firewallLock.Lock()
+			defer firewallLock.Unlock()
+
+			firewallOpened.Set()
+			return deactivateFirewall()
+		},
+		closeFirewall: func() error {
+			firewallLock.Lock()
+			defer firewallLock.Unlock()
+
+			firewallOpened.UnSet()
+			return reactivateFirewall()
+		},
+	}
+	handleInterception(packets, wd)
+	go wd.run(watchdogStop)
 
-	go handleInterception(packets)
 	return nil
 }
 
-// StopNfqueueInterception stops the nfqueue interception.
+// StopNfqueueInterception stops the nfqueue interception. The firewall rules
+// are removed and verified to be gone before the queues are destroyed, so
+// that traffic is neither dropped nor passed unfiltered during the shutdown.
 func StopNfqueueInterception() error {
 	defer close(shutdownSignal)
 
-	if out4Queue != nil {
-		out4Queue.Destroy()
+	// Stop the watchdog first, so that it does not act on the shutdown.
+	close(watchdogStop)
+
+	firewallLock.Lock()
+	defer firewallLock.Unlock()
+
+	// Remove and verify firewall rules.
+	err := deactivateFirewall()
+	if err == nil {
+		err = verifyFirewallDeactivated()
 	}
-	if in4Queue != nil {
-		in4Queue.Destroy()
+	if err != nil {
+		// Try again once, the rules might have been changed concurrently.
+		log.Warningf("interception: failed to remove firewall rules, retrying: %s", err)
+		_ = deactivateFirewall()
+		err = verifyFirewallDeactivated()
 	}
-	if out6Queue != nil {
-		out6Queue.Destroy()
+
+	// Destroy the queues in any case, as the Portmaster is stopping.
+	for _, queues := range [][]nfQueue{out4Queues, in4Queues, out6Queues, in6Queues, fwd4Queues, fwd6Queues} {
+		for _, q := range queues {
+			q.Destroy()
+		}
 	}
-	if in6Queue != nil {
-		in6Queue.Destroy()
+
+	if restoreErr := nfq.RestoreConntrackAccounting(); restoreErr != nil {
+		log.Warningf("interception: failed to restore conntrack accounting setting: %s", restoreErr)
 	}
 
-	err := DeactivateNfqueueFirewall()
 	if err != nil {
 		return fmt.Errorf("interception: error while deactivating nfqueue: %s", err)
 	}
+	return nil
+}
+
+// checkRules checks the rules of the active firewall backend and re-installs
+// them if they were changed.
+func checkRules() (problems []string, err error) {
+	firewallLock.Lock()
+	defer firewallLock.Unlock()
+
+	// Skip the check if the firewall is not active or was opened on purpose.
+	if activeFirewallBackend == "" || firewallOpened.IsSet() {
+		return nil, nil
+	}
+
+	switch activeFirewallBackend {
+	case firewallBackendNftables:
+		problems, err = checkNftablesRules()
+	case firewallBackendIPTables:
+		problems, err = checkAllIPTablesRules()
+	}
+	if err != nil || len(problems) == 0 {
+		return problems, err
+	}
+
+	// Re-install the rules from scratch, as rules might be in the wrong
+	// position.
+	log.Warningf("interception: firewall rules were changed, re-installing: %s", strings.Join(problems, "; "))
+	if err := deactivateFirewall(); err != nil {
+		log.Warningf("interception: failed to remove changed firewall rules: %s", err)
+	}
+	if err := reactivateFirewall(); err != nil {
+		return problems, fmt.Errorf("failed to re-install firewall rules: %w", err)
+	}
+	return problems, nil
+}
+
+// checkAllIPTablesRules checks the IPv4 and IPv6 rules of the iptables
+// backend.
+func checkAllIPTablesRules() (problems []string, err error) {
+	v4r, v4o, _ := ipTablesRules(false)
+	v4r, err = prepareRules(v4r)
+	if err != nil {
+		return nil, err
+	}
+	problems, err = checkIPTablesRules(iptables.ProtocolIPv4, v4r, v4o)
+	if err != nil {
+		return nil, err
+	}
+
+	v6r, v6o, _ := ipTablesRules(true)
+	v6r, err = prepareRules(v6r)
+	if err != nil {
+		return nil, err
+	}
+	v6problems, err := checkIPTablesRules(iptables.ProtocolIPv6, v6r, v6o)
+	if err != nil {
+		return nil, err
+	}
+	return append(problems, v6problems...), nil
+}
+
+// checkIPTablesRules checks that all rules of the portmaster chains exist and
+// that the chains are jumped to first.
+func checkIPTablesRules(protocol iptables.Protocol, rules, once []string) (problems []string, err error) {
+	tbls, err := iptables.NewWithProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the rules of the portmaster chains and count them per chain.
+	expectedRules := make(map[string]int)
+	for _, rule := range rules {
+		splittedRule := strings.Split(rule, " ")
+		expectedRules[splittedRule[0]+" "+splittedRule[1]]++
+
+		ok, err := tbls.Exists(splittedRule[0], splittedRule[1], splittedRule[2:]...)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing rule %q", rule))
+		}
+	}
+	for chain, expected := range expectedRules {
+		splittedChain := strings.Split(chain, " ")
+		listed, err := tbls.List(splittedChain[0], splittedChain[1])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("failed to list chain %q: %s", chain, err))
+			continue
+		}
+		if n := countAppendRules(listed); n != expected {
+			problems = append(problems, fmt.Sprintf("chain %q has %d rules instead of %d", chain, n, expected))
+		}
+	}
+
+	// Check the jumps to the portmaster chains. They were inserted at the top
+	// and must be hit first.
+	for _, rule := range once {
+		splittedRule := strings.Split(rule, " ")
+		ok, err := tbls.Exists(splittedRule[0], splittedRule[1], splittedRule[2:]...)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing rule %q", rule))
+			continue
+		}
+
+		if len(splittedRule) != 4 || splittedRule[2] != "-j" {
+			continue
+		}
+		listed, err := tbls.List(splittedRule[0], splittedRule[1])
+		if err != nil {
+			return nil, err
+		}
+		first := firstAppendRule(listed)
+		if first != fmt.Sprintf("-A %s -j %s", splittedRule[1], splittedRule[3]) {
+			problems = append(problems, fmt.Sprintf("rule %q is not the first rule", rule))
+		}
+	}
+
+	return problems, nil
+}
+
+func countAppendRules(listed []string) (n int) {
+	for _, rule := range listed {
+		if strings.HasPrefix(rule, "-A ") {
+			n++
+		}
+	}
+	return n
+}
+
+func firstAppendRule(listed []string) string {
+	for _, rule := range listed {
+		if strings.HasPrefix(rule, "-A ") {
+			return strings.TrimSpace(rule)
+		}
+	}
+	return ""
+}
+
+// verifyFirewallDeactivated checks that the rules of the active firewall
+// backend are removed.
+func verifyFirewallDeactivated() error {
+	switch activeFirewallBackend {
+	case firewallBackendNftables:
+		return verifyNftablesDeactivated()
+	case firewallBackendIPTables:
+		_, v4o, v4c := ipTablesRules(false)
+		if err := verifyIPTablesDeactivated(iptables.ProtocolIPv4, v4o, v4c); err != nil {
+			return err
+		}
+		_, v6o, v6c := ipTablesRules(true)
+		return verifyIPTablesDeactivated(iptables.ProtocolIPv6, v6o, v6c)
+	default:
+		return nil
+	}
+}
+
+func verifyIPTablesDeactivated(protocol iptables.Protocol, rules, chains []string) error {
+	tbls, err := iptables.NewWithProtocol(protocol)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		splittedRule := strings.Split(rule, " ")
+		ok, err := tbls.Exists(splittedRule[0], splittedRule[1], splittedRule[2:]...)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("rule %q still exists", rule)
+		}
+	}
+
+	for _, chain := range chains {
+		splittedRule := strings.Split(chain, " ")
+		existing, err := tbls.ListChains(splittedRule[0])
+		if err != nil {
+			return err
+		}
+		for _, name := range existing {
+			if name == splittedRule[1] {
+				return fmt.Errorf("chain %q still exists", chain)
+			}
+		}
+	}
 
 	return nil
 }
 
-func handleInterception(packets chan<- packet.Packet) {
+// openQueues opens queueCount nfqueues, starting with the given queue number.
+func openQueues(firstQueue uint16, v6 bool) ([]nfQueue, error) {
+	queues := make([]nfQueue, 0, queueCount)
+	for i := uint16(0); i < uint16(queueCount); i++ {
+		q, err := nfq.New(firstQueue+i, v6)
+		if err != nil {
+			// Destroy the already opened queues.
+			for _, q := range queues {
+				q.Destroy()
+			}
+			return nil, fmt.Errorf("queue %d: %w", firstQueue+i, err)
+		}
+		queues = append(queues, q)
+	}
+	return queues, nil
+}
+
+// handleInterception starts a reader for every queue and registers it with
+// the watchdog.
+func handleInterception(packets chan<- packet.Packet, wd *watchdog) {
+	startReader := func(q nfQueue, direction packetDirection) {
+		qm := newQueueMonitor(func() int {
+			return len(q.PacketChannel())
+		})
+		wd.monitors = append(wd.monitors, qm)
+		go readQueue(q, direction, packets, qm)
+	}
+
+	for _, q := range out4Queues {
+		startReader(q, directionOutbound)
+	}
+	for _, q := range in4Queues {
+		startReader(q, directionInbound)
+	}
+	for _, q := range out6Queues {
+		startReader(q, directionOutbound)
+	}
+	for _, q := range in6Queues {
+		startReader(q, directionInbound)
+	}
+	for _, q := range fwd4Queues {
+		startReader(q, directionForwarded)
+	}
+	for _, q := range fwd6Queues {
+		startReader(q, directionForwarded)
+	}
+}
+
+type packetDirection uint8
+
+const (
+	directionOutbound packetDirection = iota
+	directionInbound
+	directionForwarded
+)
+
+// readQueue forwards the packets of a single queue in order.
+func readQueue(q nfQueue, direction packetDirection, packets chan<- packet.Packet, qm *queueMonitor) {
 	for {
 		var pkt packet.Packet
 		select {
 		case <-shutdownSignal:
 			return
-		case pkt = <-out4Queue.PacketChannel():
-			pkt.SetOutbound()
-		case pkt = <-in4Queue.PacketChannel():
-			pkt.SetInbound()
-		case pkt = <-out6Queue.PacketChannel():
+		case pkt = <-q.PacketChannel():
+		}
+
+		switch direction {
+		case directionOutbound:
 			pkt.SetOutbound()
-		case pkt = <-in6Queue.PacketChannel():
+		case directionInbound:
 			pkt.SetInbound()
+		case directionForwarded:
+			setForwardedDirection(pkt)
 		}
 
 		select {
 		case packets <- pkt:
+			qm.progress()
 		case <-shutdownSignal:
 			return
 		}
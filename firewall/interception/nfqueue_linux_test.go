@@ -0,0 +1,49 @@
+package interception
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareRules(t *testing.T) {
+	defer setRulesetOptions(false, 1, false)()
+
+	rules := []string{
+		"mangle C170 -j CONNMARK --restore-mark",
+		"mangle C170 -m mark --mark 0 -j NFQUEUE --queue-num 17040 --queue-bypass",
+	}
+
+	prepared, err := prepareRules(rules)
+	if assert.NoError(t, err) {
+		assert.Equal(t, rules, prepared)
+	}
+
+	// Balance over queue ranges and fail closed.
+	setRulesetOptions(false, 4, true)
+	prepared, err = prepareRules(rules)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{
+			"mangle C170 -j CONNMARK --restore-mark",
+			"mangle C170 -m mark --mark 0 -j NFQUEUE --queue-balance 17040:17043",
+		}, prepared)
+	}
+
+	// Invalid queue numbers fail instead of panicking.
+	_, err = prepareRules([]string{"mangle C170 -j NFQUEUE --queue-num 99999"})
+	assert.Error(t, err)
+}
+
+func TestQueueNumFromMatch(t *testing.T) {
+	num, err := queueNumFromMatch(iptablesQueueRegex, "--queue-num 17140")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(17140), num)
+	}
+
+	_, err = queueNumFromMatch(iptablesQueueRegex, "--queue-num 70000")
+	assert.Error(t, err)
+
+	_, err = queueNumFromMatch(regexp.MustCompile(`--queue-num`), "--queue-num")
+	assert.Error(t, err)
+}
@@ -0,0 +1,689 @@
+package interception
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// The nftables backend programs a dedicated table with the same mark semantics
+// and queue numbers as the iptables backend. The table is always created and
+// removed with a single netlink batch, which the kernel applies atomically.
+
+// Nftables netlink message types and attributes.
+// See linux/netfilter/nfnetlink.h and linux/netfilter/nf_tables.h.
+const (
+	nfnlSubsysNftables = 10
+	nfnlMsgBatchBegin  = 0x10
+	nfnlMsgBatchEnd    = 0x11
+
+	nftMsgNewTable = 0
+	nftMsgGetTable = 1
+	nftMsgDelTable = 2
+	nftMsgNewChain = 3
+	nftMsgNewRule  = 6
+	nftMsgGetRule  = 7
+
+	nftaTableName = 1
+
+	nftaChainTable  = 1
+	nftaChainName   = 3
+	nftaChainHook   = 4
+	nftaChainPolicy = 5
+	nftaChainType   = 7
+
+	nftaHookHooknum  = 1
+	nftaHookPriority = 2
+
+	nftaRuleTable       = 1
+	nftaRuleChain       = 2
+	nftaRuleExpressions = 4
+
+	nftaListElem = 1
+
+	nftaExprName = 1
+	nftaExprData = 2
+
+	nftaMetaDreg = 1
+	nftaMetaKey  = 2
+	nftaMetaSreg = 3
+
+	nftaCtDreg = 1
+	nftaCtKey  = 2
+	nftaCtSreg = 4
+
+	nftaCmpSreg = 1
+	nftaCmpOp   = 2
+	nftaCmpData = 3
+
+	nftaImmediateDreg = 1
+	nftaImmediateData = 2
+
+	nftaDataValue   = 1
+	nftaDataVerdict = 2
+
+	nftaVerdictCode  = 1
+	nftaVerdictChain = 2
+
+	nftaQueueNum   = 1
+	nftaQueueTotal = 2
+	nftaQueueFlags = 3
+
+	nftaRejectType     = 1
+	nftaRejectICMPCode = 2
+
+	nftaNatType        = 1
+	nftaNatFamily      = 2
+	nftaNatRegAddrMin  = 3
+	nftaNatRegProtoMin = 5
+
+	nftMetaMark    = 3
+	nftMetaNfproto = 15
+	nftMetaL4proto = 16
+
+	nftCtMark = 3
+
+	nftRegVerdict = 0
+	nftReg1       = 1
+	nftReg2       = 2
+
+	nftCmpEq = 0
+
+	nftQueueFlagBypass = 0x01
+
+	nftRejectICMPUnreach = 0
+
+	nftNatDNAT = 1
+
+	nfprotoInet = 1
+	nfprotoIPv4 = 2
+	nfprotoIPv6 = 10
+
+	nfInetLocalIn  = 1
+	nfInetForward  = 2
+	nfInetLocalOut = 3
+
+	nfAccept = 1
+
+	nftPriorityMangle = -150
+	nftPriorityFilter = 0
+	nftPriorityNat    = -100
+
+	icmpHostProhibited  = 10 // ICMP_HOST_ANO
+	icmpv6AdmProhibited = 1  // ICMPV6_ADM_PROHIBITED
+
+	ipProtoICMP = 1
+	ipProtoTCP  = 6
+	ipProtoUDP  = 17
+)
+
+// Verdict codes of the immediate expression.
+const (
+	nftVerdictDrop   int32 = 0
+	nftVerdictJump   int32 = -3
+	nftVerdictReturn int32 = -5
+)
+
+const (
+	nftTable = "portmaster"
+
+	// nftablesReplyTimeout is the time to wait for the kernel to acknowledge
+	// a batch.
+	nftablesReplyTimeout = 5 * time.Second
+)
+
+// nftChain is a chain of the portmaster table. Base chains have a hook, all
+// other chains are only jumped to.
+type nftChain struct {
+	name  string
+	hook  *nftHook
+	rules []nftRule
+}
+
+type nftHook struct {
+	chainType string
+	hooknum   uint32
+	priority  int32
+}
+
+// nftRule is a list of expressions that are evaluated in order.
+type nftRule []nftExpr
+
+// nftExpr is a single expression of a rule.
+type nftExpr struct {
+	name string
+	data func(ae *netlink.AttributeEncoder)
+}
+
+// nftablesRuleset returns the chains of the portmaster table. It adds the
+// chains for gateway mode if enabled, balances packets over queue ranges if
+// more than one queue is used and removes the queue bypass when failing
+// closed.
+func nftablesRuleset() []nftChain {
+	chains := []nftChain{
+		{
+			name:  "mangle_output",
+			hook:  &nftHook{chainType: "route", hooknum: nfInetLocalOut, priority: nftPriorityMangle},
+			rules: queueRules(17040, 17060),
+		},
+		{
+			name:  "mangle_input",
+			hook:  &nftHook{chainType: "filter", hooknum: nfInetLocalIn, priority: nftPriorityMangle},
+			rules: queueRules(17140, 17160),
+		},
+		{
+			name: "filter_output",
+			hook: &nftHook{chainType: "filter", hooknum: nfInetLocalOut, priority: nftPriorityFilter},
+			rules: []nftRule{
+				{exprVerdict(nftVerdictJump, "verdict")},
+			},
+		},
+		{
+			name: "filter_input",
+			hook: &nftHook{chainType: "filter", hooknum: nfInetLocalIn, priority: nftPriorityFilter},
+			rules: []nftRule{
+				{exprVerdict(nftVerdictJump, "verdict")},
+			},
+		},
+	}
+
+	if gatewayMode {
+		chains = append(chains,
+			nftChain{
+				name:  "mangle_forward",
+				hook:  &nftHook{chainType: "filter", hooknum: nfInetForward, priority: nftPriorityMangle},
+				rules: queueRules(17240, 17260),
+			},
+			nftChain{
+				name: "filter_forward",
+				hook: &nftHook{chainType: "filter", hooknum: nfInetForward, priority: nftPriorityFilter},
+				rules: []nftRule{
+					{exprVerdict(nftVerdictJump, "verdict")},
+				},
+			},
+		)
+	}
+
+	return append(chains,
+		nftChain{
+			name: "verdict",
+			rules: concatRules(
+				[]nftRule{
+					withMark(0, exprVerdict(nftVerdictDrop, "")),
+					withMark(1700, exprVerdict(nftVerdictReturn, "")),
+				},
+				rejectRules(1701),
+				[]nftRule{
+					withMark(1702, exprVerdict(nftVerdictDrop, "")),
+					// ct mark set meta mark
+					{exprMetaLoad(nftMetaMark), exprCtSet(nftCtMark)},
+					withMark(1710, exprVerdict(nftVerdictReturn, "")),
+				},
+				rejectRules(1711),
+				[]nftRule{
+					withMark(1712, exprVerdict(nftVerdictDrop, "")),
+					withMark(1717, exprVerdict(nftVerdictReturn, "")),
+				},
+			),
+		},
+		nftChain{
+			name: "nat_output",
+			hook: &nftHook{chainType: "nat", hooknum: nfInetLocalOut, priority: nftPriorityNat},
+			rules: []nftRule{
+				dnatRule(1799, ipProtoUDP, net.IPv4(127, 0, 0, 17), 53),
+				dnatRule(1717, ipProtoTCP, net.IPv4(127, 0, 0, 17), 717),
+				dnatRule(1717, ipProtoUDP, net.IPv4(127, 0, 0, 17), 717),
+				dnatRule(1799, ipProtoUDP, net.IPv6loopback, 53),
+				dnatRule(1717, ipProtoTCP, net.IPv6loopback, 717),
+				dnatRule(1717, ipProtoUDP, net.IPv6loopback, 717),
+			},
+		},
+	)
+}
+
+// queueRules restores the connection mark and queues packets without a mark
+// to the given IPv4 and IPv6 queues.
+func queueRules(v4Queue, v6Queue uint16) []nftRule {
+	var flags uint16
+	if !failClosed {
+		flags = nftQueueFlagBypass
+	}
+	total := uint16(1)
+	if queueCount > 1 {
+		total = uint16(queueCount)
+	}
+
+	return []nftRule{
+		// meta mark set ct mark
+		{exprCtLoad(nftCtMark), exprMetaSet(nftMetaMark)},
+		withMark(0, exprMetaLoad(nftMetaNfproto), exprCmpEq([]byte{nfprotoIPv4}), exprQueue(v4Queue, total, flags)),
+		withMark(0, exprMetaLoad(nftMetaNfproto), exprCmpEq([]byte{nfprotoIPv6}), exprQueue(v6Queue, total, flags)),
+	}
+}
+
+// rejectRules rejects packets with the given mark, except ICMP packets.
+// Accepting ICMP packets is required for rejecting to work, as the rejection
+// ICMP packet will have the same mark. Blocked ICMP packets will always result
+// in a drop within the Portmaster.
+func rejectRules(mark uint32) []nftRule {
+	return []nftRule{
+		withMark(mark,
+			exprMetaLoad(nftMetaL4proto), exprCmpEq([]byte{ipProtoICMP}),
+			exprVerdict(nftVerdictReturn, ""),
+		),
+		withMark(mark,
+			exprMetaLoad(nftMetaNfproto), exprCmpEq([]byte{nfprotoIPv4}),
+			exprReject(nftRejectICMPUnreach, icmpHostProhibited),
+		),
+		withMark(mark,
+			exprMetaLoad(nftMetaNfproto), exprCmpEq([]byte{nfprotoIPv6}),
+			exprReject(nftRejectICMPUnreach, icmpv6AdmProhibited),
+		),
+	}
+}
+
+// dnatRule redirects packets with the given mark and protocol to the given
+// address and port.
+func dnatRule(mark uint32, l4proto uint8, ip net.IP, port uint16) nftRule {
+	family := uint32(nfprotoIPv6)
+	if ip4 := ip.To4(); ip4 != nil {
+		family = nfprotoIPv4
+		ip = ip4
+	}
+
+	portData := make([]byte, 2)
+	binary.BigEndian.PutUint16(portData, port)
+
+	return withMark(mark,
+		exprMetaLoad(nftMetaNfproto), exprCmpEq([]byte{uint8(family)}),
+		exprMetaLoad(nftMetaL4proto), exprCmpEq([]byte{l4proto}),
+		exprImmediate(nftReg1, ip),
+		exprImmediate(nftReg2, portData),
+		exprDNAT(family, nftReg1, nftReg2),
+	)
+}
+
+// withMark prepends a match on the packet mark to the given expressions.
+func withMark(mark uint32, exprs ...nftExpr) nftRule {
+	return append(nftRule{exprMetaLoad(nftMetaMark), exprCmpEq(nlenc.Uint32Bytes(mark))}, exprs...)
+}
+
+func concatRules(ruleLists ...[]nftRule) []nftRule {
+	var rules []nftRule
+	for _, list := range ruleLists {
+		rules = append(rules, list...)
+	}
+	return rules
+}
+
+func exprMetaLoad(key uint32) nftExpr {
+	return nftExpr{name: "meta", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaMetaKey, key)
+		ae.Uint32(nftaMetaDreg, nftReg1)
+	}}
+}
+
+func exprMetaSet(key uint32) nftExpr {
+	return nftExpr{name: "meta", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaMetaKey, key)
+		ae.Uint32(nftaMetaSreg, nftReg1)
+	}}
+}
+
+func exprCtLoad(key uint32) nftExpr {
+	return nftExpr{name: "ct", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaCtKey, key)
+		ae.Uint32(nftaCtDreg, nftReg1)
+	}}
+}
+
+func exprCtSet(key uint32) nftExpr {
+	return nftExpr{name: "ct", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaCtKey, key)
+		ae.Uint32(nftaCtSreg, nftReg1)
+	}}
+}
+
+func exprCmpEq(data []byte) nftExpr {
+	return nftExpr{name: "cmp", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaCmpSreg, nftReg1)
+		ae.Uint32(nftaCmpOp, nftCmpEq)
+		ae.Nested(nftaCmpData, func(dae *netlink.AttributeEncoder) error {
+			dae.Bytes(nftaDataValue, data)
+			return nil
+		})
+	}}
+}
+
+func exprImmediate(reg uint32, data []byte) nftExpr {
+	return nftExpr{name: "immediate", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaImmediateDreg, reg)
+		ae.Nested(nftaImmediateData, func(dae *netlink.AttributeEncoder) error {
+			dae.Bytes(nftaDataValue, data)
+			return nil
+		})
+	}}
+}
+
+func exprVerdict(code int32, chain string) nftExpr {
+	return nftExpr{name: "immediate", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaImmediateDreg, nftRegVerdict)
+		ae.Nested(nftaImmediateData, func(dae *netlink.AttributeEncoder) error {
+			dae.Nested(nftaDataVerdict, func(vae *netlink.AttributeEncoder) error {
+				vae.Uint32(nftaVerdictCode, uint32(code))
+				if chain != "" {
+					vae.String(nftaVerdictChain, chain)
+				}
+				return nil
+			})
+			return nil
+		})
+	}}
+}
+
+func exprQueue(num, total, flags uint16) nftExpr {
+	return nftExpr{name: "queue", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint16(nftaQueueNum, num)
+		ae.Uint16(nftaQueueTotal, total)
+		ae.Uint16(nftaQueueFlags, flags)
+	}}
+}
+
+func exprReject(rejectType uint32, code uint8) nftExpr {
+	return nftExpr{name: "reject", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaRejectType, rejectType)
+		ae.Uint8(nftaRejectICMPCode, code)
+	}}
+}
+
+func exprDNAT(family, addrReg, protoReg uint32) nftExpr {
+	return nftExpr{name: "nat", data: func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(nftaNatType, nftNatDNAT)
+		ae.Uint32(nftaNatFamily, family)
+		ae.Uint32(nftaNatRegAddrMin, addrReg)
+		ae.Uint32(nftaNatRegProtoMin, protoReg)
+	}}
+}
+
+// nftablesAvailable returns whether the kernel supports nftables.
+func nftablesAvailable() bool {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return false
+	}
+	defer conn.Close() //nolint:errcheck
+
+	msg, err := newNftMsg(nftMsgGetTable, netlink.Request|netlink.Dump, nfprotoInet, nil)
+	if err != nil {
+		return false
+	}
+	_, err = conn.Execute(msg)
+	return err == nil
+}
+
+// nftablesListing holds the listing of the table right after it was created.
+var nftablesListing []byte
+
+func activateNftablesFirewall() error {
+	msgs, err := nftablesActivateMsgs(nftablesRuleset())
+	if err != nil {
+		return fmt.Errorf("failed to build nftables table %s: %w", nftTable, err)
+	}
+	if err := sendNftablesBatch(msgs); err != nil {
+		return fmt.Errorf("failed to create nftables table %s: %w", nftTable, err)
+	}
+
+	listing, err := listNftablesTable()
+	if err != nil {
+		return fmt.Errorf("failed to list nftables table %s: %w", nftTable, err)
+	}
+	nftablesListing = listing
+	return nil
+}
+
+// checkNftablesRules checks that the table was not changed since it was
+// created.
+func checkNftablesRules() (problems []string, err error) {
+	listing, err := listNftablesTable()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to list nftables table %s: %w", nftTable, err)
+	case len(listing) == 0:
+		return []string{fmt.Sprintf("table %s is missing", nftTable)}, nil
+	case !bytes.Equal(listing, nftablesListing):
+		return []string{fmt.Sprintf("table %s was changed", nftTable)}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// listNftablesTable returns the rules of the table as the kernel reports
+// them. The listing is empty if the table does not exist.
+func listNftablesTable() ([]byte, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	msg, err := newNftMsg(nftMsgGetRule, netlink.Request|netlink.Dump, nfprotoInet, func(ae *netlink.AttributeEncoder) {
+		ae.String(nftaRuleTable, nftTable)
+	})
+	if err != nil {
+		return nil, err
+	}
+	replies, err := conn.Execute(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var listing []byte
+	for _, reply := range replies {
+		// Skip the nfgenmsg header, as it holds the ruleset generation, which
+		// changes with every change of any table.
+		if len(reply.Data) > 4 {
+			listing = append(listing, reply.Data[4:]...)
+		}
+	}
+	return listing, nil
+}
+
+// DeactivateNftablesFirewall removes the portmaster nftables table.
+func DeactivateNftablesFirewall() error {
+	msgs, err := nftablesRemoveMsgs()
+	if err != nil {
+		return fmt.Errorf("failed to build removal of nftables table %s: %w", nftTable, err)
+	}
+	if err := sendNftablesBatch(msgs); err != nil {
+		return fmt.Errorf("failed to remove nftables table %s: %w", nftTable, err)
+	}
+	return nil
+}
+
+// verifyNftablesDeactivated checks that the table was removed.
+func verifyNftablesDeactivated() error {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	msg, err := newNftMsg(nftMsgGetTable, netlink.Request|netlink.Acknowledge, nfprotoInet, func(ae *netlink.AttributeEncoder) {
+		ae.String(nftaTableName, nftTable)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Execute(msg)
+	switch {
+	case err == nil:
+		return fmt.Errorf("nftables table %s still exists", nftTable)
+	case errors.Is(err, unix.ENOENT):
+		return nil
+	default:
+		return fmt.Errorf("failed to check nftables table %s: %w", nftTable, err)
+	}
+}
+
+// nftablesRemoveMsgs returns the messages that remove the table. Adding it
+// first makes the removal succeed if the table does not exist.
+func nftablesRemoveMsgs() ([]netlink.Message, error) {
+	encodeName := func(ae *netlink.AttributeEncoder) {
+		ae.String(nftaTableName, nftTable)
+	}
+
+	addTable, err := newNftMsg(nftMsgNewTable, netlink.Request|netlink.Create|netlink.Acknowledge, nfprotoInet, encodeName)
+	if err != nil {
+		return nil, err
+	}
+	delTable, err := newNftMsg(nftMsgDelTable, netlink.Request|netlink.Acknowledge, nfprotoInet, encodeName)
+	if err != nil {
+		return nil, err
+	}
+	return []netlink.Message{addTable, delTable}, nil
+}
+
+// nftablesActivateMsgs returns the messages that replace the table with the
+// given chains.
+func nftablesActivateMsgs(chains []nftChain) ([]netlink.Message, error) {
+	msgs, err := nftablesRemoveMsgs()
+	if err != nil {
+		return nil, err
+	}
+
+	addTable, err := newNftMsg(nftMsgNewTable, netlink.Request|netlink.Create|netlink.Acknowledge, nfprotoInet, func(ae *netlink.AttributeEncoder) {
+		ae.String(nftaTableName, nftTable)
+	})
+	if err != nil {
+		return nil, err
+	}
+	msgs = append(msgs, addTable)
+
+	// Add all chains first, as rules may jump to chains defined later.
+	for _, chain := range chains {
+		chain := chain
+		msg, err := newNftMsg(nftMsgNewChain, netlink.Request|netlink.Create|netlink.Acknowledge, nfprotoInet, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaChainTable, nftTable)
+			ae.String(nftaChainName, chain.name)
+			if chain.hook != nil {
+				ae.Nested(nftaChainHook, func(hae *netlink.AttributeEncoder) error {
+					hae.Uint32(nftaHookHooknum, chain.hook.hooknum)
+					hae.Uint32(nftaHookPriority, uint32(chain.hook.priority))
+					return nil
+				})
+				ae.Uint32(nftaChainPolicy, nfAccept)
+				ae.String(nftaChainType, chain.hook.chainType)
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode chain %s: %w", chain.name, err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	for _, chain := range chains {
+		for _, rule := range chain.rules {
+			chainName := chain.name
+			rule := rule
+			msg, err := newNftMsg(nftMsgNewRule, netlink.Request|netlink.Create|netlink.Append|netlink.Acknowledge, nfprotoInet, func(ae *netlink.AttributeEncoder) {
+				ae.String(nftaRuleTable, nftTable)
+				ae.String(nftaRuleChain, chainName)
+				ae.Nested(nftaRuleExpressions, func(lae *netlink.AttributeEncoder) error {
+					for _, expr := range rule {
+						expr := expr
+						lae.Nested(nftaListElem, func(eae *netlink.AttributeEncoder) error {
+							eae.String(nftaExprName, expr.name)
+							eae.Nested(nftaExprData, func(dae *netlink.AttributeEncoder) error {
+								expr.data(dae)
+								return nil
+							})
+							return nil
+						})
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode rule of chain %s: %w", chain.name, err)
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return msgs, nil
+}
+
+// newNftMsg builds an nftables message of the given type.
+func newNftMsg(msgType uint16, flags netlink.HeaderFlags, family uint8, addAttrs func(ae *netlink.AttributeEncoder)) (netlink.Message, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	if addAttrs != nil {
+		addAttrs(ae)
+	}
+	attrs, err := ae.Encode()
+	if err != nil {
+		return netlink.Message{}, err
+	}
+
+	// Prepend nfgenmsg header.
+	return netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(nfnlSubsysNftables<<8 | msgType),
+			Flags: flags,
+		},
+		Data: append([]byte{family, 0, 0, 0}, attrs...),
+	}, nil
+}
+
+// nftablesBatch wraps the given messages in a batch, which the kernel applies
+// in a single transaction.
+func nftablesBatch(msgs []netlink.Message) []netlink.Message {
+	// The resource id of the batch messages holds the subsystem.
+	batchHeader := []byte{unix.AF_UNSPEC, 0, 0, 0}
+	binary.BigEndian.PutUint16(batchHeader[2:], nfnlSubsysNftables)
+
+	batch := make([]netlink.Message, 0, len(msgs)+2)
+	batch = append(batch, netlink.Message{
+		Header: netlink.Header{Type: nfnlMsgBatchBegin, Flags: netlink.Request},
+		Data:   batchHeader,
+	})
+	batch = append(batch, msgs...)
+	return append(batch, netlink.Message{
+		Header: netlink.Header{Type: nfnlMsgBatchEnd, Flags: netlink.Request},
+		Data:   batchHeader,
+	})
+}
+
+// sendNftablesBatch applies the given messages in a single transaction and
+// waits for the acknowledgement of every message.
+func sendNftablesBatch(msgs []netlink.Message) error {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if _, err := conn.SendMessages(nftablesBatch(msgs)); err != nil {
+		return err
+	}
+
+	// Every message of the batch is acknowledged or answered with an error.
+	if err := conn.SetReadDeadline(time.Now().Add(nftablesReplyTimeout)); err != nil {
+		return err
+	}
+	for acked := 0; acked < len(msgs); {
+		replies, err := conn.Receive()
+		if err != nil {
+			return err
+		}
+		acked += len(replies)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package interception
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/stretchr/testify/assert"
+)
+
+// setRulesetOptions sets the options the ruleset depends on and returns a
+// function that restores them.
+func setRulesetOptions(gateway bool, queues uint, closed bool) (restore func()) {
+	prevGateway, prevQueues, prevClosed := gatewayMode, queueCount, failClosed
+	gatewayMode, queueCount, failClosed = gateway, queues, closed
+	return func() {
+		gatewayMode, queueCount, failClosed = prevGateway, prevQueues, prevClosed
+	}
+}
+
+func chainNames(chains []nftChain) []string {
+	names := make([]string, 0, len(chains))
+	for _, chain := range chains {
+		names = append(names, chain.name)
+	}
+	return names
+}
+
+func encodeExpr(t *testing.T, expr nftExpr) *netlink.AttributeDecoder {
+	t.Helper()
+
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	expr.data(ae)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad.ByteOrder = binary.BigEndian
+	return ad
+}
+
+// decodeQueue returns the queue number, total and flags of a queue expression.
+func decodeQueue(t *testing.T, expr nftExpr) (num, total, flags uint16) {
+	t.Helper()
+
+	assert.Equal(t, "queue", expr.name)
+	ad := encodeExpr(t, expr)
+	for ad.Next() {
+		switch ad.Type() {
+		case nftaQueueNum:
+			num = ad.Uint16()
+		case nftaQueueTotal:
+			total = ad.Uint16()
+		case nftaQueueFlags:
+			flags = ad.Uint16()
+		}
+	}
+	assert.NoError(t, ad.Err())
+	return num, total, flags
+}
+
+func TestNftablesRuleset(t *testing.T) {
+	defer setRulesetOptions(false, 1, false)()
+
+	chains := nftablesRuleset()
+	assert.Equal(t,
+		[]string{"mangle_output", "mangle_input", "filter_output", "filter_input", "verdict", "nat_output"},
+		chainNames(chains),
+	)
+
+	// The output queue rules queue to a single queue and bypass it if the
+	// Portmaster is not running.
+	mangleOutput := chains[0]
+	assert.Len(t, mangleOutput.rules, 3)
+	num, total, flags := decodeQueue(t, mangleOutput.rules[1][len(mangleOutput.rules[1])-1])
+	assert.Equal(t, uint16(17040), num)
+	assert.Equal(t, uint16(1), total)
+	assert.Equal(t, uint16(nftQueueFlagBypass), flags)
+	num, _, _ = decodeQueue(t, mangleOutput.rules[2][len(mangleOutput.rules[2])-1])
+	assert.Equal(t, uint16(17060), num)
+
+	// The verdict chain has a rule for every mark of the iptables backend.
+	var verdict nftChain
+	for _, chain := range chains {
+		if chain.name == "verdict" {
+			verdict = chain
+		}
+	}
+	assert.Nil(t, verdict.hook)
+	assert.Len(t, verdict.rules, 13)
+
+	// Marks are compared in host byte order.
+	ad := encodeExpr(t, verdict.rules[1][1])
+	var mark []byte
+	for ad.Next() {
+		if ad.Type() == nftaCmpData {
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				for nad.Next() {
+					if nad.Type() == nftaDataValue {
+						mark = nad.Bytes()
+					}
+				}
+				return nil
+			})
+		}
+	}
+	assert.NoError(t, ad.Err())
+	assert.Equal(t, nlenc.Uint32Bytes(1700), mark)
+}
+
+func TestNftablesRulesetOptions(t *testing.T) {
+	defer setRulesetOptions(true, 4, true)()
+
+	chains := nftablesRuleset()
+	assert.Equal(t,
+		[]string{"mangle_output", "mangle_input", "filter_output", "filter_input", "mangle_forward", "filter_forward", "verdict", "nat_output"},
+		chainNames(chains),
+	)
+
+	// Packets are balanced over the queues and dropped if the Portmaster is
+	// not running.
+	mangleForward := chains[4]
+	num, total, flags := decodeQueue(t, mangleForward.rules[1][len(mangleForward.rules[1])-1])
+	assert.Equal(t, uint16(17240), num)
+	assert.Equal(t, uint16(4), total)
+	assert.Equal(t, uint16(0), flags)
+}
+
+func TestNftablesActivateMsgs(t *testing.T) {
+	defer setRulesetOptions(false, 1, false)()
+
+	chains := nftablesRuleset()
+	msgs, err := nftablesActivateMsgs(chains)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rules int
+	for _, chain := range chains {
+		rules += len(chain.rules)
+	}
+	if !assert.Len(t, msgs, 3+len(chains)+rules) {
+		return
+	}
+
+	// The table is replaced: added, deleted and added again.
+	for i, msgType := range []uint16{nftMsgNewTable, nftMsgDelTable, nftMsgNewTable} {
+		assert.Equal(t, netlink.HeaderType(nfnlSubsysNftables<<8|msgType), msgs[i].Header.Type)
+		assert.Equal(t, uint8(nfprotoInet), msgs[i].Data[0])
+	}
+	for _, msg := range msgs[3 : 3+len(chains)] {
+		assert.Equal(t, netlink.HeaderType(nfnlSubsysNftables<<8|nftMsgNewChain), msg.Header.Type)
+	}
+
+	// The first rule of the verdict chain drops packets without a mark. It
+	// follows the rules of the mangle and filter chains.
+	ruleMsg := msgs[3+len(chains)+len(chains[0].rules)+len(chains[1].rules)+len(chains[2].rules)+len(chains[3].rules)]
+	assert.Equal(t, netlink.HeaderType(nfnlSubsysNftables<<8|nftMsgNewRule), ruleMsg.Header.Type)
+	assert.NotZero(t, ruleMsg.Header.Flags&netlink.Append)
+
+	ad, err := netlink.NewAttributeDecoder(ruleMsg.Data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		chain string
+		exprs []string
+	)
+	for ad.Next() {
+		switch ad.Type() {
+		case nftaRuleChain:
+			chain = ad.String()
+		case nftaRuleExpressions:
+			ad.Nested(func(lad *netlink.AttributeDecoder) error {
+				for lad.Next() {
+					lad.Nested(func(ead *netlink.AttributeDecoder) error {
+						for ead.Next() {
+							if ead.Type() == nftaExprName {
+								exprs = append(exprs, ead.String())
+							}
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		}
+	}
+	assert.NoError(t, ad.Err())
+	assert.Equal(t, "verdict", chain)
+	assert.Equal(t, []string{"meta", "cmp", "immediate"}, exprs)
+}
+
+func TestNftablesBatch(t *testing.T) {
+	msgs, err := nftablesRemoveMsgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := nftablesBatch(msgs)
+	if !assert.Len(t, batch, len(msgs)+2) {
+		return
+	}
+	assert.Equal(t, netlink.HeaderType(nfnlMsgBatchBegin), batch[0].Header.Type)
+	assert.Equal(t, netlink.HeaderType(nfnlMsgBatchEnd), batch[len(batch)-1].Header.Type)
+	assert.Equal(t, []byte{0, 0, 0, nfnlSubsysNftables}, batch[0].Data)
+	assert.Equal(t, msgs, batch[1:len(batch)-1])
+}
@@ -3,9 +3,17 @@
 import (
 	"time"
 
+	pmmetrics "github.com/safing/portmaster/metrics"
 	"github.com/safing/portmaster/network/packet"
 )
 
+var packetDecisionLatency = pmmetrics.NewHistogramVec(
+	"portmaster_firewall_packet_decision_seconds",
+	"Time from intercepting a packet until a verdict was issued.",
+	pmmetrics.DefaultLatencyBuckets,
+	"verdict",
+)
+
 type tracedPacket struct {
 	start time.Time
 	packet.Packet
@@ -19,6 +27,9 @@ func tracePacket(p packet.Packet) packet.Packet {
 }
 
 func (p *tracedPacket) markServed(v string) {
+	if pmmetrics.Enabled() {
+		packetDecisionLatency.With(v).Observe(time.Since(p.start).Seconds())
+	}
 	if packetMetricsDestination == "" {
 		return
 	}
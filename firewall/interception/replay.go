@@ -0,0 +1,486 @@
+package interception
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/network/state"
+	"github.com/safing/portmaster/process"
+)
+
+// The replay backend feeds the packets of a recorded pcap or pcapng file
+// through the firewall instead of intercepting live traffic, and records the
+// issued verdicts in a report. Connections are attributed to processes using
+// the configured flows instead of the system state, so that no special
+// privileges are required.
+
+const (
+	// replayVerdictTimeout defines how long to wait for the verdict of a
+	// replayed packet before continuing with the next one.
+	replayVerdictTimeout = 10 * time.Second
+
+	pcapngMagic = 0x0A0D0D0A
+)
+
+var (
+	replayFile       string
+	replayConfigFile string
+	replayReportFile string
+
+	// replayStop and replayDone are set while a replay is running.
+	replayStop chan struct{}
+	replayDone chan struct{}
+	replayLock sync.Mutex
+
+	// replayPermanentVerdicts holds the permanent verdicts of connections.
+	// They are applied by the system to all further packets of a connection,
+	// which then do not reach the Portmaster anymore.
+	replayPermanentVerdicts     = make(map[string]string)
+	replayPermanentVerdictsLock sync.Mutex
+)
+
+func init() {
+	flag.StringVar(&replayFile, "replay-pcap", "", "replay the packets of the given pcap or pcapng file instead of intercepting packets - for testing only")
+	flag.StringVar(&replayConfigFile, "replay-config", "", "the configuration of local IPs and process flows for replaying packets")
+	flag.StringVar(&replayReportFile, "replay-report", "", "write the verdicts of replayed packets to the specified file")
+}
+
+func replayEnabled() bool {
+	return replayFile != ""
+}
+
+// ReplayConfig configures how recorded packets are replayed.
+type ReplayConfig struct {
+	// LocalIPs holds the IPs of the recording host, which define the direction
+	// of the packets.
+	LocalIPs []net.IP
+	// Flows maps connections to processes. The first matching flow is used.
+	Flows []*ReplayFlow
+}
+
+// ReplayFlow maps connections to a configured process. Unset address fields
+// match any value. The PID identifies the process within the replay only, it
+// is mapped to a fake PID that does not collide with real processes.
+type ReplayFlow struct {
+	Protocol   packet.IPProtocol
+	LocalIP    net.IP
+	LocalPort  uint16
+	RemoteIP   net.IP
+	RemotePort uint16
+	Inbound    bool
+
+	PID  int
+	Path string
+}
+
+// ReplayReport holds the results of a replay.
+type ReplayReport struct {
+	File     string
+	Started  time.Time
+	Finished time.Time
+
+	// Packets holds the number of packets read from the file.
+	Packets int
+	// Skipped holds the number of packets that could not be replayed, eg.
+	// because they are no IP packets or do not belong to the recording host.
+	Skipped int
+	// Summary holds the number of packets per verdict.
+	Summary map[string]int
+	// Verdicts holds the verdict of every replayed packet.
+	Verdicts []*ReplayVerdict
+	// Error holds the error that stopped the replay, if any.
+	Error string
+}
+
+// ReplayVerdict is the verdict issued for a replayed packet.
+type ReplayVerdict struct {
+	Index        int
+	Timestamp    time.Time
+	Packet       string
+	ConnectionID string
+	Verdict      string
+	// Offloaded is set if the packet was handled by an earlier permanent
+	// verdict and would not have reached the Portmaster.
+	Offloaded bool
+}
+
+// replayPacket is a recorded packet that records the verdict it receives.
+type replayPacket struct {
+	packet.Base
+
+	verdict chan string
+}
+
+func (pkt *replayPacket) setVerdict(verdict string) error {
+	select {
+	case pkt.verdict <- verdict:
+	default:
+		log.Warningf("interception: replayed packet %s received more than one verdict: %s", pkt, verdict)
+	}
+	return nil
+}
+
+func (pkt *replayPacket) Accept() error {
+	return pkt.setVerdict("accept")
+}
+
+func (pkt *replayPacket) Block() error {
+	return pkt.setVerdict("block")
+}
+
+func (pkt *replayPacket) Drop() error {
+	return pkt.setVerdict("drop")
+}
+
+func (pkt *replayPacket) PermanentAccept() error {
+	return pkt.setVerdict("perm-accept")
+}
+
+func (pkt *replayPacket) PermanentBlock() error {
+	return pkt.setVerdict("perm-block")
+}
+
+func (pkt *replayPacket) PermanentDrop() error {
+	return pkt.setVerdict("perm-drop")
+}
+
+func (pkt *replayPacket) RerouteToNameserver() error {
+	return pkt.setVerdict("reroute-ns")
+}
+
+func (pkt *replayPacket) RerouteToTunnel() error {
+	return pkt.setVerdict("reroute-tunnel")
+}
+
+func isPermanentReplayVerdict(verdict string) bool {
+	switch verdict {
+	case "perm-accept", "perm-block", "perm-drop":
+		return true
+	default:
+		return false
+	}
+}
+
+func getReplayPermanentVerdict(connID string) (verdict string, ok bool) {
+	replayPermanentVerdictsLock.Lock()
+	defer replayPermanentVerdictsLock.Unlock()
+
+	verdict, ok = replayPermanentVerdicts[connID]
+	return
+}
+
+func setReplayPermanentVerdict(connID, verdict string) {
+	replayPermanentVerdictsLock.Lock()
+	defer replayPermanentVerdictsLock.Unlock()
+
+	if verdict == "" {
+		delete(replayPermanentVerdicts, connID)
+	} else {
+		replayPermanentVerdicts[connID] = verdict
+	}
+}
+
+// updateReplayVerdict changes the permanent verdict of a replayed connection,
+// like updateVerdict does for the connection tracking of the system.
+func updateReplayVerdict(info *packet.Info, verdict network.Verdict) error {
+	pkt := &packet.Base{}
+	pkt.SetPacketInfo(*info)
+
+	switch verdict {
+	case network.VerdictAccept:
+		setReplayPermanentVerdict(pkt.GetConnectionID(), "perm-accept")
+	case network.VerdictBlock:
+		setReplayPermanentVerdict(pkt.GetConnectionID(), "perm-block")
+	case network.VerdictDrop:
+		setReplayPermanentVerdict(pkt.GetConnectionID(), "perm-drop")
+	default:
+		setReplayPermanentVerdict(pkt.GetConnectionID(), "")
+	}
+	return nil
+}
+
+func startReplay(packets chan<- packet.Packet) error {
+	config := &ReplayConfig{}
+	if replayConfigFile != "" {
+		data, err := ioutil.ReadFile(replayConfigFile)
+		if err != nil {
+			return fmt.Errorf("failed to read replay config: %w", err)
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return fmt.Errorf("failed to parse replay config: %w", err)
+		}
+	}
+	if len(config.LocalIPs) == 0 {
+		return errors.New("replay config must define the local IPs of the recording host")
+	}
+
+	// Attribute connections using the configured flows.
+	flows := make([]*state.FakeFlow, 0, len(config.Flows))
+	for _, flow := range config.Flows {
+		if flow.PID < 0 {
+			return fmt.Errorf("replay flow has invalid PID %d", flow.PID)
+		}
+		pid := process.FakeProcessID(flow.PID)
+
+		flows = append(flows, &state.FakeFlow{
+			Protocol:   flow.Protocol,
+			LocalIP:    flow.LocalIP,
+			LocalPort:  flow.LocalPort,
+			RemoteIP:   flow.RemoteIP,
+			RemotePort: flow.RemotePort,
+			PID:        pid,
+			Inbound:    flow.Inbound,
+		})
+		if _, ok := process.GetProcessFromStorage(pid); !ok {
+			process.AddFakeProcess(context.Background(), pid, flow.Path)
+		}
+	}
+
+	f, err := os.Open(replayFile)
+	if err != nil {
+		return fmt.Errorf("failed to open replay file: %w", err)
+	}
+	source, err := newPacketSource(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	replayLock.Lock()
+	defer replayLock.Unlock()
+
+	if replayStop != nil {
+		_ = f.Close()
+		return errors.New("replay is already running")
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	replayStop, replayDone = stop, done
+	state.UseFakeFlows(flows)
+
+	log.Warningf("interception: replaying packets from %s instead of intercepting packets", replayFile)
+	go func() {
+		defer close(done)
+		defer f.Close() //nolint:errcheck
+
+		report := replay(source, config, packets, stop)
+		if err := writeReplayReport(report); err != nil {
+			log.Errorf("interception: failed to write replay report: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// stopReplay stops the running replay, if any, and waits for it to finish.
+func stopReplay() error {
+	replayLock.Lock()
+	defer replayLock.Unlock()
+
+	if replayStop == nil {
+		return nil
+	}
+	close(replayStop)
+	<-replayDone
+	replayStop, replayDone = nil, nil
+
+	state.UseFakeFlows(nil)
+	return nil
+}
+
+// packetSource reads packets and their link type from a capture file.
+type packetSource interface {
+	ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error)
+	LinkType() layers.LinkType
+}
+
+// ngPacketSource adapts the pcapng reader, which supports multiple
+// interfaces, to the packetSource interface.
+type ngPacketSource struct {
+	*pcapgo.NgReader
+	lastLinkType layers.LinkType
+}
+
+func (src *ngPacketSource) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	data, ci, err = src.NgReader.ReadPacketData()
+	if err == nil {
+		if intf, err := src.Interface(ci.InterfaceIndex); err == nil {
+			src.lastLinkType = intf.LinkType
+		}
+	}
+	return data, ci, err
+}
+
+func (src *ngPacketSource) LinkType() layers.LinkType {
+	return src.lastLinkType
+}
+
+func newPacketSource(r io.Reader) (packetSource, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay file: %w", err)
+	}
+
+	// The section header block type is the same in both byte orders.
+	if binary.BigEndian.Uint32(magic) == pcapngMagic {
+		ngReader, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pcapng file: %w", err)
+		}
+		return &ngPacketSource{
+			NgReader:     ngReader,
+			lastLinkType: ngReader.LinkType(),
+		}, nil
+	}
+
+	reader, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pcap file: %w", err)
+	}
+	return reader, nil
+}
+
+// replay feeds all packets of the source to the firewall, one at a time,
+// until the source is exhausted or stop is closed.
+func replay(source packetSource, config *ReplayConfig, packets chan<- packet.Packet, stop <-chan struct{}) *ReplayReport {
+	report := &ReplayReport{
+		File:    replayFile,
+		Started: time.Now(),
+		Summary: make(map[string]int),
+	}
+	defer func() {
+		report.Finished = time.Now()
+	}()
+
+	for {
+		data, ci, err := source.ReadPacketData()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			log.Infof("interception: finished replaying %d packets from %s", report.Packets, replayFile)
+			return report
+		default:
+			report.Error = err.Error()
+			log.Errorf("interception: failed to read packet from replay file: %s", err)
+			return report
+		}
+		report.Packets++
+
+		pkt, ok := newReplayPacket(data, source.LinkType(), config.LocalIPs)
+		if !ok {
+			report.Skipped++
+			continue
+		}
+
+		record := &ReplayVerdict{
+			Index:        report.Packets,
+			Timestamp:    ci.Timestamp,
+			Packet:       pkt.String(),
+			ConnectionID: pkt.GetConnectionID(),
+		}
+
+		if verdict, ok := getReplayPermanentVerdict(pkt.GetConnectionID()); ok {
+			record.Verdict = verdict
+			record.Offloaded = true
+		} else {
+			select {
+			case packets <- pkt:
+			case <-stop:
+				report.Error = "replay was stopped"
+				return report
+			}
+
+			select {
+			case record.Verdict = <-pkt.verdict:
+			case <-time.After(replayVerdictTimeout):
+				record.Verdict = "none"
+				log.Warningf("interception: replayed packet %s did not receive a verdict", pkt)
+			case <-stop:
+				report.Error = "replay was stopped"
+				return report
+			}
+
+			if isPermanentReplayVerdict(record.Verdict) {
+				setReplayPermanentVerdict(pkt.GetConnectionID(), record.Verdict)
+			}
+		}
+
+		report.Summary[record.Verdict]++
+		report.Verdicts = append(report.Verdicts, record)
+	}
+}
+
+// newReplayPacket creates a packet from recorded data. It returns false if
+// the data is not an IP packet sent or received by the recording host.
+func newReplayPacket(data []byte, linkType layers.LinkType, localIPs []net.IP) (*replayPacket, bool) {
+	decoded := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{
+		Lazy:   true,
+		NoCopy: true,
+	})
+	networkLayer := decoded.NetworkLayer()
+	if networkLayer == nil {
+		return nil, false
+	}
+	ipData := append(append([]byte{}, networkLayer.LayerContents()...), networkLayer.LayerPayload()...)
+
+	pkt := &replayPacket{
+		verdict: make(chan string, 1),
+	}
+	pkt.Payload = ipData
+	if err := packet.Parse(ipData, pkt.Info()); err != nil {
+		return nil, false
+	}
+
+	// Set the direction from the view of the recording host.
+	switch {
+	case isReplayLocalIP(pkt.Info().Src, localIPs):
+		pkt.SetOutbound()
+	case isReplayLocalIP(pkt.Info().Dst, localIPs):
+		pkt.SetInbound()
+	default:
+		return nil, false
+	}
+
+	return pkt, true
+}
+
+func isReplayLocalIP(ip net.IP, localIPs []net.IP) bool {
+	for _, localIP := range localIPs {
+		if ip.Equal(localIP) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeReplayReport(report *ReplayReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if replayReportFile == "" {
+		log.Infof("interception: replay summary: %d packets, %d skipped, verdicts: %v", report.Packets, report.Skipped, report.Summary)
+		return nil
+	}
+	return ioutil.WriteFile(replayReportFile, data, 0o600)
+}
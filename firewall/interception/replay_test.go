@@ -0,0 +1,148 @@
+package interception
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portmaster/network/packet"
+)
+
+var (
+	replayTestLocalIP  = net.IPv4(10, 0, 0, 2).To4()
+	replayTestRemoteIP = net.IPv4(1, 1, 1, 1).To4()
+)
+
+// writeReplayTestPacket writes a TCP packet between the given addresses.
+func writeReplayTestPacket(t *testing.T, w *pcapgo.Writer, src net.IP, srcPort uint16, dst net.IP, dstPort uint16) {
+	t.Helper()
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    src,
+		DstIP:    dst,
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		SYN:     true,
+		Window:  1024,
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}, eth, ip, tcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.WritePacket(gopacket.CaptureInfo{
+		Timestamp:     time.Now(),
+		CaptureLength: len(buf.Bytes()),
+		Length:        len(buf.Bytes()),
+	}, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	replayPermanentVerdictsLock.Lock()
+	replayPermanentVerdicts = make(map[string]string)
+	replayPermanentVerdictsLock.Unlock()
+
+	var capture bytes.Buffer
+	w := pcapgo.NewWriter(&capture)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	// A connection that is permanently accepted.
+	writeReplayTestPacket(t, w, replayTestLocalIP, 40000, replayTestRemoteIP, 443)
+	writeReplayTestPacket(t, w, replayTestRemoteIP, 443, replayTestLocalIP, 40000)
+	writeReplayTestPacket(t, w, replayTestLocalIP, 40000, replayTestRemoteIP, 443)
+	// A connection that is blocked packet by packet.
+	writeReplayTestPacket(t, w, replayTestLocalIP, 40001, replayTestRemoteIP, 443)
+	writeReplayTestPacket(t, w, replayTestLocalIP, 40001, replayTestRemoteIP, 443)
+	// A packet that does not belong to the recording host.
+	writeReplayTestPacket(t, w, net.IPv4(10, 0, 0, 3).To4(), 40002, replayTestRemoteIP, 443)
+
+	source, err := newPacketSource(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act as the firewall.
+	packets := make(chan packet.Packet)
+	defer close(packets)
+	go func() {
+		for pkt := range packets {
+			if pkt.Info().LocalPort() == 40000 {
+				_ = pkt.PermanentAccept()
+			} else {
+				_ = pkt.Block()
+			}
+		}
+	}()
+
+	report := replay(source, &ReplayConfig{
+		LocalIPs: []net.IP{replayTestLocalIP},
+	}, packets, make(chan struct{}))
+
+	assert.Empty(t, report.Error)
+	assert.Equal(t, 6, report.Packets)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, map[string]int{"perm-accept": 3, "block": 2}, report.Summary)
+	if assert.Len(t, report.Verdicts, 5) {
+		// Packets of permanently accepted connections are offloaded, in both
+		// directions.
+		assert.False(t, report.Verdicts[0].Offloaded)
+		assert.True(t, report.Verdicts[1].Offloaded)
+		assert.True(t, report.Verdicts[2].Offloaded)
+		assert.Equal(t, report.Verdicts[0].ConnectionID, report.Verdicts[1].ConnectionID)
+		assert.False(t, report.Verdicts[4].Offloaded)
+	}
+}
+
+func TestReplayStop(t *testing.T) {
+	var capture bytes.Buffer
+	w := pcapgo.NewWriter(&capture)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	writeReplayTestPacket(t, w, replayTestLocalIP, 41000, replayTestRemoteIP, 443)
+
+	source, err := newPacketSource(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody handles the packets, the replay only returns when stopped.
+	stop := make(chan struct{})
+	close(stop)
+	report := replay(source, &ReplayConfig{
+		LocalIPs: []net.IP{replayTestLocalIP},
+	}, make(chan packet.Packet), stop)
+	assert.Equal(t, "replay was stopped", report.Error)
+
+	// Stopping a replay that is not running does nothing.
+	assert.NoError(t, stopReplay())
+	assert.NoError(t, stopReplay())
+}
@@ -0,0 +1,138 @@
+package interception
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+const (
+	watchdogTickDuration = 1 * time.Second
+	queueStallThreshold  = 10 * time.Second
+)
+
+var (
+	// failClosed defines whether traffic is blocked instead of passed
+	// unfiltered when the packet handling fails.
+	failClosed bool
+)
+
+// SetFailClosed sets whether traffic should be blocked instead of passed
+// unfiltered when the Portmaster stops handling packets. It must be called
+// before Start.
+func SetFailClosed(enabled bool) {
+	failClosed = enabled
+}
+
+// queueMonitor tracks the progress of a single queue reader.
+type queueMonitor struct {
+	// pending returns the number of packets waiting to be read.
+	pending func() int
+	// lastProgress holds the unix nano timestamp of when the reader last
+	// handed over a packet.
+	lastProgress int64
+	// waitingSince holds the time since when packets are waiting. It is
+	// only accessed by the watchdog.
+	waitingSince time.Time
+}
+
+func newQueueMonitor(pending func() int) *queueMonitor {
+	return &queueMonitor{
+		pending:      pending,
+		lastProgress: time.Now().UnixNano(),
+	}
+}
+
+// progress records that the reader handed over a packet.
+func (qm *queueMonitor) progress() {
+	atomic.StoreInt64(&qm.lastProgress, time.Now().UnixNano())
+}
+
+// stalledFor returns for how long the reader did not make any progress
+// while packets are waiting.
+func (qm *queueMonitor) stalledFor(now time.Time) time.Duration {
+	if qm.pending() == 0 {
+		qm.waitingSince = time.Time{}
+		return 0
+	}
+	if qm.waitingSince.IsZero() {
+		qm.waitingSince = now
+	}
+
+	// Packets may have arrived after a long time of inactivity.
+	lastProgress := time.Unix(0, atomic.LoadInt64(&qm.lastProgress))
+	if lastProgress.Before(qm.waitingSince) {
+		return now.Sub(qm.waitingSince)
+	}
+	return now.Sub(lastProgress)
+}
+
+// watchdog detects stalled queue readers. When failing open, it removes the
+// firewall rules while the readers are stalled, as the kernel only bypasses
+// a queue if no reader is bound to it at all. When failing closed, the rules
+// stay in place and traffic is blocked until the readers recover.
+type watchdog struct {
+	monitors   []*queueMonitor
+	threshold  time.Duration
+	failClosed bool
+
+	// openFirewall removes the firewall rules.
+	openFirewall func() error
+	// closeFirewall re-installs the firewall rules.
+	closeFirewall func() error
+
+	stalled bool
+}
+
+func (wd *watchdog) run(done <-chan struct{}) {
+	ticker := time.NewTicker(watchdogTickDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			wd.check(now)
+		}
+	}
+}
+
+// check checks the queue readers and acts on changes of their state. It
+// returns whether the readers are stalled.
+func (wd *watchdog) check(now time.Time) (stalled bool) {
+	for _, qm := range wd.monitors {
+		if qm.stalledFor(now) >= wd.threshold {
+			stalled = true
+			break
+		}
+	}
+
+	switch {
+	case stalled && !wd.stalled:
+		wd.stalled = true
+		if wd.failClosed {
+			log.Errorf("interception: packet handling stalled for more than %s, blocking traffic (failing closed)", wd.threshold)
+			return true
+		}
+
+		log.Errorf("interception: packet handling stalled for more than %s, removing firewall rules (failing open)", wd.threshold)
+		if err := wd.openFirewall(); err != nil {
+			log.Errorf("interception: failed to remove firewall rules: %s", err)
+		}
+
+	case !stalled && wd.stalled:
+		wd.stalled = false
+		log.Warningf("interception: packet handling recovered")
+		if wd.failClosed {
+			return false
+		}
+
+		if err := wd.closeFirewall(); err != nil {
+			log.Errorf("interception: failed to re-install firewall rules: %s", err)
+		}
+	}
+
+	return stalled
+}
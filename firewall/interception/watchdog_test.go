@@ -0,0 +1,114 @@
+package interception
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFirewall struct {
+	active  bool
+	opened  int
+	closed  int
+	pending int
+}
+
+func (ff *fakeFirewall) open() error {
+	ff.active = false
+	ff.opened++
+	return nil
+}
+
+func (ff *fakeFirewall) close() error {
+	ff.active = true
+	ff.closed++
+	return nil
+}
+
+func newTestWatchdog(ff *fakeFirewall, failClosed bool) (*watchdog, *queueMonitor) {
+	qm := newQueueMonitor(func() int {
+		return ff.pending
+	})
+	return &watchdog{
+		monitors:      []*queueMonitor{qm},
+		threshold:     10 * time.Second,
+		failClosed:    failClosed,
+		openFirewall:  ff.open,
+		closeFirewall: ff.close,
+	}, qm
+}
+
+func TestWatchdogFailOpen(t *testing.T) {
+	t.Parallel()
+
+	ff := &fakeFirewall{active: true}
+	wd, qm := newTestWatchdog(ff, false)
+	now := time.Now()
+
+	// Idle reader without pending packets is not stalled.
+	assert.False(t, wd.check(now.Add(time.Minute)), "idle reader must not be stalled")
+
+	// Packets arrive after a long idle time, the reader stalls.
+	ff.pending = 5
+	assert.False(t, wd.check(now.Add(2*time.Minute)), "reader must not be stalled before threshold")
+	assert.True(t, ff.active, "rules must be active")
+	assert.True(t, wd.check(now.Add(2*time.Minute+10*time.Second)), "reader must be stalled after threshold")
+	assert.False(t, ff.active, "rules must be removed when failing open")
+	assert.Equal(t, 1, ff.opened)
+
+	// The stall is only acted upon once.
+	assert.True(t, wd.check(now.Add(3*time.Minute)))
+	assert.Equal(t, 1, ff.opened)
+
+	// Reader recovers.
+	ff.pending = 0
+	qm.progress()
+	assert.False(t, wd.check(time.Now()), "reader must have recovered")
+	assert.True(t, ff.active, "rules must be re-installed after recovery")
+	assert.Equal(t, 1, ff.closed)
+}
+
+func TestWatchdogFailClosed(t *testing.T) {
+	t.Parallel()
+
+	ff := &fakeFirewall{active: true}
+	wd, qm := newTestWatchdog(ff, true)
+	now := time.Now()
+
+	// Reader stalls while handling a constant stream of packets.
+	ff.pending = 100
+	assert.False(t, wd.check(now.Add(5*time.Second)))
+	assert.True(t, wd.check(now.Add(15*time.Second)), "reader must be stalled after threshold")
+	assert.True(t, ff.active, "rules must stay when failing closed")
+	assert.Equal(t, 0, ff.opened)
+
+	// Reader recovers.
+	qm.progress()
+	assert.False(t, wd.check(time.Now()), "reader must have recovered")
+	assert.True(t, ff.active, "rules must still be active")
+	assert.Equal(t, 0, ff.closed)
+}
+
+func TestQueueMonitorProgress(t *testing.T) {
+	t.Parallel()
+
+	pending := 1
+	qm := newQueueMonitor(func() int {
+		return pending
+	})
+	now := time.Now()
+
+	// A reader that keeps making progress is never stalled.
+	for i := 1; i <= 5; i++ {
+		qm.progress()
+		assert.Less(t, int64(qm.stalledFor(time.Now().Add(time.Second))), int64(2*time.Second))
+	}
+
+	// A reader without progress is stalled for the time since packets are waiting.
+	pending = 0
+	assert.Equal(t, time.Duration(0), qm.stalledFor(now.Add(time.Hour)))
+	pending = 1
+	assert.Equal(t, time.Duration(0), qm.stalledFor(now.Add(2*time.Hour)))
+	assert.Equal(t, time.Minute, qm.stalledFor(now.Add(2*time.Hour+time.Minute)))
+}
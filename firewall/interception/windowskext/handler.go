@@ -26,7 +26,7 @@ type VerdictRequest struct {
 	localPort  uint16    // Source Port
 	remotePort uint16    // Destination port
 	_          uint32    // compartmentID
-	_          uint32    // interfaceIndex
+	ifIndex    uint32    // Interface Index
 	_          uint32    // subInterfaceIndex
 	packetSize uint32
 }
@@ -71,6 +71,7 @@ func Handler(packets chan packet.Packet) {
 		info.Inbound = packetInfo.direction > 0
 		info.InTunnel = false
 		info.Protocol = packet.IPProtocol(packetInfo.protocol)
+		info.InterfaceIndex = int(packetInfo.ifIndex)
 
 		// IP version
 		if packetInfo.ipV6 == 1 {
@@ -0,0 +1,135 @@
+package firewall
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+var (
+	killSwitchExemptions     endpoints.Endpoints
+	killSwitchExemptionsLock sync.RWMutex
+)
+
+// updateKillSwitchExemptions parses the configured kill switch exemptions.
+// Invalid rules are skipped.
+func updateKillSwitchExemptions(_ context.Context, _ interface{}) error {
+	definitions := killSwitchExemptionDefinitions()
+	exemptions := make(endpoints.Endpoints, 0, len(definitions))
+	for _, definition := range definitions {
+		ep, err := endpoints.ParseEndpoints([]string{definition})
+		if err != nil {
+			log.Warningf("filter: ignoring invalid kill switch exemption: %s", err)
+			continue
+		}
+		exemptions = append(exemptions, ep...)
+	}
+
+	killSwitchExemptionsLock.Lock()
+	defer killSwitchExemptionsLock.Unlock()
+
+	killSwitchExemptions = exemptions
+	return nil
+}
+
+// checkKillSwitch blocks outgoing connections to the Internet that do not
+// leave through one of the interfaces of the VPN kill switch. This includes
+// forwarded connections and the connections of the Portmaster itself, eg. to
+// upstream DNS servers.
+func checkKillSwitch(ctx context.Context, conn *network.Connection, _ packet.Packet) bool {
+	interfaces := killSwitchInterfaces()
+
+	// DNS requests do not have an IP yet, the connections to the upstream
+	// DNS servers are checked as connections of the Portmaster.
+	switch {
+	case len(interfaces) == 0,
+		conn.Inbound,
+		conn.Entity == nil,
+		conn.Entity.IP == nil,
+		!netutils.IPIsGlobal(conn.Entity.IP):
+		return false
+	}
+
+	// Connectivity checks must work on all networks, eg. to detect captive
+	// portals before the VPN is connected.
+	if netenv.IsConnectivityDomain(conn.Entity.Domain) {
+		return false
+	}
+
+	egress := killSwitchEgressInterface(conn)
+	if killSwitchInterfacePermitted(egress, interfaces) {
+		return false
+	}
+
+	// Check the exemptions, eg. for the handshake of the VPN.
+	killSwitchExemptionsLock.RLock()
+	result, _ := killSwitchExemptions.Match(ctx, conn.Entity)
+	killSwitchExemptionsLock.RUnlock()
+	switch result {
+	case endpoints.Permitted:
+		log.Tracer(ctx).Tracef("filter: %s is exempt from the VPN kill switch", conn)
+		return false
+	case endpoints.Denied:
+		// Explicitly not exempt.
+	}
+
+	if egress == "" {
+		egress = "unknown interface"
+	}
+	conn.Block("VPN kill switch: connection would leave through "+egress, CfgOptionKillSwitchInterfacesKey)
+	return true
+}
+
+// killSwitchEgressInterface returns the interface the connection currently
+// leaves through. The interface of the local IP is looked up again, so that
+// re-evaluating the connection after the VPN dropped catches it: if the local
+// IP is not assigned to any interface anymore, the egress interface is
+// unknown. The interception reports the egress interface of forwarded
+// connections, which is also used if the connection has no local IP.
+func killSwitchEgressInterface(conn *network.Connection) string {
+	if conn.Forwarded || conn.LocalIP == nil {
+		return conn.Interface
+	}
+	return netenv.GetInterfaceOfIP(conn.LocalIP)
+}
+
+// killSwitchInterfacePermitted returns whether the given interface is one of
+// the interfaces of the VPN kill switch.
+func killSwitchInterfacePermitted(name string, interfaces []string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, permitted := range interfaces {
+		switch {
+		case name == permitted:
+			return true
+		case strings.HasSuffix(permitted, "*") && strings.HasPrefix(name, strings.TrimSuffix(permitted, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// recheckKillSwitch re-evaluates all connections when the network changes,
+// as the VPN might have dropped.
+func recheckKillSwitch(_ context.Context, _ interface{}) error {
+	if len(killSwitchInterfaces()) == 0 {
+		return nil
+	}
+
+	// Re-evaluation may take a while, do not block the event.
+	interceptionModule.StartWorker("re-check vpn kill switch", func(ctx context.Context) error {
+		changed := reevaluateConnections(ctx, "", true)
+		log.Infof("filter: re-evaluated connections for the VPN kill switch after network change, %d verdicts changed", changed)
+		return nil
+	})
+	return nil
+}
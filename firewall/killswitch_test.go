@@ -0,0 +1,105 @@
+package firewall
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+)
+
+// setKillSwitchInterfaces sets the interfaces of the VPN kill switch and
+// returns a function that restores the previous option.
+func setKillSwitchInterfaces(interfaces []string) (restore func()) {
+	previous := killSwitchInterfaces
+	killSwitchInterfaces = func() []string {
+		return interfaces
+	}
+	return func() {
+		killSwitchInterfaces = previous
+	}
+}
+
+func TestKillSwitchInterfacePermitted(t *testing.T) {
+	interfaces := []string{"wg0", "tun*"}
+
+	for name, expected := range map[string]bool{
+		"wg0":  true,
+		"tun0": true,
+		"tun":  true,
+		"wg1":  false,
+		"eth0": false,
+		"":     false,
+	} {
+		if killSwitchInterfacePermitted(name, interfaces) != expected {
+			t.Errorf("interface %q: expected permitted to be %v", name, expected)
+		}
+	}
+
+	if killSwitchInterfacePermitted("wg0", nil) {
+		t.Error("no interface must be permitted without configured interfaces")
+	}
+}
+
+func TestCheckKillSwitch(t *testing.T) {
+	defer setKillSwitchInterfaces([]string{"wg0", "tun*"})()
+
+	newConn := func(iface string, ip net.IP) *network.Connection {
+		return &network.Connection{
+			Interface: iface,
+			Entity: &intel.Entity{
+				IP:       ip,
+				Protocol: 6,
+				Port:     443,
+			},
+		}
+	}
+	internet := net.IPv4(1, 1, 1, 1)
+
+	internal := newConn("eth0", internet)
+	internal.Internal = true
+	forwarded := newConn("eth0", internet)
+	forwarded.Forwarded = true
+	inbound := newConn("eth0", internet)
+	inbound.Inbound = true
+	// The local IP of the VPN is not assigned anymore, eg. after the VPN
+	// dropped.
+	dropped := newConn("wg0", internet)
+	dropped.LocalIP = net.ParseIP("2001:db8::17")
+	// The egress interface of forwarded connections is never looked up.
+	forwardedVPN := newConn("wg0", internet)
+	forwardedVPN.Forwarded = true
+	forwardedVPN.LocalIP = net.IPv4(192, 168, 1, 2)
+
+	for name, test := range map[string]struct {
+		conn    *network.Connection
+		blocked bool
+	}{
+		"vpn":             {newConn("wg0", internet), false},
+		"vpn prefix":      {newConn("tun3", internet), false},
+		"other interface": {newConn("eth0", internet), true},
+		"no interface":    {newConn("", internet), true},
+		"internal":        {internal, true},
+		"forwarded":       {forwarded, true},
+		"forwarded vpn":   {forwardedVPN, false},
+		"vpn dropped":     {dropped, true},
+		"inbound":         {inbound, false},
+		"lan":             {newConn("eth0", net.IPv4(192, 168, 1, 1)), false},
+		"dns request":     {newConn("eth0", nil), false},
+	} {
+		blocked := checkKillSwitch(context.Background(), test.conn, nil)
+		if blocked != test.blocked {
+			t.Errorf("%s: expected blocked to be %v", name, test.blocked)
+		}
+		if blocked && test.conn.Verdict != network.VerdictBlock {
+			t.Errorf("%s: expected verdict block, got %s", name, test.conn.Verdict.Verb())
+		}
+	}
+
+	// Without configured interfaces, the kill switch is disabled.
+	setKillSwitchInterfaces(nil)
+	if checkKillSwitch(context.Background(), newConn("eth0", internet), nil) {
+		t.Error("kill switch must be disabled without configured interfaces")
+	}
+}
@@ -41,38 +41,60 @@
 type deciderFn func(context.Context, *network.Connection, packet.Packet) bool
 
 var deciders = []deciderFn{
+	// The VPN kill switch also applies to the connections of the Portmaster.
+	checkKillSwitch,
 	checkPortmasterConnection,
 	checkSelfCommunication,
+	checkPortScanBlock,
 	checkConnectionType,
+	checkICMP,
 	checkConnectionScope,
 	checkEndpointLists,
+	checkTLSEndpointLists,
+	checkHTTPEndpointLists,
 	checkConnectivityDomain,
 	checkBypassPrevention,
 	checkFilterLists,
 	dropInbound,
 	checkDomainHeuristics,
+	checkICMPHeuristics,
 	checkAutoPermitRelated,
 }
 
 // DecideOnConnection makes a decision about a connection.
 // When called, the connection and profile is already locked.
 func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packet.Packet) {
+	decideOnConnection(ctx, conn, pkt, true)
+}
+
+// decideOnConnection makes a decision about a connection. If mayPrompt is
+// false and the default action is to ask, the user is not prompted and the
+// verdict is left undecided. It returns whether the default action is to ask.
+// When called, the connection and profile is already locked.
+func decideOnConnection(ctx context.Context, conn *network.Connection, pkt packet.Packet, mayPrompt bool) (ask bool) {
 	// Check if we have a process and profile.
 	layeredProfile := conn.Process().Profile()
 	if layeredProfile == nil {
 		conn.Deny("unknown process or profile", noReasonOptionKey)
-		return
+		return false
 	}
 
 	// Check if the layered profile needs updating.
 	if layeredProfile.NeedsUpdate() {
+		layeredProfile.Update()
+	}
+
+	// Check if the profile changed since the connection was last decided on.
+	if revCnt := layeredProfile.RevisionCnt(); conn.ProfileRevisionCounter != revCnt {
 		// Update revision counter in connection.
-		conn.ProfileRevisionCounter = layeredProfile.Update()
+		conn.ProfileRevisionCounter = revCnt
 		conn.SaveWhenFinished()
 
 		// Reset verdict for connection.
-		log.Tracer(ctx).Infof("filter: re-evaluating verdict on %s", conn)
-		conn.Verdict = network.VerdictUndecided
+		if conn.Verdict != network.VerdictUndecided {
+			log.Tracer(ctx).Infof("filter: re-evaluating verdict on %s", conn)
+			conn.Verdict = network.VerdictUndecided
+		}
 
 		// Reset entity if it exists.
 		if conn.Entity != nil {
@@ -80,21 +102,36 @@ func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packe
 		}
 	}
 
-	// Run all deciders and return if they came to a conclusion.
-	done, defaultAction := runDeciders(ctx, conn, pkt)
-	if done {
-		return
+	// Update the network location, it may have changed since the connection
+	// was last decided on.
+	if conn.Entity != nil {
+		conn.Entity.NetworkLocation = ""
+		if location := profile.GetActiveLocation(); location != nil {
+			conn.Entity.NetworkLocation = location.Name
+		}
 	}
 
-	// Deciders did not conclude, use default action.
-	switch defaultAction {
-	case profile.DefaultActionPermit:
-		conn.Accept("default permit", profile.CfgOptionDefaultActionKey)
-	case profile.DefaultActionAsk:
-		prompt(ctx, conn, pkt)
-	default:
-		conn.Deny("default block", profile.CfgOptionDefaultActionKey)
+	// Run all deciders and check if they came to a conclusion.
+	done, defaultAction := runDeciders(ctx, conn, pkt)
+	ask = !done && defaultAction == profile.DefaultActionAsk
+	if !done {
+		// Deciders did not conclude, use default action.
+		switch defaultAction {
+		case profile.DefaultActionPermit:
+			conn.Accept("default permit", profile.CfgOptionDefaultActionKey)
+		case profile.DefaultActionAsk:
+			if !mayPrompt {
+				return true
+			}
+			prompt(ctx, conn, pkt)
+		default:
+			conn.Deny("default block", profile.CfgOptionDefaultActionKey)
+		}
 	}
+
+	// Evaluate any staged profile changes against the live verdict.
+	checkShadowPolicy(ctx, conn, pkt, ask)
+	return ask
 }
 
 func runDeciders(ctx context.Context, conn *network.Connection, pkt packet.Packet) (done bool, defaultAction uint8) {
@@ -252,6 +289,15 @@ func checkConnectionScope(_ context.Context, conn *network.Connection, _ packet.
 	if conn.Entity.IP != nil {
 		classification := netutils.ClassifyIP(conn.Entity.IP)
 
+		// For forwarded connections, private networks beyond the LAN of the
+		// device are reached through the upstream gateway and are treated
+		// like the Internet.
+		if conn.Forwarded &&
+			(classification == netutils.SiteLocal || classification == netutils.LinkLocal) &&
+			!netenv.IsLANIP(conn.Entity.IP) {
+			classification = netutils.Global
+		}
+
 		switch classification {
 		case netutils.Global, netutils.GlobalMulticast:
 			if p.BlockScopeInternet() {
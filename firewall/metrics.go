@@ -0,0 +1,28 @@
+package firewall
+
+import (
+	"github.com/safing/portmaster/metrics"
+)
+
+var (
+	packetVerdictMetric = metrics.NewCounterVec(
+		"portmaster_firewall_packets",
+		"Packets handled by the firewall, by verdict.",
+		"verdict",
+	)
+	metricPacketsAccepted = packetVerdictMetric.With("accept")
+	metricPacketsBlocked  = packetVerdictMetric.With("block")
+	metricPacketsDropped  = packetVerdictMetric.With("drop")
+	metricPacketsRerouted = packetVerdictMetric.With("reroute")
+	metricPacketsFailed   = packetVerdictMetric.With("failed")
+
+	promptMetric = metrics.NewCounterVec(
+		"portmaster_firewall_prompts",
+		"Prompts shown to the user, by their outcome within the prompt wait time.",
+		"outcome",
+	)
+	metricPromptsAllowed = promptMetric.With("allowed")
+	metricPromptsBlocked = promptMetric.With("blocked")
+	metricPromptsPending = promptMetric.With("pending")
+	metricPromptsAborted = promptMetric.With("aborted")
+)
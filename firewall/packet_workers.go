@@ -0,0 +1,290 @@
+package firewall
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+const (
+	minPacketWorkers      = 4
+	packetWorkerQueueSize = 256
+
+	// maxPendingPackets is the maximum number of packets that are queued
+	// for a connection while it is being created.
+	maxPendingPackets = 1000
+
+	// connectionCreators is the number of workers that create connections.
+	// Creating a connection mostly waits for process and domain lookups, so
+	// there are more of them than CPUs.
+	connectionCreators = 32
+	// connectionCreatorQueueSize is the number of new connections that may
+	// wait for a connection creator.
+	connectionCreatorQueueSize = 1024
+	// maxPendingConnections is the maximum number of connections that are
+	// being created or wait for a connection creator.
+	maxPendingConnections = connectionCreators + connectionCreatorQueueSize
+)
+
+var (
+	// packetWorkerQueues holds the queues of the packet workers. Packets
+	// are assigned to a worker by the hash of their connection ID, so that
+	// the packets of a connection are handled in order.
+	packetWorkerQueues []chan packet.Packet
+
+	// packetsBackpressured counts the packets that had to wait for a full
+	// worker queue.
+	packetsBackpressured = new(uint64)
+	// packetsQueuedMax holds the highest number of packets queued for the
+	// workers since the last reset.
+	packetsQueuedMax = new(uint64)
+	// packetsOverloaded counts the first packets of new connections that
+	// were dropped, because the connection creators could not keep up.
+	packetsOverloaded = new(uint64)
+
+	// connectionCreatorQueue holds the first packets of the connections that
+	// wait for a connection creator.
+	connectionCreatorQueue = make(chan packet.Packet, connectionCreatorQueueSize)
+
+	// pendingConnections holds the queued packets of connections that are
+	// being created. Creating a connection looks up its process and domain,
+	// which may take a while, so this is done outside of the packet workers.
+	pendingConnections     = make(map[string][]packet.Packet)
+	pendingConnectionsLock sync.Mutex
+)
+
+// startPacketWorkers starts the packet handler and its workers.
+func startPacketWorkers() {
+	workers := runtime.NumCPU()
+	if workers < minPacketWorkers {
+		workers = minPacketWorkers
+	}
+
+	packetWorkerQueues = make([]chan packet.Packet, workers)
+	for i := range packetWorkerQueues {
+		queue := make(chan packet.Packet, packetWorkerQueueSize)
+		packetWorkerQueues[i] = queue
+		interceptionModule.StartServiceWorker("packet worker", 0, func(ctx context.Context) error {
+			return packetWorker(ctx, queue)
+		})
+	}
+
+	for i := 0; i < connectionCreators; i++ {
+		interceptionModule.StartServiceWorker("connection creator", 0, connectionCreator)
+	}
+
+	interceptionModule.StartServiceWorker("packet handler", 0, packetHandler)
+}
+
+// packetHandler distributes intercepted packets to the packet workers. If the
+// queue of a worker is full, it waits and thereby slows down reading packets
+// from the interception.
+func packetHandler(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case pkt := <-interception.Packets:
+			queue := packetWorkerQueues[packetWorkerIndex(pkt)]
+
+			select {
+			case queue <- pkt:
+			default:
+				atomic.AddUint64(packetsBackpressured, 1)
+				select {
+				case queue <- pkt:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+			updatePacketsQueuedMax()
+		}
+	}
+}
+
+func packetWorker(ctx context.Context, queue chan packet.Packet) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case pkt := <-queue:
+			handlePacket(ctx, pkt)
+		}
+	}
+}
+
+// createConnection hands the packet to the connection creators, which create
+// its connection. Packets of the connection that arrive in the meantime are
+// queued by queuePendingPacket and handed to the connection in order. If the
+// connection creators cannot keep up, the packet is dropped, so that floods
+// of new connections cannot exhaust memory.
+func createConnection(pkt packet.Packet) {
+	connID := pkt.GetConnectionID()
+
+	pendingConnectionsLock.Lock()
+	if len(pendingConnections) >= maxPendingConnections {
+		pendingConnectionsLock.Unlock()
+		dropOverloadedPacket(pkt)
+		return
+	}
+	pendingConnections[connID] = nil
+	pendingConnectionsLock.Unlock()
+
+	select {
+	case connectionCreatorQueue <- pkt:
+	default:
+		pendingConnectionsLock.Lock()
+		delete(pendingConnections, connID)
+		pendingConnectionsLock.Unlock()
+		dropOverloadedPacket(pkt)
+	}
+}
+
+func dropOverloadedPacket(pkt packet.Packet) {
+	atomic.AddUint64(packetsOverloaded, 1)
+	log.Tracer(pkt.Ctx()).Warningf("filter: dropping packet %s, as too many connections are being created", pkt)
+	_ = pkt.Drop()
+}
+
+func connectionCreator(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case pkt := <-connectionCreatorQueue:
+			createQueuedConnection(pkt)
+		}
+	}
+}
+
+// createQueuedConnection creates the connection of the given packet and hands
+// it all packets that were queued in the meantime.
+func createQueuedConnection(pkt packet.Packet) {
+	connID := pkt.GetConnectionID()
+
+	conn := network.NewConnectionFromFirstPacket(pkt)
+	log.Tracer(pkt.Ctx()).Tracef("filter: created new connection %s", conn.ID)
+	conn.SetFirewallHandler(initialHandler)
+	conn.HandlePacket(pkt)
+
+	for {
+		pending := takePendingPackets(connID)
+		if len(pending) == 0 {
+			return
+		}
+		for _, pendingPkt := range pending {
+			log.Tracer(pendingPkt.Ctx()).Tracef("filter: assigned to connection %s", conn.ID)
+			conn.HandlePacket(pendingPkt)
+		}
+	}
+}
+
+// queuePendingPacket queues the packet if its connection is being created
+// and returns whether it did so. Packets that overflow the queue are dropped.
+func queuePendingPacket(pkt packet.Packet) (queued bool) {
+	connID := pkt.GetConnectionID()
+
+	pendingConnectionsLock.Lock()
+	defer pendingConnectionsLock.Unlock()
+
+	pending, ok := pendingConnections[connID]
+	if !ok {
+		return false
+	}
+
+	if len(pending) >= maxPendingPackets {
+		log.Tracer(pkt.Ctx()).Warningf("filter: dropping packet %s, as too many packets are pending for connection %s", pkt, connID)
+		_ = pkt.Drop()
+		return true
+	}
+
+	pendingConnections[connID] = append(pending, pkt)
+	return true
+}
+
+// takePendingPackets returns the queued packets of a pending connection. If
+// there are none, the connection is not pending anymore and subsequent packets
+// are not queued anymore.
+func takePendingPackets(connID string) []packet.Packet {
+	pendingConnectionsLock.Lock()
+	defer pendingConnectionsLock.Unlock()
+
+	pending := pendingConnections[connID]
+	if len(pending) == 0 {
+		delete(pendingConnections, connID)
+		return nil
+	}
+
+	pendingConnections[connID] = nil
+	return pending
+}
+
+func packetWorkerIndex(pkt packet.Packet) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(pkt.GetConnectionID()))
+	return int(h.Sum32() % uint32(len(packetWorkerQueues)))
+}
+
+// packetsQueued returns the number of packets currently queued for the
+// packet workers.
+func packetsQueued() (queued uint64) {
+	for _, queue := range packetWorkerQueues {
+		queued += uint64(len(queue))
+	}
+	return queued
+}
+
+func updatePacketsQueuedMax() {
+	queued := packetsQueued()
+	for {
+		max := atomic.LoadUint64(packetsQueuedMax)
+		if queued <= max || atomic.CompareAndSwapUint64(packetsQueuedMax, max, queued) {
+			return
+		}
+	}
+}
+
+// logPacketQueues logs the state of the packet worker queues. If packets had
+// to wait for a full queue, the packet workers cannot keep up, which delays
+// all connections, so this is logged as a warning. The same applies to packets
+// that were dropped, because the connection creators could not keep up.
+func logPacketQueues() {
+	backpressured := atomic.LoadUint64(packetsBackpressured)
+	if backpressured > 0 {
+		log.Warningf(
+			"filter: packet workers cannot keep up, %d packets waited for a full queue (queued %d, max %d)",
+			backpressured,
+			packetsQueued(),
+			atomic.LoadUint64(packetsQueuedMax),
+		)
+	}
+
+	overloaded := atomic.LoadUint64(packetsOverloaded)
+	if overloaded > 0 {
+		log.Warningf(
+			"filter: connection creators cannot keep up, dropped %d packets of new connections (waiting %d)",
+			overloaded,
+			len(connectionCreatorQueue),
+		)
+	}
+
+	if backpressured > 0 || overloaded > 0 {
+		return
+	}
+
+	log.Tracef(
+		"filter: packets queued %d (max %d), backpressured %d, overloaded %d",
+		packetsQueued(),
+		atomic.LoadUint64(packetsQueuedMax),
+		backpressured,
+		overloaded,
+	)
+}
@@ -0,0 +1,165 @@
+package firewall
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/safing/portmaster/network/packet"
+)
+
+type testPacket struct {
+	packet.Base
+
+	dropped bool
+}
+
+func (pkt *testPacket) Accept() error              { return nil }
+func (pkt *testPacket) Block() error               { return nil }
+func (pkt *testPacket) Drop() error                { pkt.dropped = true; return nil }
+func (pkt *testPacket) PermanentAccept() error     { return nil }
+func (pkt *testPacket) PermanentBlock() error      { return nil }
+func (pkt *testPacket) PermanentDrop() error       { return nil }
+func (pkt *testPacket) RerouteToNameserver() error { return nil }
+func (pkt *testPacket) RerouteToTunnel() error     { return nil }
+
+func newTestPacket(srcPort uint16) *testPacket {
+	pkt := &testPacket{}
+	pkt.SetCtx(context.Background())
+	pkt.SetPacketInfo(packet.Info{
+		Version:  packet.IPv4,
+		Protocol: packet.TCP,
+		Src:      net.IPv4(192, 168, 1, 2),
+		SrcPort:  srcPort,
+		Dst:      net.IPv4(1, 1, 1, 1),
+		DstPort:  443,
+	})
+	return pkt
+}
+
+func TestPacketWorkerIndex(t *testing.T) {
+	previousQueues := packetWorkerQueues
+	defer func() {
+		packetWorkerQueues = previousQueues
+	}()
+	packetWorkerQueues = make([]chan packet.Packet, 8)
+
+	// Packets of the same connection are always handled by the same worker.
+	for srcPort := uint16(50000); srcPort < 50100; srcPort++ {
+		index := packetWorkerIndex(newTestPacket(srcPort))
+		if index < 0 || index >= len(packetWorkerQueues) {
+			t.Fatalf("invalid worker index %d", index)
+		}
+		if packetWorkerIndex(newTestPacket(srcPort)) != index {
+			t.Errorf("packets of the same connection were assigned to different workers")
+		}
+	}
+}
+
+func TestPendingPackets(t *testing.T) {
+	first := newTestPacket(51234)
+	connID := first.GetConnectionID()
+
+	// Packets are only queued while their connection is being created.
+	if queuePendingPacket(first) {
+		t.Fatal("packet of unknown connection must not be queued")
+	}
+
+	pendingConnectionsLock.Lock()
+	pendingConnections[connID] = nil
+	pendingConnectionsLock.Unlock()
+	defer func() {
+		pendingConnectionsLock.Lock()
+		delete(pendingConnections, connID)
+		pendingConnectionsLock.Unlock()
+	}()
+
+	queued := make([]*testPacket, 0, maxPendingPackets)
+	for i := 0; i < maxPendingPackets; i++ {
+		pkt := newTestPacket(51234)
+		if !queuePendingPacket(pkt) {
+			t.Fatal("packet of pending connection must be queued")
+		}
+		queued = append(queued, pkt)
+	}
+
+	// Packets that overflow the queue are dropped.
+	overflowing := newTestPacket(51234)
+	if !queuePendingPacket(overflowing) || !overflowing.dropped {
+		t.Error("packet overflowing the queue must be dropped")
+	}
+
+	// Queued packets are taken in order.
+	pending := takePendingPackets(connID)
+	if len(pending) != len(queued) {
+		t.Fatalf("expected %d pending packets, got %d", len(queued), len(pending))
+	}
+	for i, pkt := range pending {
+		if pkt != queued[i] {
+			t.Fatalf("pending packet %d is out of order", i)
+		}
+		if queued[i].dropped {
+			t.Fatalf("queued packet %d was dropped", i)
+		}
+	}
+
+	// Once all packets are taken, the connection is not pending anymore.
+	if pending := takePendingPackets(connID); len(pending) != 0 {
+		t.Errorf("expected no pending packets, got %d", len(pending))
+	}
+	if queuePendingPacket(newTestPacket(51234)) {
+		t.Error("packet must not be queued after the connection was created")
+	}
+}
+
+func TestCreateConnectionOverload(t *testing.T) {
+	defer func() {
+		for len(connectionCreatorQueue) > 0 {
+			<-connectionCreatorQueue
+		}
+		pendingConnectionsLock.Lock()
+		pendingConnections = make(map[string][]packet.Packet)
+		pendingConnectionsLock.Unlock()
+		atomic.StoreUint64(packetsOverloaded, 0)
+	}()
+
+	// New connections wait for a connection creator, as long as there is room
+	// in the queue.
+	for srcPort := uint16(1); srcPort <= connectionCreatorQueueSize; srcPort++ {
+		pkt := newTestPacket(srcPort)
+		createConnection(pkt)
+		if pkt.dropped {
+			t.Fatalf("packet %d was dropped before the queue was full", srcPort)
+		}
+	}
+
+	// Packets of new connections are dropped when the queue is full.
+	overflowing := newTestPacket(connectionCreatorQueueSize + 1)
+	createConnection(overflowing)
+	if !overflowing.dropped {
+		t.Error("packet overflowing the connection creator queue must be dropped")
+	}
+	if queuePendingPacket(newTestPacket(connectionCreatorQueueSize + 1)) {
+		t.Error("dropped connection must not be pending")
+	}
+
+	// Packets of new connections are also dropped when too many connections
+	// are pending.
+	<-connectionCreatorQueue
+	pendingConnectionsLock.Lock()
+	for i := 0; len(pendingConnections) < maxPendingConnections; i++ {
+		pendingConnections[strconv.Itoa(i)] = nil
+	}
+	pendingConnectionsLock.Unlock()
+	tooMany := newTestPacket(connectionCreatorQueueSize + 2)
+	createConnection(tooMany)
+	if !tooMany.dropped {
+		t.Error("packet must be dropped when too many connections are pending")
+	}
+
+	if overloaded := atomic.LoadUint64(packetsOverloaded); overloaded != 2 {
+		t.Errorf("expected 2 overloaded packets, got %d", overloaded)
+	}
+}
@@ -0,0 +1,319 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/status"
+)
+
+const (
+	// portScanWindow defines the sliding window in which incoming
+	// connections of a remote IP are counted.
+	portScanWindow = 1 * time.Minute
+	// portScanMinPorts is the number of distinct local ports a remote IP
+	// must connect to within the window to be considered a port scanner.
+	portScanMinPorts = 20
+	// portScanMinHosts is the number of distinct local IPs a remote IP must
+	// connect to within the window to be considered a host scanner.
+	portScanMinHosts = 10
+	// connectionFloodMinConnections is the number of connections a remote
+	// IP must open within the window to be considered a connection flood.
+	connectionFloodMinConnections = 200
+
+	// portScanThreatTTL defines how long a threat is kept after the last
+	// connection of the scanner.
+	portScanThreatTTL = 5 * time.Minute
+	// portScanCheckInterval defines how often threats are updated.
+	portScanCheckInterval = 5 * time.Second
+
+	// maxPortScanTrackers limits the number of tracked remote IPs, so that
+	// spoofed source addresses cannot exhaust memory.
+	maxPortScanTrackers = 10000
+	// maxPortScanReportedPorts limits the number of ports in a threat.
+	maxPortScanReportedPorts = 100
+
+	portScanThreatIDPrefix = "filter:portscan-"
+
+	portScanKindPorts = "port scan"
+	portScanKindHosts = "host scan"
+	portScanKindFlood = "connection flood"
+)
+
+var (
+	portScanThreatTitles = map[string]string{
+		portScanKindPorts: "Port Scan Detected",
+		portScanKindHosts: "Host Scan Detected",
+		portScanKindFlood: "Connection Flood Detected",
+	}
+
+	portScanTrackers     = make(map[string]*portScanTracker)
+	portScanTrackersLock sync.Mutex
+)
+
+// PortScanThreat is the threat data of a detected port scan.
+type PortScanThreat struct {
+	// Source holds the IP address of the scanner.
+	Source string
+	// Scope holds the scope of the scanner's connections.
+	Scope string
+	// Kind describes the detected activity.
+	Kind string
+	// Ports holds the local ports the scanner connected to.
+	Ports []uint16
+	// Hosts holds the number of local IPs the scanner connected to.
+	Hosts int
+	// Connections holds the number of connections of the scanner.
+	Connections int
+	// BlockedUntil holds the UNIX epoch timestamp in seconds until which
+	// incoming connections from the scanner are dropped, if any.
+	BlockedUntil int64
+}
+
+// portScanTracker tracks the incoming connections of a remote IP.
+type portScanTracker struct {
+	events []portScanEvent
+
+	lastSeen     time.Time
+	blockedUntil time.Time
+
+	// detected is set to the kind of the detected activity.
+	detected string
+	// changed is set when the threat data needs to be published.
+	changed bool
+
+	scope       string
+	ports       map[uint16]struct{}
+	hosts       map[string]struct{}
+	connections int
+	threat      *status.Threat
+}
+
+type portScanEvent struct {
+	seen      time.Time
+	localIP   string
+	localPort uint16
+}
+
+// recordInboundConnection records a new incoming connection for port scan
+// detection at the given time. It must be called once per connection.
+func recordInboundConnection(ctx context.Context, conn *network.Connection, now time.Time) {
+	if !conn.Inbound ||
+		!portScanDetection() ||
+		conn.Entity.IP == nil ||
+		(conn.Scope != network.IncomingLAN && conn.Scope != network.IncomingInternet) {
+		return
+	}
+
+	source := conn.Entity.IP.String()
+
+	portScanTrackersLock.Lock()
+	defer portScanTrackersLock.Unlock()
+
+	tracker, ok := portScanTrackers[source]
+	if !ok {
+		if len(portScanTrackers) >= maxPortScanTrackers {
+			return
+		}
+		tracker = &portScanTracker{
+			scope: conn.Scope,
+			ports: make(map[uint16]struct{}),
+			hosts: make(map[string]struct{}),
+		}
+		portScanTrackers[source] = tracker
+	}
+
+	tracker.lastSeen = now
+	tracker.events = append(tracker.events, portScanEvent{
+		seen:      now,
+		localIP:   conn.LocalIP.String(),
+		localPort: conn.LocalPort,
+	})
+	tracker.pruneEvents(now)
+
+	// Check the connections within the window.
+	ports := make(map[uint16]struct{})
+	hosts := make(map[string]struct{})
+	for _, event := range tracker.events {
+		ports[event.localPort] = struct{}{}
+		hosts[event.localIP] = struct{}{}
+	}
+	var detected string
+	switch {
+	case len(ports) >= portScanMinPorts:
+		detected = portScanKindPorts
+	case len(hosts) >= portScanMinHosts:
+		detected = portScanKindHosts
+	case len(tracker.events) >= connectionFloodMinConnections:
+		detected = portScanKindFlood
+	}
+
+	// Collect the activity of detected scanners for the threat.
+	if detected != "" || tracker.detected != "" {
+		if tracker.detected == "" {
+			log.Tracer(ctx).Warningf("filter: detected %s from %s", detected, source)
+			tracker.detected = detected
+			for port := range ports {
+				tracker.ports[port] = struct{}{}
+			}
+			for host := range hosts {
+				tracker.hosts[host] = struct{}{}
+			}
+			tracker.connections = len(tracker.events)
+		} else {
+			tracker.ports[conn.LocalPort] = struct{}{}
+			tracker.hosts[conn.LocalIP.String()] = struct{}{}
+			tracker.connections++
+		}
+		tracker.changed = true
+
+		if blockDuration := portScanBlockDuration(); blockDuration > 0 && now.After(tracker.blockedUntil) {
+			tracker.blockedUntil = now.Add(time.Duration(blockDuration) * time.Second)
+			log.Tracer(ctx).Infof("filter: blocking incoming connections from %s until %s", source, tracker.blockedUntil.Format(time.RFC3339))
+		}
+	}
+}
+
+// pruneEvents removes the events that are outside of the window. More
+// events than needed to detect a connection flood are not kept.
+func (tracker *portScanTracker) pruneEvents(now time.Time) {
+	if len(tracker.events) > connectionFloodMinConnections {
+		tracker.events = tracker.events[len(tracker.events)-connectionFloodMinConnections:]
+	}
+	for i, event := range tracker.events {
+		if now.Sub(event.seen) < portScanWindow {
+			tracker.events = tracker.events[i:]
+			return
+		}
+	}
+	tracker.events = nil
+}
+
+// checkPortScanBlock drops incoming connections from detected port scanners
+// while they are blocked.
+func checkPortScanBlock(ctx context.Context, conn *network.Connection, _ packet.Packet) bool {
+	if !conn.Inbound || conn.Entity.IP == nil {
+		return false
+	}
+
+	var detected string
+	portScanTrackersLock.Lock()
+	tracker, ok := portScanTrackers[conn.Entity.IP.String()]
+	if ok && time.Now().Before(tracker.blockedUntil) {
+		detected = tracker.detected
+	}
+	portScanTrackersLock.Unlock()
+
+	if detected != "" {
+		if !conn.Shadow {
+			log.Tracer(ctx).Infof("filter: dropping connection %s from port scanner", conn)
+		}
+		conn.Drop("source was detected doing a "+detected, CfgOptionPortScanBlockDurationKey)
+		return true
+	}
+	return false
+}
+
+// portScanDetector publishes and clears port scan threats and removes
+// trackers of inactive remote IPs.
+func portScanDetector(ctx context.Context) error {
+	ticker := time.NewTicker(portScanCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			updatePortScanThreats(time.Now())
+		}
+	}
+}
+
+func updatePortScanThreats(now time.Time) {
+	portScanTrackersLock.Lock()
+	defer portScanTrackersLock.Unlock()
+
+	for source, tracker := range portScanTrackers {
+		switch {
+		case now.Sub(tracker.lastSeen) > portScanThreatTTL && now.After(tracker.blockedUntil):
+			// Activity stopped, clear the threat.
+			if tracker.threat != nil {
+				log.Infof("filter: %s from %s stopped", tracker.detected, source)
+				tracker.threat.Delete().Publish()
+			}
+			delete(portScanTrackers, source)
+
+		case tracker.detected == "" && now.Sub(tracker.lastSeen) > portScanWindow:
+			// Nothing detected within the window.
+			delete(portScanTrackers, source)
+
+		case tracker.changed:
+			tracker.publishThreat(source)
+			tracker.changed = false
+		}
+	}
+}
+
+// publishThreat raises or updates the threat of the tracker.
+func (tracker *portScanTracker) publishThreat(source string) {
+	data := &PortScanThreat{
+		Source:      source,
+		Scope:       tracker.scope,
+		Kind:        tracker.detected,
+		Hosts:       len(tracker.hosts),
+		Connections: tracker.connections,
+	}
+	for port := range tracker.ports {
+		data.Ports = append(data.Ports, port)
+	}
+	sort.Slice(data.Ports, func(i, j int) bool { return data.Ports[i] < data.Ports[j] })
+	if len(data.Ports) > maxPortScanReportedPorts {
+		data.Ports = data.Ports[:maxPortScanReportedPorts]
+	}
+	if !tracker.blockedUntil.IsZero() {
+		data.BlockedUntil = tracker.blockedUntil.Unix()
+	}
+
+	// Scans from the Internet are common and mostly harmless, scans from the
+	// LAN indicate an attacker in the local network.
+	mitigationLevel := status.SecurityLevelNormal
+	location := "the Internet"
+	if tracker.scope == network.IncomingLAN {
+		mitigationLevel = status.SecurityLevelHigh
+		location = "the local network"
+	}
+	if tracker.detected == portScanKindFlood {
+		mitigationLevel = status.SecurityLevelExtreme
+	}
+
+	msg := fmt.Sprintf(
+		"A %s from %s in %s was detected: %d connections to %d ports on %d local IP addresses.",
+		tracker.detected, source, location, data.Connections, len(tracker.ports), data.Hosts,
+	)
+	if data.BlockedUntil > 0 {
+		msg += " Incoming connections from this IP address are dropped for a while."
+	}
+
+	if tracker.threat == nil {
+		tracker.threat = status.NewThreat(
+			portScanThreatIDPrefix+source,
+			portScanThreatTitles[tracker.detected],
+			msg,
+		)
+	} else {
+		tracker.threat.Lock()
+		tracker.threat.Message = msg
+		tracker.threat.Unlock()
+	}
+	tracker.threat.
+		SetData(data).
+		SetMitigationLevel(mitigationLevel).
+		Publish()
+}
@@ -0,0 +1,241 @@
+package firewall
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+)
+
+// setPortScanOptions sets the port scan options and returns a function that
+// restores the previous options and clears all trackers.
+func setPortScanOptions(detection bool, blockDuration int64) (restore func()) {
+	previousDetection := portScanDetection
+	previousBlockDuration := portScanBlockDuration
+	portScanDetection = func() bool {
+		return detection
+	}
+	portScanBlockDuration = func() int64 {
+		return blockDuration
+	}
+	resetPortScanTrackers()
+
+	return func() {
+		portScanDetection = previousDetection
+		portScanBlockDuration = previousBlockDuration
+		resetPortScanTrackers()
+	}
+}
+
+func resetPortScanTrackers() {
+	portScanTrackersLock.Lock()
+	defer portScanTrackersLock.Unlock()
+
+	portScanTrackers = make(map[string]*portScanTracker)
+}
+
+func getPortScanTracker(source net.IP) *portScanTracker {
+	portScanTrackersLock.Lock()
+	defer portScanTrackersLock.Unlock()
+
+	return portScanTrackers[source.String()]
+}
+
+func newInboundConn(source, local net.IP, localPort uint16) *network.Connection {
+	return &network.Connection{
+		Inbound:   true,
+		Scope:     network.IncomingLAN,
+		LocalIP:   local,
+		LocalPort: localPort,
+		Entity: &intel.Entity{
+			IP:       source,
+			Protocol: 6,
+		},
+	}
+}
+
+func TestPortScanWindow(t *testing.T) {
+	defer setPortScanOptions(true, 0)()
+
+	source := net.IPv4(192, 168, 1, 66)
+	local := net.IPv4(192, 168, 1, 2)
+	start := time.Now()
+
+	// One port less than needed within the window.
+	for port := uint16(1); port < portScanMinPorts; port++ {
+		recordInboundConnection(context.Background(), newInboundConn(source, local, port), start)
+	}
+	if tracker := getPortScanTracker(source); tracker == nil || tracker.detected != "" {
+		t.Fatal("port scan must not be detected below the threshold")
+	}
+
+	// The first connections left the window when the next port is scanned.
+	later := start.Add(portScanWindow)
+	recordInboundConnection(context.Background(), newInboundConn(source, local, portScanMinPorts), later)
+	tracker := getPortScanTracker(source)
+	if tracker.detected != "" {
+		t.Fatal("connections outside of the window must not be counted")
+	}
+	if len(tracker.events) != 1 {
+		t.Errorf("expected 1 connection within the window, got %d", len(tracker.events))
+	}
+
+	// Reaching the threshold within the window is a port scan.
+	for port := uint16(1); port < portScanMinPorts; port++ {
+		recordInboundConnection(context.Background(), newInboundConn(source, local, port), later.Add(time.Second))
+	}
+	if tracker.detected != portScanKindPorts {
+		t.Fatalf("expected %s, got %q", portScanKindPorts, tracker.detected)
+	}
+	if len(tracker.ports) != portScanMinPorts || tracker.connections != portScanMinPorts {
+		t.Errorf("expected %d ports and connections, got %d ports and %d connections",
+			portScanMinPorts, len(tracker.ports), tracker.connections)
+	}
+
+	// Further connections are added to the detected scan.
+	recordInboundConnection(context.Background(), newInboundConn(source, local, 8080), later.Add(2*time.Second))
+	if len(tracker.ports) != portScanMinPorts+1 || tracker.connections != portScanMinPorts+1 {
+		t.Errorf("expected %d ports and connections, got %d ports and %d connections",
+			portScanMinPorts+1, len(tracker.ports), tracker.connections)
+	}
+}
+
+func TestPortScanThresholds(t *testing.T) {
+	defer setPortScanOptions(true, 0)()
+
+	now := time.Now()
+
+	// Host scan: the same port on many local IPs.
+	hostScanner := net.IPv4(192, 168, 1, 67)
+	for i := 0; i < portScanMinHosts; i++ {
+		recordInboundConnection(context.Background(), newInboundConn(hostScanner, net.IPv4(192, 168, 1, byte(100+i)), 22), now)
+	}
+	if tracker := getPortScanTracker(hostScanner); tracker.detected != portScanKindHosts {
+		t.Errorf("expected %s, got %q", portScanKindHosts, tracker.detected)
+	}
+
+	// Connection flood: many connections to the same port.
+	flooder := net.IPv4(192, 168, 1, 68)
+	local := net.IPv4(192, 168, 1, 2)
+	for i := 0; i < connectionFloodMinConnections-1; i++ {
+		recordInboundConnection(context.Background(), newInboundConn(flooder, local, 80), now)
+	}
+	tracker := getPortScanTracker(flooder)
+	if tracker.detected != "" {
+		t.Fatal("connection flood must not be detected below the threshold")
+	}
+	recordInboundConnection(context.Background(), newInboundConn(flooder, local, 80), now)
+	if tracker.detected != portScanKindFlood {
+		t.Errorf("expected %s, got %q", portScanKindFlood, tracker.detected)
+	}
+	if len(tracker.events) > connectionFloodMinConnections {
+		t.Errorf("expected at most %d connections to be kept, got %d", connectionFloodMinConnections, len(tracker.events))
+	}
+
+	// Only new incoming connections from the LAN and the Internet are tracked.
+	outbound := newInboundConn(net.IPv4(192, 168, 1, 69), local, 80)
+	outbound.Inbound = false
+	localhost := newInboundConn(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1), 80)
+	localhost.Scope = network.IncomingHost
+	for _, conn := range []*network.Connection{outbound, localhost} {
+		recordInboundConnection(context.Background(), conn, now)
+		if getPortScanTracker(conn.Entity.IP) != nil {
+			t.Errorf("connection from %s must not be tracked", conn.Entity.IP)
+		}
+	}
+
+	// Nothing is tracked when the detection is disabled.
+	portScanDetection = func() bool { return false }
+	disabled := newInboundConn(net.IPv4(192, 168, 1, 70), local, 80)
+	recordInboundConnection(context.Background(), disabled, now)
+	if getPortScanTracker(disabled.Entity.IP) != nil {
+		t.Error("connections must not be tracked when the detection is disabled")
+	}
+}
+
+func TestPortScanBlock(t *testing.T) {
+	defer setPortScanOptions(true, 0)()
+
+	source := net.IPv4(192, 168, 1, 66)
+	local := net.IPv4(192, 168, 1, 2)
+	scan := func() {
+		now := time.Now()
+		for port := uint16(1); port <= portScanMinPorts; port++ {
+			recordInboundConnection(context.Background(), newInboundConn(source, local, port), now)
+		}
+	}
+
+	// Scanners are only reported by default.
+	scan()
+	conn := newInboundConn(source, local, 443)
+	if checkPortScanBlock(context.Background(), conn, nil) {
+		t.Error("scanner must not be blocked without a block duration")
+	}
+
+	// Scanners are blocked for the configured duration.
+	resetPortScanTrackers()
+	portScanBlockDuration = func() int64 { return 60 }
+	scan()
+	tracker := getPortScanTracker(source)
+	if until := time.Until(tracker.blockedUntil); until <= 0 || until > time.Minute {
+		t.Fatalf("expected scanner to be blocked for a minute, got %s", until)
+	}
+	conn = newInboundConn(source, local, 443)
+	if !checkPortScanBlock(context.Background(), conn, nil) || conn.Verdict != network.VerdictDrop {
+		t.Error("connection from blocked scanner must be dropped")
+	}
+	other := newInboundConn(net.IPv4(192, 168, 1, 67), local, 443)
+	if checkPortScanBlock(context.Background(), other, nil) {
+		t.Error("connection from other IP must not be dropped")
+	}
+
+	// The block ends after the duration.
+	portScanTrackersLock.Lock()
+	tracker.blockedUntil = time.Now().Add(-time.Second)
+	portScanTrackersLock.Unlock()
+	if checkPortScanBlock(context.Background(), newInboundConn(source, local, 443), nil) {
+		t.Error("connection must not be dropped after the block ended")
+	}
+}
+
+func TestPortScanExpiry(t *testing.T) {
+	defer setPortScanOptions(true, 0)()
+
+	now := time.Now()
+	local := net.IPv4(192, 168, 1, 2)
+
+	// Trackers without detected activity are removed after the window.
+	idle := net.IPv4(192, 168, 1, 66)
+	recordInboundConnection(context.Background(), newInboundConn(idle, local, 80), now)
+	updatePortScanThreats(now.Add(portScanWindow / 2))
+	if getPortScanTracker(idle) == nil {
+		t.Fatal("tracker must be kept within the window")
+	}
+	updatePortScanThreats(now.Add(portScanWindow + time.Second))
+	if getPortScanTracker(idle) != nil {
+		t.Error("tracker must be removed after the window")
+	}
+
+	// Trackers of detected scanners are kept until the threat expired and the
+	// block ended.
+	scanner := net.IPv4(192, 168, 1, 67)
+	portScanTrackersLock.Lock()
+	portScanTrackers[scanner.String()] = &portScanTracker{
+		lastSeen:     now,
+		blockedUntil: now.Add(2 * portScanThreatTTL),
+		detected:     portScanKindPorts,
+	}
+	portScanTrackersLock.Unlock()
+
+	updatePortScanThreats(now.Add(portScanThreatTTL + time.Second))
+	if getPortScanTracker(scanner) == nil {
+		t.Fatal("tracker of blocked scanner must be kept")
+	}
+	updatePortScanThreats(now.Add(2*portScanThreatTTL + time.Second))
+	if getPortScanTracker(scanner) != nil {
+		t.Error("tracker must be removed after the threat expired and the block ended")
+	}
+}
@@ -3,6 +3,7 @@
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +29,10 @@
 	blockServingIP = "block-serving-ip"
 
 	cancelPrompt = "cancel"
+
+	// suffixes for action IDs that create temporary rules
+	forOneHourSuffix   = "-1h"
+	untilRestartSuffix = "-restart"
 )
 
 var (
@@ -52,19 +57,24 @@ func prompt(ctx context.Context, conn *network.Connection, pkt packet.Packet) {
 	// wait for response/timeout
 	select {
 	case promptResponse := <-n.Response():
-		switch promptResponse {
+		actionID, _, _ := splitPromptResponse(promptResponse)
+		switch actionID {
 		case allowDomainAll, allowDomainDistinct, allowIP, allowServingIP:
+			metricPromptsAllowed.Inc()
 			conn.Accept("permitted via prompt", profile.CfgOptionEndpointsKey)
 		default: // deny
+			metricPromptsBlocked.Inc()
 			conn.Deny("blocked via prompt", profile.CfgOptionEndpointsKey)
 		}
 
 	case <-time.After(1 * time.Second):
 		log.Tracer(ctx).Debugf("filter: continuing prompting async")
+		metricPromptsPending.Inc()
 		conn.Deny("prompting in progress, please respond to prompt", profile.CfgOptionDefaultActionKey)
 
 	case <-ctx.Done():
 		log.Tracer(ctx).Debugf("filter: aborting prompting because of shutdown")
+		metricPromptsAborted.Inc()
 		conn.Drop("shutting down", noReasonOptionKey)
 	}
 }
@@ -98,7 +108,7 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 			promptIDPrefix,
 			localProfile.ID,
 			conn.Scope,
-			pkt.Info().RemoteIP(),
+			conn.Entity.IP,
 		)
 	default: // connection to domain
 		nID = fmt.Sprintf(
@@ -164,6 +174,14 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 				ID:   allowServingIP,
 				Text: "Allow",
 			},
+			{
+				ID:   allowServingIP + forOneHourSuffix,
+				Text: "Allow for 1 Hour",
+			},
+			{
+				ID:   allowServingIP + untilRestartSuffix,
+				Text: "Allow until Restart",
+			},
 			{
 				ID:   blockServingIP,
 				Text: "Block",
@@ -176,6 +194,14 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 				ID:   allowIP,
 				Text: "Allow",
 			},
+			{
+				ID:   allowIP + forOneHourSuffix,
+				Text: "Allow for 1 Hour",
+			},
+			{
+				ID:   allowIP + untilRestartSuffix,
+				Text: "Allow until Restart",
+			},
 			{
 				ID:   blockIP,
 				Text: "Block",
@@ -188,6 +214,14 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 				ID:   allowDomainAll,
 				Text: "Allow",
 			},
+			{
+				ID:   allowDomainAll + forOneHourSuffix,
+				Text: "Allow for 1 Hour",
+			},
+			{
+				ID:   allowDomainAll + untilRestartSuffix,
+				Text: "Allow until Restart",
+			},
 			{
 				ID:   blockDomainAll,
 				Text: "Block",
@@ -215,36 +249,46 @@ func saveResponse(p *profile.Profile, entity *intel.Entity, promptResponse strin
 		}
 	}
 
+	// Get the base action and the expiry of the new rule.
+	actionID, expires, untilRestart := splitPromptResponse(promptResponse)
+	epBase := func(permitted bool) endpoints.EndpointBase {
+		return endpoints.EndpointBase{
+			Permitted:        permitted,
+			Expires:          expires,
+			ExpiresOnRestart: untilRestart,
+		}
+	}
+
 	var ep endpoints.Endpoint
-	switch promptResponse {
+	switch actionID {
 	case allowDomainAll:
 		ep = &endpoints.EndpointDomain{
-			EndpointBase:  endpoints.EndpointBase{Permitted: true},
+			EndpointBase:  epBase(true),
 			OriginalValue: "." + entity.Domain,
 		}
 	case allowDomainDistinct:
 		ep = &endpoints.EndpointDomain{
-			EndpointBase:  endpoints.EndpointBase{Permitted: true},
+			EndpointBase:  epBase(true),
 			OriginalValue: entity.Domain,
 		}
 	case blockDomainAll:
 		ep = &endpoints.EndpointDomain{
-			EndpointBase:  endpoints.EndpointBase{Permitted: false},
+			EndpointBase:  epBase(false),
 			OriginalValue: "." + entity.Domain,
 		}
 	case blockDomainDistinct:
 		ep = &endpoints.EndpointDomain{
-			EndpointBase:  endpoints.EndpointBase{Permitted: false},
+			EndpointBase:  epBase(false),
 			OriginalValue: entity.Domain,
 		}
 	case allowIP, allowServingIP:
 		ep = &endpoints.EndpointIP{
-			EndpointBase: endpoints.EndpointBase{Permitted: true},
+			EndpointBase: epBase(true),
 			IP:           entity.IP,
 		}
 	case blockIP, blockServingIP:
 		ep = &endpoints.EndpointIP{
-			EndpointBase: endpoints.EndpointBase{Permitted: false},
+			EndpointBase: epBase(false),
 			IP:           entity.IP,
 		}
 	case cancelPrompt:
@@ -253,7 +297,7 @@ func saveResponse(p *profile.Profile, entity *intel.Entity, promptResponse strin
 		return fmt.Errorf("unknown prompt response: %s", promptResponse)
 	}
 
-	switch promptResponse {
+	switch actionID {
 	case allowServingIP, blockServingIP:
 		p.AddServiceEndpoint(ep.String())
 		log.Infof("filter: added incoming rule to profile %s: %q", p, ep.String())
@@ -264,3 +308,16 @@ func saveResponse(p *profile.Profile, entity *intel.Entity, promptResponse strin
 
 	return nil
 }
+
+// splitPromptResponse splits a prompt response into the base action ID and the
+// expiry of the rule it creates.
+func splitPromptResponse(promptResponse string) (actionID string, expires int64, untilRestart bool) {
+	switch {
+	case strings.HasSuffix(promptResponse, forOneHourSuffix):
+		return strings.TrimSuffix(promptResponse, forOneHourSuffix), time.Now().Add(1 * time.Hour).Unix(), false
+	case strings.HasSuffix(promptResponse, untilRestartSuffix):
+		return strings.TrimSuffix(promptResponse, untilRestartSuffix), 0, true
+	default:
+		return promptResponse, 0, false
+	}
+}
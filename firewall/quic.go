@@ -0,0 +1,60 @@
+package firewall
+
+import (
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// quicMaxInitialPackets is the number of Initial packets after which the
+// inspection of a QUIC connection is given up.
+const quicMaxInitialPackets = 8
+
+// quicInspector decrypts the Initial packets of outgoing QUIC connections
+// and stores the server name of the ClientHello on the connection, like the
+// tlsInspector.
+type quicInspector struct {
+	// index is the index of the inspector, which is used as the key of its
+	// inspector data.
+	index uint8
+}
+
+func (qi *quicInspector) Name() string {
+	return "QUIC"
+}
+
+func (qi *quicInspector) Applies(conn *network.Connection) bool {
+	return !conn.Inbound &&
+		conn.IPProtocol == packet.UDP &&
+		conn.Entity.Port == 443 &&
+		conn.TLSContext == nil
+}
+
+func (qi *quicInspector) Inspect(conn *network.Connection, pkt packet.Packet, data []byte) (inspection.Action, error) {
+	inspectorData := conn.GetInspectorData()
+	assembler, ok := inspectorData[qi.index].(*inspection.QUICInitialAssembler)
+	if !ok {
+		assembler = &inspection.QUICInitialAssembler{}
+		inspectorData[qi.index] = assembler
+	}
+
+	hello, err := assembler.Add(data)
+	switch err {
+	case nil:
+	case inspection.ErrIncomplete:
+		if assembler.Packets >= quicMaxInitialPackets {
+			return inspection.ActionStop, nil
+		}
+		return inspection.ActionContinue, nil
+	case inspection.ErrUnsupportedQUICVersion:
+		log.Tracer(pkt.Ctx()).Tracef("filter: cannot inspect QUIC connection %s: %s", conn, err)
+		return inspection.ActionStop, nil
+	default:
+		// Not QUIC, nothing to do.
+		return inspection.ActionStop, nil
+	}
+
+	handleClientHello(conn, pkt, hello, true)
+	return inspection.ActionStop, nil
+}
@@ -0,0 +1,147 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/network"
+)
+
+const (
+	reevaluationTickDuration = 5 * time.Second
+)
+
+func registerReevaluationAPI() {
+	api.RegisterHandleFunc(
+		"/api/v1/firewall/reevaluate/{source:[a-z]+}/{id:[A-Za-z0-9_\\-]+}",
+		handleReevaluationRequest,
+	).Methods("POST")
+}
+
+func handleReevaluationRequest(w http.ResponseWriter, r *http.Request) {
+	vars := api.GetMuxVars(r)
+	scopedID := vars["source"] + "/" + vars["id"]
+
+	// Re-evaluation may take a while, do not block the request.
+	interceptionModule.StartWorker("re-evaluate connections", func(ctx context.Context) error {
+		changed := reevaluateConnections(ctx, scopedID, true)
+		log.Infof("filter: re-evaluated connections of %s, %d verdicts changed", scopedID, changed)
+		return nil
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = fmt.Fprintf(w, "re-evaluating connections of %s", scopedID)
+}
+
+// reevaluationWorker periodically re-evaluates connections with a permanent
+// verdict whose profile changed. Connections without a permanent verdict are
+// re-evaluated by the default handler with their next packet.
+func reevaluationWorker(ctx context.Context) error {
+	ticker := time.NewTicker(reevaluationTickDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			reevaluateConnections(ctx, "", false)
+		}
+	}
+}
+
+// reevaluateConnections re-evaluates the connections of the profile with the
+// given scoped ID, or of all profiles if it is empty. If force is false, only
+// connections with a permanent verdict whose profile changed since they were
+// last decided on are re-evaluated. Changed permanent verdicts are applied to
+// the established connections through the interception. Re-evaluation never
+// prompts the user: connections that would be prompted for keep their
+// previous verdict. It returns the number of connections whose verdict
+// changed.
+func reevaluateConnections(ctx context.Context, scopedID string, force bool) (changed int) {
+	if !filterEnabled() {
+		return 0
+	}
+
+	for _, conn := range network.GetAllConnections() {
+		if reevaluateConnection(ctx, conn, scopedID, force) {
+			changed++
+		}
+	}
+
+	return changed
+}
+
+func reevaluateConnection(ctx context.Context, conn *network.Connection, scopedID string, force bool) (changed bool) {
+	conn.Lock()
+	defer conn.Unlock()
+
+	// Skip connections that cannot be re-evaluated.
+	switch {
+	case conn.Ended > 0,
+		conn.Verdict == network.VerdictRerouteToNameserver,
+		conn.Verdict == network.VerdictRerouteToTunnel:
+		return false
+	case conn.Internal:
+		// Internal connections are not decided on by a profile, only the VPN
+		// kill switch applies to them.
+		previousVerdict := conn.Verdict
+		if !force || previousVerdict != network.VerdictAccept || !checkKillSwitch(ctx, conn, nil) {
+			return false
+		}
+		applyReevaluatedVerdict(conn, previousVerdict)
+		return true
+	}
+
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return false
+	}
+	if scopedID != "" && layeredProfile.LocalProfile().ScopedID() != scopedID {
+		return false
+	}
+
+	if force {
+		// Reset the revision counter to force a re-evaluation.
+		conn.ProfileRevisionCounter = 0
+	} else if !conn.VerdictPermanent || !profileChanged(conn) {
+		return false
+	}
+
+	previousVerdict := conn.Verdict
+	previousReason := conn.Reason
+	if decideOnConnection(ctx, conn, nil, false) {
+		// Keep the previous verdict instead of prompting the user for every
+		// connection, the user will be asked with the next new connection.
+		conn.Verdict = previousVerdict
+		conn.Reason = previousReason
+		return false
+	}
+	if conn.Verdict == previousVerdict {
+		return false
+	}
+	applyReevaluatedVerdict(conn, previousVerdict)
+	return true
+}
+
+// applyReevaluatedVerdict applies the changed verdict of a re-evaluated
+// connection to the established connection and saves it.
+func applyReevaluatedVerdict(conn *network.Connection, previousVerdict network.Verdict) {
+	log.Infof("filter: verdict of %s changed from %s to %s", conn, previousVerdict.Verb(), conn.Verdict.Verb())
+
+	// Update the established connection if the verdict was already handed
+	// over to the system.
+	if conn.VerdictPermanent {
+		err := interception.UpdateVerdict(conn.PacketInfo(), conn.Verdict)
+		if err != nil {
+			log.Warningf("filter: failed to update verdict of established connection %s: %s", conn, err)
+		}
+	}
+
+	conn.Save()
+}
@@ -0,0 +1,36 @@
+package firewall
+
+import (
+	"context"
+	"testing"
+
+	"github.com/safing/portmaster/network"
+)
+
+func TestReevaluateConnectionSkips(t *testing.T) {
+	defer setKillSwitchInterfaces(nil)()
+
+	for name, conn := range map[string]*network.Connection{
+		"ended": {
+			Verdict:          network.VerdictAccept,
+			VerdictPermanent: true,
+			Ended:            1,
+		},
+		"internal": {
+			Verdict:          network.VerdictAccept,
+			VerdictPermanent: true,
+			Internal:         true,
+		},
+		"rerouted": {
+			Verdict:          network.VerdictRerouteToNameserver,
+			VerdictPermanent: true,
+		},
+	} {
+		if reevaluateConnection(context.Background(), conn, "", true) {
+			t.Errorf("%s connection must not be re-evaluated", name)
+		}
+		if conn.Verdict == network.VerdictUndecided {
+			t.Errorf("verdict of %s connection was reset", name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+// Database paths:
+// cache:filter/shadow/<source>/<profile id>/<scope>/<connection id>
+
+const (
+	shadowVerdictDBPath = "cache:filter/shadow/"
+
+	// shadowVerdictTTL defines how long disagreements are kept.
+	shadowVerdictTTL = 7 * 24 * time.Hour
+)
+
+var (
+	shadowVerdictDB = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+)
+
+// ShadowVerdict records a disagreement between the live verdict of a
+// connection and the verdict the staged config changes of its profile would
+// have produced.
+type ShadowVerdict struct {
+	record.Base
+	sync.Mutex
+
+	// Profile is the scoped ID of the local profile of the connection.
+	Profile string
+	// ConnectionID is the ID of the connection. It is empty for DNS requests.
+	ConnectionID string
+	// Scope is the scope of the connection.
+	Scope string
+	// Inbound is set to true if the connection is incoming.
+	Inbound bool
+	// Domain is the domain of the remote entity, if known.
+	Domain string
+	// IP is the IP address of the remote entity, if known.
+	IP string
+	// Protocol is the IP protocol number of the connection.
+	Protocol uint8
+	// Port is the remote port of the connection.
+	Port uint16
+
+	// LiveVerdict is the verdict that was enforced.
+	LiveVerdict network.Verdict
+	// LiveReason is the reason for the enforced verdict.
+	LiveReason network.Reason
+	// ShadowVerdict is the verdict the staged config changes would have
+	// produced. It is undecided if ShadowPrompt is set.
+	ShadowVerdict network.Verdict
+	// ShadowReason is the reason for the shadow verdict.
+	ShadowReason network.Reason
+	// ShadowPrompt is set to true if the staged config changes would have
+	// prompted the user.
+	ShadowPrompt bool
+
+	// Recorded holds the UTC timestamp in seconds when the disagreement was
+	// recorded.
+	Recorded int64
+}
+
+// checkShadowPolicy evaluates the staged config changes of the connection's
+// profile and records a disagreement with the live verdict. The live verdict
+// must already be set. livePrompted signals that the live verdict was decided
+// by prompting the user.
+func checkShadowPolicy(ctx context.Context, conn *network.Connection, pkt packet.Packet, livePrompted bool) {
+	shadowProfile := conn.Process().Profile().Shadow()
+	if shadowProfile == nil {
+		return
+	}
+
+	// Evaluate the staged config on a detached copy of the connection.
+	shadowConn := conn.ShadowCopy(shadowProfile)
+	var shadowPrompted bool
+	done, defaultAction := runDeciders(ctx, shadowConn, pkt)
+	if !done {
+		switch defaultAction {
+		case profile.DefaultActionPermit:
+			shadowConn.Accept("default permit", profile.CfgOptionDefaultActionKey)
+		case profile.DefaultActionAsk:
+			// Never prompt for the shadow evaluation.
+			shadowConn.Reason.Msg = "default prompt"
+			shadowConn.Reason.OptionKey = profile.CfgOptionDefaultActionKey
+			shadowPrompted = true
+		default:
+			shadowConn.Deny("default block", profile.CfgOptionDefaultActionKey)
+		}
+	}
+
+	if shadowVerdictsAgree(conn.Verdict, livePrompted, shadowConn.Verdict, shadowPrompted) {
+		return
+	}
+
+	log.Tracer(ctx).Infof(
+		"filter: staged config of %s disagrees on %s: live %s (%s), shadow %s (%s)",
+		shadowProfile.LocalProfile().ScopedID(),
+		conn,
+		conn.Verdict.Verb(),
+		conn.Reason.Msg,
+		shadowConn.Verdict.Verb(),
+		shadowConn.Reason.Msg,
+	)
+	saveShadowVerdict(conn, shadowConn, shadowPrompted)
+}
+
+// shadowVerdictsAgree returns whether the live and shadow evaluation came to
+// the same result. Prompts only agree with other prompts, as the verdict of a
+// prompt is the answer of the user.
+func shadowVerdictsAgree(liveVerdict network.Verdict, livePrompted bool, shadowVerdict network.Verdict, shadowPrompted bool) bool {
+	switch {
+	case livePrompted || shadowPrompted:
+		return livePrompted && shadowPrompted
+	default:
+		return liveVerdict == shadowVerdict
+	}
+}
+
+func saveShadowVerdict(conn, shadowConn *network.Connection, shadowPrompted bool) {
+	sv := &ShadowVerdict{
+		Profile:       shadowConn.Process().Profile().LocalProfile().ScopedID(),
+		ConnectionID:  conn.ID,
+		Scope:         conn.Scope,
+		Inbound:       conn.Inbound,
+		Domain:        conn.Entity.Domain,
+		Protocol:      conn.Entity.Protocol,
+		Port:          conn.Entity.Port,
+		LiveVerdict:   conn.Verdict,
+		LiveReason:    conn.Reason,
+		ShadowVerdict: shadowConn.Verdict,
+		ShadowReason:  shadowConn.Reason,
+		ShadowPrompt:  shadowPrompted,
+		Recorded:      time.Now().Unix(),
+	}
+	if conn.Entity.IP != nil {
+		sv.IP = conn.Entity.IP.String()
+	}
+
+	// DNS requests do not have an ID, use a fixed one, as there is only one
+	// connection per scope.
+	connID := conn.ID
+	if connID == "" {
+		connID = "dns"
+	}
+	sv.SetKey(fmt.Sprintf("%s%s/%s/%s", shadowVerdictDBPath, sv.Profile, conn.Scope, connID))
+	sv.UpdateMeta()
+	sv.Meta().SetAbsoluteExpiry(time.Now().Add(shadowVerdictTTL).Unix())
+
+	filterModule.StartWorker("save shadow verdict", func(_ context.Context) error {
+		return shadowVerdictDB.Put(sv)
+	})
+}
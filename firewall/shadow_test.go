@@ -0,0 +1,37 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/network"
+)
+
+func TestShadowVerdictsAgree(t *testing.T) {
+	for _, test := range []struct {
+		liveVerdict    network.Verdict
+		livePrompted   bool
+		shadowVerdict  network.Verdict
+		shadowPrompted bool
+		agree          bool
+	}{
+		{network.VerdictAccept, false, network.VerdictAccept, false, true},
+		{network.VerdictBlock, false, network.VerdictBlock, false, true},
+		{network.VerdictAccept, false, network.VerdictBlock, false, false},
+		{network.VerdictBlock, false, network.VerdictDrop, false, false},
+		// The live verdict of a prompt is the answer of the user.
+		{network.VerdictAccept, true, network.VerdictUndecided, true, true},
+		{network.VerdictBlock, true, network.VerdictUndecided, true, true},
+		{network.VerdictAccept, true, network.VerdictAccept, false, false},
+		{network.VerdictAccept, false, network.VerdictUndecided, true, false},
+	} {
+		agree := shadowVerdictsAgree(test.liveVerdict, test.livePrompted, test.shadowVerdict, test.shadowPrompted)
+		if agree != test.agree {
+			t.Errorf(
+				"live %s (prompted: %v) and shadow %s (prompted: %v): expected agree=%v, got %v",
+				test.liveVerdict.Verb(), test.livePrompted,
+				test.shadowVerdict.Verb(), test.shadowPrompted,
+				test.agree, agree,
+			)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package firewall
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// tlsInspector parses the TLS ClientHello of outgoing TCP connections and
+// stores the requested server name on the connection.
+type tlsInspector struct{}
+
+func (ti *tlsInspector) Name() string {
+	return "TLS"
+}
+
+func (ti *tlsInspector) Applies(conn *network.Connection) bool {
+	return !conn.Inbound && conn.IPProtocol == packet.TCP && conn.TLSContext == nil
+}
+
+func (ti *tlsInspector) Inspect(conn *network.Connection, pkt packet.Packet, data []byte) (inspection.Action, error) {
+	hello, err := inspection.ParseClientHello(data)
+	switch err {
+	case nil:
+	case inspection.ErrIncomplete:
+		return inspection.ActionContinue, nil
+	default:
+		// Not TLS, nothing to do.
+		return inspection.ActionStop, nil
+	}
+
+	handleClientHello(conn, pkt, hello, false)
+	return inspection.ActionStop, nil
+}
+
+// handleClientHello stores the information and fingerprints of the
+// ClientHello on the connection and checks them against the endpoint lists.
+func handleClientHello(conn *network.Connection, pkt packet.Packet, hello *inspection.ClientHello, quic bool) {
+	conn.TLSContext = &network.TLSContext{
+		SNI:  strings.ToLower(hello.SNI),
+		ALPN: hello.ALPN,
+		QUIC: quic,
+		JA3:  hello.JA3(),
+		JA4:  hello.JA4(quic),
+	}
+	conn.SaveWhenFinished()
+	log.Tracer(pkt.Ctx()).Tracef(
+		"filter: TLS connection %s requested server name %q with fingerprint %s",
+		conn, conn.TLSContext.SNI, conn.TLSContext.JA4,
+	)
+
+	// Check the server name and fingerprints against the endpoint lists and
+	// for DNS bypass attempts.
+	if layeredProfile := conn.Process().Profile(); layeredProfile != nil {
+		layeredProfile.LockForUsage()
+		if !checkTLSEndpointLists(pkt.Ctx(), conn, pkt) {
+			checkInspectedBypassing(pkt.Ctx(), conn, pkt)
+		}
+		layeredProfile.UnlockForUsage()
+
+		recordTLSFingerprint(conn)
+	}
+}
+
+// checkTLSEndpointLists checks the TLS client fingerprints and, if the
+// connection has no domain, the server name of TLS connections against the
+// TLS fingerprint rules and the endpoint lists. As both are chosen by the
+// client, they are only used to deny connections.
+func checkTLSEndpointLists(ctx context.Context, conn *network.Connection, _ packet.Packet) bool {
+	if conn.Inbound || conn.TLSContext == nil {
+		return false
+	}
+
+	// Use a copy of the entity, so that the server name does not show up as
+	// a resolved domain.
+	entity := &intel.Entity{
+		Protocol:        conn.Entity.Protocol,
+		Port:            conn.Entity.Port,
+		Domain:          conn.Entity.Domain,
+		IP:              conn.Entity.IP,
+		JA3:             conn.TLSContext.JA3,
+		JA4:             conn.TLSContext.JA4,
+		Interface:       conn.Entity.Interface,
+		NetworkLocation: conn.Entity.NetworkLocation,
+	}
+	entity.SetDstPort(conn.Entity.DstPort())
+	if entity.Domain == "" && conn.TLSContext.SNI != "" {
+		fqdn := dns.Fqdn(conn.TLSContext.SNI)
+		if netutils.IsValidFqdn(fqdn) {
+			entity.Domain = fqdn
+		} else {
+			log.Tracer(ctx).Debugf("filter: ignoring invalid server name %q of %s", conn.TLSContext.SNI, conn)
+		}
+	}
+
+	tlsFingerprintRulesLock.RLock()
+	result, reason := tlsFingerprintRules.Match(ctx, entity)
+	tlsFingerprintRulesLock.RUnlock()
+	if result == endpoints.Denied {
+		conn.DenyWithContext("TLS fingerprint: "+reason.String(), CfgOptionTLSFingerprintRulesKey, reason.Context())
+		return true
+	}
+
+	p := conn.Process().Profile()
+	result, reason = p.MatchEndpoint(ctx, entity)
+	if result == endpoints.Denied {
+		conn.DenyWithContext("TLS handshake: "+reason.String(), profile.CfgOptionEndpointsKey, reason.Context())
+		return true
+	}
+
+	return false
+}
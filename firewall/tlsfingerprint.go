@@ -0,0 +1,232 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// Database paths:
+// core:filter/tls-fingerprints/<source>/<profile id>
+
+const (
+	tlsFingerprintDBPath = "core:filter/tls-fingerprints/"
+
+	// tlsFingerprintLearningPeriod defines how long the fingerprints of a
+	// profile are learned before new fingerprints raise a notification.
+	tlsFingerprintLearningPeriod = 24 * time.Hour
+
+	// tlsFingerprintSaveInterval defines how often the last seen timestamp of
+	// known fingerprints is saved.
+	tlsFingerprintSaveInterval = 1 * time.Hour
+
+	// maxTLSFingerprints defines how many fingerprints are kept per profile.
+	maxTLSFingerprints = 100
+)
+
+var (
+	tlsFingerprintDB = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	tlsFingerprintHistories     = make(map[string]*TLSFingerprintHistory)
+	tlsFingerprintHistoriesLock sync.Mutex
+
+	tlsFingerprintRules     endpoints.Endpoints
+	tlsFingerprintRulesLock sync.RWMutex
+)
+
+// TLSFingerprintHistory holds the TLS client fingerprints that were seen for
+// a profile.
+type TLSFingerprintHistory struct {
+	record.Base
+	sync.Mutex
+
+	// Profile holds the scoped ID of the profile.
+	Profile string
+	// Created holds the UNIX epoch timestamp in seconds of when the first
+	// fingerprint of the profile was seen.
+	Created int64
+	// Fingerprints holds the seen fingerprints, up to maxTLSFingerprints.
+	Fingerprints []*SeenTLSFingerprint
+}
+
+// SeenTLSFingerprint is a TLS client fingerprint seen for a profile.
+type SeenTLSFingerprint struct {
+	JA4 string
+	JA3 string
+	// SNI holds the server name of the first connection with the
+	// fingerprint.
+	SNI string
+	// FirstSeen and LastSeen hold UNIX epoch timestamps in seconds.
+	FirstSeen int64
+	LastSeen  int64
+}
+
+// updateTLSFingerprintRules parses the configured TLS fingerprint rules.
+// Invalid rules are skipped.
+func updateTLSFingerprintRules(_ context.Context, _ interface{}) error {
+	definitions := tlsFingerprintRuleDefinitions()
+	rules := make(endpoints.Endpoints, 0, len(definitions))
+	for _, definition := range definitions {
+		ep, err := endpoints.ParseEndpoints([]string{definition})
+		if err != nil {
+			log.Warningf("filter: ignoring invalid TLS fingerprint rule: %s", err)
+			continue
+		}
+		rules = append(rules, ep...)
+	}
+
+	tlsFingerprintRulesLock.Lock()
+	defer tlsFingerprintRulesLock.Unlock()
+
+	tlsFingerprintRules = rules
+	return nil
+}
+
+// recordTLSFingerprint adds the TLS fingerprint of the connection to the
+// history of its profile and notifies the user if the profile presents a new
+// fingerprint after the learning period.
+func recordTLSFingerprint(conn *network.Connection) {
+	localProfile := conn.Process().Profile().LocalProfile()
+	if localProfile == nil || conn.TLSContext == nil || conn.TLSContext.JA4 == "" {
+		return
+	}
+	profileID := localProfile.ScopedID()
+	profileName := localProfile.Name
+	seen := &SeenTLSFingerprint{
+		JA4:       conn.TLSContext.JA4,
+		JA3:       conn.TLSContext.JA3,
+		SNI:       conn.TLSContext.SNI,
+		FirstSeen: time.Now().Unix(),
+		LastSeen:  time.Now().Unix(),
+	}
+
+	filterModule.StartWorker("record tls fingerprint", func(_ context.Context) error {
+		history, err := getTLSFingerprintHistory(profileID)
+		if err != nil {
+			return err
+		}
+
+		history.Lock()
+		defer history.Unlock()
+
+		if !history.add(seen) {
+			return nil
+		}
+		if time.Since(time.Unix(history.Created, 0)) > tlsFingerprintLearningPeriod {
+			notifyNewTLSFingerprint(profileID, profileName, seen)
+		}
+		return tlsFingerprintDB.Put(history)
+	})
+}
+
+// add adds the fingerprint to the history and returns whether the history
+// needs to be saved. The history must be locked.
+func (history *TLSFingerprintHistory) add(seen *SeenTLSFingerprint) (save bool) {
+	for _, known := range history.Fingerprints {
+		if known.JA4 == seen.JA4 {
+			// Only save the last seen timestamp occasionally.
+			save = seen.LastSeen-known.LastSeen > int64(tlsFingerprintSaveInterval.Seconds())
+			if save {
+				known.LastSeen = seen.LastSeen
+			}
+			return save
+		}
+	}
+
+	// Make room by removing the fingerprint that was not seen the longest.
+	if len(history.Fingerprints) >= maxTLSFingerprints {
+		oldest := 0
+		for i, known := range history.Fingerprints {
+			if known.LastSeen < history.Fingerprints[oldest].LastSeen {
+				oldest = i
+			}
+		}
+		history.Fingerprints = append(history.Fingerprints[:oldest], history.Fingerprints[oldest+1:]...)
+	}
+
+	history.Fingerprints = append(history.Fingerprints, seen)
+	return true
+}
+
+// getTLSFingerprintHistory returns the fingerprint history of the given
+// profile from the cache or the database, or creates a new one.
+func getTLSFingerprintHistory(profileID string) (*TLSFingerprintHistory, error) {
+	tlsFingerprintHistoriesLock.Lock()
+	defer tlsFingerprintHistoriesLock.Unlock()
+
+	history, ok := tlsFingerprintHistories[profileID]
+	if ok {
+		return history, nil
+	}
+
+	history, err := loadTLSFingerprintHistory(profileID)
+	switch {
+	case err == nil:
+	case errors.Is(err, database.ErrNotFound):
+		history = &TLSFingerprintHistory{
+			Profile: profileID,
+			Created: time.Now().Unix(),
+		}
+		history.SetKey(tlsFingerprintDBPath + profileID)
+		history.UpdateMeta()
+	default:
+		return nil, fmt.Errorf("failed to load TLS fingerprint history of %s: %w", profileID, err)
+	}
+
+	tlsFingerprintHistories[profileID] = history
+	return history, nil
+}
+
+func loadTLSFingerprintHistory(profileID string) (*TLSFingerprintHistory, error) {
+	r, err := tlsFingerprintDB.Get(tlsFingerprintDBPath + profileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.IsWrapped() {
+		history := &TLSFingerprintHistory{}
+		if err := record.Unwrap(r, history); err != nil {
+			return nil, err
+		}
+		return history, nil
+	}
+
+	history, ok := r.(*TLSFingerprintHistory)
+	if !ok {
+		return nil, fmt.Errorf("invalid type, expected TLSFingerprintHistory but got %T", r)
+	}
+	return history, nil
+}
+
+func notifyNewTLSFingerprint(profileID, profileName string, seen *SeenTLSFingerprint) {
+	destination := seen.SNI
+	if destination == "" {
+		destination = "a server"
+	}
+	log.Warningf("filter: %s presented new TLS fingerprint %s to %s", profileID, seen.JA4, destination)
+
+	notifications.Notify(&notifications.Notification{
+		EventID:  fmt.Sprintf("filter:new-tls-fingerprint-%s-%s", profileID, seen.JA4),
+		Type:     notifications.Warning,
+		Title:    "New TLS Fingerprint",
+		Category: "Privacy Filter",
+		Message: fmt.Sprintf(
+			"%s connected to %s with a TLS client that was not seen for it before (JA4 %s). This happens after updates, but may also mean that another program is using its identity.",
+			profileName,
+			destination,
+			seen.JA4,
+		),
+	})
+}
@@ -0,0 +1,71 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/network"
+)
+
+const (
+	trafficUpdateTickDuration = 10 * time.Second
+)
+
+// trafficUpdater periodically updates the traffic counters of connections
+// with a permanent verdict, as their packets are not seen by the Portmaster
+// anymore.
+func trafficUpdater(ctx context.Context) error {
+	ticker := time.NewTicker(trafficUpdateTickDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !updateTrafficStats() {
+				log.Debugf("filter: traffic counters of established connections are not supported on this platform")
+				return nil
+			}
+		}
+	}
+}
+
+// updateTrafficStats updates the traffic counters of all active connections
+// with a permanent verdict. It returns false if this is not supported.
+func updateTrafficStats() (supported bool) {
+	for _, conn := range network.GetAllConnections() {
+		err := updateConnectionTraffic(conn)
+		if errors.Is(err, interception.ErrNotSupported) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func updateConnectionTraffic(conn *network.Connection) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	// Only connections that were handed over to the system need updating.
+	if conn.Ended > 0 || !conn.VerdictPermanent {
+		return nil
+	}
+
+	stats, err := interception.GetTrafficStats(conn.PacketInfo())
+	if err != nil {
+		if !errors.Is(err, interception.ErrNotSupported) {
+			log.Tracef("filter: failed to get traffic counters of %s: %s", conn, err)
+		}
+		return err
+	}
+
+	if conn.UpdateTraffic(*stats) {
+		conn.Save()
+	}
+	return nil
+}
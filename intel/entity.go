@@ -54,6 +54,24 @@ type Entity struct {
 	// resolved for Domain.
 	CNAME []string
 
+	// URLPath is the path of the HTTP request made on the connection. It is
+	// only set when the request was inspected.
+	URLPath string
+
+	// JA3 and JA4 are the TLS fingerprints of the client of the connection.
+	// They are only set when the TLS handshake was inspected.
+	JA3 string
+	JA4 string
+
+	// Interface is the name of the network interface the connection leaves
+	// or enters the host through. It is only set for connections created
+	// from packets.
+	Interface string
+
+	// NetworkLocation is the name of the network location the host was in
+	// when the connection was last decided on.
+	NetworkLocation string
+
 	// IP is the IP address of the connection. If domain is
 	// set, IP has been resolved by following all CNAMEs.
 	IP net.IP
@@ -0,0 +1,30 @@
+package filterlists
+
+import (
+	"github.com/safing/portmaster/metrics"
+)
+
+var (
+	updateMetric = metrics.NewCounterVec(
+		"portmaster_filterlists_updates",
+		"Updates of the filter lists, by result.",
+		"result",
+	)
+	metricUpdatesSucceeded = updateMetric.With("success")
+	metricUpdatesFailed    = updateMetric.With("failure")
+)
+
+func init() {
+	metrics.NewGaugeFunc(
+		"portmaster_filterlists_loaded",
+		"Whether the filter lists are loaded and used for filtering.",
+		nil,
+		func() []metrics.Sample {
+			var loaded float64
+			if isLoaded() {
+				loaded = 1
+			}
+			return []metrics.Sample{{Value: loaded}}
+		},
+	)
+}
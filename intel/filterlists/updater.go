@@ -22,6 +22,7 @@ func tryListUpdate(ctx context.Context) error {
 	err := performUpdate(ctx)
 
 	if err != nil {
+		metricUpdatesFailed.Inc()
 		if !isLoaded() {
 			module.Error(filterlistsDisabled, err.Error())
 		} else {
@@ -30,6 +31,8 @@ func tryListUpdate(ctx context.Context) error {
 		return err
 	}
 
+	metricUpdatesSucceeded.Inc()
+
 	// if the module is in an error, warning or hint state resolve that right now.
 	module.Resolve(filterlistsDisabled)
 	module.Resolve(filterlistsStaleDataSurvived)
@@ -0,0 +1,391 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metric types.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultLatencyBuckets are histogram buckets in seconds that are suited for
+// the handling latency of packets and requests.
+var DefaultLatencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+}
+
+// metric is a metric family that can be exposed.
+type metric interface {
+	family() *family
+	writeSamples(w io.Writer)
+}
+
+var (
+	registry     = make(map[string]metric)
+	registryLock sync.RWMutex
+)
+
+func register(m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	name := m.family().name
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("metrics: metric %s is already registered", name))
+	}
+	registry[name] = m
+}
+
+// family holds the description of a metric family.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (f *family) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	}
+}
+
+// formatLabels formats the labels with the given values, plus an optional
+// extra label.
+func (f *family) formatLabels(values []string, extra ...string) string {
+	if len(f.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(f.labels)+1)
+	for i, label := range f.labels {
+		parts = append(parts, label+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	if len(extra) == 2 {
+		parts = append(parts, extra[0]+`="`+escapeLabelValue(extra[1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (f *family) checkLabelValues(values []string) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: metric %s requires %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	value uint64
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increases the counter by the given value.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// CounterVec is a family of counters that are partitioned by labels.
+type CounterVec struct {
+	f family
+
+	counters     map[string]*labeledCounter
+	countersLock sync.RWMutex
+}
+
+type labeledCounter struct {
+	Counter
+	values []string
+}
+
+// NewCounterVec registers and returns a new counter family. The name must not
+// have the "_total" suffix, as it is added to the samples.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{
+		f: family{
+			name:   name,
+			help:   help,
+			typ:    typeCounter,
+			labels: labels,
+		},
+		counters: make(map[string]*labeledCounter),
+	}
+	register(cv)
+	return cv
+}
+
+// With returns the counter with the given label values.
+func (cv *CounterVec) With(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+
+	cv.countersLock.RLock()
+	c, ok := cv.counters[key]
+	cv.countersLock.RUnlock()
+	if ok {
+		return &c.Counter
+	}
+
+	cv.f.checkLabelValues(values)
+
+	cv.countersLock.Lock()
+	defer cv.countersLock.Unlock()
+
+	c, ok = cv.counters[key]
+	if !ok {
+		c = &labeledCounter{
+			values: append([]string(nil), values...),
+		}
+		cv.counters[key] = c
+	}
+	return &c.Counter
+}
+
+func (cv *CounterVec) family() *family {
+	return &cv.f
+}
+
+func (cv *CounterVec) writeSamples(w io.Writer) {
+	cv.countersLock.RLock()
+	defer cv.countersLock.RUnlock()
+
+	for _, key := range sortedKeys(cv.counters) {
+		c := cv.counters[key]
+		fmt.Fprintf(w, "%s_total%s %d\n", cv.f.name, cv.f.formatLabels(c.values), c.Value())
+	}
+}
+
+// Histogram counts observed values in buckets.
+type Histogram struct {
+	buckets []float64
+
+	lock   sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds a value to the histogram.
+func (h *Histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// HistogramVec is a family of histograms that are partitioned by labels.
+type HistogramVec struct {
+	f       family
+	buckets []float64
+
+	histograms     map[string]*labeledHistogram
+	histogramsLock sync.RWMutex
+}
+
+type labeledHistogram struct {
+	Histogram
+	values []string
+}
+
+// NewHistogramVec registers and returns a new histogram family with the given
+// bucket upper bounds, which must be sorted in increasing order.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	hv := &HistogramVec{
+		f: family{
+			name:   name,
+			help:   help,
+			typ:    typeHistogram,
+			labels: labels,
+		},
+		buckets:    buckets,
+		histograms: make(map[string]*labeledHistogram),
+	}
+	register(hv)
+	return hv
+}
+
+// With returns the histogram with the given label values.
+func (hv *HistogramVec) With(values ...string) *Histogram {
+	key := strings.Join(values, "\xff")
+
+	hv.histogramsLock.RLock()
+	h, ok := hv.histograms[key]
+	hv.histogramsLock.RUnlock()
+	if ok {
+		return &h.Histogram
+	}
+
+	hv.f.checkLabelValues(values)
+
+	hv.histogramsLock.Lock()
+	defer hv.histogramsLock.Unlock()
+
+	h, ok = hv.histograms[key]
+	if !ok {
+		h = &labeledHistogram{
+			Histogram: Histogram{
+				buckets: hv.buckets,
+				counts:  make([]uint64, len(hv.buckets)),
+			},
+			values: append([]string(nil), values...),
+		}
+		hv.histograms[key] = h
+	}
+	return &h.Histogram
+}
+
+func (hv *HistogramVec) family() *family {
+	return &hv.f
+}
+
+func (hv *HistogramVec) writeSamples(w io.Writer) {
+	hv.histogramsLock.RLock()
+	defer hv.histogramsLock.RUnlock()
+
+	for _, key := range sortedKeys(hv.histograms) {
+		h := hv.histograms[key]
+
+		h.lock.Lock()
+		for i, upperBound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.f.name, hv.f.formatLabels(h.values, "le", formatFloat(upperBound)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.f.name, hv.f.formatLabels(h.values, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.f.name, hv.f.formatLabels(h.values), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.f.name, hv.f.formatLabels(h.values), h.count)
+		h.lock.Unlock()
+	}
+}
+
+// Sample is a single value of a gauge family with its label values.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// GaugeFunc is a family of gauges whose values are collected when the
+// metrics are exposed.
+type GaugeFunc struct {
+	f       family
+	collect func() []Sample
+}
+
+// NewGaugeFunc registers a gauge family whose samples are returned by the
+// given function when the metrics are exposed.
+func NewGaugeFunc(name, help string, labels []string, collect func() []Sample) *GaugeFunc {
+	gf := &GaugeFunc{
+		f: family{
+			name:   name,
+			help:   help,
+			typ:    typeGauge,
+			labels: labels,
+		},
+		collect: collect,
+	}
+	register(gf)
+	return gf
+}
+
+func (gf *GaugeFunc) family() *family {
+	return &gf.f
+}
+
+func (gf *GaugeFunc) writeSamples(w io.Writer) {
+	samples := gf.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+
+	for _, sample := range samples {
+		if len(sample.LabelValues) != len(gf.f.labels) {
+			continue
+		}
+		fmt.Fprintf(w, "%s%s %s\n", gf.f.name, gf.f.formatLabels(sample.LabelValues), formatFloat(sample.Value))
+	}
+}
+
+// Write writes all registered metrics in the OpenMetrics text format.
+func Write(w io.Writer) {
+	registryLock.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryLock.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		registryLock.RLock()
+		m := registry[name]
+		registryLock.RUnlock()
+
+		m.family().writeHeader(w)
+		m.writeSamples(w)
+	}
+	fmt.Fprint(w, "# EOF\n")
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch typed := m.(type) {
+	case map[string]*labeledCounter:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]*labeledHistogram:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetRegistry() {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry = make(map[string]metric)
+}
+
+func TestExposition(t *testing.T) {
+	resetRegistry()
+
+	counter := NewCounterVec("test_packets", "Test packets.", "verdict")
+	counter.With("block").Inc()
+	counter.With("accept").Add(3)
+
+	histogram := NewHistogramVec("test_latency_seconds", "Test latency.", []float64{0.1, 1}, "verdict")
+	histogram.With("accept").Observe(0.05)
+	histogram.With("accept").Observe(0.5)
+	histogram.With("accept").Observe(2)
+
+	NewGaugeFunc("test_connections", "Test \"connections\"\nper profile.", []string{"profile"}, func() []Sample {
+		return []Sample{
+			{LabelValues: []string{`local/a"b`}, Value: 2},
+			{LabelValues: []string{"invalid", "label count"}, Value: 1},
+		}
+	})
+
+	buf := new(bytes.Buffer)
+	Write(buf)
+
+	assert.Equal(t, `# TYPE test_connections gauge
+# HELP test_connections Test "connections"\nper profile.
+test_connections{profile="local/a\"b"} 2
+# TYPE test_latency_seconds histogram
+# HELP test_latency_seconds Test latency.
+test_latency_seconds_bucket{verdict="accept",le="0.1"} 1
+test_latency_seconds_bucket{verdict="accept",le="1"} 2
+test_latency_seconds_bucket{verdict="accept",le="+Inf"} 3
+test_latency_seconds_sum{verdict="accept"} 2.55
+test_latency_seconds_count{verdict="accept"} 3
+# TYPE test_packets counter
+# HELP test_packets Test packets.
+test_packets_total{verdict="accept"} 3
+test_packets_total{verdict="block"} 1
+# EOF
+`, buf.String())
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	resetRegistry()
+
+	NewCounterVec("test_duplicate", "")
+	assert.Panics(t, func() {
+		NewCounterVec("test_duplicate", "")
+	})
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/modules"
+)
+
+const (
+	// CfgOptionEnableMetricsKey is the config key of the metrics endpoint.
+	CfgOptionEnableMetricsKey = "core/enableMetrics"
+
+	contentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+var (
+	module *modules.Module
+
+	enableMetrics config.BoolOption
+)
+
+func init() {
+	module = modules.Register("metrics", prep, nil, nil, "base")
+}
+
+func prep() error {
+	err := config.Register(&config.Option{
+		Name:            "Metrics Endpoint",
+		Key:             CfgOptionEnableMetricsKey,
+		Description:     "Expose firewall and resolver statistics in the OpenMetrics format at /api/v1/metrics of the API, eg. for scraping with Prometheus. Enabling this also measures the decision latency of every packet.",
+		OptType:         config.OptTypeBool,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		RequiresRestart: true,
+		DefaultValue:    false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 530,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	enableMetrics = config.Concurrent.GetAsBool(CfgOptionEnableMetricsKey, false)
+
+	api.RegisterHandleFunc("/api/v1/metrics", handleMetricsRequest).Methods("GET")
+	return nil
+}
+
+// Enabled returns whether the metrics endpoint is enabled. Metrics are always
+// recorded, but costly measurements should only be taken if enabled.
+func Enabled() bool {
+	if enableMetrics == nil {
+		return false
+	}
+	return enableMetrics()
+}
+
+func handleMetricsRequest(w http.ResponseWriter, r *http.Request) {
+	if !Enabled() {
+		http.Error(w, "metrics endpoint is disabled", http.StatusNotFound)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	Write(buf)
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -116,3 +116,37 @@ func checkIfMyIP(ip net.IP) (mine bool, matched bool) {
 
 	return false, false
 }
+
+// IsLANIP returns whether the given IP is within a network that is directly
+// attached to the host, excluding networks that contain a gateway of the host.
+// When the host is used as a gateway, these are the networks on its LAN side.
+func IsLANIP(ip net.IP) bool {
+	// Refresh the assigned networks if the IP is unknown.
+	if _, err := IsMyIP(ip); err != nil {
+		log.Warningf("netenv: failed to check if %s is in a LAN network: %s", ip, err)
+	}
+	gateways := Gateways()
+
+	myNetworksLock.Lock()
+	defer myNetworksLock.Unlock()
+
+	for _, myNet := range myNetworks {
+		if !myNet.Contains(ip) {
+			continue
+		}
+
+		// Check if the network is on the WAN side.
+		isWAN := false
+		for _, gateway := range gateways {
+			if myNet.Contains(gateway) {
+				isWAN = true
+				break
+			}
+		}
+		if !isWAN {
+			return true
+		}
+	}
+
+	return false
+}
@@ -201,6 +201,86 @@ func getConnectivityStateFromDbus() (OnlineStatus, error) {
 	return StatusUnknown, nil
 }
 
+// getNetworkIdentityFromDbus returns the SSID of the connected Wi-Fi network
+// and the domain name assigned by DHCP of the primary connection.
+func getNetworkIdentityFromDbus() (ssid, dhcpDomain string, err error) {
+	dbusConnLock.Lock()
+	defer dbusConnLock.Unlock()
+
+	if dbusConn == nil {
+		dbusConn, err = dbus.SystemBus()
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	primaryConnectionVariant, err := getNetworkManagerProperty(dbusConn, dbus.ObjectPath("/org/freedesktop/NetworkManager"), "org.freedesktop.NetworkManager.PrimaryConnection")
+	if err != nil {
+		return "", "", err
+	}
+	primaryConnection, ok := primaryConnectionVariant.Value().(dbus.ObjectPath)
+	if !ok {
+		return "", "", errors.New("dbus: could not assert type of /org/freedesktop/NetworkManager:org.freedesktop.NetworkManager.PrimaryConnection")
+	}
+	if primaryConnection == "/" {
+		// Not connected.
+		return "", "", nil
+	}
+
+	// Get the domain name from the DHCP options.
+	dhcp4ConfigVariant, err := getNetworkManagerProperty(dbusConn, primaryConnection, "org.freedesktop.NetworkManager.Connection.Active.Dhcp4Config")
+	if err != nil {
+		return "", "", err
+	}
+	dhcp4Config, ok := dhcp4ConfigVariant.Value().(dbus.ObjectPath)
+	if ok && dhcp4Config != "/" {
+		optionsVariant, err := getNetworkManagerProperty(dbusConn, dhcp4Config, "org.freedesktop.NetworkManager.DHCP4Config.Options")
+		if err != nil {
+			return "", "", err
+		}
+		options, ok := optionsVariant.Value().(map[string]dbus.Variant)
+		if !ok {
+			return "", "", fmt.Errorf("dbus: could not assert type of %s:org.freedesktop.NetworkManager.DHCP4Config.Options", dhcp4Config)
+		}
+		if domainName, ok := options["domain_name"]; ok {
+			dhcpDomain, _ = domainName.Value().(string)
+		}
+	}
+
+	// Get the SSID from the access point of wireless devices.
+	devicesVariant, err := getNetworkManagerProperty(dbusConn, primaryConnection, "org.freedesktop.NetworkManager.Connection.Active.Devices")
+	if err != nil {
+		return "", "", err
+	}
+	devices, ok := devicesVariant.Value().([]dbus.ObjectPath)
+	if !ok {
+		return "", "", fmt.Errorf("dbus: could not assert type of %s:org.freedesktop.NetworkManager.Connection.Active.Devices", primaryConnection)
+	}
+	for _, device := range devices {
+		// Only wireless devices have an access point.
+		accessPointVariant, err := getNetworkManagerProperty(dbusConn, device, "org.freedesktop.NetworkManager.Device.Wireless.ActiveAccessPoint")
+		if err != nil {
+			continue
+		}
+		accessPoint, ok := accessPointVariant.Value().(dbus.ObjectPath)
+		if !ok || accessPoint == "/" {
+			continue
+		}
+
+		ssidVariant, err := getNetworkManagerProperty(dbusConn, accessPoint, "org.freedesktop.NetworkManager.AccessPoint.Ssid")
+		if err != nil {
+			return "", "", err
+		}
+		ssidBytes, ok := ssidVariant.Value().([]byte)
+		if !ok {
+			return "", "", fmt.Errorf("dbus: could not assert type of %s:org.freedesktop.NetworkManager.AccessPoint.Ssid", accessPoint)
+		}
+		return string(ssidBytes), dhcpDomain, nil
+	}
+
+	return "", dhcpDomain, nil
+}
+
 func getNetworkManagerProperty(conn *dbus.Conn, objectPath dbus.ObjectPath, property string) (dbus.Variant, error) {
 	object := conn.Object("org.freedesktop.NetworkManager", objectPath)
 	return object.GetProperty(property)
@@ -26,4 +26,13 @@ func TestDbus(t *testing.T) {
 		t.Errorf("getConnectivityStateFromDbus failed: %s", err)
 	}
 	t.Logf("getConnectivityStateFromDbus: %v", connectivityState)
+
+	// The network identity is only available if NetworkManager manages the
+	// primary connection.
+	ssid, dhcpDomain, err := getNetworkIdentityFromDbus()
+	if err != nil {
+		t.Logf("getNetworkIdentityFromDbus failed: %s", err)
+	} else {
+		t.Logf("getNetworkIdentityFromDbus: ssid=%q dhcpDomain=%q", ssid, dhcpDomain)
+	}
 }
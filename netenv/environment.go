@@ -4,11 +4,6 @@
 	"net"
 )
 
-// TODO: find a good way to identify a network
-// best options until now:
-// MAC of gateway
-// domain parameter of dhcp
-
 // TODO: get dhcp servers on windows:
 // windows: https://msdn.microsoft.com/en-us/library/windows/desktop/aa365917
 // this info might already be included in the interfaces api provided by golang!
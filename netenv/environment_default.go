@@ -12,6 +12,8 @@ func Gateways() []net.IP {
 	return nil
 }
 
+func addPlatformNetworkIdentity(identity *NetworkIdentity) {}
+
 // TODO: implement using
 // ifconfig
 // scutil --nwi
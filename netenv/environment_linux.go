@@ -219,3 +219,15 @@ func addNameservers(nameservers, newNameservers []Nameserver) []Nameserver {
 	}
 	return nameservers
 }
+
+// addPlatformNetworkIdentity adds the SSID and the DHCP domain from
+// NetworkManager to the network identity.
+func addPlatformNetworkIdentity(identity *NetworkIdentity) {
+	ssid, dhcpDomain, err := getNetworkIdentityFromDbus()
+	if err != nil {
+		log.Debugf("environment: could not get network identity from dbus: %s", err)
+		return
+	}
+	identity.SSID = ssid
+	identity.DHCPDomain = dhcpDomain
+}
@@ -18,4 +18,7 @@ func TestEnvironment(t *testing.T) {
 	gatewaysTest := Gateways()
 	t.Logf("gateways: %v", gatewaysTest)
 
+	identityTest := getNetworkIdentity()
+	t.Logf("network identity: %+v", identityTest)
+
 }
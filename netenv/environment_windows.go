@@ -93,3 +93,7 @@ func Nameservers() []Nameserver {
 func Gateways() []net.IP {
 	return nil
 }
+
+// TODO: get the SSID and the DHCP domain on windows, eg. using the WLAN API
+// and GetAdaptersAddresses.
+func addPlatformNetworkIdentity(identity *NetworkIdentity) {}
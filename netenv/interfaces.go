@@ -0,0 +1,112 @@
+package netenv
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+const interfacesRecheck = 5 * time.Second
+
+var (
+	interfaceOfIP        = make(map[string]string)
+	interfaceOfIndex     = make(map[int]string)
+	interfaceOfIPLock    sync.Mutex
+	interfaceOfIPExpires = time.Now()
+)
+
+// GetInterfaceOfIP returns the name of the network interface the given IP is
+// assigned to, or an empty string if it is not assigned to any interface of
+// the host. The interface of the local IP of a connection is the interface
+// the connection uses.
+func GetInterfaceOfIP(ip net.IP) string {
+	interfaceOfIPLock.Lock()
+	defer interfaceOfIPLock.Unlock()
+
+	// Check the cache first and refresh it if the IP is unknown, as
+	// addresses may have been assigned since.
+	name, ok := interfaceOfIP[ip.String()]
+	if ok || !refreshInterfaces() {
+		return name
+	}
+
+	return interfaceOfIP[ip.String()]
+}
+
+// GetInterfaceName returns the name of the network interface with the given
+// index, or an empty string if there is no such interface.
+func GetInterfaceName(index int) string {
+	if index <= 0 {
+		return ""
+	}
+
+	interfaceOfIPLock.Lock()
+	defer interfaceOfIPLock.Unlock()
+
+	// Check the cache first and refresh it if the index is unknown, as
+	// interfaces may have been added since.
+	name, ok := interfaceOfIndex[index]
+	if ok || !refreshInterfaces() {
+		return name
+	}
+
+	return interfaceOfIndex[index]
+}
+
+// refreshInterfaces refreshes the cached interfaces if they are expired and
+// returns whether they were refreshed. The lock must be held.
+func refreshInterfaces() (refreshed bool) {
+	if interfaceOfIPExpires.After(time.Now()) {
+		return false
+	}
+
+	addresses, names, err := getInterfaces()
+	if err != nil {
+		log.Warningf("netenv: failed to get interface addresses: %s", err)
+		return false
+	}
+	interfaceOfIP = addresses
+	interfaceOfIndex = names
+	interfaceOfIPExpires = time.Now().Add(interfacesRecheck)
+
+	return true
+}
+
+// resetInterfaceAddresses clears the cached interface addresses, so that
+// they are refreshed with the next lookup.
+func resetInterfaceAddresses() {
+	interfaceOfIPLock.Lock()
+	defer interfaceOfIPLock.Unlock()
+
+	interfaceOfIP = make(map[string]string)
+	interfaceOfIndex = make(map[int]string)
+	interfaceOfIPExpires = time.Now()
+}
+
+func getInterfaces() (addresses map[string]string, names map[int]string, err error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addresses = make(map[string]string)
+	names = make(map[int]string)
+	for _, iface := range interfaces {
+		names[iface.Index] = iface.Name
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Warningf("netenv: failed to get addrs from interface %s: %s", iface.Name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			if netAddr, ok := addr.(*net.IPNet); ok {
+				addresses[netAddr.IP.String()] = iface.Name
+			}
+		}
+	}
+
+	return addresses, names, nil
+}
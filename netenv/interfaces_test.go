@@ -0,0 +1,29 @@
+package netenv
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGetInterfaceOfIP(t *testing.T) {
+	if name := GetInterfaceOfIP(net.IPv4(127, 0, 0, 1)); name == "" {
+		t.Error("no interface found for 127.0.0.1")
+	}
+	if name := GetInterfaceOfIP(net.IPv4(192, 0, 2, 1)); name != "" {
+		t.Errorf("unexpected interface %s for unassigned IP", name)
+	}
+}
+
+func TestGetInterfaceName(t *testing.T) {
+	loopback := GetInterfaceOfIP(net.IPv4(127, 0, 0, 1))
+	iface, err := net.InterfaceByName(loopback)
+	if err != nil {
+		t.Fatalf("failed to get loopback interface: %s", err)
+	}
+	if name := GetInterfaceName(iface.Index); name != loopback {
+		t.Errorf("unexpected interface %s for index %d, expected %s", name, iface.Index, loopback)
+	}
+	if name := GetInterfaceName(0); name != "" {
+		t.Errorf("unexpected interface %s for index 0", name)
+	}
+}
@@ -0,0 +1,20 @@
+//+build !linux
+
+package netenv
+
+import "net"
+
+// Neighbour is an entry of the neighbour table of the host.
+type Neighbour struct {
+	IP        net.IP
+	MAC       net.HardwareAddr
+	Interface int
+}
+
+// GetNeighbour returns the neighbour table entry of the given IP, or nil if
+// the IP is not a neighbour of the host.
+func GetNeighbour(ip net.IP) *Neighbour {
+	return nil
+}
+
+func resetNeighbours() {}
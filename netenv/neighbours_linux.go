@@ -0,0 +1,127 @@
+package netenv
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+
+	"github.com/safing/portbase/log"
+)
+
+const (
+	neighboursRecheck = 5 * time.Second
+
+	// ndmsgLen is the length of the ndmsg header of neighbour messages.
+	// See linux/neighbour.h.
+	ndmsgLen = 12
+
+	ndaDst    = 1
+	ndaLLAddr = 2
+)
+
+// Neighbour is an entry of the neighbour table of the host.
+type Neighbour struct {
+	IP        net.IP
+	MAC       net.HardwareAddr
+	Interface int
+}
+
+var (
+	neighbours        = make(map[string]*Neighbour)
+	neighboursLock    sync.Mutex
+	neighboursExpires = time.Now()
+)
+
+// GetNeighbour returns the neighbour table entry of the given IP, or nil if
+// the IP is not a neighbour of the host.
+func GetNeighbour(ip net.IP) *Neighbour {
+	neighboursLock.Lock()
+	defer neighboursLock.Unlock()
+
+	// Use the cache until it expires, as devices may have appeared, left or
+	// changed their MAC address since.
+	if neighboursExpires.After(time.Now()) {
+		return neighbours[ip.String()]
+	}
+
+	updated, err := getNeighbours()
+	if err != nil {
+		log.Warningf("netenv: failed to get neighbour table: %s", err)
+		return nil
+	}
+	neighbours = updated
+	neighboursExpires = time.Now().Add(neighboursRecheck)
+
+	return neighbours[ip.String()]
+}
+
+// resetNeighbours clears the cached neighbour table, so that it is refreshed
+// with the next lookup.
+func resetNeighbours() {
+	neighboursLock.Lock()
+	defer neighboursLock.Unlock()
+
+	neighbours = make(map[string]*Neighbour)
+	neighboursExpires = time.Now()
+}
+
+func getNeighbours() (map[string]*Neighbour, error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to netlink: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETNEIGH,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		// Request all address families.
+		Data: make([]byte, ndmsgLen),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump neighbour table: %w", err)
+	}
+
+	found := make(map[string]*Neighbour, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.Data) < ndmsgLen {
+			continue
+		}
+
+		// Skip entries that are not (or no longer) reachable.
+		state := nlenc.Uint16(msg.Data[8:10])
+		if state&(unix.NUD_INCOMPLETE|unix.NUD_FAILED|unix.NUD_NOARP) != 0 {
+			continue
+		}
+
+		ad, err := netlink.NewAttributeDecoder(msg.Data[ndmsgLen:])
+		if err != nil {
+			continue
+		}
+		neighbour := &Neighbour{
+			Interface: int(nlenc.Int32(msg.Data[4:8])),
+		}
+		for ad.Next() {
+			switch ad.Type() {
+			case ndaDst:
+				neighbour.IP = net.IP(ad.Bytes())
+			case ndaLLAddr:
+				neighbour.MAC = net.HardwareAddr(ad.Bytes())
+			}
+		}
+		if ad.Err() != nil || neighbour.IP == nil || len(neighbour.MAC) == 0 {
+			continue
+		}
+
+		found[neighbour.IP.String()] = neighbour
+	}
+
+	return found, nil
+}
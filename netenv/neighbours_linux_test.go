@@ -0,0 +1,33 @@
+package netenv
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNeighboursCache(t *testing.T) {
+	// 192.0.2.0/24 is reserved for documentation and never a neighbour.
+	ip := net.IPv4(192, 0, 2, 17)
+	cached := &Neighbour{
+		IP:  ip,
+		MAC: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x17},
+	}
+
+	neighboursLock.Lock()
+	neighbours = map[string]*Neighbour{ip.String(): cached}
+	neighboursExpires = time.Now().Add(time.Minute)
+	neighboursLock.Unlock()
+	defer resetNeighbours()
+
+	if GetNeighbour(ip) != cached {
+		t.Error("neighbour must be taken from the cache")
+	}
+
+	// Entries are not used anymore once the cache was reset, eg. because the
+	// network changed.
+	resetNeighbours()
+	if GetNeighbour(ip) == cached {
+		t.Error("neighbour must not be taken from the cache after a reset")
+	}
+}
@@ -78,6 +78,9 @@ func monitorNetworkChanges(ctx context.Context) error {
 				continue serviceLoop
 			}
 			lastNetworkChecksum = newChecksum
+			resetInterfaceAddresses()
+			resetNeighbours()
+			updateNetworkIdentity()
 
 			if trigger {
 				triggerOnlineStatusInvestigation()
@@ -0,0 +1,80 @@
+package netenv
+
+import (
+	"strings"
+	"sync"
+)
+
+// NetworkIdentity holds information that identifies the network the host is
+// currently connected to.
+type NetworkIdentity struct {
+	// GatewayMACs holds the MAC addresses of the gateways.
+	GatewayMACs []string
+	// DHCPDomain holds the domain name assigned by DHCP, where available.
+	DHCPDomain string
+	// SearchDomains holds the DNS search domains of the nameservers.
+	SearchDomains []string
+	// SSID holds the SSID of the connected Wi-Fi network, where available.
+	SSID string
+}
+
+var (
+	networkIdentity     *NetworkIdentity
+	networkIdentityLock sync.Mutex
+)
+
+// GetNetworkIdentity returns information that identifies the network the
+// host is currently connected to. It is updated when the network changes.
+// The returned struct must not be modified.
+func GetNetworkIdentity() *NetworkIdentity {
+	networkIdentityLock.Lock()
+	defer networkIdentityLock.Unlock()
+
+	if networkIdentity == nil {
+		networkIdentity = getNetworkIdentity()
+	}
+	return networkIdentity
+}
+
+// updateNetworkIdentity refreshes the network identity.
+func updateNetworkIdentity() {
+	identity := getNetworkIdentity()
+
+	networkIdentityLock.Lock()
+	defer networkIdentityLock.Unlock()
+
+	networkIdentity = identity
+}
+
+func getNetworkIdentity() *NetworkIdentity {
+	identity := &NetworkIdentity{}
+
+	for _, gateway := range Gateways() {
+		if neighbour := GetNeighbour(gateway); neighbour != nil && len(neighbour.MAC) > 0 {
+			identity.GatewayMACs = appendUnique(identity.GatewayMACs, neighbour.MAC.String())
+		}
+	}
+
+	for _, nameserver := range Nameservers() {
+		for _, search := range nameserver.Search {
+			search = strings.TrimSuffix(strings.ToLower(search), ".")
+			if search != "" {
+				identity.SearchDomains = appendUnique(identity.SearchDomains, search)
+			}
+		}
+	}
+
+	addPlatformNetworkIdentity(identity)
+	identity.DHCPDomain = strings.TrimSuffix(strings.ToLower(identity.DHCPDomain), ".")
+
+	return identity
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, entry := range list {
+		if entry == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -54,15 +54,21 @@ func cleanConnections() (activePIDs map[int]struct{}) {
 			switch {
 			case conn.Ended == 0:
 				// Step 1: check if still active
-				exists := state.Exists(&packet.Info{
-					Inbound:  false, // src == local
-					Version:  conn.IPVersion,
-					Protocol: conn.IPProtocol,
-					Src:      conn.LocalIP,
-					SrcPort:  conn.LocalPort,
-					Dst:      conn.Entity.IP,
-					DstPort:  conn.Entity.Port,
-				}, now)
+				var exists bool
+				if conn.Forwarded {
+					exists = forwardedConnectionChecker != nil &&
+						forwardedConnectionChecker(conn.PacketInfo())
+				} else {
+					exists = state.Exists(&packet.Info{
+						Inbound:  false, // src == local
+						Version:  conn.IPVersion,
+						Protocol: conn.IPProtocol,
+						Src:      conn.LocalIP,
+						SrcPort:  conn.LocalPort,
+						Dst:      conn.Entity.IP,
+						DstPort:  conn.Entity.Port,
+					}, now)
+				}
 
 				activePIDs[conn.process.Pid] = struct{}{}
 
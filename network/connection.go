@@ -15,6 +15,7 @@
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/profile"
 	"github.com/safing/portmaster/resolver"
 )
 
@@ -42,6 +43,51 @@ type ProcessContext struct {
 	Source string
 }
 
+// TLSContext holds information about the TLS handshake of a connection,
+// as seen by the TLS inspector.
+type TLSContext struct {
+	// SNI is the server name that the client requested in the
+	// ClientHello.
+	SNI string
+	// ALPN holds the application protocols the client offered.
+	ALPN []string
+	// QUIC is set to true if the handshake was made using QUIC.
+	QUIC bool
+	// JA3 is the JA3 fingerprint of the ClientHello.
+	JA3 string
+	// JA4 is the JA4 fingerprint of the ClientHello.
+	JA4 string
+}
+
+// HTTPContext holds information about the plain-text HTTP request of a
+// connection, as seen by the HTTP inspector.
+type HTTPContext struct {
+	// Method is the request method.
+	Method string
+	// Host is the host the client requested.
+	Host string
+	// Path is the path of the request, without the query.
+	Path string
+	// Query is the raw query of the request.
+	Query string
+	// ContentType is the media type of the request body, if any.
+	ContentType string
+	// Accept holds the media types the client accepts.
+	Accept string
+	// HostMismatch is set to true if Host does not match the domain the
+	// IP address was resolved for, which is a sign of domain fronting.
+	HostMismatch bool
+}
+
+// DNSPayloadContext holds information about a DNS query in wire format that
+// was sent to a port other than 53, as seen by the DNS payload inspector.
+type DNSPayloadContext struct {
+	// Name is the queried name, as an FQDN.
+	Name string
+	// Type is the queried record type.
+	Type uint16
+}
+
 // Connection describes a distinct physical network connection
 // identified by the IP/Port pair.
 type Connection struct { //nolint:maligned // TODO: fix alignment
@@ -65,6 +111,11 @@ type Connection struct {
 	// only set when a connection object is created and is considered
 	// immutable afterwards.
 	Inbound bool
+	// Forwarded is set to true if the connection is forwarded by the host
+	// for a LAN device in gateway mode. The local endpoint is the LAN device.
+	// Forwarded is only set when a connection object is created and is
+	// considered immutable afterwards.
+	Forwarded bool
 	// IPProtocol is set to the transport protocol used by the connection.
 	// Is is considered immutable once a connection object has been
 	// created. IPProtocol is not set for connections that have been
@@ -78,6 +129,12 @@ type Connection struct {
 	// set for connections created from DNS requests. LocalPort is
 	// considered immutable once a connection object has been created.
 	LocalPort uint16
+	// Interface holds the name of the network interface the connection
+	// leaves or enters the host through. It is taken from the packet or
+	// looked up from the local IP. It is not set for connections created
+	// from DNS requests or if the interface is unknown. Interface is
+	// considered immutable once a connection object has been created.
+	Interface string
 	// Entity describes the remote entity that the connection has been
 	// established to. The entity might be changed or information might
 	// be added to it during the livetime of a connection. Access to
@@ -114,20 +171,53 @@ type Connection struct {
 	Tunneled bool
 	// Encrypted is currently unused and MUST be ignored.
 	Encrypted bool
+	// TLSContext holds information about the TLS handshake of the
+	// connection. It is set by the TLS inspector once the ClientHello
+	// has been seen and is nil otherwise. Access to TLSContext must be
+	// guarded by the connection lock.
+	TLSContext *TLSContext
+	// HTTPContext holds information about the first plain-text HTTP
+	// request of the connection. It is set by the HTTP inspector and is
+	// nil otherwise. Access to HTTPContext must be guarded by the
+	// connection lock.
+	HTTPContext *HTTPContext
+	// DNSPayloadContext holds information about a DNS query that was sent
+	// to a port other than 53. It is set by the DNS payload inspector and
+	// is nil otherwise. Access to DNSPayloadContext must be guarded by the
+	// connection lock.
+	DNSPayloadContext *DNSPayloadContext
 	// ProcessContext holds additional information about the process
 	// that iniated the connection. It is set once when the connection
 	// object is created and is considered immutable afterwards.
 	ProcessContext ProcessContext
+	// Traffic holds the traffic counters of the connection. Packets are
+	// counted while they are handled by the Portmaster. Traffic of
+	// connections with a permanent verdict is updated periodically from the
+	// connection tracking of the system, if supported. Access to Traffic
+	// must be guarded by the connection lock.
+	Traffic TrafficStats
 	// Internal is set to true if the connection is attributed as an
 	// Portmaster internal connection. Internal may be set at different
 	// points and access to it must be guarded by the connection lock.
 	Internal bool
+	// Shadow is set to true on detached copies of a connection that are used
+	// to evaluate staged profile changes. Deciders must not have side
+	// effects, like notifications, for shadow connections. Shadow is only
+	// set when a connection object is created and is considered immutable
+	// afterwards.
+	Shadow bool
 	// process holds a reference to the actor process. That is, the
 	// process instance that initated the connection.
 	process *process.Process
 	// pkgQueue is used to serialize packet handling for a single
 	// connection and is served by the connections packetHandler.
 	pktQueue chan packet.Packet
+	// pktQueueActive is set while the packetHandler serves pktQueue.
+	// It is guarded by pktQueueLock instead of the connection lock, so
+	// that packets can be queued while the firewall handler holds the
+	// connection lock.
+	pktQueueActive bool
+	pktQueueLock   sync.Mutex
 	// firewallHandler is the firewall handler that is called for
 	// each packet sent to pktQueue.
 	firewallHandler FirewallHandler
@@ -137,12 +227,15 @@ type Connection struct {
 	saveWhenFinished bool
 	// activeInspectors is a slice of booleans where each entry
 	// maps to the index of an available inspector. If the value
-	// is true the inspector is currently active. False indicates
-	// that the inspector has finished and should be skipped.
+	// is true the inspector has finished and should be skipped.
+	// False indicates that the inspector is currently active.
 	activeInspectors []bool
 	// inspectorData holds additional meta data for the inspectors.
 	// using the inspectors index as a map key.
 	inspectorData map[uint8]interface{}
+	// inspectorStream holds the state of the TCP stream reassembly
+	// that is shared by all inspectors.
+	inspectorStream interface{}
 	// ProfileRevisionCounter is used to track changes to the process
 	// profile and required for correct re-evaluation of a connections
 	// verdict.
@@ -225,7 +318,16 @@ func NewConnectionFromDNSRequest(ctx context.Context, fqdn string, cnames []stri
 // NewConnectionFromFirstPacket returns a new connection based on the given packet.
 func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 	// get Process
-	proc, inbound, err := process.GetProcessByConnection(pkt.Ctx(), pkt.Info())
+	var proc *process.Process
+	var inbound bool
+	var err error
+	if pkt.Info().Forwarded {
+		// Attribute forwarded connections to the LAN device.
+		inbound = pkt.Info().Inbound
+		proc, err = process.GetDeviceProcess(pkt.Ctx(), pkt.Info().LocalIP())
+	} else {
+		proc, inbound, err = process.GetProcessByConnection(pkt.Ctx(), pkt.Info())
+	}
 	if err != nil {
 		log.Tracer(pkt.Ctx()).Debugf("network: failed to find process of packet %s: %s", pkt, err)
 		proc = process.GetUnidentifiedProcess(pkt.Ctx())
@@ -306,15 +408,25 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 		}
 	}
 
+	// Get the interface from the packet, fall back to the interface of the
+	// local IP, which is the interface the host routes the connection through.
+	iface := netenv.GetInterfaceName(pkt.Info().InterfaceIndex)
+	if iface == "" && !pkt.Info().Forwarded {
+		iface = netenv.GetInterfaceOfIP(pkt.Info().LocalIP())
+	}
+	entity.Interface = iface
+
 	return &Connection{
 		ID:        pkt.GetConnectionID(),
 		Scope:     scope,
 		IPVersion: pkt.Info().Version,
 		Inbound:   inbound,
+		Forwarded: pkt.Info().Forwarded,
 		// local endpoint
 		IPProtocol:     pkt.Info().Protocol,
 		LocalIP:        pkt.Info().LocalIP(),
 		LocalPort:      pkt.Info().LocalPort(),
+		Interface:      iface,
 		ProcessContext: getProcessContext(pkt.Ctx(), proc),
 		process:        proc,
 		// remote endpoint
@@ -330,6 +442,43 @@ func GetConnection(id string) (*Connection, bool) {
 	return conns.get(id)
 }
 
+// GetAllConnections returns all connections that were created from packets.
+func GetAllConnections() []*Connection {
+	all := conns.clone()
+
+	list := make([]*Connection, 0, len(all))
+	for _, conn := range all {
+		list = append(list, conn)
+	}
+	return list
+}
+
+// PacketInfo returns the packet info of the first packet of the connection.
+// It is only valid for connections created from packets. The connection must
+// be locked.
+func (conn *Connection) PacketInfo() *packet.Info {
+	info := &packet.Info{
+		Inbound:   conn.Inbound,
+		Forwarded: conn.Forwarded,
+		Version:   conn.IPVersion,
+		Protocol:  conn.IPProtocol,
+	}
+
+	if conn.Inbound {
+		info.Src = conn.Entity.IP
+		info.SrcPort = conn.Entity.Port
+		info.Dst = conn.LocalIP
+		info.DstPort = conn.LocalPort
+	} else {
+		info.Src = conn.LocalIP
+		info.SrcPort = conn.LocalPort
+		info.Dst = conn.Entity.IP
+		info.DstPort = conn.Entity.Port
+	}
+
+	return info
+}
+
 // AcceptWithContext accepts the connection.
 func (conn *Connection) AcceptWithContext(reason, reasonOptionKey string, ctx interface{}) {
 	if !conn.SetVerdict(VerdictAccept, reason, reasonOptionKey, ctx) {
@@ -407,6 +556,46 @@ func (conn *Connection) SetVerdict(newVerdict Verdict, reason, reasonOptionKey s
 	return false
 }
 
+// ShadowCopy returns a detached copy of the connection that is attributed to
+// the given layered profile. It is used to evaluate staged profile changes
+// and carries a fresh entity, so that list lookups do not interfere with the
+// live connection. The inspection results are shared with the live
+// connection. The copy is marked as Shadow and is never saved. The
+// connection must be locked.
+func (conn *Connection) ShadowCopy(layeredProfile *profile.LayeredProfile) *Connection {
+	entity := &intel.Entity{
+		Protocol:        conn.Entity.Protocol,
+		Port:            conn.Entity.Port,
+		Domain:          conn.Entity.Domain,
+		CNAME:           conn.Entity.CNAME,
+		IP:              conn.Entity.IP,
+		Interface:       conn.Entity.Interface,
+		NetworkLocation: conn.Entity.NetworkLocation,
+	}
+	entity.SetDstPort(conn.Entity.DstPort())
+
+	return &Connection{
+		ID:                     conn.ID,
+		Scope:                  conn.Scope,
+		IPVersion:              conn.IPVersion,
+		Inbound:                conn.Inbound,
+		Forwarded:              conn.Forwarded,
+		IPProtocol:             conn.IPProtocol,
+		LocalIP:                conn.LocalIP,
+		LocalPort:              conn.LocalPort,
+		Interface:              conn.Interface,
+		Entity:                 entity,
+		Started:                conn.Started,
+		TLSContext:             conn.TLSContext,
+		HTTPContext:            conn.HTTPContext,
+		DNSPayloadContext:      conn.DNSPayloadContext,
+		ProcessContext:         conn.ProcessContext,
+		Shadow:                 true,
+		process:                conn.process.ShadowCopy(layeredProfile),
+		ProfileRevisionCounter: conn.ProfileRevisionCounter,
+	}
+}
+
 // Process returns the connection's process.
 func (conn *Connection) Process() *process.Process {
 	return conn.process
@@ -463,67 +652,123 @@ func (conn *Connection) delete() {
 // worker to handle the packets.
 func (conn *Connection) SetFirewallHandler(handler FirewallHandler) {
 	if conn.firewallHandler == nil {
-		conn.pktQueue = make(chan packet.Packet, 1000)
+		queue := make(chan packet.Packet, 1000)
+
+		conn.pktQueueLock.Lock()
+		conn.pktQueue = queue
+		conn.pktQueueActive = true
+		conn.pktQueueLock.Unlock()
 
 		// start handling
 		module.StartWorker("packet handler", func(ctx context.Context) error {
-			conn.packetHandler()
+			conn.packetHandler(queue)
 			return nil
 		})
 	}
 	conn.firewallHandler = handler
 }
 
-// StopFirewallHandler unsets the firewall handler and stops the handler worker.
+// StopFirewallHandler unsets the firewall handler and stops the handler worker
+// after it handled the packets that are already queued.
 func (conn *Connection) StopFirewallHandler() {
 	conn.firewallHandler = nil
-	conn.pktQueue <- nil
+
+	conn.pktQueueLock.Lock()
+	defer conn.pktQueueLock.Unlock()
+	conn.pktQueueActive = false
 }
 
 // HandlePacket queues packet of Link for handling
 func (conn *Connection) HandlePacket(pkt packet.Packet) {
+	// Queue the packet without waiting for the connection lock, as the
+	// firewall handler holds it while deciding on the connection, which may
+	// include waiting for the user to answer a prompt.
+	if conn.queuePacket(pkt) {
+		return
+	}
+
 	conn.Lock()
 	defer conn.Unlock()
 
-	// execute handler or verdict
-	if conn.firewallHandler != nil {
-		conn.pktQueue <- pkt
-		// TODO: drop if overflowing?
-	} else {
-		defaultFirewallHandler(conn, pkt)
+	// Check again, as the firewall handler may have been set in the meantime.
+	if conn.queuePacket(pkt) {
+		return
 	}
+
+	conn.countPacket(pkt)
+	defaultFirewallHandler(conn, pkt)
+}
+
+// queuePacket queues the packet for the firewall handler, if it is active.
+// Packets that overflow the queue are dropped.
+func (conn *Connection) queuePacket(pkt packet.Packet) (queued bool) {
+	conn.pktQueueLock.Lock()
+	defer conn.pktQueueLock.Unlock()
+
+	if !conn.pktQueueActive {
+		return false
+	}
+
+	select {
+	case conn.pktQueue <- pkt:
+	default:
+		log.Tracer(pkt.Ctx()).Warningf("network: dropping packet %s, as the packet queue of connection %s is full", pkt, conn.ID)
+		_ = pkt.Drop()
+	}
+	return true
 }
 
 // packetHandler sequentially handles queued packets
-func (conn *Connection) packetHandler() {
-	for pkt := range conn.pktQueue {
-		if pkt == nil {
-			return
+func (conn *Connection) packetHandler(queue chan packet.Packet) {
+	for pkt := range queue {
+		if stopped := conn.handleQueuedPacket(pkt); !stopped {
+			continue
 		}
-		// get handler
-		conn.Lock()
 
-		// execute handler or verdict
-		if conn.firewallHandler != nil {
-			conn.firewallHandler(conn, pkt)
-		} else {
-			defaultFirewallHandler(conn, pkt)
-		}
-		// log verdict
-		log.Tracer(pkt.Ctx()).Infof("filter: connection %s %s: %s", conn, conn.Verdict.Verb(), conn.Reason.Msg)
-
-		// save does not touch any changing data
-		// must not be locked, will deadlock with cleaner functions
-		if conn.saveWhenFinished {
-			conn.saveWhenFinished = false
-			conn.Save()
+		// No new packets are queued after the firewall handler was
+		// stopped, handle the remaining ones and exit.
+		for {
+			select {
+			case pkt := <-queue:
+				conn.handleQueuedPacket(pkt)
+			default:
+				return
+			}
 		}
+	}
+}
+
+// handleQueuedPacket handles a queued packet and returns whether the firewall
+// handler is stopped.
+func (conn *Connection) handleQueuedPacket(pkt packet.Packet) (stopped bool) {
+	// get handler
+	conn.Lock()
 
-		conn.Unlock()
+	conn.countPacket(pkt)
 
-		// submit trace logs
-		log.Tracer(pkt.Ctx()).Submit()
+	// execute handler or verdict
+	if conn.firewallHandler != nil {
+		conn.firewallHandler(conn, pkt)
+	} else {
+		defaultFirewallHandler(conn, pkt)
 	}
+	// log verdict
+	log.Tracer(pkt.Ctx()).Infof("filter: connection %s %s: %s", conn, conn.Verdict.Verb(), conn.Reason.Msg)
+
+	// save does not touch any changing data
+	// must not be locked, will deadlock with cleaner functions
+	if conn.saveWhenFinished {
+		conn.saveWhenFinished = false
+		conn.Save()
+	}
+
+	stopped = conn.firewallHandler == nil
+	conn.Unlock()
+
+	// submit trace logs
+	log.Tracer(pkt.Ctx()).Submit()
+
+	return stopped
 }
 
 // GetActiveInspectors returns the list of active inspectors.
@@ -546,6 +791,16 @@ func (conn *Connection) SetInspectorData(new map[uint8]interface{}) {
 	conn.inspectorData = new
 }
 
+// GetInspectorStream returns the shared stream state of the inspectors.
+func (conn *Connection) GetInspectorStream() interface{} {
+	return conn.inspectorStream
+}
+
+// SetInspectorStream sets the shared stream state of the inspectors.
+func (conn *Connection) SetInspectorStream(new interface{}) {
+	conn.inspectorStream = new
+}
+
 // String returns a string representation of conn.
 func (conn *Connection) String() string {
 	switch conn.Scope {
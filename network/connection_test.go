@@ -0,0 +1,134 @@
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/process"
+)
+
+func TestShadowCopy(t *testing.T) {
+	conn := &Connection{
+		ID:        "6-192.168.1.2-51234-1.1.1.1-443",
+		Scope:     PeerInternet,
+		Inbound:   false,
+		Forwarded: true,
+		LocalIP:   net.IPv4(192, 168, 1, 2),
+		LocalPort: 51234,
+		Interface: "eth0",
+		Entity: &intel.Entity{
+			Domain:          "example.com.",
+			IP:              net.IPv4(1, 1, 1, 1),
+			Protocol:        6,
+			Port:            443,
+			NetworkLocation: "Office",
+		},
+		TLSContext:        &TLSContext{SNI: "example.com"},
+		HTTPContext:       &HTTPContext{Host: "example.com", Path: "/"},
+		DNSPayloadContext: &DNSPayloadContext{Name: "example.com.", Type: 1},
+		Verdict:           VerdictBlock,
+		process:           &process.Process{Pid: 1234},
+	}
+
+	shadowConn := conn.ShadowCopy(nil)
+
+	if !shadowConn.Shadow || conn.Shadow {
+		t.Error("only the copy must be marked as shadow")
+	}
+	if shadowConn.Verdict != VerdictUndecided {
+		t.Errorf("copy must not carry the live verdict, got %s", shadowConn.Verdict.Verb())
+	}
+	if shadowConn.Forwarded != conn.Forwarded ||
+		shadowConn.Interface != conn.Interface ||
+		shadowConn.TLSContext != conn.TLSContext ||
+		shadowConn.HTTPContext != conn.HTTPContext ||
+		shadowConn.DNSPayloadContext != conn.DNSPayloadContext {
+		t.Errorf("copy lost connection information: %+v", shadowConn)
+	}
+	if shadowConn.Entity == conn.Entity {
+		t.Fatal("copy must have its own entity")
+	}
+	if shadowConn.Entity.Domain != conn.Entity.Domain ||
+		!shadowConn.Entity.IP.Equal(conn.Entity.IP) ||
+		shadowConn.Entity.Port != conn.Entity.Port ||
+		shadowConn.Entity.NetworkLocation != conn.Entity.NetworkLocation {
+		t.Errorf("copy lost entity information: %+v", shadowConn.Entity)
+	}
+	if shadowConn.Process() == conn.Process() || shadowConn.Process().Pid != conn.Process().Pid {
+		t.Error("copy must have its own process with the same PID")
+	}
+}
+
+func TestPacketQueue(t *testing.T) {
+	previousHandler := defaultFirewallHandler
+	defer func() {
+		defaultFirewallHandler = previousHandler
+	}()
+	defaultFirewallHandler = func(conn *Connection, pkt packet.Packet) {
+		pkt.(*testPacket).handled = true
+	}
+
+	conn := &Connection{
+		ID:     "6-192.168.1.2-51234-1.1.1.1-443",
+		Entity: &intel.Entity{},
+	}
+	newPacket := func() *testPacket {
+		pkt := newTestPacket(false, nil)
+		pkt.SetCtx(context.Background())
+		return pkt
+	}
+
+	// Without a firewall handler, packets are handled directly.
+	direct := newPacket()
+	conn.HandlePacket(direct)
+	if !direct.handled {
+		t.Error("packet must be handled directly without a firewall handler")
+	}
+
+	// With a firewall handler, packets are queued without taking the
+	// connection lock, which the firewall handler holds while deciding.
+	queue := make(chan packet.Packet, 1)
+	conn.pktQueue = queue
+	conn.pktQueueActive = true
+	conn.firewallHandler = func(conn *Connection, pkt packet.Packet) {
+		pkt.(*testPacket).handled = true
+		conn.StopFirewallHandler()
+	}
+
+	queued := newPacket()
+	overflowing := newPacket()
+	conn.Lock()
+	conn.HandlePacket(queued)
+	conn.HandlePacket(overflowing)
+	conn.Unlock()
+	if queued.handled || queued.dropped {
+		t.Error("packet must be queued")
+	}
+	if !overflowing.dropped {
+		t.Error("packet overflowing the queue must be dropped")
+	}
+
+	// The packet handler exits after the firewall handler was stopped.
+	done := make(chan struct{})
+	go func() {
+		conn.packetHandler(queue)
+		close(done)
+	}()
+	<-done
+	if !queued.handled {
+		t.Error("queued packet must be handled")
+	}
+	if conn.pktQueueActive {
+		t.Error("packet queue must be inactive after stopping the firewall handler")
+	}
+
+	// Afterwards, packets are handled directly again.
+	after := newPacket()
+	conn.HandlePacket(after)
+	if !after.handled {
+		t.Error("packet must be handled directly after stopping the firewall handler")
+	}
+}
@@ -0,0 +1,109 @@
+// +build linux
+
+package ebpf
+
+import (
+	"encoding/binary"
+)
+
+// instruction is a single eBPF instruction.
+// See linux/bpf.h and Documentation/networking/filter.rst.
+type instruction struct {
+	code uint8
+	dst  uint8
+	src  uint8
+	off  int16
+	imm  int32
+}
+
+// Registers.
+const (
+	r0 uint8 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10 // read-only frame pointer
+)
+
+// Helper functions.
+const (
+	fnMapUpdateElem     = 2
+	fnKtimeGetNS        = 5
+	fnGetCurrentPidTgid = 14
+	fnGetCurrentUIDGID  = 15
+)
+
+// bpfPseudoMapFD marks the immediate of a 64 bit load as map file descriptor.
+const bpfPseudoMapFD = 1
+
+func movReg(dst, src uint8) instruction {
+	return instruction{code: 0xbf, dst: dst, src: src}
+}
+
+func movImm(dst uint8, imm int32) instruction {
+	return instruction{code: 0xb7, dst: dst, imm: imm}
+}
+
+func addImm(dst uint8, imm int32) instruction {
+	return instruction{code: 0x07, dst: dst, imm: imm}
+}
+
+func rshImm(dst uint8, imm int32) instruction {
+	return instruction{code: 0x77, dst: dst, imm: imm}
+}
+
+// loadMem32 loads 32 bits from src+off into dst.
+func loadMem32(dst, src uint8, off int16) instruction {
+	return instruction{code: 0x61, dst: dst, src: src, off: off}
+}
+
+// storeMem32 stores the lower 32 bits of src to dst+off.
+func storeMem32(dst, src uint8, off int16) instruction {
+	return instruction{code: 0x63, dst: dst, src: src, off: off}
+}
+
+// storeMem64 stores src to dst+off.
+func storeMem64(dst, src uint8, off int16) instruction {
+	return instruction{code: 0x7b, dst: dst, src: src, off: off}
+}
+
+// storeImm32 stores imm as 32 bits to dst+off.
+func storeImm32(dst uint8, off int16, imm int32) instruction {
+	return instruction{code: 0x62, dst: dst, off: off, imm: imm}
+}
+
+// loadMapFD loads the map with the given file descriptor into dst. It takes
+// two instructions.
+func loadMapFD(dst uint8, fd int) []instruction {
+	return []instruction{
+		{code: 0x18, dst: dst, src: bpfPseudoMapFD, imm: int32(fd)},
+		{},
+	}
+}
+
+func call(fn int32) instruction {
+	return instruction{code: 0x85, imm: fn}
+}
+
+func exit() instruction {
+	return instruction{code: 0x95}
+}
+
+// assemble encodes the instructions in the format expected by the kernel.
+func assemble(insns []instruction) []byte {
+	code := make([]byte, 8*len(insns))
+	for i, insn := range insns {
+		b := code[i*8:]
+		b[0] = insn.code
+		b[1] = insn.dst&0xf | insn.src<<4
+		binary.LittleEndian.PutUint16(b[2:4], uint16(insn.off))
+		binary.LittleEndian.PutUint32(b[4:8], uint32(insn.imm))
+	}
+	return code
+}
@@ -0,0 +1,32 @@
+// +build linux
+
+package ebpf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	insns := []instruction{
+		movReg(r6, r1),
+		rshImm(r0, 32),
+		storeMem32(r10, r0, -16),
+		addImm(r2, -40),
+	}
+	insns = append(insns, loadMapFD(r1, 5)...)
+	insns = append(insns, exit())
+
+	expected := []byte{
+		0xbf, 0x16, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // r6 = r1
+		0x77, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, // r0 >>= 32
+		0x63, 0x0a, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, // *(u32 *)(r10 - 16) = r0
+		0x07, 0x02, 0x00, 0x00, 0xd8, 0xff, 0xff, 0xff, // r2 += -40
+		0x18, 0x11, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, // r1 = map fd 5
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x95, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // exit
+	}
+	if code := assemble(insns); !bytes.Equal(code, expected) {
+		t.Errorf("unexpected code:\n%x\nexpected:\n%x", code, expected)
+	}
+}
@@ -0,0 +1,437 @@
+// +build linux
+
+// Package ebpf attributes sockets to processes using eBPF programs attached
+// to the root cgroup. It requires cgroup v2.
+package ebpf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/network/socket"
+)
+
+// The attribution programs are attached to the root cgroup and record the
+// process of every socket that connects, sends a datagram to an address or
+// binds. Connects and sends are recorded by the remote address, binds by the
+// local address. The records are kept in LRU maps that are read by Lookup.
+
+const (
+	// keySize is the size of a map key:
+	// struct { u32 protocol; u32 port; u32 ip[4]; }
+	// The port and IP are in network byte order.
+	keySize = 24
+	// valueSize is the size of a map value:
+	// struct { u32 pid; u32 uid; u64 monotonic_ns; }
+	valueSize = 16
+
+	maxEntries = 16384
+
+	// maxConnectRecordAge defines how long a connect record is used to
+	// attribute connections. As connect records are keyed by the remote
+	// address only, older records might belong to another process that
+	// contacted the same remote address in the meantime.
+	maxConnectRecordAge = 10 * time.Second
+)
+
+// Offsets of the fields of struct bpf_sock_addr.
+// See linux/bpf.h.
+const (
+	ctxUserIP4  = 4
+	ctxUserIP6  = 8
+	ctxUserPort = 24
+	ctxProtocol = 36
+)
+
+// programSpecs lists the attribution programs.
+var programSpecs = []struct {
+	name       string
+	attachType uint32
+	bind       bool
+	v6         bool
+}{
+	{"pm_connect4", bpfCgroupInet4Connect, false, false},
+	{"pm_connect6", bpfCgroupInet6Connect, false, true},
+	{"pm_sendmsg4", bpfCgroupUDP4Sendmsg, false, false},
+	{"pm_sendmsg6", bpfCgroupUDP6Sendmsg, false, true},
+	{"pm_bind4", bpfCgroupInet4Bind, true, false},
+	{"pm_bind6", bpfCgroupInet6Bind, true, true},
+}
+
+type attachedProgram struct {
+	fd         int
+	attachType uint32
+}
+
+var (
+	connectMapFD = -1
+	bindMapFD    = -1
+	cgroupFD     = -1
+	programs     []*attachedProgram
+	active       bool
+	lock         sync.RWMutex
+)
+
+// Start loads the attribution programs and attaches them to the root cgroup.
+// It fails if eBPF or cgroup v2 are not available.
+func Start() (err error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if active {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	cgroupPath, err := findCgroup2Mount()
+	if err != nil {
+		return err
+	}
+	cgroupFD, err = unix.Open(cgroupPath, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup %s: %w", cgroupPath, err)
+	}
+
+	connectMapFD, err = createMap(keySize, valueSize, maxEntries)
+	if err != nil {
+		return err
+	}
+	bindMapFD, err = createMap(keySize, valueSize, maxEntries)
+	if err != nil {
+		return err
+	}
+
+	for _, prog := range programSpecs {
+		mapFD := connectMapFD
+		if prog.bind {
+			mapFD = bindMapFD
+		}
+		fd, err := loadProgram(prog.name, recordProgram(mapFD, prog.v6), prog.attachType)
+		if err != nil {
+			return err
+		}
+		if err := attachProgram(cgroupFD, fd, prog.attachType); err != nil {
+			_ = unix.Close(fd)
+			return fmt.Errorf("failed to attach program %s: %w", prog.name, err)
+		}
+		programs = append(programs, &attachedProgram{
+			fd:         fd,
+			attachType: prog.attachType,
+		})
+	}
+
+	active = true
+	log.Infof("ebpf: attached socket attribution programs to cgroup %s", cgroupPath)
+	return nil
+}
+
+// Stop detaches the attribution programs.
+func Stop() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !active {
+		return nil
+	}
+	active = false
+	return cleanup()
+}
+
+// DetachAll detaches attribution programs that are still attached to the
+// root cgroup. Attached programs are not detached when their process exits,
+// so they remain after an unclean shutdown. It must not be called while the
+// attribution is started.
+func DetachAll() error {
+	cgroupPath, err := findCgroup2Mount()
+	if err != nil {
+		return err
+	}
+	cgroup, err := unix.Open(cgroupPath, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup %s: %w", cgroupPath, err)
+	}
+	defer unix.Close(cgroup) //nolint:errcheck
+
+	var lastErr error
+	for _, prog := range programSpecs {
+		ids, err := queryPrograms(cgroup, prog.attachType)
+		if err != nil {
+			return fmt.Errorf("failed to query programs of cgroup %s: %w", cgroupPath, err)
+		}
+
+		for _, id := range ids {
+			if err := detachProgramByID(cgroup, id, prog.name, prog.attachType); err != nil {
+				lastErr = err
+			}
+		}
+	}
+
+	return lastErr
+}
+
+// detachProgramByID detaches the program with the given ID from the cgroup,
+// if it has the given name.
+func detachProgramByID(cgroup int, id uint32, name string, attachType uint32) error {
+	fd, err := openProgram(id)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			// The program was detached in the meantime.
+			return nil
+		}
+		return fmt.Errorf("failed to open program %d: %w", id, err)
+	}
+	defer unix.Close(fd) //nolint:errcheck
+
+	progName, err := programName(fd)
+	if err != nil {
+		return fmt.Errorf("failed to get name of program %d: %w", id, err)
+	}
+	if progName != name {
+		return nil
+	}
+
+	if err := detachProgram(cgroup, fd, attachType); err != nil {
+		return fmt.Errorf("failed to detach program %s: %w", name, err)
+	}
+	return nil
+}
+
+// cleanup detaches all programs and closes all file descriptors. The lock
+// must be held.
+func cleanup() error {
+	var lastErr error
+	for _, prog := range programs {
+		if err := detachProgram(cgroupFD, prog.fd, prog.attachType); err != nil {
+			lastErr = fmt.Errorf("failed to detach program: %w", err)
+		}
+		_ = unix.Close(prog.fd)
+	}
+	programs = nil
+
+	for _, fd := range []*int{&connectMapFD, &bindMapFD, &cgroupFD} {
+		if *fd >= 0 {
+			_ = unix.Close(*fd)
+			*fd = -1
+		}
+	}
+
+	return lastErr
+}
+
+// recordProgram returns a program that records the current process in the
+// given map, keyed by the protocol and the address of the socket call.
+func recordProgram(mapFD int, v6 bool) []instruction {
+	insns := []instruction{
+		// Save the context.
+		movReg(r6, r1),
+
+		// value.pid = bpf_get_current_pid_tgid() >> 32
+		call(fnGetCurrentPidTgid),
+		rshImm(r0, 32),
+		storeMem32(r10, r0, -16),
+		// value.uid = (u32) bpf_get_current_uid_gid()
+		call(fnGetCurrentUIDGID),
+		storeMem32(r10, r0, -12),
+		// value.monotonic_ns = bpf_ktime_get_ns()
+		call(fnKtimeGetNS),
+		storeMem64(r10, r0, -8),
+
+		// key.protocol = ctx->protocol
+		loadMem32(r1, r6, ctxProtocol),
+		storeMem32(r10, r1, -40),
+		// key.port = ctx->user_port
+		loadMem32(r1, r6, ctxUserPort),
+		storeMem32(r10, r1, -36),
+	}
+
+	// key.ip = ctx->user_ip4 or ctx->user_ip6
+	if v6 {
+		for i := int16(0); i < 4; i++ {
+			insns = append(insns,
+				loadMem32(r1, r6, ctxUserIP6+4*i),
+				storeMem32(r10, r1, -32+4*i),
+			)
+		}
+	} else {
+		insns = append(insns,
+			loadMem32(r1, r6, ctxUserIP4),
+			storeMem32(r10, r1, -32),
+			storeImm32(r10, -28, 0),
+			storeImm32(r10, -24, 0),
+			storeImm32(r10, -20, 0),
+		)
+	}
+
+	// bpf_map_update_elem(map, &key, &value, BPF_ANY)
+	insns = append(insns, loadMapFD(r1, mapFD)...)
+	return append(insns,
+		movReg(r2, r10),
+		addImm(r2, -40),
+		movReg(r3, r10),
+		addImm(r3, -16),
+		movImm(r4, 0),
+		call(fnMapUpdateElem),
+
+		// Always permit the socket call.
+		movImm(r0, 1),
+		exit(),
+	)
+}
+
+// findCgroup2Mount returns the mount point of the cgroup v2 hierarchy.
+func findCgroup2Mount() (string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[2] == "cgroup2" {
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// Lookup returns the PID of the process that the connection of the given
+// packet belongs to, and whether the connection is inbound. It returns false
+// if the connection was not recorded.
+func Lookup(pktInfo *packet.Info) (pid int, inbound bool, ok bool) {
+	if pktInfo.Protocol != packet.TCP && pktInfo.Protocol != packet.UDP {
+		return socket.UnidentifiedProcessID, pktInfo.Inbound, false
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	if !active {
+		return socket.UnidentifiedProcessID, pktInfo.Inbound, false
+	}
+
+	// Check if a local socket connected or sent to the remote address, which
+	// makes the connection outbound.
+	if pktInfo.RemotePort() != 0 {
+		for _, key := range lookupKeys(pktInfo.Protocol, pktInfo.RemoteIP(), pktInfo.RemotePort(), false) {
+			pid, uid, monotonicNS, found := lookupRecord(connectMapFD, key)
+			if found &&
+				time.Duration(monotonicNow()-monotonicNS) < maxConnectRecordAge &&
+				processMatches(pid, uid) {
+				return pid, false, true
+			}
+		}
+	}
+
+	// Check if a local socket is bound to the local address.
+	for _, key := range lookupKeys(pktInfo.Protocol, pktInfo.LocalIP(), pktInfo.LocalPort(), true) {
+		pid, uid, _, found := lookupRecord(bindMapFD, key)
+		if found && processMatches(pid, uid) {
+			return pid, pktInfo.Inbound, true
+		}
+	}
+
+	return socket.UnidentifiedProcessID, pktInfo.Inbound, false
+}
+
+// lookupKeys returns the keys to look up for the given address, including the
+// IPv4-mapped form used by dual-stack sockets and, if withAny is set, the
+// unspecified addresses used by sockets bound to all addresses.
+func lookupKeys(protocol packet.IPProtocol, ip net.IP, port uint16, withAny bool) [][]byte {
+	ips := []net.IP{ip}
+	if ip4 := ip.To4(); ip4 != nil {
+		ips = []net.IP{ip4, ip4.To16()}
+		if withAny {
+			ips = append(ips, net.IPv4zero.To4(), net.IPv6unspecified)
+		}
+	} else if withAny {
+		ips = append(ips, net.IPv6unspecified)
+	}
+
+	keys := make([][]byte, 0, len(ips))
+	for _, ip := range ips {
+		key := make([]byte, keySize)
+		binary.LittleEndian.PutUint32(key[0:4], uint32(protocol))
+		binary.BigEndian.PutUint16(key[4:6], port)
+		copy(key[8:], ip)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func lookupRecord(mapFD int, key []byte) (pid, uid int, monotonicNS int64, found bool) {
+	value := make([]byte, valueSize)
+	found, err := lookupMap(mapFD, key, value)
+	if err != nil {
+		log.Warningf("ebpf: failed to look up socket record: %s", err)
+		return socket.UnidentifiedProcessID, 0, 0, false
+	}
+	if !found {
+		return socket.UnidentifiedProcessID, 0, 0, false
+	}
+
+	pid, uid, monotonicNS = parseRecord(value)
+	return pid, uid, monotonicNS, true
+}
+
+// parseRecord parses a map value.
+func parseRecord(value []byte) (pid, uid int, monotonicNS int64) {
+	return int(binary.LittleEndian.Uint32(value[0:4])),
+		int(binary.LittleEndian.Uint32(value[4:8])),
+		int64(binary.LittleEndian.Uint64(value[8:16]))
+}
+
+// processMatches checks if the process with the given PID still runs as the
+// recorded user. If it does not, the PID was reused by another process and
+// the record must not be used. Records of processes that already exited are
+// kept, as they still identify the process in the process cache.
+func processMatches(pid, uid int) bool {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+
+	processUID, ok := parseStatusUID(status)
+	return ok && processUID == uid
+}
+
+// parseStatusUID returns the real UID from the contents of /proc/<pid>/status.
+func parseStatusUID(status []byte) (uid int, ok bool) {
+	for _, line := range strings.Split(string(status), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "Uid:" {
+			uid, err := strconv.Atoi(fields[1])
+			return uid, err == nil
+		}
+	}
+	return 0, false
+}
+
+func monotonicNow() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return ts.Nano()
+}
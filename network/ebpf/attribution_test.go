@@ -0,0 +1,69 @@
+// +build linux
+
+package ebpf
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/safing/portmaster/network/packet"
+)
+
+func TestLookupKeys(t *testing.T) {
+	// IPv4 addresses are also looked up in their IPv4-mapped form.
+	keys := lookupKeys(packet.TCP, net.IPv4(192, 168, 1, 2), 443, false)
+	expected := [][]byte{
+		{6, 0, 0, 0, 0x01, 0xbb, 0, 0, 192, 168, 1, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{6, 0, 0, 0, 0x01, 0xbb, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 192, 168, 1, 2},
+	}
+	if len(keys) != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(keys))
+	}
+	for i, key := range keys {
+		if !bytes.Equal(key, expected[i]) {
+			t.Errorf("unexpected key %d: %x, expected %x", i, key, expected[i])
+		}
+	}
+
+	// Sockets bound to all addresses are matched by the unspecified addresses.
+	keys = lookupKeys(packet.UDP, net.IPv4(192, 168, 1, 2), 53, true)
+	if len(keys) != 4 {
+		t.Fatalf("expected 4 keys, got %d", len(keys))
+	}
+	for _, key := range keys[2:] {
+		if key[0] != 17 || !bytes.Equal(key[8:], make([]byte, 16)) {
+			t.Errorf("unexpected key for unspecified address: %x", key)
+		}
+	}
+
+	keys = lookupKeys(packet.TCP, net.ParseIP("2001:db8::1"), 80, true)
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if !bytes.Equal(keys[0][8:], net.ParseIP("2001:db8::1")) {
+		t.Errorf("unexpected key: %x", keys[0])
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	pid, uid, monotonicNS := parseRecord([]byte{
+		0x39, 0x30, 0, 0, // pid
+		0xe8, 0x03, 0, 0, // uid
+		0x00, 0xca, 0x9a, 0x3b, 0, 0, 0, 0, // monotonic_ns
+	})
+	if pid != 12345 || uid != 1000 || monotonicNS != 1000000000 {
+		t.Errorf("unexpected record: pid=%d uid=%d monotonic_ns=%d", pid, uid, monotonicNS)
+	}
+}
+
+func TestParseStatusUID(t *testing.T) {
+	uid, ok := parseStatusUID([]byte("Name:\tcurl\nPid:\t1234\nUid:\t1000\t0\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\n"))
+	if !ok || uid != 1000 {
+		t.Errorf("unexpected uid %d (ok=%v)", uid, ok)
+	}
+
+	if _, ok := parseStatusUID([]byte("Name:\tcurl\n")); ok {
+		t.Error("status without uid must not be parsed")
+	}
+}
@@ -0,0 +1,256 @@
+// +build linux
+
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpf(2) commands.
+const (
+	bpfMapCreate     = 0
+	bpfMapLookupElem = 1
+	bpfProgLoad      = 5
+	bpfProgAttach    = 8
+	bpfProgDetach    = 9
+	bpfProgGetFDByID = 13
+	bpfObjGetInfo    = 15
+	bpfProgQuery     = 16
+)
+
+// Map and program types, attach types and flags.
+// See linux/bpf.h.
+const (
+	bpfMapTypeLRUHash = 9
+
+	bpfProgTypeCgroupSockAddr = 18
+
+	bpfCgroupInet4Bind    = 8
+	bpfCgroupInet6Bind    = 9
+	bpfCgroupInet4Connect = 10
+	bpfCgroupInet6Connect = 11
+	bpfCgroupUDP4Sendmsg  = 14
+	bpfCgroupUDP6Sendmsg  = 15
+
+	bpfFAllowMulti = 2
+)
+
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+type mapElemAttr struct {
+	mapFD uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+type progLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+type progAttachAttr struct {
+	targetFD    uint32
+	attachBPFFD uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+type progQueryAttr struct {
+	targetFD    uint32
+	attachType  uint32
+	queryFlags  uint32
+	attachFlags uint32
+	progIDs     uint64
+	progCnt     uint32
+	_           uint32
+}
+
+type progGetFDAttr struct {
+	progID    uint32
+	nextID    uint32
+	openFlags uint32
+}
+
+type objInfoAttr struct {
+	bpfFD   uint32
+	infoLen uint32
+	info    uint64
+}
+
+// progInfo is the beginning of struct bpf_prog_info, up to the name.
+type progInfo struct {
+	progType        uint32
+	id              uint32
+	tag             [8]byte
+	jitedProgLen    uint32
+	xlatedProgLen   uint32
+	jitedProgInsns  uint64
+	xlatedProgInsns uint64
+	loadTime        uint64
+	createdByUID    uint32
+	nrMapIDs        uint32
+	mapIDs          uint64
+	name            [16]byte
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (uintptr, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// createMap creates a LRU hash map and returns its file descriptor.
+func createMap(keySize, valueSize, maxEntries int) (int, error) {
+	attr := mapCreateAttr{
+		mapType:    bpfMapTypeLRUHash,
+		keySize:    uint32(keySize),
+		valueSize:  uint32(valueSize),
+		maxEntries: uint32(maxEntries),
+	}
+	fd, err := bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, fmt.Errorf("failed to create map: %w", err)
+	}
+	return int(fd), nil
+}
+
+// lookupMap looks up the given key in the map and writes the value to the
+// given buffer. It returns false if the key was not found.
+func lookupMap(mapFD int, key, value []byte) (bool, error) {
+	attr := mapElemAttr{
+		mapFD: uint32(mapFD),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+	}
+	_, err := bpf(bpfMapLookupElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+
+	switch err {
+	case nil:
+		return true, nil
+	case unix.ENOENT:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// loadProgram loads a cgroup socket address program with the given expected
+// attach type and returns its file descriptor.
+func loadProgram(name string, insns []instruction, attachType uint32) (int, error) {
+	code := assemble(insns)
+	license := []byte("GPL\x00")
+
+	attr := progLoadAttr{
+		progType:           bpfProgTypeCgroupSockAddr,
+		insnCnt:            uint32(len(insns)),
+		insns:              uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		expectedAttachType: attachType,
+	}
+	copy(attr.progName[:len(attr.progName)-1], name)
+
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return -1, fmt.Errorf("failed to load program %s: %w", name, err)
+	}
+	return int(fd), nil
+}
+
+// attachProgram attaches the program to the cgroup. Other programs attached
+// to the cgroup are kept.
+func attachProgram(cgroupFD, progFD int, attachType uint32) error {
+	attr := progAttachAttr{
+		targetFD:    uint32(cgroupFD),
+		attachBPFFD: uint32(progFD),
+		attachType:  attachType,
+		attachFlags: bpfFAllowMulti,
+	}
+	_, err := bpf(bpfProgAttach, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// detachProgram detaches the program from the cgroup. Attached programs are
+// not detached when their file descriptor is closed.
+func detachProgram(cgroupFD, progFD int, attachType uint32) error {
+	attr := progAttachAttr{
+		targetFD:    uint32(cgroupFD),
+		attachBPFFD: uint32(progFD),
+		attachType:  attachType,
+	}
+	_, err := bpf(bpfProgDetach, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// queryPrograms returns the IDs of the programs attached to the cgroup.
+func queryPrograms(cgroupFD int, attachType uint32) ([]uint32, error) {
+	ids := make([]uint32, 64)
+	attr := progQueryAttr{
+		targetFD:   uint32(cgroupFD),
+		attachType: attachType,
+		progIDs:    uint64(uintptr(unsafe.Pointer(&ids[0]))),
+		progCnt:    uint32(len(ids)),
+	}
+	_, err := bpf(bpfProgQuery, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(ids)
+	if err != nil {
+		return nil, err
+	}
+	return ids[:attr.progCnt], nil
+}
+
+// openProgram returns a file descriptor of the program with the given ID.
+func openProgram(id uint32) (int, error) {
+	attr := progGetFDAttr{
+		progID: id,
+	}
+	fd, err := bpf(bpfProgGetFDByID, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, err
+	}
+	return int(fd), nil
+}
+
+// programName returns the name of the program.
+func programName(progFD int) (string, error) {
+	var info progInfo
+	attr := objInfoAttr{
+		bpfFD:   uint32(progFD),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+	_, err := bpf(bpfObjGetInfo, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(&info)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(info.name[:], "\x00")), nil
+}
@@ -0,0 +1,34 @@
+package network
+
+import (
+	"github.com/safing/portmaster/metrics"
+)
+
+func init() {
+	metrics.NewGaugeFunc(
+		"portmaster_network_connections",
+		"Active connections by profile.",
+		[]string{"profile"},
+		collectConnectionsPerProfile,
+	)
+}
+
+func collectConnectionsPerProfile() []metrics.Sample {
+	perProfile := make(map[string]float64)
+	for _, conn := range GetAllConnections() {
+		conn.Lock()
+		if conn.Ended == 0 {
+			perProfile[conn.ProcessContext.Source+"/"+conn.ProcessContext.Profile]++
+		}
+		conn.Unlock()
+	}
+
+	samples := make([]metrics.Sample, 0, len(perProfile))
+	for scopedID, count := range perProfile {
+		samples = append(samples, metrics.Sample{
+			LabelValues: []string{scopedID},
+			Value:       count,
+		})
+	}
+	return samples
+}
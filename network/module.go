@@ -1,17 +1,24 @@
 package network
 
 import (
+	"errors"
+
+	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/network/state"
 )
 
 var (
 	module *modules.Module
 
 	defaultFirewallHandler FirewallHandler
+
+	forwardedConnectionChecker func(*packet.Info) bool
 )
 
 func init() {
-	module = modules.Register("network", nil, start, nil, "base", "processes")
+	module = modules.Register("network", nil, start, stop, "base", "processes")
 }
 
 // SetDefaultFirewallHandler sets the default firewall handler.
@@ -21,14 +28,39 @@ func SetDefaultFirewallHandler(handler FirewallHandler) {
 	}
 }
 
+// SetForwardedConnectionChecker sets the function that checks whether a
+// forwarded connection still exists, as forwarded connections do not show up
+// in the system network state.
+func SetForwardedConnectionChecker(checker func(*packet.Info) bool) {
+	if forwardedConnectionChecker == nil {
+		forwardedConnectionChecker = checker
+	}
+}
+
 func start() error {
 	err := registerAsDatabase()
 	if err != nil {
 		return err
 	}
 
+	registerTrafficAPI()
+
+	// Attribute sockets using eBPF if available, else fall back to the system
+	// state tables only.
+	err = state.StartEBPF()
+	switch {
+	case err == nil:
+	case errors.Is(err, state.ErrEBPFNotSupported):
+	default:
+		log.Warningf("network: eBPF socket attribution is unavailable, falling back to system state tables: %s", err)
+	}
+
 	module.StartServiceWorker("clean connections", 0, connectionCleaner)
 	module.StartServiceWorker("write open dns requests", 0, openDNSRequestWriter)
 
 	return nil
 }
+
+func stop() error {
+	return state.StopEBPF()
+}
@@ -0,0 +1,33 @@
+package packet
+
+// ICMP and ICMPv6 echo types.
+const (
+	ICMPv4EchoReply   = 0
+	ICMPv4EchoRequest = 8
+	ICMPv6EchoRequest = 128
+	ICMPv6EchoReply   = 129
+)
+
+// IsICMPEcho returns whether the packet is an ICMP or ICMPv6 echo request or
+// reply.
+func (pi *Info) IsICMPEcho() bool {
+	switch pi.Protocol {
+	case ICMP:
+		return pi.ICMPType == ICMPv4EchoRequest || pi.ICMPType == ICMPv4EchoReply
+	case ICMPv6:
+		return pi.ICMPType == ICMPv6EchoRequest || pi.ICMPType == ICMPv6EchoReply
+	}
+	return false
+}
+
+// IsICMPEchoRequest returns whether the packet is an ICMP or ICMPv6 echo
+// request.
+func (pi *Info) IsICMPEchoRequest() bool {
+	switch pi.Protocol {
+	case ICMP:
+		return pi.ICMPType == ICMPv4EchoRequest
+	case ICMPv6:
+		return pi.ICMPType == ICMPv6EchoRequest
+	}
+	return false
+}
@@ -67,7 +67,10 @@ func (pkt *Base) HasPorts() bool {
 
 // GetPayload returns the packet payload. In some cases, this will fetch the payload from the os integration system.
 func (pkt *Base) GetPayload() ([]byte, error) {
-	return pkt.Payload, ErrFailedToLoadPayload
+	if len(pkt.Payload) == 0 {
+		return nil, ErrFailedToLoadPayload
+	}
+	return pkt.Payload, nil
 }
 
 // GetConnectionID returns the link ID for this packet.
@@ -79,13 +82,22 @@ func (pkt *Base) GetConnectionID() string {
 }
 
 func (pkt *Base) createConnectionID() {
-	if pkt.info.Protocol == TCP || pkt.info.Protocol == UDP {
+	switch {
+	case pkt.info.Protocol == TCP || pkt.info.Protocol == UDP:
 		if pkt.info.Inbound {
 			pkt.connID = fmt.Sprintf("%d-%s-%d-%s-%d", pkt.info.Protocol, pkt.info.Dst, pkt.info.DstPort, pkt.info.Src, pkt.info.SrcPort)
 		} else {
 			pkt.connID = fmt.Sprintf("%d-%s-%d-%s-%d", pkt.info.Protocol, pkt.info.Src, pkt.info.SrcPort, pkt.info.Dst, pkt.info.DstPort)
 		}
-	} else {
+	case pkt.info.IsICMPEcho():
+		// Include the echo identifier, so that every ping session is a separate
+		// connection.
+		if pkt.info.Inbound {
+			pkt.connID = fmt.Sprintf("%d-%s-%s-%d", pkt.info.Protocol, pkt.info.Dst, pkt.info.Src, pkt.info.ICMPEchoID)
+		} else {
+			pkt.connID = fmt.Sprintf("%d-%s-%s-%d", pkt.info.Protocol, pkt.info.Src, pkt.info.Dst, pkt.info.ICMPEchoID)
+		}
+	default:
 		if pkt.info.Inbound {
 			pkt.connID = fmt.Sprintf("%d-%s-%s", pkt.info.Protocol, pkt.info.Dst, pkt.info.Src)
 		} else {
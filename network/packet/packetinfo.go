@@ -6,13 +6,26 @@
 
 // Info holds IP and TCP/UDP header information
 type Info struct {
-	Inbound  bool
-	InTunnel bool
+	Inbound   bool
+	InTunnel  bool
+	Forwarded bool
 
 	Version          IPVersion
 	Protocol         IPProtocol
 	SrcPort, DstPort uint16
 	Src, Dst         net.IP
+
+	// ICMPType and ICMPCode hold the type and code of ICMP and ICMPv6
+	// packets.
+	ICMPType, ICMPCode uint8
+	// ICMPEchoID holds the identifier of ICMP and ICMPv6 echo requests and
+	// replies.
+	ICMPEchoID uint16
+
+	// InterfaceIndex holds the index of the network interface the packet
+	// was received on or is sent out of, as reported by the interception.
+	// It is 0 if unknown.
+	InterfaceIndex int
 }
 
 // LocalIP returns the local IP of the packet.
@@ -72,7 +72,11 @@ func parseUDPLite(packet gopacket.Packet, info *Info) error {
 func parseICMPv4(packet gopacket.Packet, info *Info) error {
 	if icmp, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
 		info.Protocol = ICMP
-		_ = icmp
+		info.ICMPType = icmp.TypeCode.Type()
+		info.ICMPCode = icmp.TypeCode.Code()
+		if info.IsICMPEcho() {
+			info.ICMPEchoID = icmp.Id
+		}
 	}
 	return nil
 }
@@ -80,7 +84,11 @@ func parseICMPv4(packet gopacket.Packet, info *Info) error {
 func parseICMPv6(packet gopacket.Packet, info *Info) error {
 	if icmp6, ok := packet.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok {
 		info.Protocol = ICMPv6
-		_ = icmp6
+		info.ICMPType = icmp6.TypeCode.Type()
+		info.ICMPCode = icmp6.TypeCode.Code()
+	}
+	if echo, ok := packet.Layer(layers.LayerTypeICMPv6Echo).(*layers.ICMPv6Echo); ok {
+		info.ICMPEchoID = echo.Identifier
 	}
 	return nil
 }
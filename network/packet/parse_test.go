@@ -0,0 +1,95 @@
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func serializeICMPv4Echo(t *testing.T, typeCode layers.ICMPv4TypeCode, id uint16) []byte {
+	t.Helper()
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolICMPv4,
+			SrcIP:    net.IPv4(192, 0, 2, 1),
+			DstIP:    net.IPv4(198, 51, 100, 1),
+		},
+		&layers.ICMPv4{TypeCode: typeCode, Id: id, Seq: 1},
+		gopacket.Payload("ping"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseICMPEcho(t *testing.T) {
+	info := &Info{}
+	err := Parse(serializeICMPv4Echo(t, layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), 4321), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsICMPEchoRequest() {
+		t.Errorf("expected echo request, got type %d", info.ICMPType)
+	}
+	if info.ICMPEchoID != 4321 {
+		t.Errorf("expected echo identifier 4321, got %d", info.ICMPEchoID)
+	}
+	if info.SrcPort != 0 || info.DstPort != 0 {
+		t.Errorf("ICMP packets must not have ports, got %d and %d", info.SrcPort, info.DstPort)
+	}
+
+	// Only echo messages have an identifier.
+	info = &Info{}
+	err = Parse(serializeICMPv4Echo(t, layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort), 4321), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ICMPEchoID != 0 {
+		t.Errorf("expected no echo identifier, got %d", info.ICMPEchoID)
+	}
+}
+
+func TestICMPEchoConnectionID(t *testing.T) {
+	newPacket := func(info Info) *Base {
+		pkt := &Base{}
+		pkt.SetPacketInfo(info)
+		return pkt
+	}
+
+	request := newPacket(Info{
+		Protocol:   ICMP,
+		Src:        net.IPv4(192, 0, 2, 1),
+		Dst:        net.IPv4(198, 51, 100, 1),
+		ICMPType:   ICMPv4EchoRequest,
+		ICMPEchoID: 1,
+	})
+	reply := newPacket(Info{
+		Inbound:    true,
+		Protocol:   ICMP,
+		Src:        net.IPv4(198, 51, 100, 1),
+		Dst:        net.IPv4(192, 0, 2, 1),
+		ICMPType:   ICMPv4EchoReply,
+		ICMPEchoID: 1,
+	})
+	otherSession := newPacket(Info{
+		Protocol:   ICMP,
+		Src:        net.IPv4(192, 0, 2, 1),
+		Dst:        net.IPv4(198, 51, 100, 1),
+		ICMPType:   ICMPv4EchoRequest,
+		ICMPEchoID: 2,
+	})
+
+	if request.GetConnectionID() != reply.GetConnectionID() {
+		t.Errorf("request and reply must share a connection: %s != %s", request.GetConnectionID(), reply.GetConnectionID())
+	}
+	if request.GetConnectionID() == otherSession.GetConnectionID() {
+		t.Errorf("ping sessions must be separate connections: %s", request.GetConnectionID())
+	}
+}
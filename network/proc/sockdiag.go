@@ -0,0 +1,252 @@
+// +build linux
+
+package proc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/safing/portmaster/network/socket"
+)
+
+// The sock_diag netlink interface returns the same information as the
+// /proc/net/{tcp,udp}[6] files, but in binary form and with support for
+// querying single sockets.
+// See linux/sock_diag.h and linux/inet_diag.h.
+
+const (
+	sockDiagByFamily = 20
+
+	// inetDiagReqV2Len is the length of struct inet_diag_req_v2.
+	inetDiagReqV2Len = 56
+	// inetDiagMsgLen is the length of struct inet_diag_msg.
+	inetDiagMsgLen = 72
+
+	// inetDiagNoCookie disables the cookie check when querying single sockets.
+	inetDiagNoCookie = 0xFFFFFFFF
+
+	tcpListenState = 10
+	allTCPStates   = 0xFFFFFFFF
+)
+
+// GetTCP4TableNetlink returns the system table for IPv4 TCP activity using
+// the sock_diag netlink interface.
+func GetTCP4TableNetlink() (connections []*socket.ConnectionInfo, listeners []*socket.BindInfo, err error) {
+	return getTableFromNetlink(TCP4)
+}
+
+// GetTCP6TableNetlink returns the system table for IPv6 TCP activity using
+// the sock_diag netlink interface.
+func GetTCP6TableNetlink() (connections []*socket.ConnectionInfo, listeners []*socket.BindInfo, err error) {
+	return getTableFromNetlink(TCP6)
+}
+
+// GetUDP4TableNetlink returns the system table for IPv4 UDP activity using
+// the sock_diag netlink interface.
+func GetUDP4TableNetlink() (binds []*socket.BindInfo, err error) {
+	_, binds, err = getTableFromNetlink(UDP4)
+	return
+}
+
+// GetUDP6TableNetlink returns the system table for IPv6 UDP activity using
+// the sock_diag netlink interface.
+func GetUDP6TableNetlink() (binds []*socket.BindInfo, err error) {
+	_, binds, err = getTableFromNetlink(UDP6)
+	return
+}
+
+// QueryTCPSocket queries the TCP socket with the given addresses. It returns
+// nil if there is no such socket or if it is not yet accepted by a process.
+func QueryTCPSocket(v6 bool, local, remote socket.Address) (*socket.ConnectionInfo, error) {
+	stack := TCP4
+	if v6 {
+		stack = TCP6
+	}
+
+	connections, _, err := querySockDiag(stack, local, remote)
+	if err != nil || len(connections) == 0 {
+		return nil, err
+	}
+	return connections[0], nil
+}
+
+// QueryUDPSocket queries the UDP socket that receives packets for the given
+// addresses. It returns nil if there is no such socket.
+func QueryUDPSocket(v6 bool, local, remote socket.Address) (*socket.BindInfo, error) {
+	stack := UDP4
+	if v6 {
+		stack = UDP6
+	}
+
+	_, binds, err := querySockDiag(stack, local, remote)
+	if err != nil || len(binds) == 0 {
+		return nil, err
+	}
+	return binds[0], nil
+}
+
+func getTableFromNetlink(stack uint8) (connections []*socket.ConnectionInfo, binds []*socket.BindInfo, err error) {
+	req, err := newInetDiagRequest(stack)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msgs, err := executeSockDiag(req, netlink.Request|netlink.Dump)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connections, binds = parseSockDiagMessages(stack, msgs)
+	return connections, binds, nil
+}
+
+func querySockDiag(stack uint8, local, remote socket.Address) (connections []*socket.ConnectionInfo, binds []*socket.BindInfo, err error) {
+	req, err := newInetDiagRequest(stack)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Fill in the socket ID. UDP sockets are looked up like for a received
+	// packet, so the source is the remote address.
+	if stack == UDP4 || stack == UDP6 {
+		local, remote = remote, local
+	}
+	binary.BigEndian.PutUint16(req[8:10], local.Port)
+	binary.BigEndian.PutUint16(req[10:12], remote.Port)
+	putDiagIP(req[12:28], stack, local.IP)
+	putDiagIP(req[28:44], stack, remote.IP)
+	binary.LittleEndian.PutUint32(req[48:52], inetDiagNoCookie)
+	binary.LittleEndian.PutUint32(req[52:56], inetDiagNoCookie)
+
+	msgs, err := executeSockDiag(req, netlink.Request)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	connections, binds = parseSockDiagMessages(stack, msgs)
+
+	// Sockets that are not yet accepted do not have an inode.
+	if len(connections) > 0 && connections[0].Inode == 0 {
+		connections = nil
+	}
+	return connections, binds, nil
+}
+
+// newInetDiagRequest returns a struct inet_diag_req_v2 for the given stack.
+func newInetDiagRequest(stack uint8) ([]byte, error) {
+	req := make([]byte, inetDiagReqV2Len)
+
+	switch stack {
+	case TCP4, UDP4:
+		req[0] = unix.AF_INET
+	case TCP6, UDP6:
+		req[0] = unix.AF_INET6
+	default:
+		return nil, fmt.Errorf("unsupported table stack: %d", stack)
+	}
+
+	switch stack {
+	case TCP4, TCP6:
+		req[1] = unix.IPPROTO_TCP
+	case UDP4, UDP6:
+		req[1] = unix.IPPROTO_UDP
+	}
+
+	// Request sockets in all states.
+	binary.LittleEndian.PutUint32(req[4:8], allTCPStates)
+
+	return req, nil
+}
+
+func executeSockDiag(req []byte, flags netlink.HeaderFlags) ([]netlink.Message, error) {
+	conn, err := netlink.Dial(unix.NETLINK_INET_DIAG, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to sock_diag: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	return conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  sockDiagByFamily,
+			Flags: flags,
+		},
+		Data: req,
+	})
+}
+
+// parseSockDiagMessages parses struct inet_diag_msg messages into connections
+// and binds the same way getTableFromSource does.
+func parseSockDiagMessages(stack uint8, msgs []netlink.Message) (connections []*socket.ConnectionInfo, binds []*socket.BindInfo) {
+	for _, msg := range msgs {
+		data := msg.Data
+		if len(data) < inetDiagMsgLen {
+			continue
+		}
+
+		localIP := getDiagIP(data[8:24], stack)
+		localPort := binary.BigEndian.Uint16(data[4:6])
+		uid := int(binary.LittleEndian.Uint32(data[64:68]))
+		inode := int(binary.LittleEndian.Uint32(data[68:72]))
+
+		switch {
+		case stack == UDP4 || stack == UDP6,
+			data[1] == tcpListenState:
+
+			binds = append(binds, &socket.BindInfo{
+				Local: socket.Address{
+					IP:   localIP,
+					Port: localPort,
+				},
+				PID:   socket.UnidentifiedProcessID,
+				UID:   uid,
+				Inode: inode,
+			})
+
+		default:
+
+			connections = append(connections, &socket.ConnectionInfo{
+				Local: socket.Address{
+					IP:   localIP,
+					Port: localPort,
+				},
+				Remote: socket.Address{
+					IP:   getDiagIP(data[24:40], stack),
+					Port: binary.BigEndian.Uint16(data[6:8]),
+				},
+				PID:   socket.UnidentifiedProcessID,
+				UID:   uid,
+				Inode: inode,
+			})
+		}
+	}
+
+	return connections, binds
+}
+
+func getDiagIP(data []byte, stack uint8) net.IP {
+	switch stack {
+	case TCP4, UDP4:
+		return net.IPv4(data[0], data[1], data[2], data[3])
+	default:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, data[:16])
+		return ip
+	}
+}
+
+func putDiagIP(data []byte, stack uint8, ip net.IP) {
+	switch stack {
+	case TCP4, UDP4:
+		copy(data, ip.To4())
+	default:
+		copy(data, ip.To16())
+	}
+}
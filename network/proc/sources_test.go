@@ -0,0 +1,220 @@
+// +build linux
+
+package proc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portmaster/network/socket"
+)
+
+type tableFixture struct {
+	stack      uint8
+	listening  bool
+	localIP    net.IP
+	localPort  uint16
+	remoteIP   net.IP
+	remotePort uint16
+	uid        int
+	inode      int
+}
+
+var tableFixtures = []tableFixture{
+	{TCP4, true, net.ParseIP("127.0.0.1"), 53, net.IPv4zero, 0, 101, 21366},
+	{TCP4, true, net.IPv4zero, 22, net.IPv4zero, 0, 0, 19934},
+	{TCP4, false, net.ParseIP("192.168.1.10"), 41234, net.ParseIP("93.184.216.34"), 443, 1000, 355122},
+	{TCP6, true, net.IPv6zero, 80, net.IPv6zero, 0, 33, 20122},
+	{TCP6, false, net.ParseIP("2001:db8::10"), 51000, net.ParseIP("2606:2800:220:1:248:1893:25c8:1946"), 443, 1000, 355201},
+	{UDP4, false, net.ParseIP("0.0.0.0"), 68, net.IPv4zero, 0, 0, 18245},
+	{UDP4, false, net.ParseIP("192.168.1.10"), 37512, net.ParseIP("9.9.9.9"), 53, 1000, 355302},
+	{UDP6, false, net.ParseIP("fe80::1"), 546, net.IPv6zero, 0, 0, 18250},
+}
+
+// expectedTables returns the connections and binds that both sources must
+// return for the fixtures of the given stack.
+func expectedTables(stack uint8) (connections []*socket.ConnectionInfo, binds []*socket.BindInfo) {
+	for _, f := range tableFixtures {
+		if f.stack != stack {
+			continue
+		}
+
+		if f.listening || stack == UDP4 || stack == UDP6 {
+			binds = append(binds, &socket.BindInfo{
+				Local: socket.Address{IP: f.localIP, Port: f.localPort},
+				PID:   socket.UnidentifiedProcessID,
+				UID:   f.uid,
+				Inode: f.inode,
+			})
+		} else {
+			connections = append(connections, &socket.ConnectionInfo{
+				Local:  socket.Address{IP: f.localIP, Port: f.localPort},
+				Remote: socket.Address{IP: f.remoteIP, Port: f.remotePort},
+				PID:    socket.UnidentifiedProcessID,
+				UID:    f.uid,
+				Inode:  f.inode,
+			})
+		}
+	}
+	return connections, binds
+}
+
+// procFixture renders the fixtures of the given stack like /proc/net/{tcp,udp}[6].
+func procFixture(stack uint8) string {
+	var b strings.Builder
+	b.WriteString("  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n")
+	for i, f := range tableFixtures {
+		if f.stack != stack {
+			continue
+		}
+
+		state := "01"
+		switch {
+		case f.listening:
+			state = "0A"
+		case stack == UDP4 || stack == UDP6:
+			state = "07"
+		}
+
+		fmt.Fprintf(&b, "%4d: %s:%04X %s:%04X %s 00000000:00000000 00:00000000 00000000 %5d        0 %d 1 0000000000000000 100 0 0 10 0\n",
+			i, procIP(stack, f.localIP), f.localPort, procIP(stack, f.remoteIP), f.remotePort, state, f.uid, f.inode)
+	}
+	return b.String()
+}
+
+func procIP(stack uint8, ip net.IP) string {
+	var b strings.Builder
+	switch stack {
+	case TCP4, UDP4:
+		ip = ip.To4()
+	default:
+		ip = ip.To16()
+	}
+	// Every 32 bit word is written in host byte order.
+	for i := 0; i < len(ip); i += 4 {
+		fmt.Fprintf(&b, "%08X", binary.LittleEndian.Uint32(ip[i:i+4]))
+	}
+	return b.String()
+}
+
+// sockDiagFixture renders the fixtures of the given stack like the sock_diag
+// netlink interface.
+func sockDiagFixture(stack uint8) []netlink.Message {
+	var msgs []netlink.Message
+	for _, f := range tableFixtures {
+		if f.stack != stack {
+			continue
+		}
+
+		data := make([]byte, inetDiagMsgLen)
+		data[1] = 1 // TCP_ESTABLISHED
+		switch {
+		case f.listening:
+			data[1] = tcpListenState
+		case stack == UDP4 || stack == UDP6:
+			data[1] = 7 // TCP_CLOSE
+		}
+		binary.BigEndian.PutUint16(data[4:6], f.localPort)
+		binary.BigEndian.PutUint16(data[6:8], f.remotePort)
+		putDiagIP(data[8:24], stack, f.localIP)
+		putDiagIP(data[24:40], stack, f.remoteIP)
+		binary.LittleEndian.PutUint32(data[64:68], uint32(f.uid))
+		binary.LittleEndian.PutUint32(data[68:72], uint32(f.inode))
+
+		msgs = append(msgs, netlink.Message{Data: data})
+	}
+	return msgs
+}
+
+func TestTableSources(t *testing.T) {
+	t.Parallel()
+
+	for _, stack := range []uint8{TCP4, TCP6, UDP4, UDP6} {
+		expectedConnections, expectedBinds := expectedTables(stack)
+
+		connections, binds, err := parseProcTable(stack, strings.NewReader(procFixture(stack)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, expectedConnections, connections, "procfs connections of stack %d", stack)
+		assert.Equal(t, expectedBinds, binds, "procfs binds of stack %d", stack)
+
+		connections, binds = parseSockDiagMessages(stack, sockDiagFixture(stack))
+		assert.Equal(t, expectedConnections, connections, "netlink connections of stack %d", stack)
+		assert.Equal(t, expectedBinds, binds, "netlink binds of stack %d", stack)
+	}
+}
+
+func TestNetlinkQuery(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close() //nolint:errcheck
+
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	local := conn.LocalAddr().(*net.TCPAddr)
+	remote := conn.RemoteAddr().(*net.TCPAddr)
+	connInfo, err := QueryTCPSocket(
+		false,
+		socket.Address{IP: local.IP, Port: uint16(local.Port)},
+		socket.Address{IP: remote.IP, Port: uint16(remote.Port)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, connInfo, "socket must be found") {
+		assert.Equal(t, uint16(local.Port), connInfo.Local.Port)
+		assert.NotZero(t, connInfo.Inode)
+		assert.Equal(t, os.Getpid(), GetPID(connInfo))
+	}
+
+	// The listener must show up in the full table.
+	_, listeners, err := GetTCP4TableNetlink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenPort := uint16(ln.Addr().(*net.TCPAddr).Port)
+	found := false
+	for _, listener := range listeners {
+		if listener.Local.Port == listenPort {
+			found = true
+		}
+	}
+	assert.True(t, found, "listener must be in table")
+
+	// UDP sockets are found by the addresses of a packet they would receive.
+	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close() //nolint:errcheck
+
+	udpLocal := udpConn.LocalAddr().(*net.UDPAddr)
+	bindInfo, err := QueryUDPSocket(
+		false,
+		socket.Address{IP: udpLocal.IP, Port: uint16(udpLocal.Port)},
+		socket.Address{IP: net.ParseIP("127.0.0.2"), Port: 53},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, bindInfo, "socket must be found") {
+		assert.Equal(t, uint16(udpLocal.Port), bindInfo.Local.Port)
+		assert.NotZero(t, bindInfo.Inode)
+	}
+}
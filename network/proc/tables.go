@@ -6,6 +6,7 @@
 	"bufio"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strconv"
@@ -44,10 +45,12 @@
 	ICMP4
 	ICMP6
 
-	tcp4ProcFile = "/proc/net/tcp"
-	tcp6ProcFile = "/proc/net/tcp6"
-	udp4ProcFile = "/proc/net/udp"
-	udp6ProcFile = "/proc/net/udp6"
+	tcp4ProcFile  = "/proc/net/tcp"
+	tcp6ProcFile  = "/proc/net/tcp6"
+	udp4ProcFile  = "/proc/net/udp"
+	udp6ProcFile  = "/proc/net/udp6"
+	icmp4ProcFile = "/proc/net/icmp"
+	icmp6ProcFile = "/proc/net/icmp6"
 
 	tcpListenStateHex = "0A"
 )
@@ -74,6 +77,20 @@ func GetUDP6Table() (binds []*socket.BindInfo, err error) {
 	return
 }
 
+// GetICMP4Table returns the system table for IPv4 ICMP ping sockets. The local
+// port of ping sockets is the echo identifier.
+func GetICMP4Table() (binds []*socket.BindInfo, err error) {
+	_, binds, err = getTableFromSource(ICMP4, icmp4ProcFile)
+	return
+}
+
+// GetICMP6Table returns the system table for IPv6 ICMP ping sockets. The local
+// port of ping sockets is the echo identifier.
+func GetICMP6Table() (binds []*socket.BindInfo, err error) {
+	_, binds, err = getTableFromSource(ICMP6, icmp6ProcFile)
+	return
+}
+
 const (
 	// hint: we split fields by multiple delimiters, see procDelimiter
 	fieldIndexLocalIP    = 1
@@ -85,24 +102,28 @@ func GetUDP6Table() (binds []*socket.BindInfo, err error) {
 )
 
 func getTableFromSource(stack uint8, procFile string) (connections []*socket.ConnectionInfo, binds []*socket.BindInfo, err error) {
+	// open file
+	socketData, err := os.Open(procFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer socketData.Close()
+
+	return parseProcTable(stack, socketData)
+}
+
+func parseProcTable(stack uint8, socketData io.Reader) (connections []*socket.ConnectionInfo, binds []*socket.BindInfo, err error) {
 
 	var ipConverter func(string) net.IP
 	switch stack {
-	case TCP4, UDP4:
+	case TCP4, UDP4, ICMP4:
 		ipConverter = convertIPv4
-	case TCP6, UDP6:
+	case TCP6, UDP6, ICMP6:
 		ipConverter = convertIPv6
 	default:
 		return nil, nil, fmt.Errorf("unsupported table stack: %d", stack)
 	}
 
-	// open file
-	socketData, err := os.Open(procFile)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer socketData.Close()
-
 	// file scanner
 	scanner := bufio.NewScanner(socketData)
 	scanner.Split(bufio.ScanLines)
@@ -142,7 +163,7 @@ func getTableFromSource(stack uint8, procFile string) (connections []*socket.Con
 		}
 
 		switch stack {
-		case UDP4, UDP6:
+		case UDP4, UDP6, ICMP4, ICMP6:
 
 			binds = append(binds, &socket.BindInfo{
 				Local: socket.Address{
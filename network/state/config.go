@@ -0,0 +1,14 @@
+package state
+
+// Configuration Keys
+var (
+	// CfgOptionSocketTableSourceKey is registered by the process package, as
+	// it imports this package.
+	CfgOptionSocketTableSourceKey = "core/socketTableSource"
+)
+
+// Socket table sources.
+const (
+	SocketTableSourceNetlink = "netlink"
+	SocketTableSourceProcfs  = "procfs"
+)
@@ -17,6 +17,10 @@ func Exists(pktInfo *packet.Info, now time.Time) (exists bool) {
 
 	// TODO: create lookup maps before running a flurry of Exists() checks.
 
+	if existsFake() {
+		return true
+	}
+
 	switch {
 	case pktInfo.Version == packet.IPv4 && pktInfo.Protocol == packet.TCP:
 		return tcp4Table.exists(pktInfo)
@@ -30,6 +34,12 @@ func Exists(pktInfo *packet.Info, now time.Time) (exists bool) {
 	case pktInfo.Version == packet.IPv6 && pktInfo.Protocol == packet.UDP:
 		return udp6Table.exists(pktInfo, now)
 
+	case pktInfo.Version == packet.IPv4 && pktInfo.Protocol == packet.ICMP:
+		return icmp4Table.exists(icmpEchoSocketInfo(pktInfo), now)
+
+	case pktInfo.Version == packet.IPv6 && pktInfo.Protocol == packet.ICMPv6:
+		return icmp6Table.exists(icmpEchoSocketInfo(pktInfo), now)
+
 	default:
 		return false
 	}
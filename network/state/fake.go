@@ -0,0 +1,84 @@
+package state
+
+import (
+	"net"
+	"sync"
+
+	"github.com/safing/portmaster/network/packet"
+)
+
+// FakeFlow maps connections to a process for the fake system state. Unset
+// fields match any value.
+type FakeFlow struct {
+	Protocol   packet.IPProtocol
+	LocalIP    net.IP
+	LocalPort  uint16
+	RemoteIP   net.IP
+	RemotePort uint16
+
+	// PID is the PID of the process the matching connections belong to.
+	PID int
+	// Inbound defines whether the matching connections are inbound.
+	Inbound bool
+}
+
+var (
+	fakeFlows     []*FakeFlow
+	fakeFlowsLock sync.RWMutex
+)
+
+// UseFakeFlows replaces the system state tables with the given flows, so that
+// connections can be attributed without any access to the system, eg. when
+// replaying recorded traffic. Passing nil switches back to the system state.
+func UseFakeFlows(flows []*FakeFlow) {
+	fakeFlowsLock.Lock()
+	defer fakeFlowsLock.Unlock()
+
+	fakeFlows = flows
+}
+
+// lookupFake returns the fake flow matching the connection, if any. It
+// returns false if fake flows are not in use.
+func lookupFake(pktInfo *packet.Info) (flow *FakeFlow, active bool) {
+	fakeFlowsLock.RLock()
+	defer fakeFlowsLock.RUnlock()
+
+	if fakeFlows == nil {
+		return nil, false
+	}
+
+	for _, flow := range fakeFlows {
+		if flow.matches(pktInfo) {
+			return flow, true
+		}
+	}
+
+	return nil, true
+}
+
+// existsFake returns whether fake flows are in use. While they are, all
+// connections are regarded as existing, as there is no system state that
+// could tell otherwise.
+func existsFake() (active bool) {
+	fakeFlowsLock.RLock()
+	defer fakeFlowsLock.RUnlock()
+
+	return fakeFlows != nil
+}
+
+func (flow *FakeFlow) matches(pktInfo *packet.Info) bool {
+	switch {
+	case flow.Protocol != 0 && flow.Protocol != pktInfo.Protocol:
+		return false
+	case flow.LocalIP != nil && !flow.LocalIP.Equal(pktInfo.LocalIP()):
+		return false
+	case flow.LocalPort != 0 && flow.LocalPort != pktInfo.LocalPort():
+		return false
+	case flow.RemoteIP != nil && !flow.RemoteIP.Equal(pktInfo.RemoteIP()):
+		return false
+	case flow.RemotePort != 0 && flow.RemotePort != pktInfo.RemotePort():
+		return false
+	default:
+		return true
+	}
+}
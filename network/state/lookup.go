@@ -27,6 +27,7 @@
 var (
 	ErrConnectionNotFound = errors.New("could not find connection in system state tables")
 	ErrPIDNotFound        = errors.New("could not find pid for socket inode")
+	ErrEBPFNotSupported   = errors.New("eBPF attribution is not supported on this platform")
 )
 
 var (
@@ -45,6 +46,27 @@ func Lookup(pktInfo *packet.Info) (pid int, inbound bool, err error) {
 		}
 	}
 
+	// Use the fake flows instead of the system state, if set.
+	if flow, active := lookupFake(pktInfo); active {
+		if flow == nil {
+			return socket.UnidentifiedProcessID, pktInfo.Inbound, ErrConnectionNotFound
+		}
+		return flow.PID, flow.Inbound, nil
+	}
+
+	// Consult the eBPF attribution first, if it is active. It is faster than
+	// the system state tables and also knows about short-lived sockets that
+	// are already gone from them. Records of reused PIDs are rejected by the
+	// eBPF attribution itself.
+	if pid, inbound, ok := lookupEBPF(pktInfo); ok {
+		return pid, inbound, nil
+	}
+
+	return lookupTables(pktInfo)
+}
+
+// lookupTables looks for the given connection in the system state tables.
+func lookupTables(pktInfo *packet.Info) (pid int, inbound bool, err error) {
 	switch {
 	case pktInfo.Version == packet.IPv4 && pktInfo.Protocol == packet.TCP:
 		return tcp4Table.lookup(pktInfo)
@@ -58,6 +80,19 @@ func Lookup(pktInfo *packet.Info) (pid int, inbound bool, err error) {
 	case pktInfo.Version == packet.IPv6 && pktInfo.Protocol == packet.UDP:
 		return udp6Table.lookup(pktInfo)
 
+	case pktInfo.IsICMPEcho():
+		// Only outgoing echo requests and their replies belong to a ping
+		// socket, incoming echo requests are answered by the kernel.
+		// Pings sent via raw sockets are not attributed, as raw sockets are
+		// not bound to the echo identifier and receive all ICMP packets.
+		if pktInfo.IsICMPEchoRequest() == pktInfo.Inbound {
+			return socket.UnidentifiedProcessID, pktInfo.Inbound, ErrConnectionNotFound
+		}
+		if pktInfo.Version == packet.IPv4 {
+			return icmp4Table.lookup(icmpEchoSocketInfo(pktInfo))
+		}
+		return icmp6Table.lookup(icmpEchoSocketInfo(pktInfo))
+
 	default:
 		return socket.UnidentifiedProcessID, false, errors.New("unsupported protocol for finding process")
 	}
@@ -80,6 +115,14 @@ func (table *tcpTable) lookup(pktInfo *packet.Info) (
 			socketInfo, inbound = table.dualStack.findSocket(pktInfo)
 		}
 
+		// If there still is no match, query the socket directly before the
+		// tables are refetched.
+		if socketInfo == nil && i%2 == 1 {
+			if connInfo := table.querySocket(pktInfo); connInfo != nil {
+				socketInfo, inbound = connInfo, false
+			}
+		}
+
 		// If there's a match, check we have the PID and return.
 		if socketInfo != nil {
 			return checkPID(socketInfo, inbound)
@@ -157,6 +200,12 @@ func (table *udpTable) lookup(pktInfo *packet.Info) (
 			socketInfo = table.dualStack.findSocket(pktInfo, isInboundMulticast)
 		}
 
+		// If there still is no match, query the socket directly before the
+		// tables are refetched.
+		if socketInfo == nil && i%2 == 1 && !isInboundMulticast {
+			socketInfo = table.querySocket(pktInfo)
+		}
+
 		// If there's a match, get the direction and check we have the PID, then return.
 		if socketInfo != nil {
 			// If there is no remote port, do check for the direction of the
@@ -3,17 +3,133 @@
 import (
 	"time"
 
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/ebpf"
 	"github.com/safing/portmaster/network/proc"
 	"github.com/safing/portmaster/network/socket"
 )
 
 var (
-	getTCP4Table = proc.GetTCP4Table
-	getTCP6Table = proc.GetTCP6Table
-	getUDP4Table = proc.GetUDP4Table
-	getUDP6Table = proc.GetUDP6Table
+	lookupEBPF = ebpf.Lookup
+
+	socketTableSource = config.Concurrent.GetAsString(CfgOptionSocketTableSourceKey, SocketTableSourceProcfs)
+
+	// netlinkFailed is set when the netlink source failed, in which case
+	// procfs is used instead.
+	netlinkFailed = abool.New()
 )
 
+func init() {
+	tcp4Table.fetchSocket = queryTCP4Socket
+	tcp6Table.fetchSocket = queryTCP6Socket
+	udp4Table.fetchSocket = queryUDP4Socket
+	udp6Table.fetchSocket = queryUDP6Socket
+}
+
+func useNetlink() bool {
+	return socketTableSource() == SocketTableSourceNetlink && netlinkFailed.IsNotSet()
+}
+
+func netlinkFailedWith(err error) {
+	if netlinkFailed.SetToIf(false, true) {
+		log.Warningf("state: failed to use netlink for socket tables, falling back to procfs: %s", err)
+	}
+}
+
+func getTCP4Table() (connections []*socket.ConnectionInfo, listeners []*socket.BindInfo, err error) {
+	if useNetlink() {
+		connections, listeners, err = proc.GetTCP4TableNetlink()
+		if err == nil {
+			return connections, listeners, nil
+		}
+		netlinkFailedWith(err)
+	}
+	return proc.GetTCP4Table()
+}
+
+func getTCP6Table() (connections []*socket.ConnectionInfo, listeners []*socket.BindInfo, err error) {
+	if useNetlink() {
+		connections, listeners, err = proc.GetTCP6TableNetlink()
+		if err == nil {
+			return connections, listeners, nil
+		}
+		netlinkFailedWith(err)
+	}
+	return proc.GetTCP6Table()
+}
+
+func getUDP4Table() (binds []*socket.BindInfo, err error) {
+	if useNetlink() {
+		binds, err = proc.GetUDP4TableNetlink()
+		if err == nil {
+			return binds, nil
+		}
+		netlinkFailedWith(err)
+	}
+	return proc.GetUDP4Table()
+}
+
+func getUDP6Table() (binds []*socket.BindInfo, err error) {
+	if useNetlink() {
+		binds, err = proc.GetUDP6TableNetlink()
+		if err == nil {
+			return binds, nil
+		}
+		netlinkFailedWith(err)
+	}
+	return proc.GetUDP6Table()
+}
+
+func getICMP4Table() (binds []*socket.BindInfo, err error) {
+	return proc.GetICMP4Table()
+}
+
+func getICMP6Table() (binds []*socket.BindInfo, err error) {
+	return proc.GetICMP6Table()
+}
+
+func queryTCP4Socket(local, remote socket.Address) (*socket.ConnectionInfo, error) {
+	if !useNetlink() {
+		return nil, nil
+	}
+	return proc.QueryTCPSocket(false, local, remote)
+}
+
+func queryTCP6Socket(local, remote socket.Address) (*socket.ConnectionInfo, error) {
+	if !useNetlink() {
+		return nil, nil
+	}
+	return proc.QueryTCPSocket(true, local, remote)
+}
+
+func queryUDP4Socket(local, remote socket.Address) (*socket.BindInfo, error) {
+	if !useNetlink() {
+		return nil, nil
+	}
+	return proc.QueryUDPSocket(false, local, remote)
+}
+
+func queryUDP6Socket(local, remote socket.Address) (*socket.BindInfo, error) {
+	if !useNetlink() {
+		return nil, nil
+	}
+	return proc.QueryUDPSocket(true, local, remote)
+}
+
+// StartEBPF starts the eBPF attribution, which is then consulted before the
+// system state tables.
+func StartEBPF() error {
+	return ebpf.Start()
+}
+
+// StopEBPF stops the eBPF attribution.
+func StopEBPF() error {
+	return ebpf.Stop()
+}
+
 func checkPID(socketInfo socket.Info, connInbound bool) (pid int, inbound bool, err error) {
 	for i := 0; i <= lookupRetries; i++ {
 		// look for PID
@@ -2,6 +2,7 @@
 
 import (
 	"github.com/safing/portmaster/network/iphelper"
+	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/portmaster/network/socket"
 )
 
@@ -12,6 +13,29 @@
 	getUDP6Table = iphelper.GetUDP6Table
 )
 
+// Ping sockets are not listed by the IP helper.
+func getICMP4Table() (binds []*socket.BindInfo, err error) {
+	return nil, nil
+}
+
+func getICMP6Table() (binds []*socket.BindInfo, err error) {
+	return nil, nil
+}
+
 func checkPID(socketInfo socket.Info, connInbound bool) (pid int, inbound bool, err error) {
 	return socketInfo.GetPID(), connInbound, nil
 }
+
+// StartEBPF is not supported on this platform.
+func StartEBPF() error {
+	return ErrEBPFNotSupported
+}
+
+// StopEBPF is not supported on this platform.
+func StopEBPF() error {
+	return nil
+}
+
+func lookupEBPF(pktInfo *packet.Info) (pid int, inbound bool, ok bool) {
+	return socket.UnidentifiedProcessID, pktInfo.Inbound, false
+}
@@ -4,6 +4,8 @@
 	"net"
 
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/network/socket"
 )
 
 func (table *tcpTable) updateTables() {
@@ -46,3 +48,39 @@ func (table *udpTable) updateTable() {
 		table.binds = binds
 	})
 }
+
+// querySocket queries the socket of the given packet directly, which is
+// cheaper than refetching the whole table.
+func (table *tcpTable) querySocket(pktInfo *packet.Info) *socket.ConnectionInfo {
+	if table.fetchSocket == nil {
+		return nil
+	}
+
+	socketInfo, err := table.fetchSocket(
+		socket.Address{IP: pktInfo.LocalIP(), Port: pktInfo.LocalPort()},
+		socket.Address{IP: pktInfo.RemoteIP(), Port: pktInfo.RemotePort()},
+	)
+	if err != nil {
+		log.Warningf("state: failed to query TCP%d socket: %s", table.version, err)
+		return nil
+	}
+	return socketInfo
+}
+
+// querySocket queries the socket of the given packet directly, which is
+// cheaper than refetching the whole table.
+func (table *udpTable) querySocket(pktInfo *packet.Info) *socket.BindInfo {
+	if table.fetchSocket == nil {
+		return nil
+	}
+
+	socketInfo, err := table.fetchSocket(
+		socket.Address{IP: pktInfo.LocalIP(), Port: pktInfo.LocalPort()},
+		socket.Address{IP: pktInfo.RemoteIP(), Port: pktInfo.RemotePort()},
+	)
+	if err != nil {
+		log.Warningf("state: failed to query UDP%d socket: %s", table.version, err)
+		return nil
+	}
+	return socketInfo
+}
@@ -16,6 +16,8 @@ type tcpTable struct {
 
 	fetchOnceAgain utils.OnceAgain
 	fetchTable     func() (connections []*socket.ConnectionInfo, listeners []*socket.BindInfo, err error)
+	// fetchSocket queries a single socket, if supported by the platform.
+	fetchSocket func(local, remote socket.Address) (*socket.ConnectionInfo, error)
 
 	dualStack *tcpTable
 }
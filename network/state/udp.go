@@ -19,6 +19,8 @@ type udpTable struct {
 
 	fetchOnceAgain utils.OnceAgain
 	fetchTable     func() (binds []*socket.BindInfo, err error)
+	// fetchSocket queries a single socket, if supported by the platform.
+	fetchSocket func(local, remote socket.Address) (*socket.BindInfo, error)
 
 	states     map[string]map[string]*udpState
 	statesLock sync.Mutex
@@ -55,8 +57,31 @@ type udpState struct {
 		states:     make(map[string]map[string]*udpState),
 		dualStack:  udp6Table,
 	}
+
+	// Ping sockets are listed like UDP sockets that are bound to the echo
+	// identifier.
+	icmp6Table = &udpTable{
+		version:    6,
+		fetchTable: getICMP6Table,
+		states:     make(map[string]map[string]*udpState),
+	}
+
+	icmp4Table = &udpTable{
+		version:    4,
+		fetchTable: getICMP4Table,
+		states:     make(map[string]map[string]*udpState),
+	}
 )
 
+// icmpEchoSocketInfo returns a copy of the given packet info that uses the
+// echo identifier as ports, as ping sockets are bound to the echo identifier.
+func icmpEchoSocketInfo(pktInfo *packet.Info) *packet.Info {
+	socketInfo := *pktInfo
+	socketInfo.SrcPort = pktInfo.ICMPEchoID
+	socketInfo.DstPort = pktInfo.ICMPEchoID
+	return &socketInfo
+}
+
 // CleanUDPStates cleans the udp connection states which save connection directions.
 func CleanUDPStates(_ context.Context) {
 	now := time.Now().UTC()
@@ -66,6 +91,12 @@ func CleanUDPStates(_ context.Context) {
 
 	udp6Table.updateTable()
 	udp6Table.cleanStates(now)
+
+	icmp4Table.updateTable()
+	icmp4Table.cleanStates(now)
+
+	icmp6Table.updateTable()
+	icmp6Table.cleanStates(now)
 }
 
 func (table *udpTable) getConnState(
@@ -0,0 +1,168 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// TrafficStats holds the traffic counters of a connection or profile. Bytes
+// are counted as the length of the IP packets, including the IP headers, like
+// the connection tracking of the system does.
+type TrafficStats struct {
+	// PacketsSent is the number of packets sent to the remote entity.
+	PacketsSent uint64
+	// BytesSent is the number of bytes sent to the remote entity, including
+	// the IP headers.
+	BytesSent uint64
+	// PacketsReceived is the number of packets received from the remote
+	// entity.
+	PacketsReceived uint64
+	// BytesReceived is the number of bytes received from the remote entity,
+	// including the IP headers.
+	BytesReceived uint64
+}
+
+func (ts *TrafficStats) add(other TrafficStats) {
+	ts.PacketsSent += other.PacketsSent
+	ts.BytesSent += other.BytesSent
+	ts.PacketsReceived += other.PacketsReceived
+	ts.BytesReceived += other.BytesReceived
+}
+
+var (
+	// profileTraffic holds the aggregated traffic of all connections per
+	// profile, identified by the scoped profile ID.
+	profileTraffic     = make(map[string]*TrafficStats)
+	profileTrafficLock sync.Mutex
+)
+
+// countPacket adds the given packet to the traffic counters of the connection.
+// The connection must be locked.
+func (conn *Connection) countPacket(pkt packet.Packet) {
+	var size uint64
+	payload, err := pkt.GetPayload()
+	if err == nil {
+		size = ipPacketLength(payload)
+	}
+
+	var delta TrafficStats
+	if pkt.IsInbound() {
+		delta.PacketsReceived = 1
+		delta.BytesReceived = size
+	} else {
+		delta.PacketsSent = 1
+		delta.BytesSent = size
+	}
+
+	conn.Traffic.add(delta)
+	conn.addProfileTraffic(delta)
+}
+
+// ipPacketLength returns the length of the IP packet from its header, so
+// that padding and truncation by the interception do not change the counted
+// bytes. If the data does not start with an IP header or the header does not
+// hold the length, the length of the data is used.
+func ipPacketLength(data []byte) uint64 {
+	var length uint64
+	switch {
+	case len(data) >= 20 && data[0]>>4 == 4:
+		length = uint64(binary.BigEndian.Uint16(data[2:4]))
+	case len(data) >= 40 && data[0]>>4 == 6:
+		// The IPv6 payload length does not include the fixed header.
+		if payloadLength := binary.BigEndian.Uint16(data[4:6]); payloadLength > 0 {
+			length = 40 + uint64(payloadLength)
+		}
+	}
+
+	if length == 0 {
+		return uint64(len(data))
+	}
+	return length
+}
+
+// UpdateTraffic updates the traffic counters of the connection with the
+// given absolute counters, eg. from the connection tracking of the system.
+// Counters are never decreased. It returns whether any counter changed. The
+// connection must be locked.
+func (conn *Connection) UpdateTraffic(stats TrafficStats) (changed bool) {
+	var delta TrafficStats
+	updateCounter := func(current *uint64, new uint64, delta *uint64) {
+		if new > *current {
+			*delta = new - *current
+			*current = new
+			changed = true
+		}
+	}
+	updateCounter(&conn.Traffic.PacketsSent, stats.PacketsSent, &delta.PacketsSent)
+	updateCounter(&conn.Traffic.BytesSent, stats.BytesSent, &delta.BytesSent)
+	updateCounter(&conn.Traffic.PacketsReceived, stats.PacketsReceived, &delta.PacketsReceived)
+	updateCounter(&conn.Traffic.BytesReceived, stats.BytesReceived, &delta.BytesReceived)
+
+	if changed {
+		conn.addProfileTraffic(delta)
+	}
+	return changed
+}
+
+func (conn *Connection) addProfileTraffic(delta TrafficStats) {
+	if conn.ProcessContext.Profile == "" {
+		return
+	}
+	scopedID := conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile
+
+	profileTrafficLock.Lock()
+	defer profileTrafficLock.Unlock()
+
+	stats, ok := profileTraffic[scopedID]
+	if !ok {
+		stats = &TrafficStats{}
+		profileTraffic[scopedID] = stats
+	}
+	stats.add(delta)
+}
+
+// GetProfileTraffic returns the aggregated traffic of all connections of the
+// profile with the given scoped ID since the Portmaster was started.
+func GetProfileTraffic(scopedID string) TrafficStats {
+	profileTrafficLock.Lock()
+	defer profileTrafficLock.Unlock()
+
+	stats, ok := profileTraffic[scopedID]
+	if !ok {
+		return TrafficStats{}
+	}
+	return *stats
+}
+
+// GetAllProfileTraffic returns the aggregated traffic of all profiles since
+// the Portmaster was started, mapped by their scoped ID.
+func GetAllProfileTraffic() map[string]TrafficStats {
+	profileTrafficLock.Lock()
+	defer profileTrafficLock.Unlock()
+
+	all := make(map[string]TrafficStats, len(profileTraffic))
+	for scopedID, stats := range profileTraffic {
+		all[scopedID] = *stats
+	}
+	return all
+}
+
+func registerTrafficAPI() {
+	api.RegisterHandleFunc("/api/v1/network/traffic/profiles", handleProfileTrafficRequest).Methods("GET")
+}
+
+func handleProfileTrafficRequest(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(GetAllProfileTraffic())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
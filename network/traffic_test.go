@@ -0,0 +1,112 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/network/packet"
+)
+
+type testPacket struct {
+	packet.Base
+
+	handled bool
+	dropped bool
+}
+
+func (pkt *testPacket) Accept() error              { return nil }
+func (pkt *testPacket) Block() error               { return nil }
+func (pkt *testPacket) Drop() error                { pkt.dropped = true; return nil }
+func (pkt *testPacket) PermanentAccept() error     { return nil }
+func (pkt *testPacket) PermanentBlock() error      { return nil }
+func (pkt *testPacket) PermanentDrop() error       { return nil }
+func (pkt *testPacket) RerouteToNameserver() error { return nil }
+func (pkt *testPacket) RerouteToTunnel() error     { return nil }
+
+func newTestPacket(inbound bool, payload []byte) *testPacket {
+	pkt := &testPacket{}
+	pkt.Payload = payload
+	if inbound {
+		pkt.SetInbound()
+	} else {
+		pkt.SetOutbound()
+	}
+	return pkt
+}
+
+func TestIPPacketLength(t *testing.T) {
+	// IPv4 with 60 bytes total length and Ethernet padding.
+	ipv4 := make([]byte, 64)
+	ipv4[0] = 0x45
+	ipv4[3] = 60
+	// IPv6 with 20 bytes payload.
+	ipv6 := make([]byte, 60)
+	ipv6[0] = 0x60
+	ipv6[5] = 20
+	// IPv6 jumbogram without payload length.
+	jumbo := make([]byte, 100)
+	jumbo[0] = 0x60
+
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		expected uint64
+	}{
+		{"IPv4", ipv4, 60},
+		{"IPv6", ipv6, 60},
+		{"IPv6 jumbogram", jumbo, 100},
+		{"no IP header", []byte{1, 2, 3}, 3},
+		{"empty", nil, 0},
+	} {
+		if length := ipPacketLength(test.data); length != test.expected {
+			t.Errorf("%s: expected length %d, got %d", test.name, test.expected, length)
+		}
+	}
+}
+
+func TestTrafficCounting(t *testing.T) {
+	scopedID := "local/traffic-test"
+	conn := &Connection{
+		ProcessContext: ProcessContext{
+			Profile: "traffic-test",
+			Source:  "local",
+		},
+	}
+
+	ipv4 := make([]byte, 40)
+	ipv4[0] = 0x45
+	ipv4[3] = 40
+	conn.countPacket(newTestPacket(false, ipv4))
+	conn.countPacket(newTestPacket(false, ipv4))
+	conn.countPacket(newTestPacket(true, ipv4))
+	// Packets without payload are counted without bytes.
+	conn.countPacket(newTestPacket(true, nil))
+
+	expected := TrafficStats{
+		PacketsSent:     2,
+		BytesSent:       80,
+		PacketsReceived: 2,
+		BytesReceived:   40,
+	}
+	if conn.Traffic != expected {
+		t.Errorf("unexpected connection traffic %+v", conn.Traffic)
+	}
+	if profileStats := GetProfileTraffic(scopedID); profileStats != expected {
+		t.Errorf("unexpected profile traffic %+v", profileStats)
+	}
+
+	// Counters from the system only increase the counters.
+	if conn.UpdateTraffic(TrafficStats{PacketsSent: 1, BytesSent: 40}) {
+		t.Error("lower counters must not change the traffic")
+	}
+	if !conn.UpdateTraffic(TrafficStats{PacketsSent: 5, BytesSent: 200, PacketsReceived: 2, BytesReceived: 40}) {
+		t.Error("higher counters must change the traffic")
+	}
+	expected.PacketsSent = 5
+	expected.BytesSent = 200
+	if conn.Traffic != expected {
+		t.Errorf("unexpected connection traffic after update %+v", conn.Traffic)
+	}
+	if profileStats := GetProfileTraffic(scopedID); profileStats != expected {
+		t.Errorf("unexpected profile traffic after update %+v", profileStats)
+	}
+}
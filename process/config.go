@@ -2,12 +2,15 @@
 
 import (
 	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/network/state"
 )
 
 // Configuration Keys
 var (
 	CfgOptionEnableProcessDetectionKey = "core/enableProcessDetection"
 	enableProcessDetection             config.BoolOption
+
+	CfgOptionSocketTableSourceKey = state.CfgOptionSocketTableSourceKey
 )
 
 func registerConfiguration() error {
@@ -30,5 +33,37 @@ func registerConfiguration() error {
 	}
 	enableProcessDetection = config.Concurrent.GetAsBool(CfgOptionEnableProcessDetectionKey, true)
 
+	// Socket Table Source
+	// The option is used by the network/state package, which cannot import this package.
+	// Procfs stays the default until netlink has been proven on more systems.
+	err = config.Register(&config.Option{
+		Name:           "Socket Table Source",
+		Key:            CfgOptionSocketTableSourceKey,
+		Description:    "Defines how the system socket tables are read on Linux in order to attribute network traffic to processes. Netlink is faster and can look up single sockets, procfs is always used as a fallback if netlink fails.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   state.SocketTableSourceProcfs,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: 529,
+			config.CategoryAnnotation:     "Development",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Netlink",
+				Value:       state.SocketTableSourceNetlink,
+				Description: "Use the sock_diag netlink interface",
+			},
+			{
+				Name:        "Procfs",
+				Value:       state.SocketTableSourceProcfs,
+				Description: "Parse the files in /proc/net",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,79 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+)
+
+// DeviceProcessIDBase is the PID below which PIDs are assigned to device
+// pseudo-processes. Device pseudo-processes represent LAN devices whose
+// traffic is forwarded by the host in gateway mode.
+const DeviceProcessIDBase = -1000
+
+var (
+	// devicePIDs maps devices to their assigned PIDs, so that a device keeps
+	// its PID when its process is cleaned and recreated.
+	devicePIDs     = make(map[string]int)
+	devicePIDsLock sync.Mutex
+	nextDevicePID  = DeviceProcessIDBase - 1
+)
+
+// GetDeviceProcess returns the pseudo-process of the LAN device with the given
+// IP address. Devices are identified by their MAC address from the neighbour
+// table of the host, or by their IP address if it is not a neighbour.
+func GetDeviceProcess(ctx context.Context, ip net.IP) (*Process, error) {
+	device := ip.String()
+	if neighbour := netenv.GetNeighbour(ip); neighbour != nil {
+		device = neighbour.MAC.String()
+	}
+
+	// Get the PID of the device.
+	devicePIDsLock.Lock()
+	pid, ok := devicePIDs[device]
+	if !ok {
+		pid = nextDevicePID
+		nextDevicePID--
+		devicePIDs[device] = pid
+	}
+	devicePIDsLock.Unlock()
+
+	p, err, _ := getProcessSingleInflight.Do(strconv.Itoa(pid), func() (interface{}, error) {
+		// Check if we have already loaded the device process.
+		process, ok := GetProcessFromStorage(pid)
+		if ok {
+			return process, nil
+		}
+
+		process = &Process{
+			UserID:    UnidentifiedProcessID,
+			UserName:  "Device",
+			Pid:       pid,
+			ParentPid: UnidentifiedProcessID,
+			Name:      fmt.Sprintf("Device %s", device),
+			Device:    device,
+			FirstSeen: time.Now().Unix(),
+		}
+
+		// Get profile.
+		_, err := process.GetProfile(ctx)
+		if err != nil {
+			log.Tracer(ctx).Errorf("process: failed to get profile for device process %s: %s", process, err)
+		}
+
+		// Save process to storage.
+		process.Save()
+		return process, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.(*Process), nil
+}
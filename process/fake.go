@@ -0,0 +1,46 @@
+package process
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+// fakeProcessIDBase is the highest PID of fake processes. Fake processes use
+// negative PIDs below the special processes, so that they never collide with
+// the PIDs of real processes.
+const fakeProcessIDBase = -1000
+
+// FakeProcessID returns the PID of the fake process with the given ID, which
+// must not be negative.
+func FakeProcessID(id int) int {
+	return fakeProcessIDBase - id
+}
+
+// AddFakeProcess adds a process with the given PID and path to the process
+// storage without checking the system. This is used to attribute connections
+// to configured processes when replaying recorded traffic.
+func AddFakeProcess(ctx context.Context, pid int, path string) *Process {
+	process := &Process{
+		UserID:    UnidentifiedProcessID,
+		UserName:  "Unknown",
+		Pid:       pid,
+		ParentPid: UnidentifiedProcessID,
+		Path:      path,
+		ExecName:  filepath.Base(path),
+		Name:      filepath.Base(path),
+		FirstSeen: time.Now().Unix(),
+	}
+
+	// Get profile.
+	_, err := process.GetProfile(ctx)
+	if err != nil {
+		log.Tracer(ctx).Errorf("process: failed to get profile for fake process %s: %s", process, err)
+	}
+
+	// Save process to storage.
+	process.Save()
+	return process
+}
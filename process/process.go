@@ -44,6 +44,10 @@ type Process struct {
 	CmdLine   string
 	FirstArg  string
 
+	// Device holds the MAC or IP address of the LAN device that a device
+	// pseudo-process represents in gateway mode.
+	Device string
+
 	LocalProfileKey string
 	profile         *profile.LayeredProfile
 
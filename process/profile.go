@@ -32,6 +32,10 @@ func (p *Process) GetProfile(ctx context.Context) (changed bool, err error) {
 		profileID = profile.UnidentifiedProfileID
 	case SystemProcessID:
 		profileID = profile.SystemProfileID
+	default:
+		if p.Device != "" {
+			profileID = profile.DeviceProfileID(p.Device)
+		}
 	}
 
 	// Get the (linked) local profile.
@@ -67,3 +71,25 @@ func (p *Process) updateProfileMetadata(localProfile *profile.Profile) {
 		}
 	}
 }
+
+// ShadowCopy returns a copy of the process that is attributed to the given
+// layered profile instead of its own. The copy is not stored and must only be
+// used for evaluating staged profile changes.
+func (p *Process) ShadowCopy(layeredProfile *profile.LayeredProfile) *Process {
+	return &Process{
+		Name:            p.Name,
+		UserID:          p.UserID,
+		UserName:        p.UserName,
+		UserHome:        p.UserHome,
+		Pid:             p.Pid,
+		ParentPid:       p.ParentPid,
+		Path:            p.Path,
+		ExecName:        p.ExecName,
+		Cwd:             p.Cwd,
+		CmdLine:         p.CmdLine,
+		FirstArg:        p.FirstArg,
+		Device:          p.Device,
+		LocalProfileKey: p.LocalProfileKey,
+		profile:         layeredProfile,
+	}
+}
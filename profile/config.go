@@ -50,6 +50,10 @@
 	cfgOptionBlockInbound      config.IntOption // security level option
 	cfgOptionBlockInboundOrder = 20
 
+	CfgOptionBlockICMPKey   = "filter/blockICMP"
+	cfgOptionBlockICMP      config.IntOption // security level option
+	cfgOptionBlockICMPOrder = 21
+
 	// Rules
 
 	CfgOptionEndpointsKey   = "filter/endpoints"
@@ -96,6 +100,10 @@
 	cfgOptionDisableAutoPermit      config.IntOption // security level option
 	cfgOptionDisableAutoPermitOrder = 65
 
+	CfgOptionICMPHeuristicsKey   = "filter/icmpHeuristics"
+	cfgOptionICMPHeuristics      config.IntOption // security level option
+	cfgOptionICMPHeuristicsOrder = 66
+
 	// Permanent Verdicts Order = 96
 
 	CfgOptionUseSPNKey   = "spn/useSPN"
@@ -177,11 +185,23 @@ func registerConfiguration() error {
 	- Matching domains containing text: "*example*"
 - By country (based on IP): "US"
 - By filter list - use the filterlist ID prefixed with "L:": "L:MAL"
+- By network interface the connection uses, prefixed with "Interface:": "Interface:eth1"
+	- Matching interfaces with a wildcard suffix: "Interface:tun*"
+- By network location the device is in, prefixed with "Location:": "Location:Office"
 - Match anything: "*"
 
 Additionally, you may supply a protocol and port just behind that using numbers ("6/80") or names ("TCP/HTTP").  
 In this case the rule is only matched if the protocol and port also match.  
 Example: "192.168.0.1 TCP/HTTP"
+
+Finally, you may restrict a rule to a schedule by adding it at the very end, prefixed with "@".  
+A schedule consists of days ("mon-fri", "sat"), time ranges ("09:00-17:00", "22:00-06:00") and a timezone ("Europe/Vienna"), separated by commas. All parts are optional, the local time is used if no timezone is given.  
+The rule is ignored outside of its schedule.  
+Example: "AS1234 @mon-fri,09:00-17:00"
+
+Rules may also expire, which is usually set when answering a prompt. The expiry is added last, prefixed with "~", and is either a timestamp in RFC3339 format or "restart" to expire when the Portmaster restarts.  
+Expired rules are ignored and removed automatically.  
+Example: "example.com ~2020-08-03T10:00:00Z"
 `, `"`, "`")
 
 	// Endpoint Filter List
@@ -198,7 +218,7 @@ func registerConfiguration() error {
 			config.DisplayOrderAnnotation: cfgOptionEndpointsOrder,
 			config.CategoryAnnotation:     "Rules",
 		},
-		ValidationRegex: `^(\+|\-) [A-z0-9\.:\-*/]+( [A-z0-9/]+)?$`,
+		ValidationRegex: `^(\+|\-) [A-z0-9\.:\-*/]+( [A-z0-9/]+)?( @[A-z0-9,:/+\-]+)?( ~[A-z0-9:\-]+)?$`,
 	})
 	if err != nil {
 		return err
@@ -238,7 +258,7 @@ func registerConfiguration() error {
 				},
 			},
 		},
-		ValidationRegex: `^(\+|\-) [A-z0-9\.:\-*/]+( [A-z0-9/]+)?$`,
+		ValidationRegex: `^(\+|\-) [A-z0-9\.:\-*/]+( [A-z0-9/]+)?( @[A-z0-9,:/+\-]+)?( ~[A-z0-9:\-]+)?$`,
 	})
 	if err != nil {
 		return err
@@ -430,6 +450,28 @@ func registerConfiguration() error {
 	cfgOptionBlockInbound = config.Concurrent.GetAsInt(CfgOptionBlockInboundKey, int64(status.SecurityLevelsHighAndExtreme))
 	cfgIntOptions[CfgOptionBlockInboundKey] = cfgOptionBlockInbound
 
+	// Block ICMP
+	err = config.Register(&config.Option{
+		Name:           "Block ICMP",
+		Key:            CfgOptionBlockICMPKey,
+		Description:    "ICMP and ICMPv6 messages, such as ping, that are sent or received by the app. Messages that are required for the network to function, such as Neighbor Discovery or Path MTU Discovery, are always permitted. Only applies if ICMP filtering is enabled in the global settings. Is stronger than Rules (see below).",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   status.SecurityLevelOff,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.DisplayOrderAnnotation: cfgOptionBlockICMPOrder,
+			config.CategoryAnnotation:     "Connection Types",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionBlockICMP = config.Concurrent.GetAsInt(CfgOptionBlockICMPKey, int64(status.SecurityLevelOff))
+	cfgIntOptions[CfgOptionBlockICMPKey] = cfgOptionBlockICMP
+
 	// Filter Out-of-Scope DNS Records
 	err = config.Register(&config.Option{
 		Name:           "Enforce Global/Private Split-View",
@@ -492,6 +534,27 @@ func registerConfiguration() error {
 	}
 	cfgOptionDomainHeuristics = config.Concurrent.GetAsInt(CfgOptionDomainHeuristicsKey, int64(status.SecurityLevelsAll))
 
+	// ICMP heuristics
+	err = config.Register(&config.Option{
+		Name:           "Enable ICMP Heuristics",
+		Key:            CfgOptionICMPHeuristicsKey,
+		Description:    "Checks ICMP echo messages (ping) for oversized or random-looking payloads, which are a sign of data being tunneled through ICMP, and blocks them. Only applies if ICMP filtering is enabled in the global settings.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   status.SecurityLevelsAll,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.DisplayOrderAnnotation: cfgOptionICMPHeuristicsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionICMPHeuristics = config.Concurrent.GetAsInt(CfgOptionICMPHeuristicsKey, int64(status.SecurityLevelsAll))
+
 	// Bypass prevention
 	err = config.Register(&config.Option{
 		Name: "Block Bypassing",
@@ -499,7 +562,8 @@ func registerConfiguration() error {
 		Description: `Prevent apps from bypassing the privacy filter.  
 Current Features:  
 - Disable Firefox' internal DNS-over-HTTPs resolver
-- Block direct access to public DNS resolvers`,
+- Block direct access to public DNS resolvers
+- Block encrypted DNS (DNS-over-TLS, DNS-over-QUIC, known DNS-over-HTTPS servers) and DNS queries to ports other than 53, as seen by the traffic inspection`,
 		OptType:        config.OptTypeInt,
 		ExpertiseLevel: config.ExpertiseLevelUser,
 		ReleaseLevel:   config.ReleaseLevelBeta,
@@ -0,0 +1,13 @@
+package profile
+
+import (
+	"testing"
+)
+
+func TestRegisterConfiguration(t *testing.T) {
+	// Registration validates the default values of all options and fails
+	// the module prep if any of them is invalid.
+	if err := registerConfiguration(); err != nil {
+		t.Fatal(err)
+	}
+}
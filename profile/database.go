@@ -3,6 +3,7 @@
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/safing/portbase/config"
@@ -66,6 +67,13 @@ func startProfileUpdateChecker() error {
 
 				// mark as outdated
 				markActiveProfileAsOutdated(strings.TrimPrefix(r.Key(), profilesDBPath))
+
+				// re-select the network location, as its criteria might have changed
+				if strings.HasPrefix(r.Key(), makeProfileKey(SourceLocation, "")) {
+					module.StartWorker("update network location", func(ctx context.Context) error {
+						return updateActiveLocation(ctx, nil)
+					})
+				}
 			case <-ctx.Done():
 				return profilesSub.Cancel()
 			}
@@ -94,6 +102,9 @@ func (h *databaseHook) PrePut(r record.Record) (record.Record, error) {
 
 	// clean config
 	config.CleanHierarchicalConfig(profile.Config)
+	if profile.StagedConfig != nil {
+		config.CleanHierarchicalConfig(profile.StagedConfig)
+	}
 
 	// prepare config
 	err = profile.prepConfig()
@@ -109,5 +120,13 @@ func (h *databaseHook) PrePut(r record.Record) (record.Record, error) {
 		return nil, err
 	}
 
+	// check staged config
+	if profile.HasStagedConfig() {
+		_, err = profile.stagedCopy()
+		if err != nil {
+			return nil, fmt.Errorf("invalid staged config: %w", err)
+		}
+	}
+
 	return profile, nil
 }
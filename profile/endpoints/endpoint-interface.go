@@ -0,0 +1,63 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/safing/portmaster/intel"
+)
+
+const interfacePrefix = "INTERFACE:"
+
+var (
+	interfaceRegex = regexp.MustCompile(`^[A-Za-z0-9_.:@+-]{1,64}\*?$`)
+)
+
+// EndpointInterface matches the network interface the connection leaves or
+// enters the host through. A trailing "*" matches interfaces by prefix.
+type EndpointInterface struct {
+	EndpointBase
+
+	Interface string
+	Prefix    bool
+}
+
+// Matches checks whether the given entity matches this endpoint definition.
+func (ep *EndpointInterface) Matches(_ context.Context, entity *intel.Entity) (EPResult, Reason) {
+	// The interface is not known for connections created from DNS requests.
+	switch {
+	case entity.Interface == "":
+		return NoMatch, nil
+	case ep.Prefix && strings.HasPrefix(entity.Interface, ep.Interface),
+		!ep.Prefix && entity.Interface == ep.Interface:
+		return ep.match(ep, entity, entity.Interface, "connection uses network interface")
+	default:
+		return NoMatch, nil
+	}
+}
+
+func (ep *EndpointInterface) String() string {
+	if ep.Prefix {
+		return ep.renderPPP("Interface:" + ep.Interface + "*")
+	}
+	return ep.renderPPP("Interface:" + ep.Interface)
+}
+
+func parseTypeInterface(fields []string) (Endpoint, error) {
+	if !strings.HasPrefix(strings.ToUpper(fields[1]), interfacePrefix) {
+		return nil, nil
+	}
+
+	name := fields[1][len(interfacePrefix):]
+	if !interfaceRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid network interface %s", name)
+	}
+
+	ep := &EndpointInterface{
+		Interface: strings.TrimSuffix(name, "*"),
+		Prefix:    strings.HasSuffix(name, "*"),
+	}
+	return ep.parsePPP(ep, fields)
+}
@@ -0,0 +1,49 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/safing/portmaster/intel"
+)
+
+const locationPrefix = "LOCATION:"
+
+// EndpointLocation matches the network location the host is in, as
+// configured in the network location profiles. Names are matched
+// case-insensitively.
+type EndpointLocation struct {
+	EndpointBase
+
+	Location string
+}
+
+// Matches checks whether the given entity matches this endpoint definition.
+func (ep *EndpointLocation) Matches(_ context.Context, entity *intel.Entity) (EPResult, Reason) {
+	if strings.EqualFold(entity.NetworkLocation, ep.Location) {
+		return ep.match(ep, entity, entity.NetworkLocation, "host is in network location")
+	}
+
+	return NoMatch, nil
+}
+
+func (ep *EndpointLocation) String() string {
+	return ep.renderPPP("Location:" + ep.Location)
+}
+
+func parseTypeLocation(fields []string) (Endpoint, error) {
+	if !strings.HasPrefix(strings.ToUpper(fields[1]), locationPrefix) {
+		return nil, nil
+	}
+
+	name := fields[1][len(locationPrefix):]
+	if name == "" {
+		return nil, fmt.Errorf("missing network location name in %s", fields[1])
+	}
+
+	ep := &EndpointLocation{
+		Location: name,
+	}
+	return ep.parsePPP(ep, fields)
+}
@@ -0,0 +1,83 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/safing/portmaster/intel"
+)
+
+const (
+	tlsFingerprintTypeJA3 = "JA3"
+	tlsFingerprintTypeJA4 = "JA4"
+)
+
+var (
+	ja3Regex = regexp.MustCompile(`^[0-9a-f]{1,32}\*?$`)
+	ja4Regex = regexp.MustCompile(`^[0-9a-z_]{1,36}\*?$`)
+)
+
+// EndpointTLSFingerprint matches the JA3 or JA4 fingerprint of the TLS client.
+// A trailing "*" matches fingerprints by prefix.
+type EndpointTLSFingerprint struct {
+	EndpointBase
+
+	Type        string
+	Fingerprint string
+	Prefix      bool
+}
+
+// Matches checks whether the given entity matches this endpoint definition.
+func (ep *EndpointTLSFingerprint) Matches(_ context.Context, entity *intel.Entity) (EPResult, Reason) {
+	fingerprint := entity.JA3
+	if ep.Type == tlsFingerprintTypeJA4 {
+		fingerprint = entity.JA4
+	}
+
+	// The fingerprint is only known once the handshake has been inspected.
+	switch {
+	case fingerprint == "":
+		return NoMatch, nil
+	case ep.Prefix && strings.HasPrefix(fingerprint, ep.Fingerprint),
+		!ep.Prefix && fingerprint == ep.Fingerprint:
+		return ep.match(ep, entity, fingerprint, "TLS fingerprint matches")
+	default:
+		return NoMatch, nil
+	}
+}
+
+func (ep *EndpointTLSFingerprint) String() string {
+	if ep.Prefix {
+		return ep.renderPPP(ep.Type + ":" + ep.Fingerprint + "*")
+	}
+	return ep.renderPPP(ep.Type + ":" + ep.Fingerprint)
+}
+
+func parseTypeTLSFingerprint(fields []string) (Endpoint, error) {
+	splitted := strings.SplitN(fields[1], ":", 2)
+	if len(splitted) != 2 {
+		return nil, nil
+	}
+
+	ep := &EndpointTLSFingerprint{
+		Type:        strings.ToUpper(splitted[0]),
+		Fingerprint: strings.ToLower(splitted[1]),
+	}
+	switch {
+	case ep.Type == tlsFingerprintTypeJA3 && ja3Regex.MatchString(ep.Fingerprint):
+	case ep.Type == tlsFingerprintTypeJA4 && ja4Regex.MatchString(ep.Fingerprint):
+	case ep.Type == tlsFingerprintTypeJA3 || ep.Type == tlsFingerprintTypeJA4:
+		return nil, fmt.Errorf("invalid %s fingerprint %s", ep.Type, splitted[1])
+	default:
+		return nil, nil
+	}
+
+	if strings.HasSuffix(ep.Fingerprint, "*") {
+		ep.Prefix = true
+		ep.Fingerprint = strings.TrimSuffix(ep.Fingerprint, "*")
+	}
+
+	return ep.parsePPP(ep, fields)
+}
@@ -0,0 +1,92 @@
+package endpoints
+
+import (
+	"context"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/safing/portmaster/intel"
+)
+
+// EndpointURL matches the path of HTTP requests by prefix, optionally
+// restricted to a domain. The prefix only matches whole path segments. As the
+// request is chosen by the client, URL endpoints can only deny requests.
+type EndpointURL struct {
+	EndpointBase
+
+	OriginalValue string
+	// Domain holds the domain the endpoint is restricted to. It is nil if the
+	// endpoint applies to all domains.
+	Domain *EndpointDomain
+	// PathPrefix holds the cleaned path prefix.
+	PathPrefix string
+}
+
+// Matches checks whether the given entity matches this endpoint definition.
+func (ep *EndpointURL) Matches(ctx context.Context, entity *intel.Entity) (EPResult, Reason) {
+	// The path is only known once the request has been inspected.
+	if entity.URLPath == "" || !urlPathHasPrefix(path.Clean(entity.URLPath), ep.PathPrefix) {
+		return NoMatch, nil
+	}
+
+	if ep.Domain != nil {
+		result, _ := ep.Domain.Matches(ctx, entity)
+		if result == NoMatch || result == Undeterminable {
+			return result, nil
+		}
+	}
+
+	return ep.match(ep, entity, ep.OriginalValue, "URL matches")
+}
+
+// urlPathHasPrefix returns whether the cleaned path starts with the cleaned
+// prefix on a segment boundary.
+func urlPathHasPrefix(cleanedPath, prefix string) bool {
+	switch {
+	case prefix == "/":
+		return true
+	case !strings.HasPrefix(cleanedPath, prefix):
+		return false
+	default:
+		return len(cleanedPath) == len(prefix) || cleanedPath[len(prefix)] == '/'
+	}
+}
+
+func (ep *EndpointURL) String() string {
+	return ep.renderPPP(ep.OriginalValue)
+}
+
+func parseTypeURL(fields []string) (Endpoint, error) {
+	// The path starts at the first slash, use "*" as the domain to match
+	// any domain.
+	slash := strings.Index(fields[1], "/")
+	if slash <= 0 {
+		return nil, nil
+	}
+	domain, pathPrefix := fields[1][:slash], fields[1][slash:]
+
+	ep := &EndpointURL{
+		OriginalValue: fields[1],
+		PathPrefix:    path.Clean(pathPrefix),
+	}
+
+	if domain != "*" {
+		// IP ranges are parsed before, this is an invalid one.
+		if net.ParseIP(domain) != nil {
+			return nil, nil
+		}
+
+		domainEp, err := parseTypeDomain([]string{fields[0], domain})
+		if domainEp == nil || err != nil {
+			return nil, nil
+		}
+		ep.Domain = domainEp.(*EndpointDomain)
+	}
+
+	if fields[0] == "+" {
+		return nil, invalidDefinitionError(fields, "URL endpoints can only deny requests")
+	}
+
+	return ep.parsePPP(ep, fields)
+}
@@ -5,6 +5,7 @@
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/safing/portmaster/intel"
 	"github.com/safing/portmaster/network/reference"
@@ -14,6 +15,8 @@
 type Endpoint interface {
 	Matches(ctx context.Context, entity *intel.Entity) (EPResult, Reason)
 	String() string
+	ActiveAt(t time.Time) bool
+	NextScheduleChange(t time.Time) time.Time
 }
 
 // EndpointBase provides general functions for implementing an Endpoint to reduce boilerplate.
@@ -23,6 +26,47 @@ type EndpointBase struct {
 	EndPort   uint16
 
 	Permitted bool
+
+	// Schedule optionally restricts when the endpoint is active.
+	Schedule *Schedule
+
+	// Expires holds the unix timestamp at which the endpoint expires. It is
+	// zero if the endpoint does not expire at a certain time.
+	Expires int64
+	// ExpiresOnRestart is set to true if the endpoint expires when the
+	// Portmaster is restarted.
+	ExpiresOnRestart bool
+}
+
+// ActiveAt returns whether the endpoint is active at the given time according
+// to its schedule and expiry. Endpoints without either are always active.
+func (ep *EndpointBase) ActiveAt(t time.Time) bool {
+	if ep.Expires > 0 && t.Unix() >= ep.Expires {
+		return false
+	}
+	if ep.Schedule == nil {
+		return true
+	}
+	return ep.Schedule.ActiveAt(t)
+}
+
+// NextScheduleChange returns the next point in time after t at which the
+// endpoint becomes active or inactive, including when it expires. It returns
+// the zero time if the endpoint neither has a schedule nor expires.
+func (ep *EndpointBase) NextScheduleChange(t time.Time) time.Time {
+	var next time.Time
+	if ep.Schedule != nil {
+		next = ep.Schedule.NextChange(t)
+	}
+
+	if ep.Expires > 0 && t.Unix() < ep.Expires {
+		expires := time.Unix(ep.Expires, 0)
+		if next.IsZero() || expires.Before(next) {
+			next = expires
+		}
+	}
+
+	return next
 }
 
 func (ep *EndpointBase) match(s fmt.Stringer, entity *intel.Entity, value, desc string, keyval ...interface{}) (EPResult, Reason) {
@@ -110,10 +154,38 @@ func (ep *EndpointBase) renderPPP(s string) string {
 		}
 	}
 
+	if ep.Schedule != nil {
+		rendered += " " + ep.Schedule.String()
+	}
+
+	if ep.Expires > 0 || ep.ExpiresOnRestart {
+		rendered += " " + renderExpiry(ep.Expires, ep.ExpiresOnRestart)
+	}
+
 	return rendered
 }
 
 func (ep *EndpointBase) parsePPP(typedEp Endpoint, fields []string) (Endpoint, error) { //nolint:gocognit // TODO
+	// parse expiry, it is always the last field
+	if len(fields) > 2 && strings.HasPrefix(fields[len(fields)-1], ExpiryPrefix) {
+		var err error
+		ep.Expires, ep.ExpiresOnRestart, err = parseExpiry(fields[len(fields)-1])
+		if err != nil {
+			return nil, invalidDefinitionError(fields, err.Error())
+		}
+		fields = fields[:len(fields)-1]
+	}
+
+	// parse schedule, it is always the last field after the expiry
+	if len(fields) > 2 && strings.HasPrefix(fields[len(fields)-1], SchedulePrefix) {
+		schedule, err := ParseSchedule(fields[len(fields)-1])
+		if err != nil {
+			return nil, invalidDefinitionError(fields, err.Error())
+		}
+		ep.Schedule = schedule
+		fields = fields[:len(fields)-1]
+	}
+
 	switch len(fields) {
 	case 2:
 		// nothing else to do here
@@ -236,6 +308,22 @@ func parseEndpoint(value string) (endpoint Endpoint, err error) { //nolint:gocog
 	if endpoint, err = parseTypeList(fields); endpoint != nil || err != nil {
 		return
 	}
+	// tls fingerprint
+	if endpoint, err = parseTypeTLSFingerprint(fields); endpoint != nil || err != nil {
+		return
+	}
+	// interface
+	if endpoint, err = parseTypeInterface(fields); endpoint != nil || err != nil {
+		return
+	}
+	// network location
+	if endpoint, err = parseTypeLocation(fields); endpoint != nil || err != nil {
+		return
+	}
+	// url
+	if endpoint, err = parseTypeURL(fields); endpoint != nil || err != nil {
+		return
+	}
 	// domain
 	if endpoint, err = parseTypeDomain(fields); endpoint != nil || err != nil {
 		return
@@ -3,6 +3,7 @@
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEndpointParsing(t *testing.T) {
@@ -49,6 +50,27 @@ func TestEndpointParsing(t *testing.T) {
 	testParsing(t, "+ Internet")
 	testParsing(t, "+ Localhost,LAN,Internet")
 
+	// tls fingerprint
+	testParsing(t, "- JA3:e7d705a3286e19ea42f587b344ee6865")
+	testParsing(t, "- JA4:t13d1516h2_8daaf6152771_e5627efa2ab1")
+	testParsing(t, "- JA4:t13d1516h2_8daaf6152771_* TCP/HTTPS")
+
+	// interface
+	testParsing(t, "+ Interface:eth1")
+	testParsing(t, "- Interface:tun* UDP/DNS")
+
+	// network location
+	testParsing(t, "+ Location:Office")
+	testParsing(t, "- Location:Home TCP/SSH")
+
+	// url
+	testParsing(t, "- example.com/ads/")
+	testParsing(t, "- .example.com/downloads/setup.exe")
+	testParsing(t, "- */update TCP/HTTP")
+	if _, err := parseEndpoint("+ example.com/downloads/"); err == nil {
+		t.Error("permitting URL endpoint must be rejected")
+	}
+
 	// protocol and ports
 	testParsing(t, "+ * TCP/1-1024")
 	testParsing(t, "+ * */DNS")
@@ -57,6 +79,48 @@ func TestEndpointParsing(t *testing.T) {
 	testParsing(t, "+ * UDP/1234")
 	testParsing(t, "+ * TCP/HTTP")
 	testParsing(t, "+ * TCP/80-443")
+
+	// schedule
+	testParsing(t, "- AS1234 @mon-fri,09:00-17:00")
+	testParsing(t, "+ .example.com TCP/HTTPS @22:00-06:00,UTC")
+	testParsing(t, "- * @sat-sun")
+	testParsing(t, "- L:MAL @mon,wed,fri-sun,08:00-12:00,13:00-17:00,Europe/Vienna")
+
+	// expiry
+	testParsing(t, "+ example.com ~2020-08-03T10:00:00Z")
+	testParsing(t, "+ 10.0.0.1 TCP/SSH ~restart")
+	testParsing(t, "+ * UDP @mon-fri ~2020-08-03T10:00:00Z")
+}
+
+func TestEndpointExpiry(t *testing.T) {
+	now := time.Date(2020, 8, 3, 10, 0, 0, 0, time.UTC)
+
+	if IsExpired("+ example.com", now, true) {
+		t.Error("endpoint without expiry must not expire")
+	}
+	if IsExpired("+ example.com ~2020-08-03T10:00:01Z", now, false) {
+		t.Error("endpoint must not be expired before its expiry")
+	}
+	if !IsExpired("+ example.com ~2020-08-03T10:00:00Z", now, false) {
+		t.Error("endpoint must be expired at its expiry")
+	}
+	if IsExpired("+ example.com ~restart", now, false) {
+		t.Error("endpoint must not be expired before restart")
+	}
+	if !IsExpired("+ example.com ~restart", now, true) {
+		t.Error("endpoint must be expired after restart")
+	}
+
+	ep, err := parseEndpoint("+ example.com ~2020-08-03T10:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ep.ActiveAt(now.Add(-time.Second)) || ep.ActiveAt(now) {
+		t.Error("endpoint must be inactive after its expiry")
+	}
+	if !ep.NextScheduleChange(now.Add(-time.Hour)).Equal(now) {
+		t.Error("endpoint expiry must be reported as schedule change")
+	}
 }
 
 func testParsing(t *testing.T, value string) {
@@ -4,6 +4,7 @@
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/safing/portmaster/intel"
 )
@@ -64,9 +65,11 @@ func (e Endpoints) IsSet() bool {
 }
 
 // Match checks whether the given entity matches any of the endpoint definitions in the list.
+// Endpoints that are not active according to their schedule are skipped.
 func (e Endpoints) Match(ctx context.Context, entity *intel.Entity) (result EPResult, reason Reason) {
+	now := time.Now()
 	for _, entry := range e {
-		if entry != nil {
+		if entry != nil && entry.ActiveAt(now) {
 			if result, reason = entry.Matches(ctx, entity); result != NoMatch {
 				return
 			}
@@ -76,6 +79,22 @@ func (e Endpoints) Match(ctx context.Context, entity *intel.Entity) (result EPRe
 	return NoMatch, nil
 }
 
+// NextScheduleChange returns the earliest point in time after t at which any
+// of the endpoints becomes active or inactive. It returns the zero time if
+// none of the endpoints have a schedule.
+func (e Endpoints) NextScheduleChange(t time.Time) (next time.Time) {
+	for _, entry := range e {
+		if entry == nil {
+			continue
+		}
+		change := entry.NextScheduleChange(t)
+		if !change.IsZero() && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	return next
+}
+
 func (e Endpoints) String() string {
 	s := make([]string, 0, len(e))
 	for _, entry := range e {
@@ -394,6 +394,155 @@ func TestEndpointMatching(t *testing.T) {
 	}
 	// TODO: write test for lists matcher
 
+	// URL
+
+	ep, err = parseEndpoint("- .example.com/ads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain: "www.example.com.",
+	}).Init(), NoMatch)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:  "www.example.com.",
+		URLPath: "/ads/banner.gif",
+	}).Init(), Denied)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:  "www.example.com.",
+		URLPath: "/news/ads/",
+	}).Init(), NoMatch)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:  "example.org.",
+		URLPath: "/ads/banner.gif",
+	}).Init(), NoMatch)
+	// The prefix only matches whole path segments of the cleaned path.
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:  "www.example.com.",
+		URLPath: "/ads",
+	}).Init(), Denied)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:  "www.example.com.",
+		URLPath: "/adserver/",
+	}).Init(), NoMatch)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:  "www.example.com.",
+		URLPath: "//ads/banner.gif",
+	}).Init(), Denied)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:  "www.example.com.",
+		URLPath: "/news/../ads/banner.gif",
+	}).Init(), Denied)
+
+	ep, err = parseEndpoint("- */update TCP/80")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEndpointMatch(t, ep, (&intel.Entity{
+		IP:       net.ParseIP("10.2.3.4"),
+		Protocol: 6,
+		Port:     80,
+		URLPath:  "/update/firmware.bin",
+	}).Init(), Denied)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		IP:       net.ParseIP("10.2.3.4"),
+		Protocol: 6,
+		Port:     8080,
+		URLPath:  "/update/firmware.bin",
+	}).Init(), NoMatch)
+
+	// TLS Fingerprint
+
+	ep, err = parseEndpoint("- JA4:t13d1516h2_8daaf6152771_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain: "example.com.",
+	}).Init(), NoMatch)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain: "example.com.",
+		JA4:    "t13d1516h2_8daaf6152771_e5627efa2ab1",
+	}).Init(), Denied)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain: "example.com.",
+		JA4:    "t13d1715h2_5b57614c22b0_3d5424432f57",
+	}).Init(), NoMatch)
+
+	ep, err = parseEndpoint("+ ja3:E7D705A3286E19EA42F587B344EE6865")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEndpointMatch(t, ep, (&intel.Entity{
+		JA3: "e7d705a3286e19ea42f587b344ee6865",
+	}).Init(), Permitted)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		JA3: "e7d705a3286e19ea42f587b344ee6866",
+	}).Init(), NoMatch)
+
+	_, err = parseEndpoint("- JA3:not-a-hash")
+	if err == nil {
+		t.Error("invalid JA3 fingerprint must not be parsed")
+	}
+
+	// Interface
+
+	ep, err = parseEndpoint("+ Interface:tun*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain: "example.com.",
+	}).Init(), NoMatch)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:    "example.com.",
+		Interface: "tun0",
+	}).Init(), Permitted)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:    "example.com.",
+		Interface: "eth0",
+	}).Init(), NoMatch)
+
+	ep, err = parseEndpoint("- interface:eth1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Interface: "eth1",
+	}).Init(), Denied)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Interface: "eth10",
+	}).Init(), NoMatch)
+
+	_, err = parseEndpoint("- Interface:")
+	if err == nil {
+		t.Error("empty interface must not be parsed")
+	}
+
+	// Network Location
+
+	ep, err = parseEndpoint("- Location:Office")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain: "example.com.",
+	}).Init(), NoMatch)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:          "example.com.",
+		NetworkLocation: "office",
+	}).Init(), Denied)
+	testEndpointMatch(t, ep, (&intel.Entity{
+		Domain:          "example.com.",
+		NetworkLocation: "Home",
+	}).Init(), NoMatch)
+
 }
 
 func getLineNumberOfCaller(levels int) int {
@@ -0,0 +1,62 @@
+package endpoints
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// ExpiryPrefix marks the expiry qualifier of an endpoint definition.
+	ExpiryPrefix = "~"
+
+	// ExpiryRestart is the expiry qualifier value for endpoints that expire
+	// when the Portmaster is restarted.
+	ExpiryRestart = "restart"
+)
+
+// parseExpiry parses an expiry qualifier in the format "~<time>", where time
+// is in RFC3339 format, or "~restart".
+func parseExpiry(value string) (expires int64, onRestart bool, err error) {
+	if !strings.HasPrefix(value, ExpiryPrefix) {
+		return 0, false, fmt.Errorf("expiry must start with %q", ExpiryPrefix)
+	}
+	value = strings.TrimPrefix(value, ExpiryPrefix)
+
+	if value == ExpiryRestart {
+		return 0, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, false, fmt.Errorf("expiry must be %q or in RFC3339 format: %s", ExpiryRestart, err)
+	}
+	return t.Unix(), false, nil
+}
+
+func renderExpiry(expires int64, onRestart bool) string {
+	if onRestart {
+		return ExpiryPrefix + ExpiryRestart
+	}
+	return ExpiryPrefix + time.Unix(expires, 0).UTC().Format(time.RFC3339)
+}
+
+// IsExpired returns whether the given endpoint definition is expired at the
+// given time. If restarted is true, endpoint definitions that expire when the
+// Portmaster is restarted are reported as expired too.
+func IsExpired(definition string, t time.Time, restarted bool) bool {
+	fields := strings.Fields(definition)
+	if len(fields) < 3 || !strings.HasPrefix(fields[len(fields)-1], ExpiryPrefix) {
+		return false
+	}
+
+	expires, onRestart, err := parseExpiry(fields[len(fields)-1])
+	switch {
+	case err != nil:
+		return false
+	case onRestart:
+		return restarted
+	default:
+		return t.Unix() >= expires
+	}
+}
@@ -0,0 +1,299 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SchedulePrefix marks the schedule qualifier of an endpoint definition.
+	SchedulePrefix = "@"
+
+	minutesPerDay = 24 * 60
+)
+
+var (
+	timeRangeRegex = regexp.MustCompile(`^([0-9]{1,2}):([0-9]{2})-([0-9]{1,2}):([0-9]{2})$`)
+
+	// weekdayNames are in the order they are rendered in.
+	weekdayNames = []struct {
+		name string
+		day  time.Weekday
+	}{
+		{"mon", time.Monday},
+		{"tue", time.Tuesday},
+		{"wed", time.Wednesday},
+		{"thu", time.Thursday},
+		{"fri", time.Friday},
+		{"sat", time.Saturday},
+		{"sun", time.Sunday},
+	}
+)
+
+// Schedule restricts an endpoint to certain days and times of day.
+type Schedule struct {
+	// Days holds the days on which the schedule is active, indexed by
+	// time.Weekday.
+	Days [7]bool
+	// Ranges holds the time ranges during which the schedule is active. If
+	// empty, the schedule is active for the whole day.
+	Ranges []TimeRange
+	// Location is the timezone the schedule is defined in.
+	Location *time.Location
+}
+
+// TimeRange is a range of time within a day, defined in minutes since
+// midnight. If End is before Start, the range extends past midnight into the
+// following day.
+type TimeRange struct {
+	Start int
+	End   int
+}
+
+// ParseSchedule parses a schedule qualifier in the format
+// "@<days>,<from>-<to>,<timezone>". All parts are optional, but at least one
+// must be given. Days may be single days ("sat") or day ranges ("mon-fri"),
+// time ranges are given in 24h format ("22:00-06:00") and the timezone must
+// be an IANA timezone name ("Europe/Vienna"). Multiple days and time ranges
+// may be given. If no timezone is given, the local time is used.
+func ParseSchedule(value string) (*Schedule, error) {
+	if !strings.HasPrefix(value, SchedulePrefix) {
+		return nil, fmt.Errorf("schedule must start with %q", SchedulePrefix)
+	}
+	value = strings.TrimPrefix(value, SchedulePrefix)
+	if value == "" {
+		return nil, errors.New("schedule is empty")
+	}
+
+	s := &Schedule{
+		Location: time.Local,
+	}
+	var daysSet bool
+	var locationSet bool
+
+	for _, part := range strings.Split(value, ",") {
+		// Time range.
+		if timeRangeRegex.MatchString(part) {
+			tr, err := parseTimeRange(part)
+			if err != nil {
+				return nil, err
+			}
+			s.Ranges = append(s.Ranges, tr)
+			continue
+		}
+
+		// Days.
+		if days, ok := parseDays(part); ok {
+			for _, day := range days {
+				s.Days[day] = true
+			}
+			daysSet = true
+			continue
+		}
+
+		// Timezone.
+		if locationSet {
+			return nil, fmt.Errorf("invalid schedule part %q: timezone already set", part)
+		}
+		loc, err := time.LoadLocation(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule part %q: not a day, time range or timezone", part)
+		}
+		s.Location = loc
+		locationSet = true
+	}
+
+	// Activate all days if none were given.
+	if !daysSet {
+		for day := range s.Days {
+			s.Days[day] = true
+		}
+	}
+
+	// Check if the schedule restricts anything at all.
+	if len(s.Ranges) == 0 && s.activeDays() == 7 {
+		return nil, errors.New("schedule is always active")
+	}
+
+	return s, nil
+}
+
+func parseTimeRange(value string) (TimeRange, error) {
+	matches := timeRangeRegex.FindStringSubmatch(value)
+	if len(matches) != 5 {
+		return TimeRange{}, fmt.Errorf("invalid time range %q", value)
+	}
+
+	var minutes [4]int
+	for i, match := range matches[1:] {
+		n, err := strconv.Atoi(match)
+		if err != nil {
+			return TimeRange{}, fmt.Errorf("invalid time range %q: %s", value, err)
+		}
+		minutes[i] = n
+	}
+
+	// Check values. The end of the range may be "24:00".
+	if minutes[0] > 23 || minutes[1] > 59 || minutes[3] > 59 ||
+		minutes[2] > 24 || (minutes[2] == 24 && minutes[3] != 0) {
+		return TimeRange{}, fmt.Errorf("invalid time range %q: time out of range", value)
+	}
+
+	tr := TimeRange{
+		Start: minutes[0]*60 + minutes[1],
+		End:   minutes[2]*60 + minutes[3],
+	}
+	if tr.Start == tr.End {
+		return TimeRange{}, fmt.Errorf("invalid time range %q: start and end are equal", value)
+	}
+	return tr, nil
+}
+
+func parseDays(value string) (days []time.Weekday, ok bool) {
+	splitted := strings.Split(strings.ToLower(value), "-")
+	switch len(splitted) {
+	case 1:
+		day, ok := parseWeekday(splitted[0])
+		if !ok {
+			return nil, false
+		}
+		return []time.Weekday{day}, true
+	case 2:
+		from, ok := parseWeekday(splitted[0])
+		if !ok {
+			return nil, false
+		}
+		to, ok := parseWeekday(splitted[1])
+		if !ok {
+			return nil, false
+		}
+		// Walk from the first to the last day, wrapping around the week.
+		for day := from; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == to {
+				return days, true
+			}
+		}
+	default:
+		return nil, false
+	}
+}
+
+func parseWeekday(value string) (time.Weekday, bool) {
+	for _, wd := range weekdayNames {
+		if wd.name == value {
+			return wd.day, true
+		}
+	}
+	return 0, false
+}
+
+// ActiveAt returns whether the schedule is active at the given time.
+func (s *Schedule) ActiveAt(t time.Time) bool {
+	t = t.In(s.Location)
+	day := t.Weekday()
+
+	if len(s.Ranges) == 0 {
+		return s.Days[day]
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	previousDay := (day + 6) % 7
+	for _, tr := range s.Ranges {
+		if tr.Start < tr.End {
+			if s.Days[day] && minute >= tr.Start && minute < tr.End {
+				return true
+			}
+			continue
+		}
+
+		// The range extends past midnight. It belongs to the day it starts on.
+		if s.Days[day] && minute >= tr.Start {
+			return true
+		}
+		if s.Days[previousDay] && minute < tr.End {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextChange returns the next point in time after t at which the schedule
+// becomes active or inactive. It returns the zero time if the schedule does
+// not change within the next week.
+func (s *Schedule) NextChange(t time.Time) time.Time {
+	active := s.ActiveAt(t)
+
+	// Collect the minutes of the day on which the state could change.
+	boundaries := []int{0}
+	for _, tr := range s.Ranges {
+		boundaries = append(boundaries, tr.Start, tr.End%minutesPerDay)
+	}
+	sort.Ints(boundaries)
+
+	t = t.In(s.Location)
+	year, month, day := t.Date()
+	for dayOffset := 0; dayOffset <= 7; dayOffset++ {
+		for _, minute := range boundaries {
+			candidate := time.Date(year, month, day+dayOffset, 0, minute, 0, 0, s.Location)
+			if candidate.After(t) && s.ActiveAt(candidate) != active {
+				return candidate
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) activeDays() (n int) {
+	for _, active := range s.Days {
+		if active {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Schedule) String() string {
+	var parts []string
+
+	// Render days as ranges, unless all days are active.
+	if s.activeDays() < 7 {
+		for i := 0; i < len(weekdayNames); i++ {
+			if !s.Days[weekdayNames[i].day] {
+				continue
+			}
+			// Find the end of the consecutive days.
+			j := i
+			for j+1 < len(weekdayNames) && s.Days[weekdayNames[j+1].day] {
+				j++
+			}
+			if i == j {
+				parts = append(parts, weekdayNames[i].name)
+			} else {
+				parts = append(parts, weekdayNames[i].name+"-"+weekdayNames[j].name)
+			}
+			i = j
+		}
+	}
+
+	for _, tr := range s.Ranges {
+		parts = append(parts, fmt.Sprintf(
+			"%02d:%02d-%02d:%02d",
+			tr.Start/60, tr.Start%60,
+			tr.End/60, tr.End%60,
+		))
+	}
+
+	if s.Location != time.Local {
+		parts = append(parts, s.Location.String())
+	}
+
+	return SchedulePrefix + strings.Join(parts, ",")
+}
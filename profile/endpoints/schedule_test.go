@@ -0,0 +1,94 @@
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleParsing(t *testing.T) {
+	for _, value := range []string{
+		"@mon-fri",
+		"@sat,sun",
+		"@fri-mon,22:00-06:00",
+		"@09:00-17:00,Europe/Vienna",
+		"@00:00-24:00,mon",
+	} {
+		_, err := ParseSchedule(value)
+		assert.NoError(t, err, value)
+	}
+
+	for _, value := range []string{
+		"@",
+		"mon-fri",
+		"@mon-sun",
+		"@UTC",
+		"@funday",
+		"@09:00-09:00",
+		"@25:00-26:00",
+		"@09:60-10:00",
+		"@UTC,Europe/Vienna",
+	} {
+		_, err := ParseSchedule(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	// 2020-08-03 is a monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, 8, 3+day, hour, minute, 0, 0, time.UTC)
+	}
+
+	workHours, err := ParseSchedule("@mon-fri,09:00-17:00,UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, workHours.ActiveAt(at(0, 8, 59)))
+	assert.True(t, workHours.ActiveAt(at(0, 9, 0)))
+	assert.True(t, workHours.ActiveAt(at(4, 16, 59)))
+	assert.False(t, workHours.ActiveAt(at(4, 17, 0)))
+	assert.False(t, workHours.ActiveAt(at(5, 12, 0)))
+
+	// Ranges past midnight belong to the day they start on.
+	nights, err := ParseSchedule("@fri,22:00-06:00,UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, nights.ActiveAt(at(4, 21, 59)))
+	assert.True(t, nights.ActiveAt(at(4, 22, 0)))
+	assert.True(t, nights.ActiveAt(at(5, 5, 59)))
+	assert.False(t, nights.ActiveAt(at(5, 6, 0)))
+	assert.False(t, nights.ActiveAt(at(5, 22, 0)))
+
+	// Timezones are respected.
+	vienna, err := ParseSchedule("@09:00-17:00,Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, vienna.ActiveAt(at(0, 7, 0))) // 09:00 CEST
+	assert.False(t, vienna.ActiveAt(at(0, 15, 0)))
+}
+
+func TestScheduleNextChange(t *testing.T) {
+	// 2020-08-03 is a monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, 8, 3+day, hour, minute, 0, 0, time.UTC)
+	}
+
+	workHours, err := ParseSchedule("@mon-fri,09:00-17:00,UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, at(0, 9, 0), workHours.NextChange(at(0, 3, 0)))
+	assert.Equal(t, at(0, 17, 0), workHours.NextChange(at(0, 9, 0)))
+	assert.Equal(t, at(7, 9, 0), workHours.NextChange(at(4, 17, 0)))
+
+	weekend, err := ParseSchedule("@sat-sun,UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, at(5, 0, 0), weekend.NextChange(at(1, 12, 0)))
+	assert.Equal(t, at(7, 0, 0), weekend.NextChange(at(5, 0, 0)))
+}
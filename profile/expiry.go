@@ -0,0 +1,126 @@
+package profile
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+const (
+	expiredEndpointsCleanerTickDuration = 1 * time.Minute
+)
+
+// cleanExpiredEndpointsOnStart removes all expired endpoints, including the
+// ones that expire on restart, from all profiles in the database.
+func cleanExpiredEndpointsOnStart() error {
+	it, err := profileDB.Query(query.New(profilesDBPath))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for r := range it.Next {
+		profile, err := prepProfile(r)
+		if err != nil {
+			log.Warningf("profile: failed to load profile %s for cleaning expired rules: %s", r.Key(), err)
+			continue
+		}
+
+		// Check for an active version of the profile first.
+		if activeProfile := getActiveProfile(profile.ScopedID()); activeProfile != nil {
+			profile = activeProfile
+		}
+
+		profile.removeExpiredEndpoints(now, true)
+	}
+
+	return it.Err()
+}
+
+// cleanExpiredEndpoints periodically removes expired endpoints from the active
+// profiles.
+func cleanExpiredEndpoints(ctx context.Context, task *modules.Task) error {
+	now := time.Now()
+	for _, profile := range getAllActiveProfiles() {
+		profile.removeExpiredEndpoints(now, false)
+	}
+
+	return nil
+}
+
+// removeExpiredEndpoints removes all expired endpoints, saves the profile and
+// increases the revision counter of the layered profile if anything changed.
+func (profile *Profile) removeExpiredEndpoints(now time.Time, restarted bool) {
+	changed := false
+
+	// When finished, save the profile.
+	defer func() {
+		if !changed {
+			return
+		}
+
+		err := profile.Save()
+		if err != nil {
+			log.Warningf("profile: failed to save profile %s after removing expired rules: %s", profile.ScopedID(), err)
+		}
+	}()
+
+	// When finished increase the revision counter of the layered profile.
+	defer func() {
+		if !changed || profile.layeredProfile == nil {
+			return
+		}
+
+		profile.layeredProfile.Lock()
+		defer profile.layeredProfile.Unlock()
+
+		profile.layeredProfile.RevisionCounter++
+	}()
+
+	// Lock the profile for editing.
+	profile.Lock()
+	defer profile.Unlock()
+
+	for _, cfgKey := range []string{CfgOptionEndpointsKey, CfgOptionServiceEndpointsKey} {
+		endpointList, ok := profile.configPerspective.GetAsStringArray(cfgKey)
+		if !ok {
+			continue
+		}
+
+		cleaned := make([]string, 0, len(endpointList))
+		for _, entry := range endpointList {
+			if endpoints.IsExpired(entry, now, restarted) {
+				log.Infof("profile: removing expired rule from %s: %s", profile, entry)
+				continue
+			}
+			cleaned = append(cleaned, entry)
+		}
+
+		if len(cleaned) != len(endpointList) {
+			config.PutValueIntoHierarchicalConfig(profile.Config, cfgKey, cleaned)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	// Reload the profile manually in order to parse the new lists.
+	var err error
+	profile.configPerspective, err = config.NewPerspective(profile.Config)
+	if err != nil {
+		log.Warningf("profile: failed to prepare %s config after removing expired rules: %s", profile, err)
+		return
+	}
+	profile.dataParsed = false
+	err = profile.parseConfig()
+	if err != nil {
+		log.Warningf("profile: failed to parse %s config after removing expired rules: %s", profile, err)
+	}
+}
@@ -21,6 +21,10 @@
 
 	// SystemProfileID is the profile ID used for the system/kernel.
 	SystemProfileID = "_system"
+
+	// DeviceProfileIDPrefix is the prefix of profile IDs used for LAN devices
+	// in gateway mode.
+	DeviceProfileIDPrefix = "_device-"
 )
 
 var getProfileSingleInflight singleflight.Group
@@ -71,6 +75,11 @@ func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
 				case SystemProfileID:
 					profile = New(SourceLocal, SystemProfileID, linkedPath)
 					err = nil
+				default:
+					if strings.HasPrefix(id, DeviceProfileIDPrefix) {
+						profile = New(SourceLocal, id, linkedPath)
+						err = nil
+					}
 				}
 			}
 
@@ -132,6 +141,12 @@ func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
 	return p.(*Profile), nil
 }
 
+// DeviceProfileID returns the profile ID for the LAN device with the given MAC
+// or IP address.
+func DeviceProfileID(device string) string {
+	return DeviceProfileIDPrefix + strings.NewReplacer(":", "-", ".", "-").Replace(device)
+}
+
 // getProfile fetches the profile for the given scoped ID.
 func getProfile(scopedID string) (profile *Profile, err error) {
 	// Get profile from the database.
@@ -0,0 +1,203 @@
+package profile
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+)
+
+// Network locations are profiles with the location source. The location
+// profile of the network the host is currently connected to is layered into
+// all layered profiles, below the app profiles and above the global
+// configuration. Its config and security level therefore apply to all apps
+// while the network is connected.
+
+var (
+	activeLocation     *Profile
+	activeLocationLock sync.RWMutex
+)
+
+// NetworkCriteria holds the criteria that identify the network of a
+// location. All criteria that are set must match, where a criterion matches
+// if any of its values matches.
+type NetworkCriteria struct {
+	// GatewayMACs holds MAC addresses of the gateway.
+	GatewayMACs []string
+	// DHCPDomains holds domain names assigned by DHCP.
+	DHCPDomains []string
+	// SearchDomains holds DNS search domains.
+	SearchDomains []string
+	// SSIDs holds Wi-Fi network names.
+	SSIDs []string
+}
+
+// Matches returns whether the given network identity matches the criteria
+// and how many criteria matched. Criteria without any values always match,
+// making a location without criteria the fallback for unknown networks.
+func (nc *NetworkCriteria) Matches(identity *netenv.NetworkIdentity) (matches bool, score int) {
+	if nc == nil {
+		return true, 0
+	}
+
+	for _, criterion := range []struct {
+		values []string
+		actual []string
+		exact  bool
+	}{
+		{values: nc.GatewayMACs, actual: identity.GatewayMACs},
+		{values: nc.DHCPDomains, actual: []string{identity.DHCPDomain}},
+		{values: nc.SearchDomains, actual: identity.SearchDomains},
+		{values: nc.SSIDs, actual: []string{identity.SSID}, exact: true},
+	} {
+		if len(criterion.values) == 0 {
+			continue
+		}
+		if !criterionMatches(criterion.values, criterion.actual, criterion.exact) {
+			return false, 0
+		}
+		score++
+	}
+
+	return true, score
+}
+
+func criterionMatches(values, actual []string, exact bool) bool {
+	for _, value := range values {
+		if !exact {
+			value = strings.TrimSuffix(value, ".")
+		}
+		for _, a := range actual {
+			switch {
+			case a == "":
+			case exact && value == a:
+				return true
+			case !exact && strings.EqualFold(value, a):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetActiveLocation returns the location profile of the network the host is
+// currently connected to, or nil if no location matches.
+func GetActiveLocation() *Profile {
+	activeLocationLock.RLock()
+	defer activeLocationLock.RUnlock()
+
+	return activeLocation
+}
+
+// activeLocationID returns the scoped ID of the active location profile, or
+// an empty string if there is none.
+func activeLocationID() string {
+	if location := GetActiveLocation(); location != nil {
+		return location.ScopedID()
+	}
+	return ""
+}
+
+// updateActiveLocation selects the location profile that matches the current
+// network best. Layered profiles switch to the new location with their next
+// update.
+func updateActiveLocation(_ context.Context, _ interface{}) error {
+	identity := netenv.GetNetworkIdentity()
+
+	it, err := profileDB.Query(query.New(makeProfileKey(SourceLocation, "")))
+	if err != nil {
+		return err
+	}
+
+	var bestID string
+	bestScore := -1
+	for r := range it.Next {
+		location, err := EnsureProfile(r)
+		if err != nil {
+			log.Warningf("profiles: failed to load location %s: %s", r.Key(), err)
+			continue
+		}
+
+		matches, score := location.Network.Matches(identity)
+		if !matches {
+			continue
+		}
+		// Prefer the most specific match, use the ID to break ties.
+		if score > bestScore || (score == bestScore && location.ID < bestID) {
+			bestID = location.ID
+			bestScore = score
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	var location *Profile
+	if bestID != "" {
+		location, err = GetProfile(SourceLocation, bestID, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	activeLocationLock.Lock()
+	defer activeLocationLock.Unlock()
+
+	switch {
+	case location == nil && activeLocation == nil:
+	case location != nil && activeLocation != nil && location.ID == activeLocation.ID:
+		// Same location, the profile might have been updated.
+	case location == nil:
+		log.Infof("profiles: left network location %s", activeLocation.Name)
+	default:
+		log.Infof("profiles: entered network location %s", location.Name)
+	}
+	activeLocation = location
+
+	return nil
+}
+
+// updateLocationLayer replaces the location layer with the active location
+// profile and returns whether it changed. The layered profile must be locked.
+func (lp *LayeredProfile) updateLocationLayer() (changed bool) {
+	if !lp.locationChanged() {
+		return false
+	}
+	location := GetActiveLocation()
+
+	// Remove the previous location layer.
+	layers := make([]*Profile, 0, len(lp.layers)+1)
+	layerIDs := make([]string, 0, len(lp.layers)+1)
+	for _, layer := range lp.layers {
+		if layer.Source != SourceLocation {
+			layers = append(layers, layer)
+			layerIDs = append(layerIDs, layer.ScopedID())
+		}
+	}
+
+	// Add the active location as the last layer.
+	if location != nil {
+		layers = append(layers, location)
+		layerIDs = append(layerIDs, location.ScopedID())
+	}
+
+	lp.layers = layers
+	lp.LayerIDs = layerIDs
+	return true
+}
+
+// locationChanged returns whether the active location differs from the
+// location layer. The layered profile must be read locked.
+func (lp *LayeredProfile) locationChanged() bool {
+	var current string
+	for _, layer := range lp.layers {
+		if layer.Source == SourceLocation {
+			current = layer.ScopedID()
+			break
+		}
+	}
+	return current != activeLocationID()
+}
@@ -0,0 +1,153 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/netenv"
+)
+
+func TestNetworkCriteriaMatches(t *testing.T) {
+	identity := &netenv.NetworkIdentity{
+		GatewayMACs:   []string{"02:00:00:00:00:01"},
+		DHCPDomain:    "office.example.com",
+		SearchDomains: []string{"example.com", "corp.example.com"},
+		SSID:          "Office",
+	}
+
+	for _, test := range []struct {
+		name     string
+		criteria *NetworkCriteria
+		matches  bool
+		score    int
+	}{
+		{
+			name:     "no criteria",
+			criteria: nil,
+			matches:  true,
+		},
+		{
+			name:     "empty criteria",
+			criteria: &NetworkCriteria{},
+			matches:  true,
+		},
+		{
+			name: "gateway",
+			criteria: &NetworkCriteria{
+				GatewayMACs: []string{"02:00:00:00:00:02", "02:00:00:00:00:01"},
+			},
+			matches: true,
+			score:   1,
+		},
+		{
+			name: "domains ignore case and trailing dot",
+			criteria: &NetworkCriteria{
+				DHCPDomains:   []string{"Office.Example.com."},
+				SearchDomains: []string{"corp.example.com."},
+			},
+			matches: true,
+			score:   2,
+		},
+		{
+			name: "ssid is exact",
+			criteria: &NetworkCriteria{
+				SSIDs: []string{"office"},
+			},
+			matches: false,
+		},
+		{
+			name: "all criteria must match",
+			criteria: &NetworkCriteria{
+				GatewayMACs: []string{"02:00:00:00:00:01"},
+				SSIDs:       []string{"Home"},
+			},
+			matches: false,
+		},
+		{
+			name: "all criteria",
+			criteria: &NetworkCriteria{
+				GatewayMACs:   []string{"02:00:00:00:00:01"},
+				DHCPDomains:   []string{"office.example.com"},
+				SearchDomains: []string{"example.com"},
+				SSIDs:         []string{"Office"},
+			},
+			matches: true,
+			score:   4,
+		},
+	} {
+		matches, score := test.criteria.Matches(identity)
+		if matches != test.matches || score != test.score {
+			t.Errorf("%s: expected matches=%v score=%d, got matches=%v score=%d",
+				test.name, test.matches, test.score, matches, score)
+		}
+	}
+
+	// Empty values of the identity never match.
+	criteria := &NetworkCriteria{SSIDs: []string{""}}
+	if matches, _ := criteria.Matches(&netenv.NetworkIdentity{}); matches {
+		t.Error("empty SSID must not match")
+	}
+}
+
+func setActiveLocation(location *Profile) {
+	activeLocationLock.Lock()
+	defer activeLocationLock.Unlock()
+
+	activeLocation = location
+}
+
+func TestUpdateLocationLayer(t *testing.T) {
+	defer setActiveLocation(nil)
+
+	app := &Profile{Source: SourceLocal, ID: "app"}
+	office := &Profile{Source: SourceLocation, ID: "office"}
+	home := &Profile{Source: SourceLocation, ID: "home"}
+
+	lp := &LayeredProfile{
+		layers:   []*Profile{app},
+		LayerIDs: []string{app.ScopedID()},
+	}
+
+	// Without an active location, nothing changes.
+	setActiveLocation(nil)
+	if lp.updateLocationLayer() {
+		t.Error("layers must not change without an active location")
+	}
+
+	// The active location is added as the last layer.
+	setActiveLocation(office)
+	if !lp.updateLocationLayer() {
+		t.Error("layers must change when entering a location")
+	}
+	checkLayerIDs(t, lp, app.ScopedID(), office.ScopedID())
+	if lp.updateLocationLayer() {
+		t.Error("layers must not change if the location stays the same")
+	}
+
+	// A new location replaces the previous one.
+	setActiveLocation(home)
+	if !lp.updateLocationLayer() {
+		t.Error("layers must change when switching the location")
+	}
+	checkLayerIDs(t, lp, app.ScopedID(), home.ScopedID())
+
+	// Leaving the location removes the layer.
+	setActiveLocation(nil)
+	if !lp.updateLocationLayer() {
+		t.Error("layers must change when leaving a location")
+	}
+	checkLayerIDs(t, lp, app.ScopedID())
+}
+
+func checkLayerIDs(t *testing.T, lp *LayeredProfile, expected ...string) {
+	t.Helper()
+
+	if len(lp.layers) != len(expected) || len(lp.LayerIDs) != len(expected) {
+		t.Fatalf("expected layers %v, got %v", expected, lp.LayerIDs)
+	}
+	for i, id := range expected {
+		if lp.LayerIDs[i] != id || lp.layers[i].ScopedID() != id {
+			t.Errorf("expected layers %v, got %v", expected, lp.LayerIDs)
+			return
+		}
+	}
+}
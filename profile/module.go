@@ -1,9 +1,12 @@
 package profile
 
 import (
+	"time"
+
 	"github.com/safing/portbase/log"
 
 	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/netenv"
 
 	// module dependencies
 	_ "github.com/safing/portmaster/core/base"
@@ -15,7 +18,7 @@
 )
 
 func init() {
-	module = modules.Register("profiles", prep, start, nil, "base", "updates")
+	module = modules.Register("profiles", prep, start, nil, "base", "updates", "netenv")
 }
 
 func prep() error {
@@ -48,6 +51,16 @@ func start() error {
 		return err
 	}
 
+	registerStagedConfigAPI()
+
+	err = cleanExpiredEndpointsOnStart()
+	if err != nil {
+		log.Warningf("profile: failed to clean expired rules: %s", err)
+	}
+	module.NewTask("clean expired rules", cleanExpiredEndpoints).
+		Repeat(expiredEndpointsCleanerTickDuration).
+		Schedule(time.Now().Add(expiredEndpointsCleanerTickDuration))
+
 	module.StartServiceWorker("clean active profiles", 0, cleanActiveProfiles)
 
 	err = updateGlobalConfigProfile(module.Ctx, nil)
@@ -55,5 +68,19 @@ func start() error {
 		log.Warningf("profile: error during loading global profile from configuration: %s", err)
 	}
 
+	err = updateActiveLocation(module.Ctx, nil)
+	if err != nil {
+		log.Warningf("profile: failed to select network location: %s", err)
+	}
+	err = module.RegisterEventHook(
+		"netenv",
+		netenv.NetworkChangedEvent,
+		"update network location",
+		updateActiveLocation,
+	)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -4,6 +4,7 @@
 	"context"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/log"
@@ -30,6 +31,14 @@ type LayeredProfile struct {
 
 	securityLevel *uint32
 
+	// nextScheduleChange is the next point in time at which a scheduled
+	// endpoint becomes active or inactive. It is zero if there is none.
+	nextScheduleChange time.Time
+
+	// shadow is the layered profile with the staged config changes of all
+	// layers applied. It is nil if no layer has staged config changes.
+	shadow *LayeredProfile
+
 	// These functions give layered access to configuration options and require
 	// the layered profile to be read locked.
 
@@ -43,17 +52,44 @@ type LayeredProfile struct {
 	BlockScopeInternet  config.BoolOption `json:"-"`
 	BlockP2P            config.BoolOption `json:"-"`
 	BlockInbound        config.BoolOption `json:"-"`
+	BlockICMP           config.BoolOption `json:"-"`
 	RemoveOutOfScopeDNS config.BoolOption `json:"-"`
 	RemoveBlockedDNS    config.BoolOption `json:"-"`
 	FilterSubDomains    config.BoolOption `json:"-"`
 	FilterCNAMEs        config.BoolOption `json:"-"`
 	PreventBypassing    config.BoolOption `json:"-"`
 	DomainHeuristics    config.BoolOption `json:"-"`
+	ICMPHeuristics      config.BoolOption `json:"-"`
 	UseSPN              config.BoolOption `json:"-"`
 }
 
 // NewLayeredProfile returns a new layered profile based on the given local profile.
 func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
+	new := newLayeredProfile(localProfile)
+
+	new.LayerIDs = append(new.LayerIDs, localProfile.ScopedID())
+	new.layers = append(new.layers, localProfile)
+
+	// TODO: Load additional profiles.
+
+	new.updateLocationLayer()
+	new.updateCaches()
+	new.updateShadow()
+
+	new.CreateMeta()
+	new.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + revisionProviderPrefix + localProfile.ScopedID())
+
+	// Inform database subscribers about the new layered profile.
+	new.Lock()
+	defer new.Unlock()
+
+	pushLayeredProfile(new)
+
+	return new
+}
+
+// newLayeredProfile returns a new layered profile without any layers.
+func newLayeredProfile(localProfile *Profile) *LayeredProfile {
 	var securityLevelVal uint32
 
 	new := &LayeredProfile{
@@ -89,6 +125,10 @@ func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
 		CfgOptionBlockInboundKey,
 		cfgOptionBlockInbound,
 	)
+	new.BlockICMP = new.wrapSecurityLevelOption(
+		CfgOptionBlockICMPKey,
+		cfgOptionBlockICMP,
+	)
 	new.RemoveOutOfScopeDNS = new.wrapSecurityLevelOption(
 		CfgOptionRemoveOutOfScopeDNSKey,
 		cfgOptionRemoveOutOfScopeDNS,
@@ -113,27 +153,15 @@ func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
 		CfgOptionDomainHeuristicsKey,
 		cfgOptionDomainHeuristics,
 	)
+	new.ICMPHeuristics = new.wrapSecurityLevelOption(
+		CfgOptionICMPHeuristicsKey,
+		cfgOptionICMPHeuristics,
+	)
 	new.UseSPN = new.wrapBoolOption(
 		CfgOptionUseSPNKey,
 		cfgOptionUseSPN,
 	)
 
-	new.LayerIDs = append(new.LayerIDs, localProfile.ScopedID())
-	new.layers = append(new.layers, localProfile)
-
-	// TODO: Load additional profiles.
-
-	new.updateCaches()
-
-	new.CreateMeta()
-	new.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + revisionProviderPrefix + localProfile.ScopedID())
-
-	// Inform database subscribers about the new layered profile.
-	new.Lock()
-	defer new.Unlock()
-
-	pushLayeredProfile(new)
-
 	return new
 }
 
@@ -208,6 +236,16 @@ func (lp *LayeredProfile) NeedsUpdate() (outdated bool) {
 		}
 	}
 
+	// Check if a scheduled endpoint became active or inactive.
+	if lp.schedulePassed() {
+		return true
+	}
+
+	// Check if the network location changed.
+	if lp.locationChanged() {
+		return true
+	}
+
 	return false
 }
 
@@ -232,6 +270,12 @@ func (lp *LayeredProfile) Update() (revisionCounter uint64) {
 	if !lp.globalValidityFlag.IsValid() {
 		changed = true
 	}
+	if lp.schedulePassed() {
+		changed = true
+	}
+	if lp.updateLocationLayer() {
+		changed = true
+	}
 
 	if changed {
 		// get global config validity flag
@@ -239,6 +283,7 @@ func (lp *LayeredProfile) Update() (revisionCounter uint64) {
 
 		// update cached data fields
 		lp.updateCaches()
+		lp.updateShadow()
 
 		// bump revision counter
 		lp.RevisionCounter++
@@ -258,6 +303,77 @@ func (lp *LayeredProfile) updateCaches() {
 		}
 	}
 	atomic.StoreUint32(lp.securityLevel, uint32(newLevel))
+
+	// update next schedule change
+	now := time.Now()
+	var next time.Time
+	for _, layer := range lp.layers {
+		layer.RLock()
+		next = earliestTime(next, layer.endpoints.NextScheduleChange(now))
+		next = earliestTime(next, layer.serviceEndpoints.NextScheduleChange(now))
+		layer.RUnlock()
+	}
+	cfgLock.RLock()
+	next = earliestTime(next, cfgEndpoints.NextScheduleChange(now))
+	next = earliestTime(next, cfgServiceEndpoints.NextScheduleChange(now))
+	cfgLock.RUnlock()
+	lp.nextScheduleChange = next
+}
+
+// schedulePassed returns whether a scheduled endpoint became active or
+// inactive since the last update. The layered profile must be read locked.
+func (lp *LayeredProfile) schedulePassed() bool {
+	return !lp.nextScheduleChange.IsZero() && !time.Now().Before(lp.nextScheduleChange)
+}
+
+// updateShadow rebuilds the shadow layered profile from the staged config
+// changes of all layers. The layered profile must be locked.
+func (lp *LayeredProfile) updateShadow() {
+	// Check if there is anything to evaluate.
+	var staged bool
+	for _, layer := range lp.layers {
+		if layer.HasStagedConfig() {
+			staged = true
+			break
+		}
+	}
+	if !staged {
+		lp.shadow = nil
+		return
+	}
+
+	shadow := newLayeredProfile(lp.localProfile)
+	for _, layer := range lp.layers {
+		stagedLayer, err := layer.stagedCopy()
+		if err != nil {
+			log.Warningf("profiles: failed to apply staged config of %s, disabling shadow evaluation: %s", layer.ScopedID(), err)
+			lp.shadow = nil
+			return
+		}
+
+		if layer == lp.localProfile {
+			shadow.localProfile = stagedLayer
+		}
+		shadow.LayerIDs = append(shadow.LayerIDs, stagedLayer.ScopedID())
+		shadow.layers = append(shadow.layers, stagedLayer)
+	}
+	shadow.updateCaches()
+
+	lp.shadow = shadow
+}
+
+// Shadow returns the layered profile with all staged config changes applied.
+// It returns nil if there are no staged config changes. The shadow profile
+// must only be used for evaluation and is replaced on every update.
+func (lp *LayeredProfile) Shadow() *LayeredProfile {
+	if lp == nil {
+		return nil
+	}
+
+	lp.RLock()
+	defer lp.RUnlock()
+
+	return lp.shadow
 }
 
 // MarkUsed marks the localProfile as used.
@@ -474,6 +590,20 @@ func (lp *LayeredProfile) wrapStringOption(configKey string, globalConfig config
 }
 */
 
+// earliestTime returns the earlier of the given times, ignoring zero times.
+func earliestTime(a, b time.Time) time.Time {
+	switch {
+	case a.IsZero():
+		return b
+	case b.IsZero():
+		return a
+	case b.Before(a):
+		return b
+	default:
+		return a
+	}
+}
+
 func max(a, b uint8) uint8 {
 	if a > b {
 		return a
@@ -0,0 +1,125 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+)
+
+// HasStagedConfig returns whether the profile has any staged config changes.
+func (profile *Profile) HasStagedConfig() bool {
+	return len(profile.StagedConfig) > 0
+}
+
+// stagedCopy returns a copy of the profile that has the staged config changes
+// applied on top of the live config. The copy is never saved and must only be
+// used for evaluating the staged changes.
+func (profile *Profile) stagedCopy() (*Profile, error) {
+	profile.RLock()
+	defer profile.RUnlock()
+
+	// Merge the staged config into the live config.
+	merged := config.Flatten(profile.Config)
+	for key, value := range config.Flatten(profile.StagedConfig) {
+		merged[key] = value
+	}
+
+	staged := &Profile{
+		ID:            profile.ID,
+		Source:        profile.Source,
+		Name:          profile.Name,
+		LinkedPath:    profile.LinkedPath,
+		SecurityLevel: profile.SecurityLevel,
+		Config:        config.Expand(merged),
+		Internal:      profile.Internal,
+	}
+	// Use the same key, so that verdict reasons reference the real profile.
+	staged.makeKey()
+
+	err := staged.prepConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = staged.parseConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return staged, nil
+}
+
+// PromoteStagedConfig merges the staged config changes into the live config,
+// clears the staged config and saves the profile.
+func (profile *Profile) PromoteStagedConfig() error {
+	profile.Lock()
+
+	if !profile.HasStagedConfig() {
+		profile.Unlock()
+		return errors.New("profile has no staged config changes")
+	}
+	profile.applyStagedConfig()
+
+	profile.Unlock()
+
+	return profile.Save()
+}
+
+// applyStagedConfig merges the staged config changes into the live config and
+// clears the staged config. The profile must be locked.
+func (profile *Profile) applyStagedConfig() {
+	if profile.Config == nil {
+		profile.Config = make(map[string]interface{})
+	}
+	for key, value := range config.Flatten(profile.StagedConfig) {
+		config.PutValueIntoHierarchicalConfig(profile.Config, key, value)
+	}
+	profile.StagedConfig = nil
+}
+
+// DiscardStagedConfig removes all staged config changes and saves the profile.
+func (profile *Profile) DiscardStagedConfig() error {
+	profile.Lock()
+	profile.StagedConfig = nil
+	profile.Unlock()
+
+	return profile.Save()
+}
+
+func registerStagedConfigAPI() {
+	api.RegisterHandleFunc(
+		"/api/v1/profiles/staged/{action:promote|discard}/{source:[a-z]+}/{id:[A-Za-z0-9_\\-]+}",
+		handleStagedConfigRequest,
+	).Methods("POST")
+}
+
+func handleStagedConfigRequest(w http.ResponseWriter, r *http.Request) {
+	vars := api.GetMuxVars(r)
+
+	// Get the profile from the database, as only saved changes may be promoted.
+	profile, err := getProfile(makeScopedID(profileSource(vars["source"]), vars["id"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch vars["action"] {
+	case "promote":
+		err = profile.PromoteStagedConfig()
+	case "discard":
+		err = profile.DiscardStagedConfig()
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to %s staged config: %s", vars["action"], err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof("profile: %sd staged config of %s", vars["action"], profile.ScopedID())
+	w.WriteHeader(http.StatusOK)
+}
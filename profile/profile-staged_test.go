@@ -0,0 +1,56 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestApplyStagedConfig(t *testing.T) {
+	profile := &Profile{
+		Config: map[string]interface{}{
+			"filter": map[string]interface{}{
+				"defaultAction": "permit",
+				"blockP2P":      true,
+			},
+		},
+		StagedConfig: map[string]interface{}{
+			"filter": map[string]interface{}{
+				"defaultAction": "block",
+				"endpoints":     []string{"+ example.com"},
+			},
+		},
+	}
+	if !profile.HasStagedConfig() {
+		t.Fatal("profile should have staged config")
+	}
+
+	profile.applyStagedConfig()
+
+	if profile.HasStagedConfig() {
+		t.Error("staged config should be cleared")
+	}
+	flattened := config.Flatten(profile.Config)
+	if flattened["filter/defaultAction"] != "block" {
+		t.Errorf("staged value was not applied: %v", flattened["filter/defaultAction"])
+	}
+	if flattened["filter/blockP2P"] != true {
+		t.Errorf("live value was lost: %v", flattened["filter/blockP2P"])
+	}
+	if endpoints, ok := flattened["filter/endpoints"].([]string); !ok || len(endpoints) != 1 {
+		t.Errorf("staged value was not added: %v", flattened["filter/endpoints"])
+	}
+
+	// Staged changes may be applied to profiles without config.
+	profile = &Profile{
+		StagedConfig: map[string]interface{}{
+			"filter": map[string]interface{}{
+				"defaultAction": "ask",
+			},
+		},
+	}
+	profile.applyStagedConfig()
+	if config.Flatten(profile.Config)["filter/defaultAction"] != "ask" {
+		t.Errorf("staged value was not applied to empty config: %v", profile.Config)
+	}
+}
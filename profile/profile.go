@@ -34,6 +34,7 @@
 	SourceSpecial    profileSource = "special" // specials (read-only)
 	SourceCommunity  profileSource = "community"
 	SourceEnterprise profileSource = "enterprise"
+	SourceLocation   profileSource = "location" // network locations
 )
 
 // Default Action IDs
@@ -84,6 +85,9 @@ type Profile struct {
 	LinkedPath string // constant
 	// LinkedProfiles is a list of other profiles
 	LinkedProfiles []string
+	// Network holds the criteria that identify the network of a location
+	// profile. It is only used by profiles with the location source.
+	Network *NetworkCriteria
 	// SecurityLevel is the mininum security level to apply to
 	// connections made with this profile.
 	// Note(ppacher): we may deprecate this one as it can easily
@@ -96,6 +100,12 @@ type Profile struct {
 	// an object) need to be concatenated for the settings database
 	// path.
 	Config map[string]interface{}
+	// StagedConfig holds config changes that are staged for review. It has
+	// the same structure as Config and is applied on top of it. Staged
+	// changes are not enforced: they are evaluated in parallel to the live
+	// config and any disagreement is recorded. Once promoted, they are
+	// merged into Config.
+	StagedConfig map[string]interface{}
 	// ApproxLastUsed holds a UTC timestamp in seconds of
 	// when this Profile was approximately last used.
 	// For performance reasons not every single usage is saved.
@@ -309,6 +319,8 @@ func (profile *Profile) addEndpointyEntry(cfgKey, newEntry string) {
 		profile.layeredProfile.Lock()
 		defer profile.layeredProfile.Unlock()
 
+		// Update caches, as the new entry might expire.
+		profile.layeredProfile.updateCaches()
 		profile.layeredProfile.RevisionCounter++
 	}()
 
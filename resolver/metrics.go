@@ -0,0 +1,44 @@
+package resolver
+
+import (
+	"errors"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portmaster/metrics"
+)
+
+var (
+	queryMetric = metrics.NewCounterVec(
+		"portmaster_resolver_queries",
+		"DNS queries sent to upstream resolvers, by resolver and response code.",
+		"resolver", "rcode",
+	)
+
+	cacheMetric = metrics.NewCounterVec(
+		"portmaster_resolver_cache_lookups",
+		"Lookups of the DNS cache, by result.",
+		"result",
+	)
+	metricCacheHits   = cacheMetric.With("hit")
+	metricCacheMisses = cacheMetric.With("miss")
+)
+
+// recordQueryMetric records the result of a query to the given resolver.
+func recordQueryMetric(resolver *Resolver, rrCache *RRCache, err error) {
+	var rcode string
+	switch {
+	case err == nil && rrCache != nil:
+		rcode = dns.RcodeToString[rrCache.RCode]
+	case errors.Is(err, ErrNotFound):
+		rcode = dns.RcodeToString[dns.RcodeNameError]
+	case errors.Is(err, ErrBlocked):
+		rcode = "blocked"
+	case errors.Is(err, ErrTimeout):
+		rcode = "timeout"
+	default:
+		rcode = "error"
+	}
+
+	queryMetric.With(resolver.GetName(), rcode).Inc()
+}
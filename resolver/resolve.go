@@ -135,8 +135,10 @@ func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
 	if !q.NoCaching {
 		rrCache = checkCache(ctx, q)
 		if rrCache != nil && !rrCache.Expired() {
+			metricCacheHits.Inc()
 			return rrCache, nil
 		}
+		metricCacheMisses.Inc()
 
 		// dedupe!
 		markRequestFinished := deduplicateRequest(ctx, q)
@@ -343,6 +345,7 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 
 			// resolve
 			rrCache, err = resolver.Conn.Query(ctx, q)
+			recordQueryMetric(resolver, rrCache, err)
 			if err != nil {
 				switch {
 				case errors.Is(err, ErrNotFound):